@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// defaultKeyPaths are the private keys OpenSSH tries when none is configured.
+var defaultKeyPaths = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_ed25519",
+}
+
+// defaultPreferredAuthentications is the order in which OpenSSH tries the
+// authentication methods we support.
+var defaultPreferredAuthentications = []string{"publickey", "keyboard-interactive", "password"}
+
+// passphraseAttempts is how many times the user gets asked for a key's
+// passphrase before giving up, like OpenSSH.
+const passphraseAttempts = 3
+
+// promptFunc asks the user a question and returns their answer. echo tells
+// whether the answer may be shown as it's typed.
+type promptFunc func(question string, echo bool) (string, error)
+
+// authenticator provides the authentication methods for connecting to hosts.
+// It's shared between all hosts, so that the user only gets asked once for
+// the passphrase of a given key.
+type authenticator struct {
+	// agent is the SSH agent holding the user's keys, if any.
+	agent agent.Agent
+	// prompt asks the user for secrets. It is nil when there's no terminal to
+	// ask on.
+	prompt promptFunc
+
+	// mu serializes prompts, since several hosts are connected to at the same
+	// time, and protects keys.
+	mu sync.Mutex
+	// keys are the decrypted private keys, by path.
+	keys map[string]ssh.Signer
+}
+
+func newAuthenticator(a agent.Agent, prompt promptFunc) *authenticator {
+	return &authenticator{
+		agent:  a,
+		prompt: prompt,
+		keys:   map[string]ssh.Signer{},
+	}
+}
+
+// sshAgent connects to the SSH agent listening on `SSH_AUTH_SOCK`. It returns
+// nil if there's no agent to connect to.
+func sshAgent() (agent.Agent, io.Closer) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil
+	}
+	return agent.NewClient(conn), conn
+}
+
+// terminalPrompt returns a promptFunc that asks the user on the controlling
+// terminal. It returns nil if there's none.
+func terminalPrompt() (promptFunc, io.Closer) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil
+	}
+
+	reader := bufio.NewReader(tty)
+	return func(question string, echo bool) (string, error) {
+		if _, err := fmt.Fprint(tty, question); err != nil {
+			return "", err
+		}
+
+		if echo {
+			answer, err := reader.ReadString('\n')
+			return strings.TrimRight(answer, "\r\n"), err
+		}
+
+		answer, err := term.ReadPassword(int(tty.Fd()))
+		_, _ = fmt.Fprintln(tty)
+		return string(answer), err
+	}, tty
+}
+
+// ask prompts the user, making sure only one prompt is shown at a time.
+func (a *authenticator) ask(question string, echo bool) (string, error) {
+	if a.prompt == nil {
+		return "", errors.New("no terminal to prompt on")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.prompt(question, echo)
+}
+
+// methods returns the authentication methods to try for conn, in order.
+func (a *authenticator) methods(conn connection) ([]ssh.AuthMethod, error) {
+	order := conn.PreferredAuthentications
+	if len(order) == 0 {
+		order = defaultPreferredAuthentications
+	}
+
+	var methods []ssh.AuthMethod
+	for _, method := range order {
+		switch strings.TrimSpace(method) {
+		case "publickey":
+			signers, err := a.signers(conn)
+			if err != nil {
+				return nil, err
+			}
+			if len(signers) > 0 {
+				methods = append(methods, ssh.PublicKeys(signers...))
+			}
+
+		case "keyboard-interactive":
+			if conn.Password != "" || a.prompt != nil {
+				methods = append(methods, ssh.KeyboardInteractive(a.keyboardInteractive(conn)))
+			}
+
+		case "password":
+			if conn.Password != "" {
+				methods = append(methods, ssh.Password(conn.Password))
+			} else if a.prompt != nil {
+				methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+					return a.ask(fmt.Sprintf("%s@%s's password: ", conn.User, conn.Address), false)
+				}))
+			}
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("no authentication method available: no private key found, and no password nor terminal to ask for one")
+	}
+
+	return methods, nil
+}
+
+// keyboardInteractive answers the server's questions. Questions that aren't
+// echoed are assumed to be asking for the password, if one was provided.
+// Others are asked to the user.
+func (a *authenticator) keyboardInteractive(conn connection) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, q := range questions {
+			if !echos[i] && conn.Password != "" {
+				answers[i] = conn.Password
+				continue
+			}
+
+			answer, err := a.ask(fmt.Sprintf("(%s@%s) %s", conn.User, conn.Address, q), echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	}
+}
+
+// signers returns the keys to try for public key authentication, in the same
+// order as OpenSSH: configured keys that are in the agent, then other keys
+// from the agent, then configured keys that aren't in the agent. When
+// `IdentitiesOnly` is set, only configured keys are used.
+func (a *authenticator) signers(conn connection) ([]ssh.Signer, error) {
+	fileSigners, err := a.fileSigners(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var agentSigners []ssh.Signer
+	if a.agent != nil {
+		agentSigners, err = a.agent.Signers()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get keys from the SSH agent: %w", err)
+		}
+	}
+
+	inAgent := func(key ssh.PublicKey) (ssh.Signer, bool) {
+		for _, s := range agentSigners {
+			if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+				return s, true
+			}
+		}
+		return nil, false
+	}
+
+	var signers, fromFiles []ssh.Signer
+	used := map[string]bool{}
+	for _, f := range fileSigners {
+		if s, ok := inAgent(f.PublicKey()); ok {
+			signers = append(signers, s)
+			used[string(s.PublicKey().Marshal())] = true
+			continue
+		}
+		fromFiles = append(fromFiles, f)
+	}
+
+	if !conn.IdentitiesOnly {
+		for _, s := range agentSigners {
+			if !used[string(s.PublicKey().Marshal())] {
+				signers = append(signers, s)
+			}
+		}
+	}
+
+	return append(signers, fromFiles...), nil
+}
+
+// fileSigners loads the private keys configured for conn, or the default ones
+// if there are none. Encrypted keys are only decrypted when the server accepts
+// them, so that the user isn't asked for passphrases needlessly.
+func (a *authenticator) fileSigners(conn connection) ([]ssh.Signer, error) {
+	keyPaths := conn.KeyPaths
+	if len(keyPaths) == 0 {
+		for _, p := range defaultKeyPaths {
+			keyPaths = append(keyPaths, expandHome(p))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, k := range keyPaths {
+		key, err := os.ReadFile(k)
+		if err != nil {
+			// Default keys and those from the SSH config are only tried if
+			// they exist, but keys given on the command line or in the
+			// inventory have to be there.
+			if !conn.KeyPathsRequired && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed reading private key %q: %v", k, err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err == nil {
+			signers = append(signers, signer)
+			continue
+		}
+
+		var missing *ssh.PassphraseMissingError
+		if !errors.As(err, &missing) {
+			return nil, fmt.Errorf("failed parsing private key %q: %v", k, err)
+		}
+
+		// The public key isn't stored in the clear in PEM encrypted keys, but
+		// it can usually be found next to them.
+		pub := missing.PublicKey
+		if pub == nil {
+			if data, err := os.ReadFile(k + ".pub"); err == nil {
+				pub, _, _, _, _ = ssh.ParseAuthorizedKey(data)
+			}
+		}
+
+		if pub == nil {
+			signer, err := a.decrypt(k, key)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, signer)
+			continue
+		}
+
+		signers = append(signers, &encryptedSigner{
+			publicKey: pub,
+			decrypt: func() (ssh.Signer, error) {
+				return a.decrypt(k, key)
+			},
+		})
+	}
+
+	return signers, nil
+}
+
+// decrypt asks the user for the passphrase of the private key at path. The
+// decrypted key is kept, so that the user is only asked once.
+func (a *authenticator) decrypt(path string, key []byte) (ssh.Signer, error) {
+	if a.prompt == nil {
+		return nil, fmt.Errorf("private key %q is passphrase protected and there's no terminal to ask for it", path)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if signer, ok := a.keys[path]; ok {
+		return signer, nil
+	}
+
+	for range passphraseAttempts {
+		passphrase, err := a.prompt(fmt.Sprintf("Enter passphrase for key '%s': ", path), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase for private key %q: %w", path, err)
+		}
+
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing private key %q: %v", path, err)
+		}
+
+		a.keys[path] = signer
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("incorrect passphrase for private key %q", path)
+}
+
+// encryptedSigner is a passphrase protected private key, which only gets
+// decrypted when it's first used to sign.
+type encryptedSigner struct {
+	publicKey ssh.PublicKey
+	decrypt   func() (ssh.Signer, error)
+}
+
+func (s *encryptedSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := s.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+func (s *encryptedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := s.decrypt()
+	if err != nil {
+		return nil, err
+	}
+
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("private key doesn't support the %s signature algorithm", algorithm)
+	}
+	return algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func writeKey(t *testing.T, priv ed25519.PrivateKey, passphrase string) string {
+	t.Helper()
+
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func publicKey(t *testing.T, priv ed25519.PrivateKey) string {
+	t.Helper()
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pub.Marshal())
+}
+
+func TestAuthenticatorSigners(t *testing.T) {
+	inBoth, agentOnly, fileOnly := newKey(t), newKey(t), newKey(t)
+
+	keyring := agent.NewKeyring()
+	for _, k := range []ed25519.PrivateKey{agentOnly, inBoth} {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: k}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyPaths := []string{writeKey(t, fileOnly, ""), writeKey(t, inBoth, "")}
+
+	tests := []struct {
+		name           string
+		identitiesOnly bool
+		expected       []ed25519.PrivateKey
+	}{
+		{
+			name:     "all keys",
+			expected: []ed25519.PrivateKey{inBoth, agentOnly, fileOnly},
+		},
+		{
+			name:           "identities only",
+			identitiesOnly: true,
+			expected:       []ed25519.PrivateKey{inBoth, fileOnly},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := newAuthenticator(keyring, nil)
+			signers, err := auth.signers(connection{
+				KeyPaths:       keyPaths,
+				IdentitiesOnly: tt.identitiesOnly,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got, expected []string
+			for _, s := range signers {
+				got = append(got, string(s.PublicKey().Marshal()))
+			}
+			for _, k := range tt.expected {
+				expected = append(expected, publicKey(t, k))
+			}
+
+			if diff := cmp.Diff(expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorMissingKey(t *testing.T) {
+	key := newKey(t)
+	keyPaths := []string{filepath.Join(t.TempDir(), "missing"), writeKey(t, key, "")}
+
+	tests := []struct {
+		name     string
+		required bool
+		wantErr  bool
+	}{
+		{
+			name: "from the SSH config",
+		},
+		{
+			name:     "from the command line",
+			required: true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := newAuthenticator(nil, nil)
+			signers, err := auth.signers(connection{
+				KeyPaths:         keyPaths,
+				KeyPathsRequired: tt.required,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(signers) != 1 || string(signers[0].PublicKey().Marshal()) != publicKey(t, key) {
+				t.Errorf("expected only the existing key, got %d keys", len(signers))
+			}
+		})
+	}
+}
+
+func TestAuthenticatorEncryptedKey(t *testing.T) {
+	priv := newKey(t)
+	keyPath := writeKey(t, priv, "hunter2")
+
+	var prompts int
+	answers := []string{"wrong", "hunter2"}
+	auth := newAuthenticator(nil, func(_ string, echo bool) (string, error) {
+		if echo {
+			t.Error("expected passphrase not to be echoed")
+		}
+		prompts++
+		return answers[prompts-1], nil
+	})
+
+	signers, err := auth.signers(connection{KeyPaths: []string{keyPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompts != 0 {
+		t.Errorf("expected no prompt before the key is used, got %d", prompts)
+	}
+	if len(signers) != 1 || string(signers[0].PublicKey().Marshal()) != publicKey(t, priv) {
+		t.Fatalf("expected the encrypted key's public key, got %v", signers)
+	}
+
+	for range 2 {
+		sig, err := signers[0].Sign(rand.Reader, []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signers[0].PublicKey().Verify([]byte("data"), sig); err != nil {
+			t.Errorf("invalid signature: %v", err)
+		}
+	}
+
+	if prompts != 2 {
+		t.Errorf("expected 2 prompts, got %d", prompts)
+	}
+}
+
+func TestAuthenticatorEncryptedKeyNoTerminal(t *testing.T) {
+	keyPath := writeKey(t, newKey(t), "hunter2")
+
+	auth := newAuthenticator(nil, nil)
+	signers, err := auth.signers(connection{KeyPaths: []string{keyPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := signers[0].Sign(rand.Reader, []byte("data")); err == nil {
+		t.Error("expected an error when there's no terminal to ask for the passphrase")
+	}
+}
+
+// startPasswordServer starts an SSH server that only accepts the given
+// password, through either password or keyboard-interactive authentication.
+func startPasswordServer(t *testing.T, password string) string {
+	t.Helper()
+
+	hostKey, err := ssh.NewSignerFromKey(newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if string(p) != password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+		KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Verification code: ", "Password: "}, []bool{true, false})
+			if err != nil {
+				return nil, err
+			}
+			if answers[0] != "123456" || answers[1] != password {
+				return nil, errors.New("wrong answers")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestAuthenticatorMethods(t *testing.T) {
+	addr := startPasswordServer(t, "s3cret")
+
+	tests := []struct {
+		name    string
+		conn    connection
+		answers map[string]string
+		wantErr bool
+	}{
+		{
+			name: "password",
+			conn: connection{
+				Password:                 "s3cret",
+				PreferredAuthentications: []string{"password"},
+			},
+		},
+		{
+			name: "keyboard-interactive with password",
+			conn: connection{
+				Password:                 "s3cret",
+				PreferredAuthentications: []string{"keyboard-interactive"},
+			},
+			answers: map[string]string{
+				"(admin@127.0.0.1) Verification code: ": "123456",
+			},
+		},
+		{
+			name: "prompted password",
+			conn: connection{
+				PreferredAuthentications: []string{"publickey", "password"},
+			},
+			answers: map[string]string{
+				"admin@127.0.0.1's password: ": "s3cret",
+			},
+		},
+		{
+			name: "wrong password",
+			conn: connection{
+				Password:                 "nope",
+				PreferredAuthentications: []string{"password"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := newAuthenticator(nil, func(question string, _ bool) (string, error) {
+				answer, ok := tt.answers[question]
+				if !ok {
+					t.Errorf("unexpected prompt %q", question)
+				}
+				return answer, nil
+			})
+
+			tt.conn.User = "admin"
+			tt.conn.Address = "127.0.0.1"
+			tt.conn.KeyPaths = []string{writeKey(t, newKey(t), "")}
+			methods, err := auth.methods(tt.conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            tt.conn.User,
+				Auth:            methods,
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if client != nil {
+				client.Close()
+			}
+		})
+	}
+}
+
+func TestAuthenticatorNoMethods(t *testing.T) {
+	auth := newAuthenticator(nil, nil)
+	_, err := auth.methods(connection{
+		PreferredAuthentications: []string{"password", "keyboard-interactive"},
+	})
+	if err == nil {
+		t.Error("expected an error when no authentication method is available")
+	}
+}
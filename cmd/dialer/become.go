@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// becomeTask holds what tells whether a task, or the tasks it runs, ask for
+// become.
+type becomeTask struct {
+	Become       *bool        `yaml:"become"`
+	BecomeMethod string       `yaml:"become_method"`
+	Block        []becomeTask `yaml:"block"`
+	Rescue       []becomeTask `yaml:"rescue"`
+	Always       []becomeTask `yaml:"always"`
+	// Tasks can be included either with a file name, or with a dict holding
+	// it.
+	IncludeTasks        any `yaml:"include_tasks"`
+	BuiltinIncludeTasks any `yaml:"ansible.builtin.include_tasks"`
+	ImportTasks         any `yaml:"import_tasks"`
+	BuiltinImportTasks  any `yaml:"ansible.builtin.import_tasks"`
+}
+
+// includedFile returns the file of the tasks the task includes or imports, if
+// any.
+func (t becomeTask) includedFile() string {
+	for _, v := range []any{t.IncludeTasks, t.BuiltinIncludeTasks, t.ImportTasks, t.BuiltinImportTasks} {
+		switch v := v.(type) {
+		case string:
+			return v
+		case map[string]any:
+			if file, ok := v["file"].(string); ok {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+type becomePlay struct {
+	Become       *bool        `yaml:"become"`
+	BecomeMethod string       `yaml:"become_method"`
+	Roles        []any        `yaml:"roles"`
+	Tasks        []becomeTask `yaml:"tasks"`
+	Handlers     []becomeTask `yaml:"handlers"`
+}
+
+// becomeFinder looks for the tasks asking for become in a playbook.
+type becomeFinder struct {
+	become bool
+	method string
+	// read holds the task files already looked at, for files including each
+	// other not to be read forever.
+	read map[string]bool
+}
+
+// task looks at t and the tasks it runs. dir is the directory the files it
+// includes are relative to.
+func (f *becomeFinder) task(t becomeTask, dir string) error {
+	if t.Become != nil && *t.Become {
+		f.become = true
+	}
+	if f.method == "" {
+		f.method = t.BecomeMethod
+	}
+
+	for _, task := range slices.Concat(t.Block, t.Rescue, t.Always) {
+		if err := f.task(task, dir); err != nil {
+			return err
+		}
+	}
+
+	// Files named with templates can only be known when the playbook runs.
+	if file := t.includedFile(); file != "" && !strings.Contains(file, "{{") {
+		return f.file(filepath.Join(dir, file), dir)
+	}
+	return nil
+}
+
+// file looks at the tasks in the file at path. Missing files are left for the
+// executer to report.
+func (f *becomeFinder) file(path, dir string) error {
+	if f.read[path] {
+		return nil
+	}
+	f.read[path] = true
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tasks: %w", err)
+	}
+
+	var tasks []becomeTask
+	if err := yaml.Unmarshal(data, &tasks); err != nil {
+		return fmt.Errorf("failed to unmarshal tasks from %s: %w", path, err)
+	}
+	for _, t := range tasks {
+		if err := f.task(t, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// role looks at the tasks and handlers of the role in dir. Like in the
+// executer, the files its tasks include are relative to its tasks directory.
+func (f *becomeFinder) role(dir string) error {
+	tasksDir := filepath.Join(dir, "tasks")
+	for _, sub := range []string{"tasks", "handlers"} {
+		for _, name := range []string{"main.yml", "main.yaml", "main"} {
+			path := filepath.Join(dir, sub, name)
+			if info, err := os.Stat(path); err != nil || info.IsDir() {
+				continue
+			}
+			if err := f.file(path, tasksDir); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// playbookBecome tells whether any of the plays in the playbook at path, or
+// any of their tasks or handlers, asks for become, and the first become
+// method set. The executer then needs to be run with escalated privileges for
+// it to be able to switch users. Tasks in blocks, roles and included files are
+// looked at too, except for files named with templates.
+func playbookBecome(path string) (bool, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read playbook: %w", err)
+	}
+
+	var plays []becomePlay
+	if err := yaml.Unmarshal(data, &plays); err != nil {
+		return false, "", fmt.Errorf("failed to unmarshal playbook: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	f := becomeFinder{read: map[string]bool{}}
+	for _, play := range plays {
+		if err := f.task(becomeTask{Become: play.Become, BecomeMethod: play.BecomeMethod}, dir); err != nil {
+			return false, "", err
+		}
+
+		// Roles are either named, or dicts naming them, which can ask for
+		// become too.
+		for _, r := range play.Roles {
+			name, _ := r.(string)
+			if m, ok := r.(map[string]any); ok {
+				name, _ = m["role"].(string)
+				become, _ := m["become"].(bool)
+				method, _ := m["become_method"].(string)
+				if err := f.task(becomeTask{Become: &become, BecomeMethod: method}, dir); err != nil {
+					return false, "", err
+				}
+			}
+			if name == "" {
+				continue
+			}
+			if err := f.role(filepath.Join(dir, "roles", name)); err != nil {
+				return false, "", err
+			}
+		}
+
+		for _, task := range slices.Concat(play.Tasks, play.Handlers) {
+			if err := f.task(task, dir); err != nil {
+				return false, "", err
+			}
+		}
+	}
+
+	return f.become, f.method, nil
+}
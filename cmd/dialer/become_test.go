@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlaybookBecome(t *testing.T) {
+	tests := []struct {
+		name     string
+		playbook string
+		// files are written next to the playbook, by path.
+		files          map[string]string
+		expected       bool
+		expectedMethod string
+	}{
+		{
+			name: "no become",
+			playbook: `
+- hosts: all
+  tasks:
+    - command:
+        cmd: whoami
+`,
+		},
+		{
+			name: "play become",
+			playbook: `
+- hosts: all
+  become: true
+  become_method: doas
+  tasks:
+    - command:
+        cmd: whoami
+`,
+			expected:       true,
+			expectedMethod: "doas",
+		},
+		{
+			name: "task become",
+			playbook: `
+- hosts: all
+  tasks:
+    - command:
+        cmd: whoami
+      become: false
+- hosts: all
+  tasks:
+    - command:
+        cmd: whoami
+      become: true
+      become_user: postgres
+`,
+			expected: true,
+		},
+		{
+			name: "become in rescue",
+			playbook: `
+- hosts: all
+  tasks:
+    - block:
+        - command:
+            cmd: "false"
+      rescue:
+        - command:
+            cmd: whoami
+          become: true
+          become_method: su
+`,
+			expected:       true,
+			expectedMethod: "su",
+		},
+		{
+			name: "become in role",
+			playbook: `
+- hosts: all
+  roles:
+    - common
+    - role: web
+`,
+			files: map[string]string{
+				"roles/common/tasks/main.yml": `
+- command:
+    cmd: whoami
+`,
+				"roles/web/tasks/main.yaml": `
+- include_tasks:
+    file: nginx.yaml
+`,
+				"roles/web/tasks/nginx.yaml": `
+- command:
+    cmd: whoami
+  become: true
+  become_method: doas
+`,
+			},
+			expected:       true,
+			expectedMethod: "doas",
+		},
+		{
+			name: "become in role handlers",
+			playbook: `
+- hosts: all
+  roles:
+    - web
+`,
+			files: map[string]string{
+				"roles/web/handlers/main.yml": `
+- name: restart nginx
+  command:
+    cmd: systemctl restart nginx
+  become: true
+`,
+			},
+			expected: true,
+		},
+		{
+			name: "become on role entry",
+			playbook: `
+- hosts: all
+  roles:
+    - role: web
+      become: true
+      become_method: su
+`,
+			files: map[string]string{
+				"roles/web/tasks/main.yml": `
+- command:
+    cmd: whoami
+`,
+			},
+			expected:       true,
+			expectedMethod: "su",
+		},
+		{
+			name: "become in included file",
+			playbook: `
+- hosts: all
+  tasks:
+    - block:
+        - ansible.builtin.import_tasks: tasks.yaml
+`,
+			files: map[string]string{
+				"tasks.yaml": `
+- ansible.builtin.include_tasks:
+    file: tasks.yaml
+- command:
+    cmd: whoami
+  become: true
+`,
+			},
+			expected: true,
+		},
+		{
+			name: "templated include",
+			playbook: `
+- hosts: all
+  tasks:
+    - include_tasks:
+        file: "{{ tasks_file }}"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			path := filepath.Join(dir, "playbook.yaml")
+			if err := os.WriteFile(path, []byte(tt.playbook), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, method, err := playbookBecome(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected become=%v, got %v", tt.expected, got)
+			}
+			if method != tt.expectedMethod {
+				t.Errorf("expected method %q, got %q", tt.expectedMethod, method)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/mickael-carl/sophons/pkg/inventory"
+)
+
+// connection holds the parameters needed to reach a single host over SSH.
+type connection struct {
+	// Address is the address to dial, which may differ from the host's name
+	// in the inventory.
+	Address  string
+	Port     string
+	User     string
+	KeyPaths []string
+	// KeyPathsRequired tells whether the keys in KeyPaths have to exist, as
+	// they do when given on the command line or in the inventory. Like with
+	// OpenSSH, missing keys from the SSH config are skipped.
+	KeyPathsRequired bool
+	// IdentitiesOnly restricts authentication to the keys in KeyPaths.
+	IdentitiesOnly        bool
+	KnownHostsPaths       []string
+	StrictHostKeyChecking string
+	// JumpHosts are the hosts to go through to reach Address, in the
+	// `[user@]host[:port]` format of OpenSSH's ProxyJump.
+	JumpHosts []string
+	// Password is used for password and keyboard-interactive authentication.
+	// The user is prompted for it when it's empty and a terminal is
+	// available.
+	Password string
+	// PreferredAuthentications is the order in which authentication methods
+	// are tried, using OpenSSH's names for them.
+	PreferredAuthentications []string
+	// Become tells whether the executer is run with escalated privileges,
+	// using BecomeMethod.
+	Become         bool
+	BecomeMethod   string
+	BecomePassword string
+}
+
+// connectionVars lists, for each connection parameter, the inventory
+// variables that can set it, in order of preference. The `ansible_ssh_*`
+// variants are the legacy names that Ansible still honours.
+var connectionVars = struct {
+	host, port, user, keyPath, password  []string
+	become, becomeMethod, becomePassword []string
+}{
+	host:           []string{"ansible_host", "ansible_ssh_host"},
+	port:           []string{"ansible_port", "ansible_ssh_port"},
+	user:           []string{"ansible_user", "ansible_ssh_user"},
+	keyPath:        []string{"ansible_ssh_private_key_file"},
+	password:       []string{"ansible_password", "ansible_ssh_pass", "ansible_ssh_password"},
+	become:         []string{"ansible_become"},
+	becomeMethod:   []string{"ansible_become_method"},
+	becomePassword: []string{"ansible_become_password", "ansible_become_pass"},
+}
+
+// sshArgsVars are the inventory variables holding extra arguments for the SSH
+// command line. Those are how Ansible users configure jump hosts.
+var sshArgsVars = []string{"ansible_ssh_common_args", "ansible_ssh_extra_args"}
+
+// jumpHostsFromArgs extracts the jump hosts from SSH command line arguments,
+// as set with either `-J` or `-o ProxyJump=`. Other arguments are ignored.
+func jumpHostsFromArgs(args string) ([]string, bool) {
+	fields := strings.Fields(args)
+	for i, f := range fields {
+		var value string
+		switch {
+		case f == "-J" && i+1 < len(fields):
+			value = fields[i+1]
+		case strings.HasPrefix(f, "-J") && len(f) > 2:
+			value = f[2:]
+		case f == "-o" && i+1 < len(fields):
+			option := strings.Trim(fields[i+1], `"'`)
+			k, v, ok := strings.Cut(option, "=")
+			if !ok || !strings.EqualFold(k, "ProxyJump") {
+				continue
+			}
+			value = v
+		default:
+			continue
+		}
+
+		value = strings.Trim(value, `"'`)
+		if strings.EqualFold(value, "none") {
+			return nil, true
+		}
+		return strings.Split(value, ","), true
+	}
+	return nil, false
+}
+
+// parseJumpHost parses a `[user@]host[:port]` jump host specification.
+func parseJumpHost(spec string) (connection, error) {
+	var conn connection
+	if user, hostPort, ok := strings.Cut(spec, "@"); ok {
+		conn.User = user
+		spec = hostPort
+	}
+
+	conn.Address = spec
+	if host, port, err := net.SplitHostPort(spec); err == nil {
+		conn.Address = host
+		conn.Port = port
+	} else if strings.Count(spec, ":") == 1 {
+		return connection{}, fmt.Errorf("invalid jump host %q: %w", spec, err)
+	}
+
+	if conn.Address == "" {
+		return connection{}, fmt.Errorf("invalid jump host %q: empty host", spec)
+	}
+
+	return conn, nil
+}
+
+// jumpHostConnection computes the connection parameters for a jump host. Like
+// with OpenSSH, the SSH config applies to the jump host, and the user and port
+// in its specification take precedence. Keys and known hosts set on the command
+// line are used for jump hosts as well, and so is password, the one of the
+// target host: Ansible hands it to whichever host asks for one first.
+func jumpHostConnection(spec string, flags connection, password string, sshCfg openSSHConfig) (connection, error) {
+	jump, err := parseJumpHost(spec)
+	if err != nil {
+		return connection{}, err
+	}
+
+	conn := connection{
+		Address:         jump.Address,
+		Port:            "22",
+		User:            flags.User,
+		KnownHostsPaths: []string{expandHome("~/.ssh/known_hosts")},
+		Password:        password,
+	}
+	conn = sshCfg.apply(conn)
+	// Jump hosts are not recursively resolved.
+	conn.JumpHosts = nil
+
+	if jump.User != "" {
+		conn.User = jump.User
+	}
+	if jump.Port != "" {
+		conn.Port = jump.Port
+	}
+	if len(flags.KeyPaths) > 0 {
+		conn.KeyPaths = flags.KeyPaths
+		conn.KeyPathsRequired = true
+	}
+	if len(flags.KnownHostsPaths) > 0 {
+		conn.KnownHostsPaths = flags.KnownHostsPaths
+	}
+
+	return conn, nil
+}
+
+// lookupVar returns the value of the first variable in names that's set in
+// vars, as a string.
+func lookupVar(vars map[string]any, names []string) (string, bool) {
+	for _, name := range names {
+		v, ok := vars[name]
+		if !ok || v == nil {
+			continue
+		}
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// isTrue tells whether an inventory variable's value is one of the ways YAML
+// and Ansible spell true.
+func isTrue(v string) bool {
+	return slices.Contains([]string{"true", "yes", "on", "1"}, strings.ToLower(v))
+}
+
+// hostConnection computes the connection parameters for a host. In order of
+// increasing precedence, those come from the SSH config, the command line
+// flags that were explicitly set and finally the inventory variables.
+func hostConnection(inv inventory.Inventory, host string, flags connection, sshCfg openSSHConfig) connection {
+	conn := connection{
+		Address:         host,
+		Port:            "22",
+		KnownHostsPaths: []string{expandHome("~/.ssh/known_hosts")},
+	}
+
+	vars := inv.NodeVars(host)
+
+	// The SSH config is matched against the address we're about to connect
+	// to, like `ssh` would do when invoked by Ansible.
+	if v, ok := lookupVar(vars, connectionVars.host); ok {
+		conn.Address = v
+	}
+	conn = sshCfg.apply(conn)
+
+	if flags.Port != "" {
+		conn.Port = flags.Port
+	}
+	if flags.User != "" {
+		conn.User = flags.User
+	}
+	if len(flags.KeyPaths) > 0 {
+		conn.KeyPaths = flags.KeyPaths
+		conn.KeyPathsRequired = true
+	}
+	if len(flags.KnownHostsPaths) > 0 {
+		conn.KnownHostsPaths = flags.KnownHostsPaths
+	}
+	if len(flags.JumpHosts) > 0 {
+		conn.JumpHosts = flags.JumpHosts
+	}
+	if flags.Password != "" {
+		conn.Password = flags.Password
+	}
+	conn.Become = flags.Become
+	conn.BecomeMethod = flags.BecomeMethod
+	conn.BecomePassword = flags.BecomePassword
+
+	for _, name := range sshArgsVars {
+		if args, ok := lookupVar(vars, []string{name}); ok {
+			if jumps, ok := jumpHostsFromArgs(args); ok {
+				conn.JumpHosts = jumps
+				break
+			}
+		}
+	}
+	if v, ok := lookupVar(vars, connectionVars.port); ok {
+		conn.Port = v
+	}
+	if v, ok := lookupVar(vars, connectionVars.user); ok {
+		conn.User = v
+	}
+	if v, ok := lookupVar(vars, connectionVars.keyPath); ok {
+		conn.KeyPaths = []string{expandHome(v)}
+		conn.KeyPathsRequired = true
+	}
+	if v, ok := lookupVar(vars, connectionVars.password); ok {
+		conn.Password = v
+	}
+	if v, ok := lookupVar(vars, connectionVars.become); ok {
+		conn.Become = isTrue(v)
+	}
+	if v, ok := lookupVar(vars, connectionVars.becomeMethod); ok {
+		conn.BecomeMethod = v
+	}
+	if v, ok := lookupVar(vars, connectionVars.becomePassword); ok {
+		conn.BecomePassword = v
+	}
+
+	return conn
+}
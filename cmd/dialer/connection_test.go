@@ -0,0 +1,240 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevinburke/ssh_config"
+
+	"github.com/mickael-carl/sophons/pkg/inventory"
+)
+
+func TestHostConnection(t *testing.T) {
+	inventoryData := []byte(`
+all:
+  hosts:
+    web1:
+      ansible_host: 10.0.0.1
+      ansible_port: 2222
+      ansible_become: yes
+      ansible_become_method: su
+      ansible_become_pass: s3cret
+    web2:
+      ansible_user: deploy
+      ansible_ssh_private_key_file: /keys/web2
+      ansible_password: hunter2
+    db1:
+      ansible_ssh_host: 10.0.1.1
+      ansible_ssh_common_args: "-o ProxyJump=ops@bastion:2222"
+  vars:
+    ansible_user: admin
+dbs:
+  hosts:
+    db1:
+  vars:
+    ansible_port: "2200"
+`)
+
+	var inv inventory.Inventory
+	if err := yaml.Unmarshal(inventoryData, &inv); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := connection{
+		User:     "root",
+		KeyPaths: []string{"/keys/default"},
+	}
+
+	sshCfg, err := ssh_config.Decode(strings.NewReader(`
+Host 10.0.1.1
+  Port 2201
+  User ignored
+
+Host web2
+  HostName web2.example.com
+  IdentityFile /keys/ignored
+  StrictHostKeyChecking accept-new
+  ProxyJump bastion1,bastion2
+  PreferredAuthentications keyboard-interactive,publickey
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want connection
+	}{
+		{
+			host: "web1",
+			want: connection{
+				Address:          "10.0.0.1",
+				Port:             "2222",
+				User:             "admin",
+				KeyPaths:         []string{"/keys/default"},
+				KeyPathsRequired: true,
+				KnownHostsPaths:  []string{expandHome("~/.ssh/known_hosts")},
+				Become:           true,
+				BecomeMethod:     "su",
+				BecomePassword:   "s3cret",
+			},
+		},
+		{
+			host: "web2",
+			want: connection{
+				Address:               "web2.example.com",
+				Port:                  "22",
+				User:                  "deploy",
+				KeyPaths:              []string{"/keys/web2"},
+				KeyPathsRequired:      true,
+				KnownHostsPaths:       []string{expandHome("~/.ssh/known_hosts")},
+				StrictHostKeyChecking: "accept-new",
+				JumpHosts:             []string{"bastion1", "bastion2"},
+				Password:              "hunter2",
+				PreferredAuthentications: []string{
+					"keyboard-interactive",
+					"publickey",
+				},
+			},
+		},
+		{
+			host: "db1",
+			want: connection{
+				Address:          "10.0.1.1",
+				Port:             "2200",
+				User:             "admin",
+				KeyPaths:         []string{"/keys/default"},
+				KeyPathsRequired: true,
+				KnownHostsPaths:  []string{expandHome("~/.ssh/known_hosts")},
+				JumpHosts:        []string{"ops@bastion:2222"},
+			},
+		},
+		{
+			host: "unknown",
+			want: connection{
+				Address:          "unknown",
+				Port:             "22",
+				User:             "root",
+				KeyPaths:         []string{"/keys/default"},
+				KeyPathsRequired: true,
+				KnownHostsPaths:  []string{expandHome("~/.ssh/known_hosts")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got := hostConnection(inv, tt.host, flags, openSSHConfig{sshCfg})
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestJumpHostsFromArgs(t *testing.T) {
+	tests := []struct {
+		args   string
+		want   []string
+		wantOK bool
+	}{
+		{args: "-J bastion", want: []string{"bastion"}, wantOK: true},
+		{args: "-Jops@bastion:2222", want: []string{"ops@bastion:2222"}, wantOK: true},
+		{args: "-o StrictHostKeyChecking=no -o ProxyJump=b1,b2", want: []string{"b1", "b2"}, wantOK: true},
+		{args: `-o "ProxyJump=bastion"`, want: []string{"bastion"}, wantOK: true},
+		{args: "-o ProxyJump=none", want: nil, wantOK: true},
+		{args: "-o ProxyCommand=nc -C", want: nil, wantOK: false},
+		{args: "", want: nil, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			got, ok := jumpHostsFromArgs(tt.args)
+			if ok != tt.wantOK {
+				t.Errorf("expected ok=%v, got %v", tt.wantOK, ok)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestJumpHostConnection(t *testing.T) {
+	sshCfg, err := ssh_config.Decode(strings.NewReader(`
+Host bastion
+  HostName bastion.example.com
+  User jumper
+  Port 2200
+  ProxyJump other
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := connection{
+		User:     "root",
+		KeyPaths: []string{"/keys/default"},
+	}
+
+	tests := []struct {
+		spec    string
+		want    connection
+		wantErr bool
+	}{
+		{
+			spec: "bastion",
+			want: connection{
+				Address:          "bastion.example.com",
+				Port:             "2200",
+				User:             "jumper",
+				KeyPaths:         []string{"/keys/default"},
+				KeyPathsRequired: true,
+				KnownHostsPaths:  []string{expandHome("~/.ssh/known_hosts")},
+				Password:         "hunter2",
+			},
+		},
+		{
+			spec: "ops@bastion:22",
+			want: connection{
+				Address:          "bastion.example.com",
+				Port:             "22",
+				User:             "ops",
+				KeyPaths:         []string{"/keys/default"},
+				KeyPathsRequired: true,
+				KnownHostsPaths:  []string{expandHome("~/.ssh/known_hosts")},
+				Password:         "hunter2",
+			},
+		},
+		{
+			spec: "[2001:db8::1]:2222",
+			want: connection{
+				Address:          "2001:db8::1",
+				Port:             "2222",
+				User:             "root",
+				KeyPaths:         []string{"/keys/default"},
+				KeyPathsRequired: true,
+				KnownHostsPaths:  []string{expandHome("~/.ssh/known_hosts")},
+				Password:         "hunter2",
+			},
+		},
+		{
+			spec:    "ops@",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := jumpHostConnection(tt.spec, flags, "hunter2", openSSHConfig{sshCfg})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
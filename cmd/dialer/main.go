@@ -1,62 +1,274 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"maps"
+	"net"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/goccy/go-yaml"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 
+	"github.com/mickael-carl/sophons/pkg/callback"
 	"github.com/mickael-carl/sophons/pkg/dialer"
 	"github.com/mickael-carl/sophons/pkg/inventory"
+	"github.com/mickael-carl/sophons/pkg/proto"
 )
 
 var (
 	username      = flag.String("u", "", "username to connect to hosts")
 	keyPath       = flag.String("k", "", "path to the SSH key to use")
 	inventoryPath = flag.String("i", "", "path to inventory file")
-	sshPort       = flag.String("p", "22", "port to use for SSH")
+	sshPort       = flag.String("p", "", "port to use for SSH (default 22)")
 	// TODO: we can use //go:embed here to embed all necessary binaries? That
 	// might make the dialer extremely large though: each executer binary is
 	// about 4.5MB right now, meaning a ~30MB binary total. It's not horrible
 	// but it's worth keeping in mind as binary size increases.
 	binDir         = flag.String("b", "", "dir containing executer binaries")
-	knownHostsPath = flag.String("known-hosts", os.ExpandEnv("$HOME/.ssh/known_hosts"), "path to the known hosts file")
+	knownHostsPath = flag.String("known-hosts", "", "path to the known hosts file (default ~/.ssh/known_hosts)")
+	sshConfigPath  = flag.String("F", "", "path to an OpenSSH client config file (default ~/.ssh/config and /etc/ssh/ssh_config)")
 	insecure       = flag.Bool("insecure", false, "whether to ignore hostkeys or not")
+	askPass        = flag.Bool("ask-pass", false, "ask for the SSH password to use for all hosts")
+	become         = flag.Bool("become", false, "run the executer with escalated privileges")
+	becomeMethod   = flag.String("become-method", "", "privilege escalation method to use: sudo, su or doas (default sudo)")
+	askBecomePass  = flag.Bool("ask-become-pass", false, "ask for the privilege escalation password to use for all hosts")
+	jumpHosts      = flag.String("J", "", "comma-separated list of jump hosts to connect through, as [user@]host[:port]")
+	forks          = flag.Int("forks", 5, "maximum number of hosts to run against in parallel")
+	tags           = flag.String("tags", "", "comma-separated tags of the tasks to run (default all)")
+	skipTags       = flag.String("skip-tags", "", "comma-separated tags of the tasks to skip")
+	check          = flag.Bool("check", false, "report what tasks would change, without changing anything")
+	diff           = flag.Bool("diff", false, "report the changes tasks make to files, packages and repositories")
+	verbosity      = callback.VerbosityFlags(flag.CommandLine)
+	callbackName   = flag.String("callback", "default", "callback reporting on the run: "+strings.Join(callback.Names(), ", "))
+	callbackOutput = flag.String("callback-output", "", "path to the file the callback writes to (default stdout)")
 )
 
-func sshConfig(insecure bool, u, k, knownHosts string) (*ssh.ClientConfig, error) {
-	key, err := os.ReadFile(k)
+// hostResult holds the outcome of running the executer against a single host.
+type hostResult struct {
+	Host   string
+	Output string
+	Err    error
+}
+
+// runHosts calls run for every host, with at most `forks` calls in flight at
+// any given time. A failure on one host doesn't prevent the others from
+// running. Results are returned in the same order as hosts.
+func runHosts(hosts []string, forks int, run func(host string) (string, error)) []hostResult {
+	if forks < 1 {
+		forks = 1
+	}
+
+	results := make([]hostResult, len(hosts))
+
+	var wg sync.WaitGroup
+	idxCh := make(chan int)
+
+	for range min(forks, len(hosts)) {
+		wg.Go(func() {
+			for i := range idxCh {
+				out, err := run(hosts[i])
+				results[i] = hostResult{
+					Host:   hosts[i],
+					Output: out,
+					Err:    err,
+				}
+			}
+		})
+	}
+
+	for i := range hosts {
+		idxCh <- i
+	}
+	close(idxCh)
+
+	wg.Wait()
+
+	return results
+}
+
+// runHost runs the executer against host, passing its events on to cb. It
+// returns whatever else the executer wrote, like its logs.
+func runHost(logger *zap.Logger, inv inventory.Inventory, sshCfg openSSHConfig, auth *authenticator, flags connection, host string, cb callback.Callback) (string, error) {
+	conn := hostConnection(inv, host, flags, sshCfg)
+
+	logger.Debug("connecting",
+		zap.String("host", host),
+		zap.String("address", conn.Address),
+		zap.String("port", conn.Port),
+		zap.String("user", conn.User),
+		zap.Strings("jump_hosts", conn.JumpHosts),
+	)
+
+	config, err := sshConfig(conn, auth, *insecure)
 	if err != nil {
-		return &ssh.ClientConfig{}, fmt.Errorf("failed reading private key %q: %v", k, err)
+		return "", fmt.Errorf("failed to create SSH config for user %q: %w", conn.User, err)
+	}
+
+	var jumps []dialer.Hop
+	for _, spec := range conn.JumpHosts {
+		jumpConn, err := jumpHostConnection(spec, flags, conn.Password, sshCfg)
+		if err != nil {
+			return "", err
+		}
+
+		jumpConfig, err := sshConfig(jumpConn, auth, *insecure)
+		if err != nil {
+			return "", fmt.Errorf("failed to create SSH config for jump host %s: %w", spec, err)
+		}
+
+		jumps = append(jumps, dialer.Hop{
+			Host:   jumpConn.Address,
+			Port:   jumpConn.Port,
+			Config: jumpConfig,
+		})
 	}
 
-	signer, err := ssh.ParsePrivateKey(key)
+	var become *dialer.Become
+	if conn.Become {
+		become = &dialer.Become{
+			Method:   conn.BecomeMethod,
+			Password: conn.BecomePassword,
+		}
+	}
+
+	var callbackErr error
+	opts := dialer.ExecuteOptions{
+		// The inventory name, and not the address, is what identifies the
+		// node for the executer.
+		Host:      host,
+		BinDir:    *binDir,
+		Inventory: *inventoryPath,
+		Playbook:  flag.Args()[0],
+		Become:    become,
+		Verbosity: *verbosity,
+		Tags:      splitFlag(*tags),
+		SkipTags:  splitFlag(*skipTags),
+		Check:     *check,
+		Diff:      *diff,
+		OnEvent: func(e *proto.Event) {
+			if err := callback.Dispatch(cb, host, e); err != nil && callbackErr == nil {
+				callbackErr = err
+			}
+		},
+	}
+
+	dialer, err := dialer.NewDialer(conn.Address, conn.Port, config, jumps...)
+	if err != nil {
+		return "", &callback.UnreachableError{Err: fmt.Errorf("failed to create dialer for %s:%s: %w", conn.Address, conn.Port, err)}
+	}
+	defer dialer.Close()
+
+	out, err := dialer.Execute(opts)
 	if err != nil {
-		return &ssh.ClientConfig{}, fmt.Errorf("failed parsing private key: %v", err)
+		return out, err
+	}
+	return out, callbackErr
+}
+
+// knownHostsMu serializes writes to known hosts files, since several hosts
+// can be connected to at the same time.
+var knownHostsMu sync.Mutex
+
+// splitFlag splits the value of a comma-separated flag, if set.
+func splitFlag(value string) []string {
+	if value == "" {
+		return nil
 	}
+	return strings.Split(value, ",")
+}
 
-	var hostKeyCallback ssh.HostKeyCallback
+func hostKeyCallback(conn connection, insecure bool) (ssh.HostKeyCallback, error) {
 	if insecure {
-		hostKeyCallback = ssh.InsecureIgnoreHostKey()
-	} else {
-		hostKeyCallback, err = knownhosts.New(knownHosts)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	// Like OpenSSH, ignore known hosts files that don't exist.
+	var paths []string
+	for _, p := range conn.KnownHostsPaths {
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("none of the known hosts files exist: %s", strings.Join(conn.KnownHostsPaths, ", "))
+	}
+
+	callback, err := knownhosts.New(paths...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create hostkey callback from %s: %v", strings.Join(paths, ", "), err)
+	}
+
+	switch conn.StrictHostKeyChecking {
+	case "no", "off", "accept-new":
+	default:
+		return callback, nil
+	}
+
+	// Unknown hosts get accepted and recorded, but a key that doesn't match
+	// the one on record is still an error.
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		f, err := os.OpenFile(paths[0], os.O_APPEND|os.O_WRONLY, 0o600)
 		if err != nil {
-			return &ssh.ClientConfig{}, fmt.Errorf("could not create hostkey callback from %s: %v", knownHosts, err)
+			return fmt.Errorf("failed to open %s to add host key: %w", paths[0], err)
+		}
+		defer f.Close()
+
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			return fmt.Errorf("failed to add host key to %s: %w", paths[0], err)
 		}
+		return nil
+	}, nil
+}
+
+// newLogger returns a logger writing human-readable logs to stderr. Debug logs,
+// with SSH connection details, are shown with `-vvvv`.
+func newLogger(verbosity int) (*zap.Logger, error) {
+	config := zap.NewProductionConfig()
+	config.Encoding = "console"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	config.DisableCaller = true
+	config.DisableStacktrace = true
+	if verbosity >= callback.MaxVerbosity {
+		config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	}
+	return config.Build()
+}
+
+func sshConfig(conn connection, auth *authenticator, insecure bool) (*ssh.ClientConfig, error) {
+	methods, err := auth.methods(conn)
+	if err != nil {
+		return &ssh.ClientConfig{}, err
+	}
+
+	hostKeyCallback, err := hostKeyCallback(conn, insecure)
+	if err != nil {
+		return &ssh.ClientConfig{}, err
 	}
 
 	return &ssh.ClientConfig{
-		User: u,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
+		User:            conn.User,
+		Auth:            methods,
 		HostKeyCallback: hostKeyCallback,
 		Timeout:         10 * time.Second,
 	}, nil
@@ -65,7 +277,7 @@ func sshConfig(insecure bool, u, k, knownHosts string) (*ssh.ClientConfig, error
 func main() {
 	flag.Parse()
 
-	logger, err := zap.NewProduction()
+	logger, err := newLogger(*verbosity)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create logger: %v", err))
 	}
@@ -94,24 +306,115 @@ func main() {
 	}
 	hosts := inventory.All()
 
-	config, err := sshConfig(*insecure, *username, *keyPath, *knownHostsPath)
+	sshConfigPaths := defaultSSHConfigPaths()
+	if *sshConfigPath != "" {
+		if _, err := os.Stat(*sshConfigPath); err != nil {
+			logger.Fatal("failed to read SSH config", zap.String("path", *sshConfigPath), zap.Error(err))
+		}
+		sshConfigPaths = []string{*sshConfigPath}
+	}
+	sshCfg, err := loadSSHConfig(sshConfigPaths...)
+	if err != nil {
+		logger.Fatal("failed to load SSH config", zap.Strings("paths", sshConfigPaths), zap.Error(err))
+	}
+
+	agentClient, agentConn := sshAgent()
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+	prompt, tty := terminalPrompt()
+	if tty != nil {
+		defer tty.Close()
+	}
+	auth := newAuthenticator(agentClient, prompt)
+
+	flags := connection{
+		Port:         *sshPort,
+		User:         *username,
+		Become:       *become,
+		BecomeMethod: *becomeMethod,
+	}
+	if *keyPath != "" {
+		flags.KeyPaths = []string{*keyPath}
+	}
+	if *knownHostsPath != "" {
+		flags.KnownHostsPaths = []string{*knownHostsPath}
+	}
+	if *jumpHosts != "" {
+		flags.JumpHosts = strings.Split(*jumpHosts, ",")
+	}
+
+	playBecome, playBecomeMethod, err := playbookBecome(flag.Args()[0])
 	if err != nil {
-		logger.Fatal("failed to create SSH config", zap.String("username", *username), zap.String("key_path", *keyPath), zap.Error(err))
+		logger.Fatal("failed to read playbook", zap.String("path", flag.Args()[0]), zap.Error(err))
+	}
+	flags.Become = flags.Become || playBecome
+	if flags.BecomeMethod == "" {
+		flags.BecomeMethod = playBecomeMethod
 	}
 
-	for host := range hosts {
-		dialer, err := dialer.NewDialer(host, *sshPort, config)
+	if *askPass {
+		flags.Password, err = auth.ask("SSH password: ", false)
+		if err != nil {
+			logger.Fatal("failed to read SSH password", zap.Error(err))
+		}
+	}
+	if *askBecomePass {
+		flags.BecomePassword, err = auth.ask("BECOME password: ", false)
 		if err != nil {
-			logger.Fatal("failed to create dialer", zap.String("endpoint", fmt.Sprintf("%s:%s", host, *sshPort)), zap.Error(err))
+			logger.Fatal("failed to read become password", zap.Error(err))
 		}
+	}
 
-		out, err := dialer.Execute(host, *binDir, *inventoryPath, flag.Args()[0])
-		// Output regardless of error: stderr is in `out` as well. Also close
-		// everything before crashing if needed.
-		fmt.Println(string(out))
-		dialer.Close()
+	output := os.Stdout
+	if *callbackOutput != "" {
+		output, err = os.Create(*callbackOutput)
 		if err != nil {
-			logger.Fatal("running sophons against host failed", zap.String("host", host), zap.Error(err))
+			logger.Fatal("failed to create callback output", zap.String("path", *callbackOutput), zap.Error(err))
+		}
+		defer output.Close()
+	}
+
+	cb, err := callback.New(*callbackName, callback.Options{
+		Output:    output,
+		Verbosity: *verbosity,
+	})
+	if err != nil {
+		logger.Fatal("failed to create callback", zap.Error(err))
+	}
+	cb = callback.Synchronized(cb)
+
+	// The executer's logs are only printed once a host is done so that they
+	// don't get interleaved with other hosts' logs.
+	var logsMu sync.Mutex
+	results := runHosts(slices.Sorted(maps.Keys(hosts)), *forks, func(host string) (string, error) {
+		out, err := runHost(logger, inventory, sshCfg, auth, flags, host, cb)
+		if err := cb.HostDone(host, err); err != nil {
+			logger.Error("failed to run host done callback", zap.String("host", host), zap.Error(err))
+		}
+
+		logsMu.Lock()
+		defer logsMu.Unlock()
+		// Output regardless of error: the executer's stderr is in `out`.
+		for line := range strings.Lines(out) {
+			fmt.Fprintf(os.Stderr, "[%s] %s", host, line)
+		}
+
+		return out, err
+	})
+
+	if err := cb.Done(); err != nil {
+		logger.Error("failed to run done callback", zap.Error(err))
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
 		}
 	}
+
+	if failed > 0 {
+		logger.Fatal("running sophons failed on some hosts", zap.Int("failed", failed), zap.Int("total", len(results)))
+	}
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRunHosts(t *testing.T) {
+	hosts := []string{"host1", "host2", "host3", "host4", "host5"}
+
+	var inFlight, maxInFlight atomic.Int32
+	got := runHosts(hosts, 2, func(host string) (string, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		if host == "host2" {
+			return "boom", errors.New("unreachable")
+		}
+		return "hello from " + host, nil
+	})
+
+	if m := maxInFlight.Load(); m > 2 {
+		t.Errorf("expected at most 2 hosts in flight, got %d", m)
+	}
+
+	expected := []hostResult{
+		{Host: "host1", Output: "hello from host1"},
+		{Host: "host2", Output: "boom", Err: errors.New("unreachable")},
+		{Host: "host3", Output: "hello from host3"},
+		{Host: "host4", Output: "hello from host4"},
+		{Host: "host5", Output: "hello from host5"},
+	}
+
+	if diff := cmp.Diff(expected, got, cmp.Comparer(func(a, b error) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Error() == b.Error()
+	}), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunHostsNoHosts(t *testing.T) {
+	got := runHosts(nil, 5, func(host string) (string, error) {
+		t.Errorf("unexpected call for host %s", host)
+		return "", nil
+	})
+
+	if len(got) != 0 {
+		t.Errorf("expected no results, got %v", got)
+	}
+}
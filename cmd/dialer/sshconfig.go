@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// openSSHConfig is a set of OpenSSH client configuration files. Just like for
+// OpenSSH, the first file that sets a value for a given host wins.
+type openSSHConfig []*ssh_config.Config
+
+// defaultSSHConfigPaths returns the paths OpenSSH loads its client
+// configuration from, in order of precedence.
+func defaultSSHConfigPaths() []string {
+	return []string{
+		expandHome("~/.ssh/config"),
+		"/etc/ssh/ssh_config",
+	}
+}
+
+// loadSSHConfig parses the OpenSSH client configuration files at paths.
+// Missing files are ignored.
+func loadSSHConfig(paths ...string) (openSSHConfig, error) {
+	var configs openSSHConfig
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		config, err := ssh_config.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH config %s: %w", p, err)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// get returns the first value for key in a Host block matching alias.
+func (c openSSHConfig) get(alias, key string) string {
+	for _, config := range c {
+		v, err := config.Get(alias, key)
+		if err == nil && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// getAll returns all the values for key in Host blocks matching alias.
+func (c openSSHConfig) getAll(alias, key string) []string {
+	var values []string
+	for _, config := range c {
+		v, err := config.GetAll(alias, key)
+		if err == nil {
+			values = append(values, v...)
+		}
+	}
+	return values
+}
+
+// apply overrides the connection parameters of conn with the ones set in the
+// SSH config for conn's address.
+func (c openSSHConfig) apply(conn connection) connection {
+	alias := conn.Address
+
+	if v := c.get(alias, "HostName"); v != "" {
+		conn.Address = strings.ReplaceAll(v, "%h", alias)
+	}
+	if v := c.get(alias, "Port"); v != "" {
+		conn.Port = v
+	}
+	if v := c.get(alias, "User"); v != "" {
+		conn.User = v
+	}
+	if v := c.getAll(alias, "IdentityFile"); len(v) > 0 {
+		conn.KeyPaths = nil
+		for _, p := range v {
+			conn.KeyPaths = append(conn.KeyPaths, expandHome(p))
+		}
+	}
+	if v := c.get(alias, "IdentitiesOnly"); v != "" {
+		conn.IdentitiesOnly = strings.EqualFold(v, "yes")
+	}
+	if v := c.get(alias, "UserKnownHostsFile"); v != "" {
+		conn.KnownHostsPaths = nil
+		for p := range strings.FieldsSeq(v) {
+			conn.KnownHostsPaths = append(conn.KnownHostsPaths, expandHome(p))
+		}
+	}
+	if v := c.get(alias, "StrictHostKeyChecking"); v != "" {
+		conn.StrictHostKeyChecking = strings.ToLower(v)
+	}
+	if v := c.get(alias, "ProxyJump"); v != "" {
+		conn.JumpHosts = nil
+		if !strings.EqualFold(v, "none") {
+			conn.JumpHosts = strings.Split(v, ",")
+		}
+	}
+	if v := c.get(alias, "PreferredAuthentications"); v != "" {
+		conn.PreferredAuthentications = strings.Split(v, ",")
+	}
+
+	return conn
+}
+
+// expandHome replaces a leading `~` in path with the current user's home
+// directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOpenSSHConfigApply(t *testing.T) {
+	dir := t.TempDir()
+
+	included := filepath.Join(dir, "included")
+	if err := os.WriteFile(included, []byte(`
+Host *.internal
+  User ops
+  IdentityFile /keys/internal
+  IdentityFile /keys/internal-fallback
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	userConfig := filepath.Join(dir, "config")
+	if err := os.WriteFile(userConfig, []byte(`
+Include `+included+`
+
+Host db?.internal
+  HostName %h.example.com
+  Port 2222
+  IdentitiesOnly yes
+  UserKnownHostsFile /hosts/one /hosts/two
+  StrictHostKeyChecking No
+
+Host *
+  User fallback
+  Port 22
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	systemConfig := filepath.Join(dir, "ssh_config")
+	if err := os.WriteFile(systemConfig, []byte(`
+Host *
+  User system
+  StrictHostKeyChecking yes
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshCfg, err := loadSSHConfig(userConfig, filepath.Join(dir, "missing"), systemConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		conn connection
+		want connection
+	}{
+		{
+			name: "pattern and include",
+			conn: connection{Address: "db1.internal", Port: "22"},
+			want: connection{
+				Address:               "db1.internal.example.com",
+				Port:                  "2222",
+				User:                  "ops",
+				KeyPaths:              []string{"/keys/internal", "/keys/internal-fallback"},
+				IdentitiesOnly:        true,
+				KnownHostsPaths:       []string{"/hosts/one", "/hosts/two"},
+				StrictHostKeyChecking: "no",
+			},
+		},
+		{
+			name: "wildcard only",
+			conn: connection{Address: "web1", Port: "2200"},
+			want: connection{
+				Address:               "web1",
+				Port:                  "22",
+				User:                  "fallback",
+				StrictHostKeyChecking: "yes",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sshCfg.apply(tt.conn)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
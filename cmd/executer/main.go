@@ -6,15 +6,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/goccy/go-yaml"
 	"github.com/nikolalohinski/gonja/v2"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
+	"github.com/mickael-carl/sophons/pkg/callback"
+	"github.com/mickael-carl/sophons/pkg/event"
 	"github.com/mickael-carl/sophons/pkg/exec"
 	"github.com/mickael-carl/sophons/pkg/inventory"
 	"github.com/mickael-carl/sophons/pkg/playbook"
+	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/role"
 	"github.com/mickael-carl/sophons/pkg/util"
 	"github.com/mickael-carl/sophons/pkg/variables"
@@ -25,8 +31,66 @@ var (
 	dataArchive      = flag.String("d", "", "path to data archive")
 	playbooksDirName = flag.String("p", "", "name of the directory containing playbooks")
 	node             = flag.String("n", "localhost", "name of the node to run the playbook against")
+	remoteUser       = flag.String("u", "", "user tasks run as when become isn't set, if the executer was escalated")
+	events           = flag.Bool("events", false, "write events for the dialer on stdout instead of running a callback")
+	callbackName     = flag.String("callback", "default", "callback reporting on the run: "+strings.Join(callback.Names(), ", "))
+	callbackOutput   = flag.String("callback-output", "", "path to the file the callback writes to (default stdout)")
+	tags             = flag.String("tags", "", "comma-separated tags of the tasks to run (default all)")
+	skipTags         = flag.String("skip-tags", "", "comma-separated tags of the tasks to skip")
+	check            = flag.Bool("check", false, "report what tasks would change, without changing anything")
+	diff             = flag.Bool("diff", false, "report the changes tasks make to files, packages and repositories")
+	verbosity        = callback.VerbosityFlags(flag.CommandLine)
 )
 
+// newLogger returns a logger writing human-readable logs to stderr. Debug logs
+// are shown from `-vvv`, and where they come from with `-vvvv`.
+func newLogger(verbosity int) (*zap.Logger, error) {
+	config := zap.NewProductionConfig()
+	config.Encoding = "console"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	config.DisableCaller = verbosity < callback.MaxVerbosity
+	config.DisableStacktrace = verbosity < callback.MaxVerbosity
+	if verbosity >= 3 {
+		config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	}
+	return config.Build()
+}
+
+// inventoryBecome returns the privilege escalation settings for a node, as
+// set by the `ansible_become*` inventory variables.
+func inventoryBecome(vars variables.Variables, remoteUser string) exec.Become {
+	b := exec.Become{RemoteUser: remoteUser}
+
+	switch v := vars["ansible_become"].(type) {
+	case bool:
+		b.Become = v
+	case string:
+		b.Become = slices.Contains([]string{"true", "yes", "on", "1"}, strings.ToLower(v))
+	}
+	if v, ok := vars["ansible_become_user"].(string); ok {
+		b.User = v
+	}
+
+	return b
+}
+
+// becomeContext applies the privilege escalation settings of a play or of one
+// of its roles on top of the ones in ctx.
+func becomeContext(ctx context.Context, become *bool, becomeUser, becomeMethod string) (context.Context, error) {
+	if err := exec.ValidateBecomeMethod(becomeMethod); err != nil {
+		return ctx, err
+	}
+
+	b, _ := exec.BecomeFromContext(ctx)
+	if become != nil {
+		b.Become = *become
+	}
+	if becomeUser != "" {
+		b.User = becomeUser
+	}
+	return exec.NewBecomeContext(ctx, b), nil
+}
+
 func playbookApply(ctx context.Context, logger *zap.Logger, playbookPath, node string, groups map[string]struct{}, roles map[string]role.Role, rolesDir string) error {
 	playbookData, err := os.ReadFile(playbookPath)
 	if err != nil {
@@ -59,11 +123,34 @@ func playbookApply(ctx context.Context, logger *zap.Logger, playbookPath, node s
 				playVars.Merge(fileVars)
 			}
 
+			if err := callback.PlayStart(ctx, &proto.PlayStart{
+				Name:  play.Name,
+				Hosts: play.Hosts,
+			}); err != nil {
+				logger.Warn("failed to run play start callback", zap.Error(err))
+			}
+
 			playCtx := variables.NewContext(ctx, playVars)
+			playCtx, err := becomeContext(playCtx, play.Become, play.BecomeUser, play.BecomeMethod)
+			if err != nil {
+				return fmt.Errorf("invalid become settings for play: %w", err)
+			}
+			playCtx, err = exec.EnvironmentContext(playCtx, play.Environment)
+			if err != nil {
+				return fmt.Errorf("invalid environment for play: %w", err)
+			}
+			playCtx = exec.NewTagsContext(playCtx, play.Tags)
+
+			handlers := exec.NewHandlers(logger)
+			playCtx = exec.NewHandlersContext(playCtx, handlers)
+			if err := handlers.Add(playCtx, play.Handlers, filepath.Dir(playbookPath), false); err != nil {
+				return fmt.Errorf("invalid handlers for play: %w", err)
+			}
 
 			// Ansible executes roles first, then tasks. See
 			// https://docs.ansible.com/ansible/latest/playbook_guide/playbooks_reuse_roles.html#using-roles-at-the-play-level.
-			for _, roleName := range play.Roles {
+			for _, playRole := range play.Roles {
+				roleName := playRole.Role
 				logger.Debug("executing role", zap.String("role", roleName))
 
 				role, ok := roles[roleName]
@@ -71,12 +158,22 @@ func playbookApply(ctx context.Context, logger *zap.Logger, playbookPath, node s
 					return fmt.Errorf("no such role: %s", roleName)
 				}
 
+				roleCtx, err := becomeContext(playCtx, playRole.Become, playRole.BecomeUser, playRole.BecomeMethod)
+				if err != nil {
+					return fmt.Errorf("invalid become settings for role %s: %w", roleName, err)
+				}
+				roleCtx, err = exec.EnvironmentContext(roleCtx, playRole.Environment)
+				if err != nil {
+					return fmt.Errorf("invalid environment for role %s: %w", roleName, err)
+				}
+				roleCtx = exec.NewTagsContext(roleCtx, playRole.Tags)
+
 				// Headsup: roles variables are *not* scoped to only the role
 				// itself. This means this call actually *has to mutate*
 				// playCtx, so that variables defined in a role can be used in
 				// subsequent ones as well as the rest of the play. Sorry
 				// Ansible but this is STUPID.
-				if err := role.Apply(playCtx, logger, filepath.Join(rolesDir, roleName)); err != nil {
+				if err := role.Apply(roleCtx, logger, filepath.Join(rolesDir, roleName)); err != nil {
 					return fmt.Errorf("failed to apply role %s: %w", roleName, err)
 				}
 			}
@@ -90,6 +187,11 @@ func playbookApply(ctx context.Context, logger *zap.Logger, playbookPath, node s
 					return fmt.Errorf("failed to execute task: %w", err)
 				}
 			}
+
+			// Notified handlers run at the end of the play.
+			if err := handlers.Flush(); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -99,7 +201,7 @@ func main() {
 	gonja.DefaultConfig.StrictUndefined = true
 	flag.Parse()
 
-	logger, err := zap.NewProduction()
+	logger, err := newLogger(*verbosity)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create logger: %v", err))
 	}
@@ -130,8 +232,46 @@ func main() {
 		groups = inventory.Find(*node)
 		vars = inventory.NodeVars(*node)
 	}
+	vars["ansible_check_mode"] = *check
+	vars["ansible_diff_mode"] = *diff
 
 	ctx := variables.NewContext(context.Background(), vars)
+	ctx = exec.NewBecomeContext(ctx, inventoryBecome(vars, *remoteUser))
+
+	onlyTags, err := proto.Tags(*tags)
+	if err != nil {
+		logger.Fatal("invalid tags", zap.Error(err))
+	}
+	skippedTags, err := proto.Tags(*skipTags)
+	if err != nil {
+		logger.Fatal("invalid tags to skip", zap.Error(err))
+	}
+	ctx = exec.NewTagSelectionContext(ctx, exec.TagSelection{Tags: onlyTags, SkipTags: skippedTags})
+	ctx = exec.NewCheckModeContext(ctx, *check)
+	ctx = exec.NewDiffModeContext(ctx, *diff)
+
+	// Logs go to stderr, leaving stdout for either the events the dialer
+	// reads, or the callback's output.
+	var cb callback.Callback = event.NewWriter(os.Stdout)
+	if !*events {
+		output := os.Stdout
+		if *callbackOutput != "" {
+			output, err = os.Create(*callbackOutput)
+			if err != nil {
+				logger.Fatal("failed to create callback output", zap.String("path", *callbackOutput), zap.Error(err))
+			}
+			defer output.Close()
+		}
+
+		cb, err = callback.New(*callbackName, callback.Options{
+			Output:    output,
+			Verbosity: *verbosity,
+		})
+		if err != nil {
+			logger.Fatal("failed to create callback", zap.Error(err))
+		}
+	}
+	ctx = callback.NewContext(ctx, cb, *node)
 
 	playbookDir := filepath.Dir(flag.Args()[0])
 	if *dataArchive != "" {
@@ -150,7 +290,14 @@ func main() {
 	}
 
 	playbookPath := flag.Args()[0]
-	if err := playbookApply(ctx, logger, playbookPath, *node, groups, roles, rolesDir); err != nil {
+	err = playbookApply(ctx, logger, playbookPath, *node, groups, roles, rolesDir)
+	if err := cb.HostDone(*node, err); err != nil {
+		logger.Warn("failed to run host done callback", zap.Error(err))
+	}
+	if err := cb.Done(); err != nil {
+		logger.Warn("failed to run done callback", zap.Error(err))
+	}
+	if err != nil {
 		logger.Fatal("failed to run playbook", zap.String("path", playbookPath), zap.Error(err))
 	}
 }
@@ -0,0 +1,199 @@
+// Package callback reports what happens during a playbook run: plays and tasks
+// starting, and the results of tasks. Like Ansible's callback plugins, several
+// implementations are available, and more can be registered.
+package callback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// Callback is notified of what happens during a playbook run, on every host.
+// Implementations don't need to be safe for concurrent use: see Synchronized.
+type Callback interface {
+	PlayStart(host string, play *proto.PlayStart) error
+	TaskStart(host string, task *proto.TaskStart) error
+	TaskResult(host string, result *proto.TaskResult) error
+	// HostDone is called once the run is over on host. err is what stopped
+	// it early, if anything did. It's an *UnreachableError if the host
+	// couldn't be connected to.
+	HostDone(host string, err error) error
+	// Done is called once the run is over on all hosts.
+	Done() error
+}
+
+// UnreachableError is the error a host's run stops with when it couldn't be
+// connected to.
+type UnreachableError struct {
+	Err error
+}
+
+func (e *UnreachableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+func isUnreachable(err error) bool {
+	var unreachable *UnreachableError
+	return errors.As(err, &unreachable)
+}
+
+// Options configures a callback.
+type Options struct {
+	// Output is where the callback writes its report.
+	Output io.Writer
+	// Verbosity is how much detail to report, from 0 to MaxVerbosity.
+	Verbosity int
+}
+
+// Factory creates a callback.
+type Factory func(Options) (Callback, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a callback available under the given name. Registering a
+// name twice replaces the previous callback.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Names returns the names of the registered callbacks, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return slices.Sorted(maps.Keys(registry))
+}
+
+// New creates the callback registered under the given name.
+func New(name string, opts Options) (Callback, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown callback %q, must be one of %v", name, Names())
+	}
+	return factory(opts)
+}
+
+// Dispatch calls the method of cb matching the event e, which happened on
+// host.
+func Dispatch(cb Callback, host string, e *proto.Event) error {
+	switch ev := e.GetEvent().(type) {
+	case *proto.Event_PlayStart:
+		return cb.PlayStart(host, ev.PlayStart)
+	case *proto.Event_TaskStart:
+		return cb.TaskStart(host, ev.TaskStart)
+	case *proto.Event_TaskResult:
+		return cb.TaskResult(host, ev.TaskResult)
+	default:
+		return fmt.Errorf("unknown event %T", ev)
+	}
+}
+
+// synchronized serializes the calls to a callback.
+type synchronized struct {
+	mu sync.Mutex
+	cb Callback
+}
+
+// Synchronized returns a callback forwarding calls to cb, one at a time, so
+// that it can be used for several hosts at once.
+func Synchronized(cb Callback) Callback {
+	return &synchronized{cb: cb}
+}
+
+func (s *synchronized) PlayStart(host string, play *proto.PlayStart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cb.PlayStart(host, play)
+}
+
+func (s *synchronized) TaskStart(host string, task *proto.TaskStart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cb.TaskStart(host, task)
+}
+
+func (s *synchronized) TaskResult(host string, result *proto.TaskResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cb.TaskResult(host, result)
+}
+
+func (s *synchronized) HostDone(host string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cb.HostDone(host, err)
+}
+
+func (s *synchronized) Done() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cb.Done()
+}
+
+var contextKey = &struct{ name string }{"callback"}
+
+// hostCallback is a callback along with the host it reports on.
+type hostCallback struct {
+	cb   Callback
+	host string
+}
+
+// NewContext returns a new context carrying cb, to report on what happens on
+// host.
+func NewContext(ctx context.Context, cb Callback, host string) context.Context {
+	return context.WithValue(ctx, contextKey, hostCallback{cb: cb, host: host})
+}
+
+// FromContext returns the callback carried by ctx and the host it reports on,
+// if any.
+func FromContext(ctx context.Context) (Callback, string, bool) {
+	hc, ok := ctx.Value(contextKey).(hostCallback)
+	return hc.cb, hc.host, ok
+}
+
+// PlayStart calls the PlayStart method of the callback carried by ctx. It
+// does nothing if there's none.
+func PlayStart(ctx context.Context, play *proto.PlayStart) error {
+	cb, host, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return cb.PlayStart(host, play)
+}
+
+// TaskStart calls the TaskStart method of the callback carried by ctx. It
+// does nothing if there's none.
+func TaskStart(ctx context.Context, task *proto.TaskStart) error {
+	cb, host, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return cb.TaskStart(host, task)
+}
+
+// TaskResult calls the TaskResult method of the callback carried by ctx. It
+// does nothing if there's none.
+func TaskResult(ctx context.Context, result *proto.TaskResult) error {
+	cb, host, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return cb.TaskResult(host, result)
+}
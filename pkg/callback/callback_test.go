@@ -0,0 +1,78 @@
+package callback
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// recorder is a Callback keeping the hosts and names of the tasks it's told
+// about.
+type recorder struct {
+	tasks []string
+}
+
+func (r *recorder) PlayStart(string, *proto.PlayStart) error {
+	return nil
+}
+
+func (r *recorder) TaskStart(host string, task *proto.TaskStart) error {
+	r.tasks = append(r.tasks, host+": "+task.GetName())
+	return nil
+}
+
+func (r *recorder) TaskResult(string, *proto.TaskResult) error {
+	return nil
+}
+
+func (r *recorder) HostDone(string, error) error {
+	return nil
+}
+
+func (r *recorder) Done() error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := &recorder{}
+	Register("test", func(Options) (Callback, error) {
+		return r, nil
+	})
+
+	for _, name := range []string{"default", "json", "junit", "test"} {
+		cb, err := New(name, Options{Output: &strings.Builder{}})
+		if err != nil {
+			t.Errorf("failed to create callback %s: %v", name, err)
+			continue
+		}
+		if name == "test" && cb != r {
+			t.Errorf("expected the registered callback, got %v", cb)
+		}
+	}
+
+	if _, err := New("nope", Options{}); err == nil {
+		t.Error("expected an error for an unknown callback")
+	}
+}
+
+func TestContext(t *testing.T) {
+	task := &proto.TaskStart{Name: "task"}
+
+	if err := TaskStart(context.Background(), task); err != nil {
+		t.Errorf("expected no error without a callback, got %v", err)
+	}
+
+	r := &recorder{}
+	ctx := NewContext(context.Background(), Synchronized(r), "web1")
+	if err := TaskStart(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"web1: task"}, r.tasks); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
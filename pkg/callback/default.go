@@ -0,0 +1,298 @@
+package callback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func init() {
+	Register("default", func(opts Options) (Callback, error) {
+		return NewDefault(opts.Output, opts.Verbosity), nil
+	})
+}
+
+// Default writes events in the same format Ansible's default output does,
+// and ends with a PLAY RECAP.
+//
+// The output of a host is written as it comes, but only for one host at a
+// time: the output of the other hosts is held back until that host is done,
+// so that it doesn't get interleaved.
+//
+// The verbosity sets how much of the task results is shown:
+//   - 0: only the results of failed tasks,
+//   - 1 (`-v`): the results of all tasks,
+//   - 2 (`-vv`) and up: the module run by each task as well.
+type Default struct {
+	w         io.Writer
+	verbosity int
+	stats     map[string]Stats
+
+	// current is the host whose output is being written as it comes.
+	current string
+	// pending holds the hosts with output held back, in the order they
+	// started.
+	pending []string
+	held    map[string]*bytes.Buffer
+	done    map[string]bool
+}
+
+func NewDefault(w io.Writer, verbosity int) *Default {
+	return &Default{
+		w:         w,
+		verbosity: verbosity,
+		stats:     map[string]Stats{},
+		held:      map[string]*bytes.Buffer{},
+		done:      map[string]bool{},
+	}
+}
+
+// write writes s, or holds it back if another host's output is being
+// written.
+func (d *Default) write(host, s string) error {
+	if d.current == "" {
+		d.current = host
+	}
+	if host == d.current {
+		_, err := io.WriteString(d.w, s)
+		return err
+	}
+
+	buf, ok := d.held[host]
+	if !ok {
+		buf = &bytes.Buffer{}
+		d.held[host] = buf
+		d.pending = append(d.pending, host)
+	}
+	buf.WriteString(s)
+	return nil
+}
+
+// next writes the output held back for the hosts that are done, in order,
+// up to the first one that isn't, which becomes the current host.
+func (d *Default) next() error {
+	d.current = ""
+	for len(d.pending) > 0 {
+		host := d.pending[0]
+		d.pending = d.pending[1:]
+
+		buf := d.held[host]
+		delete(d.held, host)
+		if _, err := d.w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+
+		if !d.done[host] {
+			d.current = host
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *Default) PlayStart(host string, play *proto.PlayStart) error {
+	name := play.GetName()
+	if name == "" {
+		name = play.GetHosts()
+	}
+	return d.write(host, banner(fmt.Sprintf("PLAY [%s]", name)))
+}
+
+func (d *Default) TaskStart(host string, task *proto.TaskStart) error {
+	title := "TASK"
+	if task.GetHandler() {
+		title = "RUNNING HANDLER"
+	}
+	s := banner(fmt.Sprintf("%s [%s]", title, taskName(task.GetName(), task.GetModule())))
+	if d.verbosity >= 2 {
+		s += fmt.Sprintf("module: %s\n", task.GetModule())
+	}
+	return d.write(host, s)
+}
+
+func (d *Default) TaskResult(host string, result *proto.TaskResult) error {
+	s := d.stats[host]
+	s.Record(result)
+	d.stats[host] = s
+	return d.write(host, d.formatResult(host, result))
+}
+
+// HostDone shows why the run on host stopped early, unless it's because a
+// task failed, which was shown already.
+func (d *Default) HostDone(host string, err error) error {
+	s := d.stats[host]
+	report := s.RecordHostDone(err)
+	d.stats[host] = s
+
+	var line string
+	switch {
+	case !report:
+	case isUnreachable(err):
+		line = fmt.Sprintf("fatal: [%s]: UNREACHABLE! => %s\n", host, resultJSON(map[string]any{
+			"msg":         err.Error(),
+			"unreachable": true,
+		}))
+	default:
+		line = fmt.Sprintf("fatal: [%s]: FAILED! => %s\n", host, resultJSON(map[string]any{
+			"msg": err.Error(),
+		}))
+	}
+
+	if err := d.write(host, line); err != nil {
+		return err
+	}
+
+	d.done[host] = true
+	if host != d.current {
+		return nil
+	}
+	return d.next()
+}
+
+// Done writes the output still held back, and the PLAY RECAP.
+func (d *Default) Done() error {
+	for _, host := range slices.Clone(d.pending) {
+		d.done[host] = true
+	}
+	if err := d.next(); err != nil {
+		return err
+	}
+	return WriteRecap(d.w, d.stats)
+}
+
+// taskName returns the name tasks are shown with: their own, or the module
+// they run if they have none.
+func taskName(name, module string) string {
+	if name == "" {
+		return module
+	}
+	return name
+}
+
+// formatResult renders a task result as Ansible does, e.g. `changed: [host]`,
+// after the changes it made in diff mode.
+func (d *Default) formatResult(host string, r *proto.TaskResult) string {
+	if items := loopItems(r); len(items) > 0 {
+		return d.formatLoopResult(host, r, items)
+	}
+
+	if r.GetFailed() {
+		line := fmt.Sprintf("fatal: [%s]: FAILED! => %s\n", host, resultJSON(resultMap(r)))
+		if r.GetIgnored() {
+			line += "...ignoring\n"
+		}
+		return line
+	}
+
+	var status string
+	switch {
+	case r.GetSkipped():
+		status = "skipping"
+	case r.GetChanged():
+		status = "changed"
+	default:
+		status = "ok"
+	}
+
+	diffs := formatDiffs(resultMap(r))
+	if d.verbosity < 1 {
+		return diffs + fmt.Sprintf("%s: [%s]\n", status, host)
+	}
+	return diffs + fmt.Sprintf("%s: [%s] => %s\n", status, host, resultJSON(resultMap(r)))
+}
+
+// formatLoopResult renders the result of a loop as Ansible does: a line per
+// item, e.g. `changed: [host] => (item=foo)`.
+func (d *Default) formatLoopResult(host string, r *proto.TaskResult, items []map[string]any) string {
+	var b strings.Builder
+	failedItem := false
+	for _, item := range items {
+		label := itemLabel(item)
+
+		var status string
+		switch {
+		case item["failed"] == true:
+			failedItem = true
+			fmt.Fprintf(&b, "failed: [%s] (item=%s) => %s\n", host, label, resultJSON(item))
+			continue
+		case item["skipped"] == true:
+			status = "skipping"
+		case item["changed"] == true:
+			status = "changed"
+		default:
+			status = "ok"
+		}
+
+		b.WriteString(formatDiffs(item))
+		if d.verbosity < 1 {
+			fmt.Fprintf(&b, "%s: [%s] => (item=%s)\n", status, host, label)
+		} else {
+			fmt.Fprintf(&b, "%s: [%s] => (item=%s) => %s\n", status, host, label, resultJSON(item))
+		}
+	}
+
+	// The loop can fail without any of its items failing, e.g. if it isn't
+	// a list.
+	if r.GetFailed() && !failedItem {
+		fmt.Fprintf(&b, "fatal: [%s]: FAILED! => %s\n", host, resultJSON(resultMap(r)))
+	}
+	if r.GetIgnored() {
+		b.WriteString("...ignoring\n")
+	}
+	return b.String()
+}
+
+// loopItems returns the results of the items of a loop, if r is the result
+// of one.
+func loopItems(r *proto.TaskResult) []map[string]any {
+	var items []map[string]any
+	for _, v := range r.GetResult().GetFields()["results"].GetListValue().GetValues() {
+		item := v.GetStructValue().AsMap()
+		if _, ok := item["ansible_loop_var"]; !ok {
+			return nil
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// itemLabel returns how an item is shown: its label if it has one, or the
+// item itself.
+func itemLabel(item map[string]any) string {
+	if label, ok := item["_ansible_item_label"].(string); ok {
+		return label
+	}
+	loopVar, _ := item["ansible_loop_var"].(string)
+	if s, ok := item[loopVar].(string); ok {
+		return s
+	}
+	return resultJSON(item[loopVar])
+}
+
+// resultMap returns the fields of a task result, as they would be registered.
+func resultMap(r *proto.TaskResult) map[string]any {
+	m := r.GetResult().AsMap()
+	m["changed"] = r.GetChanged()
+	if r.GetFailed() {
+		m["failed"] = true
+	}
+	if r.GetMsg() != "" {
+		m["msg"] = r.GetMsg()
+	}
+	return m
+}
+
+func resultJSON(v any) string {
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,272 @@
+package callback
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// run makes cb go through a run on web1 with a changed, a skipped and a
+// failed task.
+func run(t *testing.T, cb Callback) {
+	t.Helper()
+
+	result, err := structpb.NewStruct(map[string]any{"rc": 0, "stdout": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []*proto.Event{
+		{Event: &proto.Event_PlayStart{PlayStart: &proto.PlayStart{Hosts: "all"}}},
+		{Event: &proto.Event_TaskStart{TaskStart: &proto.TaskStart{Name: "say hi", Module: "command"}}},
+		{Event: &proto.Event_TaskResult{TaskResult: &proto.TaskResult{Name: "say hi", Module: "command", Changed: true, Stdout: "hi", Result: result}}},
+		{Event: &proto.Event_TaskStart{TaskStart: &proto.TaskStart{Module: "file"}}},
+		{Event: &proto.Event_TaskResult{TaskResult: &proto.TaskResult{Module: "file", Skipped: true}}},
+		{Event: &proto.Event_TaskResult{TaskResult: &proto.TaskResult{Module: "command", Failed: true, Msg: "boom"}}},
+	} {
+		if err := Dispatch(cb, "web1", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cb.HostDone("web1", errors.New("exit status 1")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		verbosity int
+		expected  string
+	}{
+		{
+			name: "default",
+			expected: `
+PLAY [all] *********************************************************************
+
+TASK [say hi] ******************************************************************
+changed: [web1]
+
+TASK [file] ********************************************************************
+skipping: [web1]
+fatal: [web1]: FAILED! => {"changed":false,"failed":true,"msg":"boom"}
+
+PLAY RECAP *********************************************************************
+web1                       : ok=1    changed=1    unreachable=0    failed=1    skipped=1    rescued=0    ignored=0
+`,
+		},
+		{
+			name:      "verbose",
+			verbosity: 2,
+			expected: `
+PLAY [all] *********************************************************************
+
+TASK [say hi] ******************************************************************
+module: command
+changed: [web1] => {"changed":true,"rc":0,"stdout":"hi"}
+
+TASK [file] ********************************************************************
+module: file
+skipping: [web1] => {"changed":false}
+fatal: [web1]: FAILED! => {"changed":false,"failed":true,"msg":"boom"}
+
+PLAY RECAP *********************************************************************
+web1                       : ok=1    changed=1    unreachable=0    failed=1    skipped=1    rescued=0    ignored=0
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			d := NewDefault(&out, tt.verbosity)
+			run(t, d)
+			if err := d.Done(); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expected, out.String()); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDefaultHosts(t *testing.T) {
+	var out strings.Builder
+	d := NewDefault(&out, 0)
+
+	steps := []func() error{
+		func() error { return d.PlayStart("web1", &proto.PlayStart{Name: "one"}) },
+		func() error { return d.PlayStart("web2", &proto.PlayStart{Name: "two"}) },
+		func() error { return d.HostDone("db1", &UnreachableError{Err: errors.New("connection refused")}) },
+		func() error { return d.PlayStart("web3", &proto.PlayStart{Name: "three"}) },
+		func() error { return d.HostDone("web1", nil) },
+		func() error { return d.HostDone("web3", errors.New("invalid playbook")) },
+		func() error { return d.Done() },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Hosts' output isn't interleaved, and shows up in the order they
+	// started.
+	expected := `
+PLAY [one] *********************************************************************
+
+PLAY [two] *********************************************************************
+fatal: [db1]: UNREACHABLE! => {"msg":"connection refused","unreachable":true}
+
+PLAY [three] *******************************************************************
+fatal: [web3]: FAILED! => {"msg":"invalid playbook"}
+
+PLAY RECAP *********************************************************************
+db1                        : ok=0    changed=0    unreachable=1    failed=0    skipped=0    rescued=0    ignored=0
+web1                       : ok=0    changed=0    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+web3                       : ok=0    changed=0    unreachable=0    failed=1    skipped=0    rescued=0    ignored=0
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDefaultIgnored(t *testing.T) {
+	var out strings.Builder
+	d := NewDefault(&out, 0)
+
+	for _, e := range []*proto.Event{
+		{Event: &proto.Event_PlayStart{PlayStart: &proto.PlayStart{Hosts: "all"}}},
+		{Event: &proto.Event_TaskStart{TaskStart: &proto.TaskStart{Name: "check", Module: "command"}}},
+		{Event: &proto.Event_TaskResult{TaskResult: &proto.TaskResult{Name: "check", Module: "command", Failed: true, Ignored: true, Msg: "boom"}}},
+	} {
+		if err := Dispatch(d, "web1", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.HostDone("web1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+PLAY [all] *********************************************************************
+
+TASK [check] *******************************************************************
+fatal: [web1]: FAILED! => {"changed":false,"failed":true,"msg":"boom"}
+...ignoring
+
+PLAY RECAP *********************************************************************
+web1                       : ok=1    changed=0    unreachable=0    failed=0    skipped=0    rescued=0    ignored=1
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDefaultLoop(t *testing.T) {
+	result, err := structpb.NewStruct(map[string]any{
+		"results": []any{
+			map[string]any{"changed": true, "item": "foo", "ansible_loop_var": "item"},
+			map[string]any{"skipped": true, "item": map[string]any{"key": "a"}, "ansible_loop_var": "item"},
+			map[string]any{"failed": true, "msg": "boom", "pkg": "baz", "ansible_loop_var": "pkg", "_ansible_item_label": "the baz"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	d := NewDefault(&out, 0)
+	for _, e := range []*proto.Event{
+		{Event: &proto.Event_PlayStart{PlayStart: &proto.PlayStart{Hosts: "all"}}},
+		{Event: &proto.Event_TaskStart{TaskStart: &proto.TaskStart{Name: "install", Module: "apt"}}},
+		{Event: &proto.Event_TaskResult{TaskResult: &proto.TaskResult{Name: "install", Module: "apt", Changed: true, Failed: true, Result: result}}},
+	} {
+		if err := Dispatch(d, "web1", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.HostDone("web1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+PLAY [all] *********************************************************************
+
+TASK [install] *****************************************************************
+changed: [web1] => (item=foo)
+skipping: [web1] => (item={"key":"a"})
+failed: [web1] (item=the baz) => {"_ansible_item_label":"the baz","ansible_loop_var":"pkg","failed":true,"msg":"boom","pkg":"baz"}
+
+PLAY RECAP *********************************************************************
+web1                       : ok=0    changed=0    unreachable=0    failed=1    skipped=0    rescued=0    ignored=0
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDefaultDiff(t *testing.T) {
+	result, err := structpb.NewStruct(map[string]any{
+		"diff": []any{
+			map[string]any{
+				"before":        "listen 80\n",
+				"after":         "listen 8080\n",
+				"before_header": "/etc/nginx.conf",
+				"after_header":  "/etc/nginx.conf",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	d := NewDefault(&out, 0)
+	for _, e := range []*proto.Event{
+		{Event: &proto.Event_PlayStart{PlayStart: &proto.PlayStart{Hosts: "all"}}},
+		{Event: &proto.Event_TaskStart{TaskStart: &proto.TaskStart{Name: "configure", Module: "template"}}},
+		{Event: &proto.Event_TaskResult{TaskResult: &proto.TaskResult{Name: "configure", Module: "template", Changed: true, Result: result}}},
+	} {
+		if err := Dispatch(d, "web1", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.HostDone("web1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+PLAY [all] *********************************************************************
+
+TASK [configure] ***************************************************************
+--- before: /etc/nginx.conf
++++ after: /etc/nginx.conf
+@@ -1 +1 @@
+-listen 80
++listen 8080
+changed: [web1]
+
+PLAY RECAP *********************************************************************
+web1                       : ok=1    changed=1    unreachable=0    failed=0    skipped=0    rescued=0    ignored=0
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
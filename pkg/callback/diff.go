@@ -0,0 +1,202 @@
+package callback
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// diffContext is how many unchanged lines are shown around changes.
+const diffContext = 3
+
+// formatDiffs renders the diffs in a task result, in diff mode, as unified
+// diffs.
+func formatDiffs(result map[string]any) string {
+	diffs, _ := result["diff"].([]any)
+
+	var b strings.Builder
+	for _, v := range diffs {
+		diff, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		before, _ := diff["before"].(string)
+		after, _ := diff["after"].(string)
+
+		fromFile, toFile := "before", "after"
+		if header, ok := diff["before_header"].(string); ok && header != "" {
+			fromFile += ": " + header
+		}
+		if header, ok := diff["after_header"].(string); ok && header != "" {
+			toFile += ": " + header
+		}
+
+		b.WriteString(unifiedDiff(before, after, fromFile, toFile))
+	}
+	return b.String()
+}
+
+// diffOp is a line of a diff: kept (' '), removed ('-') or added ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the unified diff going from before to after, as
+// Python's difflib does it, or nothing if they're the same.
+func unifiedDiff(before, after, fromFile, toFile string) string {
+	ops := diffLines(diffSplitLines(before), diffSplitLines(after))
+
+	// beforeLine and afterLine are the lines of before and after each op
+	// starts at.
+	beforeLine := make([]int, len(ops)+1)
+	afterLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		beforeLine[i+1], afterLine[i+1] = beforeLine[i], afterLine[i]
+		if op.kind != '+' {
+			beforeLine[i+1]++
+		}
+		if op.kind != '-' {
+			afterLine[i+1]++
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk goes on for as long as changes are close enough for their
+		// context to overlap.
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+
+		start, stop := max(i-diffContext, 0), min(end+diffContext, len(ops))
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromFile, toFile)
+		}
+		fmt.Fprintf(
+			&b,
+			"@@ -%s +%s @@\n",
+			diffRange(beforeLine[start], beforeLine[stop]),
+			diffRange(afterLine[start], afterLine[stop]),
+		)
+		for _, op := range ops[start:stop] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// diffSplitLines splits s in lines, keeping their line feed. A last line
+// without one is marked as such.
+func diffSplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file\n"
+	return lines
+}
+
+// diffRange renders the lines from start to stop in a hunk header.
+func diffRange(start, stop int) string {
+	switch length := stop - start; length {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, length)
+	}
+}
+
+// diffLines returns the shortest edit script going from a to b, using Myers'
+// algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+
+	// v holds, for each diagonal k, how far in a the furthest path along it
+	// got. trace holds v as it was before each step, to go back along the
+	// path found.
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+
+	slices.Reverse(ops)
+	return ops
+}
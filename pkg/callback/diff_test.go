@@ -0,0 +1,133 @@
+package callback
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// numberedLines returns the numbers from 1 to n, one per line, with
+// replacements for some of them.
+func numberedLines(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		line, ok := replace[i]
+		if !ok {
+			line = strings.Repeat("x", i)
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		expected string
+	}{
+		{
+			name:   "same",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+		},
+		{
+			name:  "created",
+			after: "a\nb\n",
+			expected: `--- before
++++ after
+@@ -0,0 +1,2 @@
++a
++b
+`,
+		},
+		{
+			name:   "removed",
+			before: "a\n",
+			expected: `--- before
++++ after
+@@ -1 +0,0 @@
+-a
+`,
+		},
+		{
+			name:   "context",
+			before: numberedLines(10, nil),
+			after:  numberedLines(10, map[int]string{5: "five"}),
+			expected: `--- before
++++ after
+@@ -2,7 +2,7 @@
+ xx
+ xxx
+ xxxx
+-xxxxx
++five
+ xxxxxx
+ xxxxxxx
+ xxxxxxxx
+`,
+		},
+		{
+			name:   "hunks",
+			before: numberedLines(10, nil),
+			after:  numberedLines(10, map[int]string{1: "one", 10: "ten"}),
+			expected: `--- before
++++ after
+@@ -1,4 +1,4 @@
+-x
++one
+ xx
+ xxx
+ xxxx
+@@ -7,4 +7,4 @@
+ xxxxxxx
+ xxxxxxxx
+ xxxxxxxxx
+-xxxxxxxxxx
++ten
+`,
+		},
+		{
+			name:   "merged hunks",
+			before: numberedLines(8, nil),
+			after:  numberedLines(8, map[int]string{1: "one", 8: "eight"}),
+			expected: `--- before
++++ after
+@@ -1,8 +1,8 @@
+-x
++one
+ xx
+ xxx
+ xxxx
+ xxxxx
+ xxxxxx
+ xxxxxxx
+-xxxxxxxx
++eight
+`,
+		},
+		{
+			name:   "no newline at end",
+			before: "a\n",
+			after:  "a\nb",
+			expected: `--- before
++++ after
+@@ -1 +1,2 @@
+ a
++b
+\ No newline at end of file
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff(tt.before, tt.after, "before", "after")
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("unifiedDiff mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package callback
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func init() {
+	Register("json", func(opts Options) (Callback, error) {
+		return NewJSON(opts.Output), nil
+	})
+}
+
+// JSON writes a single JSON document once the run is over, holding the plays
+// and task results of every host, and their stats.
+type JSON struct {
+	w     io.Writer
+	hosts map[string]*jsonHost
+	stats map[string]Stats
+}
+
+type jsonHost struct {
+	Plays []*jsonPlay `json:"plays"`
+	// Error is what stopped the run on the host early, unless a task failed.
+	Error string `json:"error,omitempty"`
+}
+
+type jsonPlay struct {
+	Name  string      `json:"name"`
+	Hosts string      `json:"hosts"`
+	Tasks []*jsonTask `json:"tasks"`
+}
+
+type jsonTask struct {
+	Name    string         `json:"name"`
+	Module  string         `json:"module"`
+	Changed bool           `json:"changed"`
+	Failed  bool           `json:"failed"`
+	Skipped bool           `json:"skipped"`
+	Result  map[string]any `json:"result"`
+}
+
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{
+		w:     w,
+		hosts: map[string]*jsonHost{},
+		stats: map[string]Stats{},
+	}
+}
+
+func (j *JSON) host(name string) *jsonHost {
+	h, ok := j.hosts[name]
+	if !ok {
+		h = &jsonHost{Plays: []*jsonPlay{}}
+		j.hosts[name] = h
+	}
+	return h
+}
+
+// play returns the play currently running on host. Tasks run outside of any
+// play, which only happens when embedding sophons, get one with no name.
+func (j *JSON) play(host string) *jsonPlay {
+	h := j.host(host)
+	if len(h.Plays) == 0 {
+		h.Plays = append(h.Plays, &jsonPlay{Tasks: []*jsonTask{}})
+	}
+	return h.Plays[len(h.Plays)-1]
+}
+
+func (j *JSON) PlayStart(host string, play *proto.PlayStart) error {
+	h := j.host(host)
+	h.Plays = append(h.Plays, &jsonPlay{
+		Name:  play.GetName(),
+		Hosts: play.GetHosts(),
+		Tasks: []*jsonTask{},
+	})
+	return nil
+}
+
+func (j *JSON) TaskStart(string, *proto.TaskStart) error {
+	return nil
+}
+
+func (j *JSON) TaskResult(host string, result *proto.TaskResult) error {
+	s := j.stats[host]
+	s.Record(result)
+	j.stats[host] = s
+
+	p := j.play(host)
+	p.Tasks = append(p.Tasks, &jsonTask{
+		Name:    result.GetName(),
+		Module:  result.GetModule(),
+		Changed: result.GetChanged(),
+		Failed:  result.GetFailed(),
+		Skipped: result.GetSkipped(),
+		Result:  resultMap(result),
+	})
+	return nil
+}
+
+func (j *JSON) HostDone(host string, err error) error {
+	h := j.host(host)
+	s := j.stats[host]
+	if s.RecordHostDone(err) {
+		h.Error = err.Error()
+	}
+	j.stats[host] = s
+	return nil
+}
+
+// Done writes the JSON document.
+func (j *JSON) Done() error {
+	enc := json.NewEncoder(j.w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		Hosts map[string]*jsonHost `json:"hosts"`
+		Stats map[string]Stats     `json:"stats"`
+	}{
+		Hosts: j.hosts,
+		Stats: j.stats,
+	}); err != nil {
+		return fmt.Errorf("failed to write JSON report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package callback
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSON(t *testing.T) {
+	var out strings.Builder
+	j := NewJSON(&out)
+	run(t, j)
+	if err := j.HostDone("db1", &UnreachableError{Err: errors.New("connection refused")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+  "hosts": {
+    "db1": {
+      "plays": [],
+      "error": "connection refused"
+    },
+    "web1": {
+      "plays": [
+        {
+          "name": "",
+          "hosts": "all",
+          "tasks": [
+            {
+              "name": "say hi",
+              "module": "command",
+              "changed": true,
+              "failed": false,
+              "skipped": false,
+              "result": {
+                "changed": true,
+                "rc": 0,
+                "stdout": "hi"
+              }
+            },
+            {
+              "name": "",
+              "module": "file",
+              "changed": false,
+              "failed": false,
+              "skipped": true,
+              "result": {
+                "changed": false
+              }
+            },
+            {
+              "name": "",
+              "module": "command",
+              "changed": false,
+              "failed": true,
+              "skipped": false,
+              "result": {
+                "changed": false,
+                "failed": true,
+                "msg": "boom"
+              }
+            }
+          ]
+        }
+      ]
+    }
+  },
+  "stats": {
+    "db1": {
+      "ok": 0,
+      "changed": 0,
+      "unreachable": 1,
+      "failed": 0,
+      "skipped": 0,
+      "rescued": 0,
+      "ignored": 0
+    },
+    "web1": {
+      "ok": 1,
+      "changed": 1,
+      "unreachable": 0,
+      "failed": 1,
+      "skipped": 1,
+      "rescued": 0,
+      "ignored": 0
+    }
+  }
+}
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
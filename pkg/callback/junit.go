@@ -0,0 +1,174 @@
+package callback
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func init() {
+	Register("junit", func(opts Options) (Callback, error) {
+		return NewJUnit(opts.Output), nil
+	})
+}
+
+// JUnit writes a JUnit XML report once the run is over, with a test suite per
+// host and a test case per task. Failed tasks, unless their errors are
+// ignored, and hosts that stopped early for any other reason, are reported as
+// failures.
+type JUnit struct {
+	w      io.Writer
+	suites map[string]*junitSuite
+	// plays holds the name of the play currently running on each host.
+	plays map[string]string
+	stats map[string]Stats
+}
+
+type junitSuites struct {
+	XMLName  xml.Name      `xml:"testsuites"`
+	Name     string        `xml:"name,attr"`
+	Tests    int           `xml:"tests,attr"`
+	Failures int           `xml:"failures,attr"`
+	Errors   int           `xml:"errors,attr"`
+	Skipped  int           `xml:"skipped,attr"`
+	Suites   []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Cases    []*junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func NewJUnit(w io.Writer) *JUnit {
+	return &JUnit{
+		w:      w,
+		suites: map[string]*junitSuite{},
+		plays:  map[string]string{},
+		stats:  map[string]Stats{},
+	}
+}
+
+func (j *JUnit) suite(host string) *junitSuite {
+	s, ok := j.suites[host]
+	if !ok {
+		s = &junitSuite{Name: host}
+		j.suites[host] = s
+	}
+	return s
+}
+
+func (j *JUnit) PlayStart(host string, play *proto.PlayStart) error {
+	name := play.GetName()
+	if name == "" {
+		name = play.GetHosts()
+	}
+	j.plays[host] = name
+	j.suite(host)
+	return nil
+}
+
+func (j *JUnit) TaskStart(string, *proto.TaskStart) error {
+	return nil
+}
+
+func (j *JUnit) TaskResult(host string, result *proto.TaskResult) error {
+	st := j.stats[host]
+	st.Record(result)
+	j.stats[host] = st
+
+	c := &junitCase{
+		Name:      taskName(result.GetName(), result.GetModule()),
+		ClassName: j.plays[host],
+		SystemOut: result.GetStdout(),
+	}
+
+	s := j.suite(host)
+	s.Tests++
+	switch {
+	case result.GetFailed() && !result.GetIgnored():
+		s.Failures++
+		c.Failure = &junitMessage{
+			Message: result.GetMsg(),
+			Text:    resultJSON(resultMap(result)),
+		}
+	case result.GetSkipped():
+		s.Skipped++
+		c.Skipped = &junitMessage{}
+	}
+	s.Cases = append(s.Cases, c)
+	return nil
+}
+
+// HostDone adds a test case for what stopped the run on host early, unless a
+// task failed, which was reported already.
+func (j *JUnit) HostDone(host string, err error) error {
+	st := j.stats[host]
+	report := st.RecordHostDone(err)
+	j.stats[host] = st
+
+	s := j.suite(host)
+	if !report {
+		return nil
+	}
+
+	c := &junitCase{
+		Name:      "run",
+		ClassName: j.plays[host],
+		Error:     &junitMessage{Message: err.Error()},
+	}
+	if isUnreachable(err) {
+		c.Name = "unreachable"
+	}
+	s.Tests++
+	s.Errors++
+	s.Cases = append(s.Cases, c)
+	return nil
+}
+
+// Done writes the JUnit XML report.
+func (j *JUnit) Done() error {
+	report := junitSuites{Name: "sophons"}
+	for _, host := range slices.Sorted(maps.Keys(j.suites)) {
+		s := j.suites[host]
+		report.Tests += s.Tests
+		report.Failures += s.Failures
+		report.Errors += s.Errors
+		report.Skipped += s.Skipped
+		report.Suites = append(report.Suites, s)
+	}
+
+	if _, err := io.WriteString(j.w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	enc := xml.NewEncoder(j.w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	if _, err := io.WriteString(j.w, "\n"); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	return nil
+}
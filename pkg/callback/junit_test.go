@@ -0,0 +1,45 @@
+package callback
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJUnit(t *testing.T) {
+	var out strings.Builder
+	j := NewJUnit(&out)
+	run(t, j)
+	if err := j.HostDone("db1", &UnreachableError{Err: errors.New("connection refused")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="sophons" tests="4" failures="1" errors="1" skipped="1">
+  <testsuite name="db1" tests="1" failures="0" errors="1" skipped="0">
+    <testcase name="unreachable" classname="">
+      <error message="connection refused"></error>
+    </testcase>
+  </testsuite>
+  <testsuite name="web1" tests="3" failures="1" errors="0" skipped="1">
+    <testcase name="say hi" classname="all">
+      <system-out>hi</system-out>
+    </testcase>
+    <testcase name="file" classname="all">
+      <skipped></skipped>
+    </testcase>
+    <testcase name="command" classname="all">
+      <failure message="boom">{&#34;changed&#34;:false,&#34;failed&#34;:true,&#34;msg&#34;:&#34;boom&#34;}</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
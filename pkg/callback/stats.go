@@ -0,0 +1,87 @@
+package callback
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// Stats counts the results of the tasks run against a host. Like Ansible, ok
+// includes changed tasks and ignored failures, and rescued failures aren't
+// counted as failed.
+type Stats struct {
+	Ok          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Unreachable int `json:"unreachable"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+	Rescued     int `json:"rescued"`
+	Ignored     int `json:"ignored"`
+}
+
+// Record counts a task result.
+func (s *Stats) Record(r *proto.TaskResult) {
+	switch {
+	case r.GetFailed() && r.GetIgnored():
+		s.Ignored++
+		s.Ok++
+		if r.GetChanged() {
+			s.Changed++
+		}
+	case r.GetFailed() && r.GetRescued():
+		s.Rescued++
+	case r.GetFailed():
+		s.Failed++
+	case r.GetSkipped():
+		s.Skipped++
+	default:
+		s.Ok++
+		if r.GetChanged() {
+			s.Changed++
+		}
+	}
+}
+
+// RecordHostDone counts err, which stopped the run on a host early. It
+// returns whether err needs reporting: it doesn't if the run wasn't stopped,
+// or if it was because of a task failure, which was counted already.
+func (s *Stats) RecordHostDone(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case isUnreachable(err):
+		s.Unreachable++
+		return true
+	case s.Failed == 0:
+		s.Failed++
+		return true
+	default:
+		return false
+	}
+}
+
+// banner returns a header line the way Ansible shows them, padded with stars.
+func banner(title string) string {
+	return "\n" + title + " " + strings.Repeat("*", max(3, 79-len(title))) + "\n"
+}
+
+// WriteRecap writes the PLAY RECAP for the given hosts, sorted by name.
+func WriteRecap(w io.Writer, stats map[string]Stats) error {
+	if _, err := io.WriteString(w, banner("PLAY RECAP")); err != nil {
+		return err
+	}
+
+	for _, host := range slices.Sorted(maps.Keys(stats)) {
+		s := stats[host]
+		line := fmt.Sprintf("%-26s : ok=%-4d changed=%-4d unreachable=%-4d failed=%-4d skipped=%-4d rescued=%-4d ignored=%d\n",
+			host, s.Ok, s.Changed, s.Unreachable, s.Failed, s.Skipped, s.Rescued, s.Ignored)
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package callback
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func TestWriteRecap(t *testing.T) {
+	var out strings.Builder
+	err := WriteRecap(&out, map[string]Stats{
+		"web2": {Unreachable: 1},
+		"web1": {Ok: 3, Changed: 1, Skipped: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+PLAY RECAP *********************************************************************
+web1                       : ok=3    changed=1    unreachable=0    failed=0    skipped=2    rescued=0    ignored=0
+web2                       : ok=0    changed=0    unreachable=1    failed=0    skipped=0    rescued=0    ignored=0
+`
+	if diff := cmp.Diff(expected, out.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStatsRecord(t *testing.T) {
+	var s Stats
+	for _, r := range []*proto.TaskResult{
+		{Changed: true},
+		{},
+		{Skipped: true},
+		{Failed: true},
+		{Failed: true, Rescued: true},
+		{Failed: true, Ignored: true},
+	} {
+		s.Record(r)
+	}
+
+	expected := Stats{Ok: 3, Changed: 1, Failed: 1, Skipped: 1, Rescued: 1, Ignored: 1}
+	if diff := cmp.Diff(expected, s); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
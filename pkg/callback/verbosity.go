@@ -0,0 +1,58 @@
+package callback
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// MaxVerbosity is the highest verbosity level, set with `-vvvv`.
+const MaxVerbosity = 4
+
+// verbosityFlag is one of `-v`, `-vv`, `-vvv` and `-vvvv`, each setting the
+// verbosity to its own level.
+type verbosityFlag struct {
+	verbosity *int
+	level     int
+}
+
+func (f verbosityFlag) String() string {
+	if f.verbosity == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*f.verbosity >= f.level)
+}
+
+func (f verbosityFlag) Set(s string) error {
+	set, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if set {
+		*f.verbosity = max(*f.verbosity, f.level)
+	}
+	return nil
+}
+
+func (f verbosityFlag) IsBoolFlag() bool {
+	return true
+}
+
+// VerbosityFlags defines the `-v` to `-vvvv` flags on fs, and returns the
+// verbosity they set, from 0 to MaxVerbosity.
+func VerbosityFlags(fs *flag.FlagSet) *int {
+	verbosity := new(int)
+	for level := 1; level <= MaxVerbosity; level++ {
+		fs.Var(verbosityFlag{verbosity: verbosity, level: level}, strings.Repeat("v", level), "verbosity level "+strconv.Itoa(level))
+	}
+	return verbosity
+}
+
+// VerbosityArg returns the flag setting the given verbosity, e.g. `-vv`, or
+// an empty string for the default verbosity.
+func VerbosityArg(verbosity int) string {
+	if verbosity <= 0 {
+		return ""
+	}
+	return "-" + strings.Repeat("v", min(verbosity, MaxVerbosity))
+}
@@ -0,0 +1,33 @@
+package callback
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestVerbosityFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{args: nil, expected: 0},
+		{args: []string{"-v"}, expected: 1},
+		{args: []string{"-vvv"}, expected: 3},
+		{args: []string{"-vvvv", "-v"}, expected: 4},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		verbosity := VerbosityFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if *verbosity != tt.expected {
+			t.Errorf("%v: expected verbosity %d, got %d", tt.args, tt.expected, *verbosity)
+		}
+		if got := VerbosityArg(*verbosity); tt.expected > 0 && got != "-"+strings.Repeat("v", tt.expected) {
+			t.Errorf("%v: unexpected verbosity flag %q", tt.args, got)
+		}
+	}
+}
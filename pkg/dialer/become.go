@@ -0,0 +1,151 @@
+package dialer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Become configures privilege escalation of the executer on the target host.
+// The executer runs as root, and switches to other users on its own for
+// tasks that ask for it.
+type Become struct {
+	// Method is the escalation command to use: sudo, su or doas. Defaults to
+	// sudo.
+	Method string
+	// Password is written to the escalation command's input when it asks for
+	// it, so that it never shows up in the process list.
+	Password string
+}
+
+// sudoPrompt is the password prompt sudo is told to use, so that it can't be
+// mistaken for something else.
+const sudoPrompt = "[sophons-become-password]:"
+
+var (
+	// sudoPromptRegexp matches sudoPrompt at the end of the output, once sudo
+	// is waiting for input.
+	sudoPromptRegexp = regexp.MustCompile(regexp.QuoteMeta(sudoPrompt) + `\s*$`)
+	// passwordPromptRegexp matches the password prompts of su and doas, which
+	// can't be customized.
+	passwordPromptRegexp = regexp.MustCompile(`(?i)password[^:\n]*:\s*$`)
+)
+
+// promptWindow is how much of the end of the output is searched for a
+// password prompt.
+const promptWindow = 256
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// command wraps cmdLine in the escalation command. It also tells whether the
+// escalation command needs a terminal to read the password from.
+func (b Become) command(cmdLine string) (string, bool, error) {
+	switch b.Method {
+	case "", "sudo":
+		if b.Password == "" {
+			return "sudo -n -- " + cmdLine, false, nil
+		}
+		return fmt.Sprintf("sudo -S -p %s -- %s", shellQuote(sudoPrompt), cmdLine), false, nil
+	case "doas":
+		if b.Password == "" {
+			return "doas -n " + cmdLine, false, nil
+		}
+		return "doas " + cmdLine, true, nil
+	case "su":
+		if b.Password == "" {
+			return "", false, errors.New("become method su requires a password")
+		}
+		return "su root -c " + shellQuote(cmdLine), true, nil
+	default:
+		return "", false, fmt.Errorf("unsupported become method %q", b.Method)
+	}
+}
+
+// promptRegexp returns the regexp matching the escalation command's password
+// prompt, and whether that prompt is unique enough not to be mistaken with
+// the executer's output.
+func (b Become) promptRegexp() (*regexp.Regexp, bool) {
+	if b.Method == "" || b.Method == "sudo" {
+		return sudoPromptRegexp, true
+	}
+	return passwordPromptRegexp, false
+}
+
+// passwordResponder forwards a command's output to out, and answers its
+// password prompt. Since a prompt isn't followed by a newline, the last line
+// is held back until it's complete, so that prompts are never forwarded. If
+// the command asks again, the password was wrong: its input is closed so that
+// it gives up instead of waiting forever.
+type passwordResponder struct {
+	password string
+	stdin    io.WriteCloser
+	prompt   *regexp.Regexp
+	// unique tells whether prompt can't be mistaken with the command's own
+	// output. If it can, prompts are only looked for until the command
+	// outputs something after being given the password.
+	unique bool
+	out    io.Writer
+
+	mu       sync.Mutex
+	pending  []byte
+	answered bool
+	done     bool
+}
+
+func (r *passwordResponder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done {
+		return r.out.Write(p)
+	}
+
+	r.pending = append(r.pending, p...)
+	if i := bytes.LastIndexByte(r.pending, '\n'); i >= 0 {
+		if _, err := r.out.Write(r.pending[:i+1]); err != nil {
+			return 0, err
+		}
+		r.pending = r.pending[i+1:]
+		r.done = r.answered && !r.unique
+	}
+
+	if r.done {
+		_, err := r.out.Write(r.pending)
+		r.pending = nil
+		return len(p), err
+	}
+
+	if !r.prompt.Match(r.pending[max(0, len(r.pending)-promptWindow):]) {
+		return len(p), nil
+	}
+	r.pending = nil
+
+	if r.answered {
+		_, _ = io.WriteString(r.out, "incorrect become password\n")
+		_ = r.stdin.Close()
+		return len(p), nil
+	}
+	r.answered = true
+
+	if _, err := io.WriteString(r.stdin, r.password+"\n"); err != nil {
+		return len(p), fmt.Errorf("failed to send become password: %w", err)
+	}
+	return len(p), nil
+}
+
+// Flush forwards the output held back, if any.
+func (r *passwordResponder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.out.Write(r.pending)
+	r.pending = nil
+	return err
+}
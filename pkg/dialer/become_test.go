@@ -0,0 +1,164 @@
+package dialer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBecomeCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		become        Become
+		expected      string
+		needsTerminal bool
+		wantErr       bool
+	}{
+		{
+			name:     "sudo without password",
+			become:   Become{},
+			expected: "sudo -n -- /tmp/executer -n host",
+		},
+		{
+			name:     "sudo with password",
+			become:   Become{Method: "sudo", Password: "hunter2"},
+			expected: "sudo -S -p '[sophons-become-password]:' -- /tmp/executer -n host",
+		},
+		{
+			name:     "doas without password",
+			become:   Become{Method: "doas"},
+			expected: "doas -n /tmp/executer -n host",
+		},
+		{
+			name:          "doas with password",
+			become:        Become{Method: "doas", Password: "hunter2"},
+			expected:      "doas /tmp/executer -n host",
+			needsTerminal: true,
+		},
+		{
+			name:          "su",
+			become:        Become{Method: "su", Password: "hunter2"},
+			expected:      "su root -c '/tmp/executer -n host'",
+			needsTerminal: true,
+		},
+		{
+			name:    "su without password",
+			become:  Become{Method: "su"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported method",
+			become:  Become{Method: "pbrun"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, needsTerminal, err := tt.become.command("/tmp/executer -n host")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+			if needsTerminal != tt.needsTerminal {
+				t.Errorf("expected needsTerminal=%v, got %v", tt.needsTerminal, needsTerminal)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	got := shellQuote(`sh -c 'echo "it's"'`)
+	expected := `'sh -c '\''echo "it'\''s"'\'''`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+// fakeStdin records what's written to it.
+type fakeStdin struct {
+	strings.Builder
+	closed bool
+}
+
+func (f *fakeStdin) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestPasswordResponder(t *testing.T) {
+	tests := []struct {
+		name           string
+		become         Become
+		chunks         []string
+		expectedOutput string
+		expectedStdin  string
+		expectedClosed bool
+	}{
+		{
+			name:           "sudo",
+			become:         Become{Password: "hunter2"},
+			chunks:         []string{"[sophons-become-", "password]:", "ok\n", "password: not a prompt\n"},
+			expectedOutput: "ok\npassword: not a prompt\n",
+			expectedStdin:  "hunter2\n",
+		},
+		{
+			name:           "sudo wrong password",
+			become:         Become{Password: "hunter2"},
+			chunks:         []string{sudoPrompt, "Sorry, try again.\n", sudoPrompt},
+			expectedOutput: "Sorry, try again.\nincorrect become password\n",
+			expectedStdin:  "hunter2\n",
+			expectedClosed: true,
+		},
+		{
+			name:           "su",
+			become:         Become{Method: "su", Password: "hunter2"},
+			chunks:         []string{"Password: ", "\r\n", "some output\r\n", "db password:"},
+			expectedOutput: "\r\nsome output\r\ndb password:",
+			expectedStdin:  "hunter2\n",
+		},
+		{
+			name:           "no prompt",
+			become:         Become{Password: "hunter2"},
+			chunks:         []string{"ok\n"},
+			expectedOutput: "ok\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := &fakeStdin{}
+			var out strings.Builder
+			prompt, unique := tt.become.promptRegexp()
+			r := &passwordResponder{
+				password: tt.become.Password,
+				stdin:    stdin,
+				prompt:   prompt,
+				unique:   unique,
+				out:      &out,
+			}
+
+			for _, c := range tt.chunks {
+				if _, err := fmt.Fprint(r, c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := r.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := out.String(); got != tt.expectedOutput {
+				t.Errorf("expected output %q, got %q", tt.expectedOutput, got)
+			}
+			if got := stdin.String(); got != tt.expectedStdin {
+				t.Errorf("expected stdin %q, got %q", tt.expectedStdin, got)
+			}
+			if stdin.closed != tt.expectedClosed {
+				t.Errorf("expected stdin closed=%v, got %v", tt.expectedClosed, stdin.closed)
+			}
+		})
+	}
+}
@@ -1,20 +1,27 @@
 package dialer
 
 import (
+	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/pkg/sftp"
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/mickael-carl/sophons/pkg/callback"
+	"github.com/mickael-carl/sophons/pkg/event"
+	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/util"
 )
 
@@ -29,28 +36,95 @@ func tempDirName() (string, error) {
 type dialer struct {
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
+	// jumpClients are the connections to the jump hosts, in the order they
+	// were established.
+	jumpClients []*ssh.Client
 }
 
-func NewDialer(host, port string, config *ssh.ClientConfig) (*dialer, error) {
-	client, err := ssh.Dial("tcp", host+":"+port, config)
+// Hop is an SSH server to connect to, either a jump host or the final target.
+// Every hop has its own configuration, and thus its own credentials and host
+// key checks.
+type Hop struct {
+	Host   string
+	Port   string
+	Config *ssh.ClientConfig
+}
+
+func (h Hop) address() string {
+	return net.JoinHostPort(h.Host, h.Port)
+}
+
+// dial connects to target, going through each of the jumps in order. The
+// connection to every hop after the first one is tunneled in a `direct-tcpip`
+// channel opened on the previous hop, like OpenSSH's ProxyJump does. It
+// returns the client for the target and the ones for the jump hosts.
+func dial(target Hop, jumps []Hop) (*ssh.Client, []*ssh.Client, error) {
+	var clients []*ssh.Client
+	closeAll := func() {
+		for _, c := range slices.Backward(clients) {
+			c.Close()
+		}
+	}
+
+	for _, hop := range slices.Concat(jumps, []Hop{target}) {
+		if len(clients) == 0 {
+			client, err := ssh.Dial("tcp", hop.address(), hop.Config)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to dial %s: %w", hop.address(), err)
+			}
+			clients = append(clients, client)
+			continue
+		}
+
+		previous := clients[len(clients)-1]
+		conn, err := previous.Dial("tcp", hop.address())
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to dial %s through %s: %w", hop.address(), previous.RemoteAddr(), err)
+		}
+
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.address(), hop.Config)
+		if err != nil {
+			conn.Close()
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to establish SSH connection to %s: %w", hop.address(), err)
+		}
+		clients = append(clients, ssh.NewClient(c, chans, reqs))
+	}
+
+	return clients[len(clients)-1], clients[:len(clients)-1], nil
+}
+
+// NewDialer connects to host, optionally through a chain of jump hosts.
+func NewDialer(host, port string, config *ssh.ClientConfig, jumps ...Hop) (*dialer, error) {
+	client, jumpClients, err := dial(Hop{Host: host, Port: port, Config: config}, jumps)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial %s: %v", host, err)
 	}
 
+	d := &dialer{
+		sshClient:   client,
+		jumpClients: jumpClients,
+	}
+
 	sftpClient, err := sftp.NewClient(client)
 	if err != nil {
+		d.Close()
 		return nil, fmt.Errorf("failed to create sftp client: %v", err)
 	}
+	d.sftpClient = sftpClient
 
-	return &dialer{
-		sshClient:  client,
-		sftpClient: sftpClient,
-	}, nil
+	return d, nil
 }
 
 func (d *dialer) Close() {
-	d.sftpClient.Close()
+	if d.sftpClient != nil {
+		d.sftpClient.Close()
+	}
 	d.sshClient.Close()
+	for _, c := range slices.Backward(d.jumpClients) {
+		c.Close()
+	}
 }
 
 func (d *dialer) runCommand(command string) (string, error) {
@@ -136,7 +210,34 @@ func (d *dialer) copyExecuterBinary(localDir, remoteDir string) error {
 	return d.copyFile(path.Join(localDir, binName), path.Join(remoteDir, "executer"), true)
 }
 
-func (d *dialer) Execute(host, binDir, inventory, playbook string) (string, error) {
+// ExecuteOptions configures a run of the executer.
+type ExecuteOptions struct {
+	// Host is the name of the host in the inventory, which is what identifies
+	// it for the executer.
+	Host      string
+	BinDir    string
+	Inventory string
+	Playbook  string
+	// Become, when set, runs the executer with escalated privileges.
+	Become *Become
+	// Verbosity is the executer's verbosity, from 0 to 4.
+	Verbosity int
+	// Tags and SkipTags select the tasks the executer runs by their tags.
+	Tags     []string
+	SkipTags []string
+	// Check runs the executer in check mode, where nothing is changed.
+	Check bool
+	// Diff has the executer report the changes tasks make.
+	Diff bool
+	// OnEvent is called for every event sent by the executer.
+	OnEvent func(*proto.Event)
+}
+
+// Execute runs opts.Playbook against opts.Host with the executer. If
+// opts.Become is set, the executer is run with escalated privileges. The
+// events sent by the executer are passed to opts.OnEvent as they come, and the
+// rest of its output is returned.
+func (d *dialer) Execute(opts ExecuteOptions) (string, error) {
 	td, err := tempDirName()
 	if err != nil {
 		return "", fmt.Errorf("failed to generate temporary directory name for execution: %w", err)
@@ -148,38 +249,168 @@ func (d *dialer) Execute(host, binDir, inventory, playbook string) (string, erro
 	}
 	defer d.sftpClient.RemoveAll(dirPath) //nolint:errcheck
 
-	if err := d.copyExecuterBinary(binDir, dirPath); err != nil {
+	if err := d.copyExecuterBinary(opts.BinDir, dirPath); err != nil {
 		return "", err
 	}
 
-	if err := d.copyFile(inventory, path.Join(dirPath, "inventory.yaml"), false); err != nil {
+	if err := d.copyFile(opts.Inventory, path.Join(dirPath, "inventory.yaml"), false); err != nil {
 		return "", fmt.Errorf("failed to copy inventory to target host: %w", err)
 	}
 
 	// TODO: ansible looks in other places for roles.
-	archivePath, err := util.Tar(filepath.Dir(playbook))
+	archivePath, err := util.Tar(filepath.Dir(opts.Playbook))
 	if err != nil {
-		return "", fmt.Errorf("failed to archive and copy %s to target host: %w", filepath.Dir(playbook), err)
+		return "", fmt.Errorf("failed to archive and copy %s to target host: %w", filepath.Dir(opts.Playbook), err)
 	}
 
 	if err := d.copyFile(archivePath, path.Join(dirPath, "data.tar.gz"), false); err != nil {
 		return "", fmt.Errorf("failed to copy data from %s to target host: %w", archivePath, err)
 	}
 
+	playbookFileName := filepath.Base(opts.Playbook)
+	playbookDirName := filepath.Base(filepath.Dir(opts.Playbook))
+	cmdLine := executerCommand(dirPath, playbookDirName, playbookFileName, d.sshClient.User(), opts)
+
+	if opts.Become == nil {
+		return d.runExecuter(cmdLine, nil, opts.OnEvent)
+	}
+
+	// The executer extracts the data archive as root, so the temporary
+	// directory can't be removed over SFTP afterwards.
+	cmdLine = "sh -c " + shellQuote(fmt.Sprintf("%s; rc=$?; rm -rf %s; exit $rc", cmdLine, dirPath))
+
+	return d.runExecuter(cmdLine, opts.Become, opts.OnEvent)
+}
+
+// executerCommand returns the command line running the executer copied to
+// dirPath. Flags all come before the playbook, since the executer stops
+// parsing them at the first argument.
+func executerCommand(dirPath, playbookDirName, playbookFileName, sshUser string, opts ExecuteOptions) string {
+	var cmdLine strings.Builder
+	cmdLine.WriteString(path.Join(dirPath, "executer"))
+	fmt.Fprintf(&cmdLine, " -events -i %s", path.Join(dirPath, "inventory.yaml"))
+	fmt.Fprintf(&cmdLine, " -d %s", path.Join(dirPath, "data.tar.gz"))
+	fmt.Fprintf(&cmdLine, " -p %s", playbookDirName)
+	fmt.Fprintf(&cmdLine, " -n %s", opts.Host)
+	if opts.Become != nil {
+		// Tasks that don't ask for become run as the user we're connected
+		// as.
+		fmt.Fprintf(&cmdLine, " -u %s", shellQuote(sshUser))
+	}
+	if opts.Verbosity > 0 {
+		fmt.Fprintf(&cmdLine, " %s", callback.VerbosityArg(opts.Verbosity))
+	}
+	if opts.Check {
+		cmdLine.WriteString(" -check")
+	}
+	if opts.Diff {
+		cmdLine.WriteString(" -diff")
+	}
+	if len(opts.Tags) > 0 {
+		fmt.Fprintf(&cmdLine, " -tags %s", shellQuote(strings.Join(opts.Tags, ",")))
+	}
+	if len(opts.SkipTags) > 0 {
+		fmt.Fprintf(&cmdLine, " -skip-tags %s", shellQuote(strings.Join(opts.SkipTags, ",")))
+	}
+	fmt.Fprintf(&cmdLine, " %s", path.Join(dirPath, playbookDirName, playbookFileName))
+	return cmdLine.String()
+}
+
+// syncBuffer is a bytes.Buffer that can be written to concurrently, since a
+// session's stdout and stderr are copied concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// runExecuter runs the executer's command line, with escalated privileges if
+// become is set. Events read from its standard output are passed to onEvent,
+// and the rest of its output is returned.
+func (d *dialer) runExecuter(cmdLine string, become *Become, onEvent func(*proto.Event)) (string, error) {
+	command := cmdLine
+	needsTerminal := false
+	if become != nil {
+		var err error
+		command, needsTerminal, err = become.command(cmdLine)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	session, err := d.sshClient.NewSession()
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
-	playbookFileName := filepath.Base(playbook)
-	playbookDirName := filepath.Base(filepath.Dir(playbook))
-	// TODO: strings.Builder
-	cmdLine := path.Join(dirPath, "executer")
-	cmdLine += fmt.Sprintf(" -i %s", path.Join(dirPath, "inventory.yaml"))
-	cmdLine += fmt.Sprintf(" -d %s", path.Join(dirPath, "data.tar.gz"))
-	cmdLine += fmt.Sprintf(" -p %s", playbookDirName)
-	cmdLine += fmt.Sprintf(" -n %s %s", host, path.Join(dirPath, playbookDirName, playbookFileName))
+	var output syncBuffer
+	stdoutReader, stdoutWriter := io.Pipe()
+	scanErr := make(chan error, 1)
+	go func() {
+		err := event.Scan(stdoutReader, onEvent, &output)
+		// Keep reading so that the executer doesn't block on its output.
+		_, _ = io.Copy(io.Discard, stdoutReader)
+		scanErr <- err
+	}()
+
+	session.Stdout = stdoutWriter
+	session.Stderr = &output
+
+	var responder *passwordResponder
+	if become != nil && become.Password != "" {
+		if needsTerminal {
+			// Output processing is turned off, so that the terminal doesn't
+			// mangle the events.
+			modes := ssh.TerminalModes{ssh.ECHO: 0, ssh.OPOST: 0}
+			if err := session.RequestPty("xterm", 40, 80, modes); err != nil {
+				return "", fmt.Errorf("failed to request terminal for %s: %w", become.Method, err)
+			}
+		}
+
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return "", fmt.Errorf("failed to get session input: %w", err)
+		}
+
+		prompt, unique := become.promptRegexp()
+		responder = &passwordResponder{
+			password: become.Password,
+			stdin:    stdin,
+			prompt:   prompt,
+			unique:   unique,
+		}
+
+		// sudo prompts on stderr, but with a terminal everything goes to
+		// stdout.
+		if needsTerminal {
+			responder.out = stdoutWriter
+			session.Stdout = responder
+		} else {
+			responder.out = &output
+			session.Stderr = responder
+		}
+	}
 
-	return d.runCommand(cmdLine)
+	err = session.Run(command)
+	if responder != nil {
+		_ = responder.Flush()
+	}
+	stdoutWriter.Close()
+
+	if scanErr := <-scanErr; scanErr != nil && err == nil {
+		err = fmt.Errorf("failed to read events: %w", scanErr)
+	}
+	return output.String(), err
 }
@@ -0,0 +1,398 @@
+package dialer
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/mickael-carl/sophons/pkg/event"
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// execHandler handles an `exec` request on a test server, and returns the
+// command's exit status.
+type execHandler func(ch ssh.Channel, command string) uint32
+
+// testServer is a minimal in-process SSH server. It forwards `direct-tcpip`
+// channels, and answers any `exec` request with its own name unless it has a
+// handler.
+type testServer struct {
+	name    string
+	addr    string
+	hostKey ssh.Signer
+	handler execHandler
+
+	mu          sync.Mutex
+	forwardedTo []string
+}
+
+func startTestServer(t *testing.T, name string, authorizedKey ssh.PublicKey) *testServer {
+	t.Helper()
+	return startExecTestServer(t, name, authorizedKey, nil)
+}
+
+func startExecTestServer(t *testing.T, name string, authorizedKey ssh.PublicKey, handler execHandler) *testServer {
+	t.Helper()
+
+	s := &testServer{
+		name:    name,
+		hostKey: newSigner(t),
+		handler: handler,
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorizedKey.Marshal()) {
+				return nil, errors.New("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(s.hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s.addr = l.Addr().String()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "direct-tcpip":
+			var payload struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+				_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+
+			target := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+			s.mu.Lock()
+			s.forwardedTo = append(s.forwardedTo, target)
+			s.mu.Unlock()
+
+			targetConn, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+
+			ch, chReqs, err := newChan.Accept()
+			if err != nil {
+				targetConn.Close()
+				continue
+			}
+			go ssh.DiscardRequests(chReqs)
+			go func() {
+				defer ch.Close()
+				defer targetConn.Close()
+				go func() { _, _ = io.Copy(targetConn, ch) }()
+				_, _ = io.Copy(ch, targetConn)
+			}()
+
+		case "session":
+			ch, chReqs, err := newChan.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				defer ch.Close()
+				for req := range chReqs {
+					if req.Type != "exec" {
+						_ = req.Reply(false, nil)
+						continue
+					}
+					_ = req.Reply(true, nil)
+
+					var status uint32
+					if s.handler != nil {
+						var payload struct{ Command string }
+						_ = ssh.Unmarshal(req.Payload, &payload)
+						status = s.handler(ch, payload.Command)
+					} else {
+						_, _ = fmt.Fprint(ch, s.name)
+					}
+
+					_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					return
+				}
+			}()
+
+		default:
+			_ = newChan.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *testServer) hop(t *testing.T, clientKey ssh.Signer) Hop {
+	t.Helper()
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Hop{
+		Host: host,
+		Port: port,
+		Config: &ssh.ClientConfig{
+			User:            s.name,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientKey)},
+			HostKeyCallback: ssh.FixedHostKey(s.hostKey.PublicKey()),
+		},
+	}
+}
+
+func TestDialThroughJumpHosts(t *testing.T) {
+	clientKey := newSigner(t)
+
+	bastion1 := startTestServer(t, "bastion1", clientKey.PublicKey())
+	bastion2 := startTestServer(t, "bastion2", clientKey.PublicKey())
+	target := startTestServer(t, "target", clientKey.PublicKey())
+
+	tests := []struct {
+		name  string
+		jumps []*testServer
+	}{
+		{name: "direct"},
+		{name: "single jump", jumps: []*testServer{bastion1}},
+		{name: "two jumps", jumps: []*testServer{bastion1, bastion2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var jumps []Hop
+			for _, j := range tt.jumps {
+				jumps = append(jumps, j.hop(t, clientKey))
+			}
+
+			client, jumpClients, err := dial(target.hop(t, clientKey), jumps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				client.Close()
+				for _, c := range jumpClients {
+					c.Close()
+				}
+			}()
+
+			if len(jumpClients) != len(jumps) {
+				t.Errorf("expected %d jump clients, got %d", len(jumps), len(jumpClients))
+			}
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+
+			out, err := session.Output("hostname")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != "target" {
+				t.Errorf("expected to reach target, reached %q", out)
+			}
+		})
+	}
+
+	// The last bastion in the chain is the one forwarding to the target.
+	bastion2.mu.Lock()
+	defer bastion2.mu.Unlock()
+	if got := bastion2.forwardedTo; len(got) != 1 || got[0] != target.addr {
+		t.Errorf("expected bastion2 to forward to %s, got %v", target.addr, got)
+	}
+}
+
+func TestDialJumpHostKeyMismatch(t *testing.T) {
+	clientKey := newSigner(t)
+
+	bastion := startTestServer(t, "bastion", clientKey.PublicKey())
+	target := startTestServer(t, "target", clientKey.PublicKey())
+
+	jump := bastion.hop(t, clientKey)
+	jump.Config.HostKeyCallback = ssh.FixedHostKey(newSigner(t).PublicKey())
+
+	_, _, err := dial(target.hop(t, clientKey), []Hop{jump})
+	if err == nil {
+		t.Fatal("expected an error when the jump host's key doesn't match")
+	}
+	if !strings.Contains(err.Error(), bastion.addr) {
+		t.Errorf("expected error to mention %s, got %v", bastion.addr, err)
+	}
+}
+
+func TestRunExecuter(t *testing.T) {
+	clientKey := newSigner(t)
+
+	playStart := &proto.Event{
+		Event: &proto.Event_PlayStart{PlayStart: &proto.PlayStart{Name: "play"}},
+	}
+
+	// The server behaves like sudo, asking for a password on stderr when the
+	// command is run through it, before running an executer sending an event.
+	target := startExecTestServer(t, "target", clientKey.PublicKey(), func(ch ssh.Channel, command string) uint32 {
+		if strings.HasPrefix(command, "sudo -S") {
+			_, _ = fmt.Fprint(ch.Stderr(), sudoPrompt)
+			password, err := bufio.NewReader(ch).ReadString('\n')
+			if err != nil || password != "hunter2\n" {
+				_, _ = fmt.Fprintln(ch.Stderr(), "sudo: incorrect password")
+				return 1
+			}
+		}
+
+		_, _ = fmt.Fprintln(ch.Stderr(), "some log")
+		if err := event.NewWriter(ch).Write(playStart); err != nil {
+			return 1
+		}
+		return 0
+	})
+
+	tests := []struct {
+		name           string
+		become         *Become
+		expectedOutput string
+		wantErr        bool
+	}{
+		{
+			name:           "no become",
+			expectedOutput: "some log\n",
+		},
+		{
+			name:           "sudo",
+			become:         &Become{Password: "hunter2"},
+			expectedOutput: "some log\n",
+		},
+		{
+			name:           "sudo wrong password",
+			become:         &Become{Password: "nope"},
+			expectedOutput: "sudo: incorrect password\n",
+			wantErr:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _, err := dial(target.hop(t, clientKey), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			d := &dialer{sshClient: client}
+
+			var events []*proto.Event
+			out, err := d.runExecuter("executer", tt.become, func(e *proto.Event) {
+				events = append(events, e)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if out != tt.expectedOutput {
+				t.Errorf("expected output %q, got %q", tt.expectedOutput, out)
+			}
+
+			var expected []*proto.Event
+			if !tt.wantErr {
+				expected = []*proto.Event{playStart}
+			}
+			if diff := cmp.Diff(expected, events, protocmp.Transform()); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExecuterCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     ExecuteOptions
+		expected string
+	}{
+		{
+			name:     "defaults",
+			opts:     ExecuteOptions{Host: "web1"},
+			expected: "/tmp/s/executer -events -i /tmp/s/inventory.yaml -d /tmp/s/data.tar.gz -p playbooks -n web1 /tmp/s/playbooks/site.yaml",
+		},
+		{
+			name:     "become and verbosity",
+			opts:     ExecuteOptions{Host: "web1", Become: &Become{}, Verbosity: 3},
+			expected: "/tmp/s/executer -events -i /tmp/s/inventory.yaml -d /tmp/s/data.tar.gz -p playbooks -n web1 -u 'deploy' -vvv /tmp/s/playbooks/site.yaml",
+		},
+		{
+			name:     "check",
+			opts:     ExecuteOptions{Host: "web1", Check: true},
+			expected: "/tmp/s/executer -events -i /tmp/s/inventory.yaml -d /tmp/s/data.tar.gz -p playbooks -n web1 -check /tmp/s/playbooks/site.yaml",
+		},
+		{
+			name:     "diff",
+			opts:     ExecuteOptions{Host: "web1", Check: true, Diff: true},
+			expected: "/tmp/s/executer -events -i /tmp/s/inventory.yaml -d /tmp/s/data.tar.gz -p playbooks -n web1 -check -diff /tmp/s/playbooks/site.yaml",
+		},
+		{
+			name:     "tags",
+			opts:     ExecuteOptions{Host: "web1", Tags: []string{"nginx", "config"}, SkipTags: []string{"never"}},
+			expected: "/tmp/s/executer -events -i /tmp/s/inventory.yaml -d /tmp/s/data.tar.gz -p playbooks -n web1 -tags 'nginx,config' -skip-tags 'never' /tmp/s/playbooks/site.yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := executerCommand("/tmp/s", "playbooks", "site.yaml", "deploy", tt.opts)
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
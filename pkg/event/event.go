@@ -0,0 +1,126 @@
+// Package event implements the stream of events the executer sends to the
+// dialer while it runs a playbook.
+//
+// Events are protobuf messages written one per line, base64 encoded and
+// prefixed with a marker. This keeps them apart from anything else written to
+// the same output: logs, commands' output, or what a terminal adds when one is
+// needed for privilege escalation.
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mickael-carl/sophons/pkg/callback"
+	protopackage "github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// prefix marks the lines holding an event.
+const prefix = "@sophons-event "
+
+// maxLineSize is the size of the longest line that can be read. Events carry
+// the output of commands, which can be large.
+const maxLineSize = 64 * 1024 * 1024
+
+// Writer is a callback.Callback writing events to an io.Writer, for the
+// dialer to read them back with Scan. It's meant for a single host, which the
+// dialer knows already, so hosts aren't written.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var _ callback.Callback = &Writer{}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) PlayStart(_ string, play *protopackage.PlayStart) error {
+	return w.Write(&protopackage.Event{
+		Event: &protopackage.Event_PlayStart{PlayStart: play},
+	})
+}
+
+func (w *Writer) TaskStart(_ string, task *protopackage.TaskStart) error {
+	return w.Write(&protopackage.Event{
+		Event: &protopackage.Event_TaskStart{TaskStart: task},
+	})
+}
+
+func (w *Writer) TaskResult(_ string, result *protopackage.TaskResult) error {
+	return w.Write(&protopackage.Event{
+		Event: &protopackage.Event_TaskResult{TaskResult: result},
+	})
+}
+
+// HostDone does nothing: the dialer knows when the executer is done.
+func (w *Writer) HostDone(string, error) error {
+	return nil
+}
+
+// Done does nothing: the dialer knows when the executer is done.
+func (w *Writer) Done() error {
+	return nil
+}
+
+// Write writes e on its own line, in a single write so that it doesn't get
+// interleaved with other output.
+func (w *Writer) Write(e *protopackage.Event) error {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	line := make([]byte, 0, len(prefix)+base64.StdEncoding.EncodedLen(len(data))+1)
+	line = append(line, prefix...)
+	line = base64.StdEncoding.AppendEncode(line, data)
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(line); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+// Scan reads r until EOF, calling onEvent for every event. Other lines are
+// written to other.
+func Scan(r io.Reader, onEvent func(*protopackage.Event), other io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+
+	// Lines ending with \r\n, like terminals output, are handled by the
+	// scanner.
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		encoded, ok := bytes.CutPrefix(line, []byte(prefix))
+		if !ok {
+			if _, err := fmt.Fprintf(other, "%s\n", line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := base64.StdEncoding.AppendDecode(nil, encoded)
+		if err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+
+		var e protopackage.Event
+		if err := proto.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("failed to unmarshal event: %w", err)
+		}
+		onEvent(&e)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,71 @@
+package event
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func TestWriterScan(t *testing.T) {
+	events := []*proto.Event{
+		{
+			Event: &proto.Event_PlayStart{
+				PlayStart: &proto.PlayStart{Name: "play", Hosts: "all"},
+			},
+		},
+		{
+			Event: &proto.Event_TaskResult{
+				TaskResult: &proto.TaskResult{
+					Name:    "task",
+					Module:  "command",
+					Changed: true,
+					Stdout:  "line 1\nline 2\n",
+				},
+			},
+		},
+	}
+
+	var out strings.Builder
+	w := NewWriter(&out)
+
+	out.WriteString("Welcome to the machine\n")
+	if err := w.PlayStart("web1", events[0].GetPlayStart()); err != nil {
+		t.Fatal(err)
+	}
+	out.WriteString(`{"level":"info","msg":"some log"}` + "\n")
+	if err := w.TaskResult("web1", events[1].GetTaskResult()); err != nil {
+		t.Fatal(err)
+	}
+	out.WriteString("no trailing newline")
+
+	// Terminals turn \n into \r\n.
+	stream := strings.ReplaceAll(out.String(), "\n", "\r\n")
+
+	var got []*proto.Event
+	var other strings.Builder
+	if err := Scan(strings.NewReader(stream), func(e *proto.Event) {
+		got = append(got, e)
+	}, &other); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(events, got, protocmp.Transform()); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+
+	expectedOther := "Welcome to the machine\n" + `{"level":"info","msg":"some log"}` + "\nno trailing newline\n"
+	if diff := cmp.Diff(expectedOther, other.String()); diff != "" {
+		t.Errorf("other output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanInvalidEvent(t *testing.T) {
+	err := Scan(strings.NewReader(prefix+"not base64!\n"), func(*proto.Event) {}, &strings.Builder{})
+	if err == nil {
+		t.Error("expected an error for an invalid event")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/arduino/go-apt-client"
@@ -41,6 +43,8 @@ type Apt struct {
 
 	apt   aptClient
 	aptFS fs.FS
+	// check is set in check mode, where nothing is changed.
+	check bool
 }
 
 type AptResult struct {
@@ -105,6 +109,10 @@ func (a *Apt) handleUpdate() (bool, time.Time, error) {
 	}
 
 	if errors.Is(err, os.ErrNotExist) && (a.UpdateCache != nil && *a.UpdateCache || a.CacheValidTime != nil) {
+		if a.check {
+			return true, time.UnixMilli(0).UTC(), nil
+		}
+
 		_, cacheErr := a.apt.CheckForUpdates()
 		if cacheErr != nil {
 			return false, time.UnixMilli(0).UTC(), cacheErr
@@ -126,6 +134,10 @@ func (a *Apt) handleUpdate() (bool, time.Time, error) {
 	}
 
 	if a.UpdateCache != nil && *a.UpdateCache || a.CacheValidTime != nil && time.Since(cacheInfo.ModTime()).Seconds() > float64(*a.CacheValidTime) {
+		if a.check {
+			return true, beforeModTime.UTC(), nil
+		}
+
 		_, cacheErr := a.apt.CheckForUpdates()
 		if cacheErr != nil {
 			return false, beforeModTime.UTC(), cacheErr
@@ -167,12 +179,16 @@ func (a *Apt) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		a.aptFS = os.DirFS("/var/lib/apt")
 	}
 
+	a.check = CheckModeFromContext(ctx)
+
 	result := AptResult{}
 
 	if a.Clean {
-		if _, err := a.apt.Clean(); err != nil {
-			result.TaskFailed()
-			return &result, fmt.Errorf("failed to clean apt cache: %w", err)
+		if !a.check {
+			if _, err := a.apt.Clean(); err != nil {
+				result.TaskFailed()
+				return &result, fmt.Errorf("failed to clean apt cache: %w", err)
+			}
 		}
 
 		result.TaskChanged()
@@ -203,6 +219,14 @@ func (a *Apt) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		result.TaskChanged()
 	}
 
+	if a.Upgrade != "" && a.check {
+		// Which packages would be upgraded can't be told without asking apt
+		// to simulate it.
+		result.Msg = checkModeUnpredictable
+		result.TaskSkipped()
+		return &result, nil
+	}
+
 	if a.Upgrade != "" {
 		switch a.Upgrade {
 		case AptUpgradeYes, AptUpgradeSafe:
@@ -226,6 +250,19 @@ func (a *Apt) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		return &result, nil
 	}
 
+	if DiffModeFromContext(ctx) {
+		installed, err := a.apt.ListInstalled()
+		if err != nil {
+			result.TaskFailed()
+			return &result, fmt.Errorf("failed to list installed packages: %w", err)
+		}
+		addDiff(ctx, &result.CommonResult, a.packagesDiff(installed, actualState))
+	}
+
+	if a.check {
+		return a.checkPackages(&result, actualState)
+	}
+
 	switch actualState {
 	case AptPresent:
 		installed, err := a.apt.ListInstalled()
@@ -295,3 +332,59 @@ func (a *Apt) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 
 	return &result, nil
 }
+
+// checkPackages reports whether the packages would be changed, in check mode.
+// Whether installed packages would be upgraded with `state=latest` can't be
+// told though.
+func (a *Apt) checkPackages(result *AptResult, state string) (Result, error) {
+	installed, err := a.apt.ListInstalled()
+	if err != nil {
+		result.TaskFailed()
+		return result, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+
+	installedCount := 0
+	for _, wanted := range a.Name.Items {
+		if slices.ContainsFunc(installed, func(p *apt.Package) bool { return p.Name == wanted }) {
+			installedCount++
+		}
+	}
+
+	switch state {
+	case AptPresent:
+		if installedCount < len(a.Name.Items) {
+			result.TaskChanged()
+		}
+	case AptLatest:
+		if installedCount < len(a.Name.Items) {
+			result.TaskChanged()
+		} else if !result.Changed {
+			result.Msg = checkModeUnpredictable
+			result.TaskSkipped()
+		}
+	case AptAbsent:
+		if installedCount > 0 {
+			result.TaskChanged()
+		}
+	default:
+		result.TaskFailed()
+		return result, fmt.Errorf("state %s is not implemented for apt", state)
+	}
+
+	return result, nil
+}
+
+// packagesDiff returns the diff of the packages in the task going from
+// installed to state: which of them are installed before and after.
+func (a *Apt) packagesDiff(installed []*apt.Package, state string) Diff {
+	var before, after strings.Builder
+	for _, name := range a.Name.Items {
+		if slices.ContainsFunc(installed, func(p *apt.Package) bool { return p.Name == name }) {
+			before.WriteString(name + "\n")
+		}
+		if state != AptAbsent {
+			after.WriteString(name + "\n")
+		}
+	}
+	return Diff{Before: before.String(), After: after.String()}
+}
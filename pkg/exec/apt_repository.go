@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/arduino/go-apt-client"
 
 	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/registry"
@@ -52,6 +56,15 @@ func uriToFilename(uri string) (string, error) {
 	return re.ReplaceAllString(u.Hostname()+u.Path, "_") + ".list", nil
 }
 
+// sourcesLines returns the sources.list lines of repos, as shown in diffs.
+func sourcesLines(repos apt.RepositoryList) string {
+	var b strings.Builder
+	for _, repo := range repos {
+		b.WriteString(repo.APTConfigLine() + "\n")
+	}
+	return b.String()
+}
+
 func (ar *AptRepository) Validate() error {
 	if ar.Repo == "" {
 		return errors.New("repo is required")
@@ -82,10 +95,21 @@ func (ar *AptRepository) Apply(ctx context.Context, _ string, _ bool) (Result, e
 		return &result, errors.New("failed to parse repo line")
 	}
 
+	check := CheckModeFromContext(ctx)
+
 	if ar.State == AptRepositoryAbsent {
 		toRemove := repos.Find(repo)
 		if toRemove != nil {
 			result.TaskChanged()
+			addDiff(ctx, &result.CommonResult, Diff{
+				Before: sourcesLines(repos),
+				After: sourcesLines(slices.DeleteFunc(slices.Clone(repos), func(r *apt.Repository) bool {
+					return r == toRemove
+				})),
+			})
+			if check {
+				return &result, nil
+			}
 			if err := ar.apt.RemoveRepository(toRemove, "/etc/apt"); err != nil {
 				result.TaskFailed()
 				return &result, fmt.Errorf("failed to remove repository: %w", err)
@@ -100,6 +124,13 @@ func (ar *AptRepository) Apply(ctx context.Context, _ string, _ bool) (Result, e
 				return &result, fmt.Errorf("failed to infer filename from repo: %w", err)
 			}
 			result.TaskChanged()
+			addDiff(ctx, &result.CommonResult, Diff{
+				Before: sourcesLines(repos),
+				After:  sourcesLines(append(slices.Clone(repos), repo)),
+			})
+			if check {
+				return &result, nil
+			}
 			if err := ar.apt.AddRepository(repo, "/etc/apt", filename); err != nil {
 				result.TaskFailed()
 				return &result, fmt.Errorf("failed to add repository: %w", err)
@@ -107,7 +138,9 @@ func (ar *AptRepository) Apply(ctx context.Context, _ string, _ bool) (Result, e
 		}
 	}
 
-	if ar.UpdateCache == nil || ar.UpdateCache != nil && *ar.UpdateCache {
+	// In check mode, the cache is left alone: only the repositories tell
+	// whether something would change.
+	if !check && (ar.UpdateCache == nil || ar.UpdateCache != nil && *ar.UpdateCache) {
 		result.TaskChanged()
 		if _, err := ar.apt.CheckForUpdates(); err != nil {
 			result.TaskFailed()
@@ -0,0 +1,165 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+var becomeContextKey = &struct{ name string }{"become"}
+
+// becomeMethods are the supported privilege escalation methods. The executer
+// itself is escalated by the dialer using one of those, and then switches
+// users on its own for individual tasks.
+var becomeMethods = []string{"sudo", "su", "doas"}
+
+// Become holds the privilege escalation settings in effect for a task.
+type Become struct {
+	// RemoteUser is the user the dialer connected as. Tasks run as that user
+	// unless Become is set. When empty, they run as the executer's own user.
+	RemoteUser string
+	Become     bool
+	// User is the user to run tasks as when Become is set. Defaults to root.
+	User string
+}
+
+// NewBecomeContext returns a new context carrying the given privilege
+// escalation settings.
+func NewBecomeContext(ctx context.Context, b Become) context.Context {
+	return context.WithValue(ctx, becomeContextKey, b)
+}
+
+// BecomeFromContext returns the privilege escalation settings carried by ctx,
+// if any.
+func BecomeFromContext(ctx context.Context) (Become, bool) {
+	b, ok := ctx.Value(becomeContextKey).(Become)
+	return b, ok
+}
+
+// ValidateBecomeMethod checks that method is a supported privilege escalation
+// method. An empty method means the default one, sudo.
+func ValidateBecomeMethod(method string) error {
+	if method != "" && !slices.Contains(becomeMethods, method) {
+		return fmt.Errorf("unsupported become_method %q, must be one of %v", method, becomeMethods)
+	}
+	return nil
+}
+
+// runAs returns the name of the user tasks should run as, or an empty string
+// to run them as the executer's own user.
+func (b Become) runAs() string {
+	if !b.Become {
+		return b.RemoteUser
+	}
+	if b.User == "" {
+		return "root"
+	}
+	return b.User
+}
+
+// taskBecomeContext applies the task level privilege escalation settings on
+// top of the ones in ctx.
+func taskBecomeContext(ctx context.Context, task Task) (context.Context, error) {
+	if err := ValidateBecomeMethod(task.BecomeMethod); err != nil {
+		return ctx, err
+	}
+
+	b, _ := BecomeFromContext(ctx)
+	if task.Become != nil {
+		b.Become = *task.Become
+	}
+	if task.BecomeUser != "" {
+		b.User = task.BecomeUser
+	}
+	return NewBecomeContext(ctx, b), nil
+}
+
+// becomeCredential returns the credential commands have to be run with, or
+// nil if they can run as the executer's own user. Switching to another user
+// requires the executer to run as root.
+func becomeCredential(ctx context.Context) (*syscall.Credential, error) {
+	b, ok := BecomeFromContext(ctx)
+	if !ok || b.runAs() == "" {
+		return nil, nil
+	}
+
+	u, err := user.Lookup(b.runAs())
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %s: %w", b.runAs(), err)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if int(uid) == os.Getuid() {
+		return nil, nil
+	}
+	if os.Getuid() != 0 {
+		return nil, fmt.Errorf("can't run as %s: the executer isn't running as root, use become at the play level or in the dialer", u.Username)
+	}
+
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up groups of user %s: %w", u.Username, err)
+	}
+	var groups []uint32
+	for _, g := range groupIDs {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(id))
+	}
+
+	return &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: groups,
+	}, nil
+}
+
+// becomeCmdFactory wraps factory so that commands are run as the user tasks
+// should run as.
+func becomeCmdFactory(ctx context.Context, factory cmdFactory) (cmdFactory, error) {
+	cred, err := becomeCredential(ctx)
+	if err != nil || cred == nil {
+		return factory, err
+	}
+
+	return func(name string, args ...string) commandExecutor {
+		cmd := factory(name, args...)
+		cmd.SetCredential(cred)
+		return cmd
+	}, nil
+}
+
+// runsAsUser tells whether content runs as the user tasks should run as on
+// its own. Commands do, and so do blocks and included tasks, since their
+// tasks each do. Other modules need the executer to switch users to run them.
+func runsAsUser(content TaskContent) bool {
+	switch content.(type) {
+	case *Command, *Shell, *Block, *IncludeTasks, *ImportTasks, *Meta:
+		return true
+	}
+	return false
+}
+
+// becomeUser switches the executer to the user tasks should run as, if it
+// isn't running as them already, and returns a function switching back.
+func becomeUser(ctx context.Context) (func() error, error) {
+	cred, err := becomeCredential(ctx)
+	if err != nil || cred == nil {
+		return func() error { return nil }, err
+	}
+	return switchUser(cred)
+}
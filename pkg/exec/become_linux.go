@@ -0,0 +1,47 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// switchUser switches the whole executer to the user in cred, and returns a
+// function switching back. The saved user ID is left alone, for the executer
+// to be able to switch back, but commands started meanwhile run as that user
+// only. Tasks run one at a time, so nothing else runs as the wrong user.
+func switchUser(cred *syscall.Credential) (func() error, error) {
+	uid, gid := syscall.Getuid(), syscall.Getgid()
+	groups, err := syscall.Getgroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the executer's groups: %w", err)
+	}
+
+	restore := func() error {
+		if err := syscall.Setresuid(uid, uid, uid); err != nil {
+			return fmt.Errorf("failed to switch back to user %d: %w", uid, err)
+		}
+		if err := syscall.Setresgid(gid, gid, gid); err != nil {
+			return fmt.Errorf("failed to switch back to group %d: %w", gid, err)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed to switch back to the executer's groups: %w", err)
+		}
+		return nil
+	}
+
+	credGroups := make([]int, len(cred.Groups))
+	for i, g := range cred.Groups {
+		credGroups[i] = int(g)
+	}
+	if err := syscall.Setgroups(credGroups); err != nil {
+		return nil, fmt.Errorf("failed to switch groups: %w", err)
+	}
+	if err := syscall.Setresgid(int(cred.Gid), int(cred.Gid), int(cred.Gid)); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to switch to group %d: %w", cred.Gid, err), restore())
+	}
+	if err := syscall.Setresuid(int(cred.Uid), int(cred.Uid), uid); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to switch to user %d: %w", cred.Uid, err), restore())
+	}
+	return restore, nil
+}
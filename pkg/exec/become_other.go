@@ -0,0 +1,14 @@
+//go:build !linux
+
+package exec
+
+import (
+	"errors"
+	"syscall"
+)
+
+// switchUser fails: switching the whole executer to another user and back
+// can only be done on Linux. Only commands can run as another user elsewhere.
+func switchUser(*syscall.Credential) (func() error, error) {
+	return nil, errors.New("only command and shell tasks can run as another user on this OS")
+}
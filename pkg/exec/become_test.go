@@ -0,0 +1,207 @@
+package exec
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func TestTaskBecomeContext(t *testing.T) {
+	pTrue := true
+	pFalse := false
+
+	tests := []struct {
+		name     string
+		base     Become
+		task     Task
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "no become",
+			base:     Become{RemoteUser: "deploy"},
+			task:     Task{},
+			expected: "deploy",
+		},
+		{
+			name:     "task become defaults to root",
+			base:     Become{RemoteUser: "deploy"},
+			task:     Task{Become: &pTrue},
+			expected: "root",
+		},
+		{
+			name:     "become_user without become",
+			base:     Become{RemoteUser: "deploy"},
+			task:     Task{BecomeUser: "postgres"},
+			expected: "deploy",
+		},
+		{
+			name:     "task become_user with play become",
+			base:     Become{RemoteUser: "deploy", Become: true},
+			task:     Task{BecomeUser: "postgres"},
+			expected: "postgres",
+		},
+		{
+			name:     "task opts out of play become",
+			base:     Become{RemoteUser: "deploy", Become: true, User: "postgres"},
+			task:     Task{Become: &pFalse},
+			expected: "deploy",
+		},
+		{
+			name:    "unsupported method",
+			task:    Task{Become: &pTrue, BecomeMethod: "pbrun"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := taskBecomeContext(NewBecomeContext(context.Background(), tt.base), tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			b, ok := BecomeFromContext(ctx)
+			if !ok {
+				t.Fatal("expected become settings in context")
+			}
+			if got := b.runAs(); got != tt.expected {
+				t.Errorf("expected to run as %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestBecomeCredential(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := becomeCredential(NewBecomeContext(context.Background(), Become{RemoteUser: current.Username}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred != nil {
+		t.Errorf("expected no credential to run as the current user, got %+v", cred)
+	}
+
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user")
+	}
+
+	cred, err = becomeCredential(NewBecomeContext(context.Background(), Become{Become: true, User: "nobody"}))
+	if os.Getuid() != 0 {
+		if err == nil {
+			t.Error("expected an error switching users when not running as root")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(nobody.Uid, strconv.FormatUint(uint64(cred.Uid), 10)); diff != "" {
+		t.Errorf("uid mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(nobody.Gid, strconv.FormatUint(uint64(cred.Gid), 10)); diff != "" {
+		t.Errorf("gid mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCommandApplyBecome(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("no nobody user")
+	}
+
+	ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+		m.EXPECT().SetCredential(gomock.Not(gomock.Nil())).Times(1)
+		m.EXPECT().SetStdout(gomock.Any()).Times(1)
+		m.EXPECT().SetStderr(gomock.Any()).Times(1)
+		m.EXPECT().Run().Return(nil).Times(1)
+	})
+	ctx = NewBecomeContext(ctx, Become{Become: true, User: "nobody"})
+
+	c := &Command{Command: &proto.Command{Cmd: "id -u"}}
+	if _, err := c.Apply(ctx, "", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTaskApplyBecome(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user")
+	}
+
+	tests := []struct {
+		name    string
+		mode    os.FileMode
+		wantErr bool
+	}{
+		{
+			name: "writable by the user",
+			mode: 0o777,
+		},
+		{
+			// Root could write there: the task doesn't run as root.
+			name:    "only writable by root",
+			mode:    0o755,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// Temporary directories are only open to their owner.
+			if err := os.Chmod(filepath.Dir(dir), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(dir, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(dir, "dest")
+
+			ctx := NewBecomeContext(context.Background(), Become{Become: true, User: "nobody"})
+			task := Task{Content: &Copy{Copy: &proto.Copy{Content: "hello", Dest: dest}}}
+			_, err := task.Apply(ctx, dir, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+
+			if os.Getuid() != 0 || os.Geteuid() != 0 {
+				t.Fatalf("the executer didn't switch back to root: uid %d, euid %d", os.Getuid(), os.Geteuid())
+			}
+			if tt.wantErr {
+				return
+			}
+
+			info, err := os.Stat(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strconv.FormatUint(uint64(info.Sys().(*syscall.Stat_t).Uid), 10); got != nobody.Uid {
+				t.Errorf("got %s owned by %s, want %s", dest, got, nobody.Uid)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/registry"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+// rescuedContextKey is set in the context of tasks whose failures get
+// rescued.
+var rescuedContextKey = &struct{ name string }{"rescued"}
+
+// Block runs its tasks, then the ones in `rescue` if one of them failed, and
+// finally the ones in `always`. The block's keywords, like `when` or
+// `become`, apply to all of them.
+type Block struct {
+	*proto.Block
+}
+
+type BlockResult struct {
+	CommonResult `yaml:",inline"`
+}
+
+func init() {
+	reg := registry.TaskRegistration{
+		// Blocks are parsed along with tasks, since `rescue` and `always`
+		// are keywords of the task holding the block.
+		ProtoFactory: func() any { return &proto.Block{} },
+		ProtoWrapper: func(msg any) any { return &proto.Task_Block{Block: msg.(*proto.Block)} },
+		ExecAdapter: func(content any) any {
+			if c, ok := content.(*proto.Task_Block); ok {
+				return &Block{Block: c.Block}
+			}
+			return nil
+		},
+	}
+	registry.Register("block", reg, (*proto.Task_Block)(nil))
+}
+
+func (b *Block) Validate() error {
+	if len(b.Tasks) == 0 {
+		return errors.New("a block needs at least one task")
+	}
+	return nil
+}
+
+// Apply runs the block. Blocks are usually run by ExecuteTask, but this is
+// used for the ones in imported or included files.
+func (b *Block) Apply(ctx context.Context, parentPath string, isRole bool) (Result, error) {
+	result := BlockResult{}
+	if err := b.run(ctx, loggerFromContext(ctx), nil, parentPath, isRole); err != nil {
+		result.TaskFailed()
+		return &result, err
+	}
+	return &result, nil
+}
+
+// run runs the block's sections. when are the block's conditions, which its
+// tasks inherit.
+func (b *Block) run(ctx context.Context, logger *zap.Logger, when []string, parentPath string, isRole bool) error {
+	if err := b.Validate(); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	tasksCtx := ctx
+	if len(b.Rescue) > 0 {
+		tasksCtx = context.WithValue(ctx, rescuedContextKey, true)
+	}
+	err := runBlockTasks(tasksCtx, logger, b.Tasks, when, parentPath, isRole)
+	if err != nil && len(b.Rescue) > 0 {
+		logger.Debug("rescuing block", zap.Error(err))
+		rescueCtx := failedTaskContext(ctx, err)
+		err = runBlockTasks(rescueCtx, logger, b.Rescue, when, parentPath, isRole)
+	}
+
+	return errors.Join(err, runBlockTasks(ctx, logger, b.Always, when, parentPath, isRole))
+}
+
+// runBlockTasks runs tasks until one of them fails, on top of their own
+// conditions.
+func runBlockTasks(ctx context.Context, logger *zap.Logger, tasks []*proto.Task, when []string, parentPath string, isRole bool) error {
+	for _, protoTask := range tasks {
+		task, err := FromProto(protoTask)
+		if err != nil {
+			return fmt.Errorf("failed to convert task: %w", err)
+		}
+		task.When = append(slices.Clone(when), task.When...)
+
+		if err := ExecuteTask(ctx, logger, *task, parentPath, isRole); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failedTaskContext returns the context `rescue` sections run with, where
+// `ansible_failed_task` and `ansible_failed_result` describe the task that
+// failed. Like registered variables, they stay set afterwards.
+func failedTaskContext(ctx context.Context, err error) context.Context {
+	vars, ok := variables.FromContext(ctx)
+	if !ok {
+		vars = variables.Variables{}
+		ctx = variables.NewContext(ctx, vars)
+	}
+
+	failedTask := map[string]any{}
+	failedResult := map[string]any{}
+
+	var taskErr *TaskError
+	if errors.As(err, &taskErr) {
+		failedTask["name"] = taskErr.Task.Name
+		failedTask["action"] = taskErr.Task.Module
+		if taskErr.Result != nil {
+			if m, err := resultToMap(taskErr.Result); err == nil {
+				failedResult = m
+			}
+		}
+	}
+
+	failedResult["failed"] = true
+	if msg, _ := failedResult["msg"].(string); msg == "" {
+		failedResult["msg"] = err.Error()
+	}
+
+	vars["ansible_failed_task"] = failedTask
+	vars["ansible_failed_result"] = failedResult
+	return ctx
+}
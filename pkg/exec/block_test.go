@@ -0,0 +1,215 @@
+package exec
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mickael-carl/sophons/pkg/callback"
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+// taskOutcomes returns the names of the tasks recorded as done, along with
+// how they went.
+func taskOutcomes(r *callbackRecorder) []string {
+	var outcomes []string
+	for _, e := range r.events {
+		result := e.GetTaskResult()
+		if result == nil {
+			continue
+		}
+		outcome := "ok"
+		switch {
+		case result.GetRescued():
+			outcome = "rescued"
+		case result.GetFailed():
+			outcome = "failed"
+		case result.GetSkipped():
+			outcome = "skipped"
+		case result.GetChanged():
+			outcome = "changed"
+		}
+		outcomes = append(outcomes, result.GetName()+": "+outcome)
+	}
+	return outcomes
+}
+
+func whenTask(name, when string) *proto.Task {
+	task := commandTask(name)
+	task.When = []string{when}
+	return task
+}
+
+func TestBlock(t *testing.T) {
+	boom := errors.New("boom")
+
+	tests := []struct {
+		name     string
+		task     *proto.Task
+		runErrs  []error
+		wantErr  bool
+		expected []string
+	}{
+		{
+			name: "no failure",
+			task: &proto.Task{Content: &proto.Task_Block{Block: &proto.Block{
+				Tasks:  []*proto.Task{commandTask("first"), commandTask("second")},
+				Rescue: []*proto.Task{commandTask("rescue")},
+				Always: []*proto.Task{commandTask("always")},
+			}}},
+			runErrs:  []error{nil, nil, nil},
+			expected: []string{"first: changed", "second: changed", "always: changed"},
+		},
+		{
+			name: "rescued",
+			task: &proto.Task{Content: &proto.Task_Block{Block: &proto.Block{
+				Tasks: []*proto.Task{commandTask("first"), commandTask("fails"), commandTask("never")},
+				Rescue: []*proto.Task{
+					whenTask("rescue", "ansible_failed_task.name == 'fails' and ansible_failed_task.action == 'command' and ansible_failed_result.failed"),
+				},
+				Always: []*proto.Task{commandTask("always")},
+			}}},
+			runErrs:  []error{nil, boom, nil, nil},
+			expected: []string{"first: changed", "fails: rescued", "rescue: changed", "always: changed"},
+		},
+		{
+			name: "not rescued",
+			task: &proto.Task{Content: &proto.Task_Block{Block: &proto.Block{
+				Tasks:  []*proto.Task{commandTask("fails"), commandTask("never")},
+				Always: []*proto.Task{commandTask("always")},
+			}}},
+			runErrs:  []error{boom, nil},
+			wantErr:  true,
+			expected: []string{"fails: failed", "always: changed"},
+		},
+		{
+			name: "rescue fails",
+			task: &proto.Task{Content: &proto.Task_Block{Block: &proto.Block{
+				Tasks:  []*proto.Task{commandTask("fails")},
+				Rescue: []*proto.Task{commandTask("rescue fails")},
+				Always: []*proto.Task{commandTask("always")},
+			}}},
+			runErrs:  []error{boom, boom, nil},
+			wantErr:  true,
+			expected: []string{"fails: rescued", "rescue fails: failed", "always: changed"},
+		},
+		{
+			name: "when and vars inherited",
+			task: &proto.Task{
+				When: []string{"answer == 42"},
+				Vars: &structpb.Struct{Fields: map[string]*structpb.Value{
+					"answer": structpb.NewNumberValue(42),
+				}},
+				Content: &proto.Task_Block{Block: &proto.Block{
+					Tasks: []*proto.Task{
+						commandTask("runs"),
+						whenTask("skipped", "answer != 42"),
+					},
+					Always: []*proto.Task{
+						{
+							When: []string{"false"},
+							Content: &proto.Task_Block{Block: &proto.Block{
+								Tasks: []*proto.Task{commandTask("nested")},
+							}},
+						},
+					},
+				}},
+			},
+			runErrs:  []error{nil},
+			expected: []string{"runs: changed", "skipped: skipped", "nested: skipped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+				m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+				m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+				var calls []any
+				for _, err := range tt.runErrs {
+					calls = append(calls, m.EXPECT().Run().Return(err))
+				}
+				gomock.InOrder(calls...)
+			})
+			vars := variables.Variables{}
+			ctx = variables.NewContext(ctx, vars)
+			recorder := &callbackRecorder{}
+			ctx = callback.NewContext(ctx, recorder, "localhost")
+
+			task, err := FromProto(tt.task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ExecuteTask(ctx, zap.NewNop(), *task, "", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.expected, taskOutcomes(recorder)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+			if _, ok := vars["answer"]; ok {
+				t.Error("expected the block's variables not to outlive it")
+			}
+		})
+	}
+}
+
+func TestBlockInclude(t *testing.T) {
+	ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+		m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+		m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+		gomock.InOrder(
+			m.EXPECT().Run().Return(errors.New("boom")),
+			m.EXPECT().Run().Return(nil),
+		)
+	})
+	vars := variables.Variables{}
+	ctx = variables.NewContext(ctx, vars)
+
+	// Blocks in included files are applied directly.
+	task, err := FromProto(&proto.Task{Content: &proto.Task_Block{Block: &proto.Block{
+		Tasks:  []*proto.Task{commandTask("fails")},
+		Rescue: []*proto.Task{{Register: "rescued", Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}}}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := task.Apply(ctx, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := vars["rescued"]; !ok {
+		t.Error("expected the rescue task's result to be registered")
+	}
+	if diff := cmp.Diff(map[string]any{"name": "fails", "action": "command"}, vars["ansible_failed_task"]); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStructToVariables(t *testing.T) {
+	s, err := structpb.NewStruct(map[string]any{
+		"int":    42,
+		"float":  1.5,
+		"list":   []any{1, "two"},
+		"nested": map[string]any{"port": 8080},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := variables.Variables{
+		"int":    42,
+		"float":  1.5,
+		"list":   []any{1, "two"},
+		"nested": map[string]any{"port": 8080},
+	}
+	if diff := cmp.Diff(expected, structToVariables(s)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
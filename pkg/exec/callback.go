@@ -0,0 +1,67 @@
+package exec
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mickael-carl/sophons/pkg/callback"
+	protopackage "github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// notifyTaskStart tells the callback carried by ctx that task starts. Failing
+// to do so doesn't fail the task.
+func notifyTaskStart(ctx context.Context, logger *zap.Logger, task Task) {
+	if err := callback.TaskStart(ctx, &protopackage.TaskStart{
+		Name:    task.Name,
+		Module:  task.Module,
+		Handler: task.Handler,
+	}); err != nil {
+		logger.Warn("failed to run task start callback", zap.Error(err))
+	}
+}
+
+// notifyTaskResult tells the callback carried by ctx about the result of
+// task. err is the error the task failed with, if any. Failing to do so
+// doesn't fail the task.
+func notifyTaskResult(ctx context.Context, logger *zap.Logger, task Task, result Result, err error) {
+	r := taskResult(task, result, err)
+	rescued, _ := ctx.Value(rescuedContextKey).(bool)
+	r.Ignored = r.Failed && task.IgnoreErrors
+	r.Rescued = r.Failed && !r.Ignored && rescued
+	if err := callback.TaskResult(ctx, r); err != nil {
+		logger.Warn("failed to run task result callback", zap.Error(err))
+	}
+}
+
+// taskResult builds the callback's view of a task's result.
+func taskResult(task Task, result Result, err error) *protopackage.TaskResult {
+	r := &protopackage.TaskResult{
+		Name:   task.Name,
+		Module: task.Module,
+	}
+
+	if result != nil {
+		r.Changed = result.IsChanged()
+		r.Failed = result.IsFailed()
+		r.Skipped = result.IsSkipped()
+
+		if resultMap, err := resultToMap(result); err == nil {
+			if s, err := structpb.NewStruct(resultMap); err == nil {
+				r.Result = s
+				r.Rc = int64(s.GetFields()["rc"].GetNumberValue())
+				r.Stdout = s.GetFields()["stdout"].GetStringValue()
+				r.Stderr = s.GetFields()["stderr"].GetStringValue()
+				r.Msg = s.GetFields()["msg"].GetStringValue()
+			}
+		}
+	}
+
+	if err != nil {
+		r.Failed = true
+		r.Msg = err.Error()
+	}
+
+	return r
+}
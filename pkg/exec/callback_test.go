@@ -0,0 +1,130 @@
+package exec
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/mickael-carl/sophons/pkg/callback"
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+// callbackRecorder is a callback.Callback keeping the events it's given.
+type callbackRecorder struct {
+	events []*proto.Event
+}
+
+func (r *callbackRecorder) PlayStart(_ string, play *proto.PlayStart) error {
+	r.events = append(r.events, &proto.Event{Event: &proto.Event_PlayStart{PlayStart: play}})
+	return nil
+}
+
+func (r *callbackRecorder) TaskStart(_ string, task *proto.TaskStart) error {
+	r.events = append(r.events, &proto.Event{Event: &proto.Event_TaskStart{TaskStart: task}})
+	return nil
+}
+
+func (r *callbackRecorder) TaskResult(_ string, result *proto.TaskResult) error {
+	r.events = append(r.events, &proto.Event{Event: &proto.Event_TaskResult{TaskResult: result}})
+	return nil
+}
+
+func (r *callbackRecorder) HostDone(string, error) error {
+	return nil
+}
+
+func (r *callbackRecorder) Done() error {
+	return nil
+}
+
+func TestExecuteTaskCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		when     []string
+		runErr   error
+		wantErr  bool
+		expected *proto.TaskResult
+	}{
+		{
+			name: "changed",
+			expected: &proto.TaskResult{
+				Name:    "say hello",
+				Module:  "command",
+				Changed: true,
+			},
+		},
+		{
+			name: "skipped",
+			when: []string{"false"},
+			expected: &proto.TaskResult{
+				Name:    "say hello",
+				Module:  "command",
+				Skipped: true,
+			},
+		},
+		{
+			name:    "failed",
+			runErr:  errors.New("boom"),
+			wantErr: true,
+			expected: &proto.TaskResult{
+				Name:   "say hello",
+				Module: "command",
+				Failed: true,
+				Rc:     -1,
+				Msg:    "failed to execute task: failed to execute command: boom",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+				if len(tt.when) > 0 {
+					return
+				}
+				m.EXPECT().SetStdout(gomock.Any())
+				m.EXPECT().SetStderr(gomock.Any())
+				m.EXPECT().Run().Return(tt.runErr)
+			})
+			ctx = variables.NewContext(ctx, variables.Variables{})
+			recorder := &callbackRecorder{}
+			ctx = callback.NewContext(ctx, recorder, "localhost")
+
+			task, err := FromProto(&proto.Task{
+				Name: "say hello",
+				When: tt.when,
+				Content: &proto.Task_Command{
+					Command: &proto.Command{Cmd: "echo hello"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ExecuteTask(ctx, zap.NewNop(), *task, "", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := []*proto.Event{
+				{
+					Event: &proto.Event_TaskStart{
+						TaskStart: &proto.TaskStart{Name: "say hello", Module: "command"},
+					},
+				},
+				{
+					Event: &proto.Event_TaskResult{TaskResult: tt.expected},
+				},
+			}
+
+			if diff := cmp.Diff(expected, recorder.events, protocmp.Transform(), protocmp.IgnoreFields(&proto.TaskResult{}, "result")); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package exec
+
+import "context"
+
+var checkModeContextKey = &struct{ name string }{"check mode"}
+
+// checkModeUnpredictable is the message of tasks skipped in check mode because
+// there's no telling what they would change.
+const checkModeUnpredictable = "skipped in check mode: the outcome can't be predicted"
+
+// NewCheckModeContext returns a new context where tasks run in check mode, if
+// check is set: they then report whether they would change anything, without
+// changing anything.
+func NewCheckModeContext(ctx context.Context, check bool) context.Context {
+	return context.WithValue(ctx, checkModeContextKey, check)
+}
+
+// CheckModeFromContext tells whether tasks run in check mode.
+func CheckModeFromContext(ctx context.Context) bool {
+	check, _ := ctx.Value(checkModeContextKey).(bool)
+	return check
+}
+
+// taskCheckModeContext applies the task's `check_mode` on top of the setting
+// in ctx.
+func taskCheckModeContext(ctx context.Context, task Task) context.Context {
+	if task.CheckMode == nil {
+		return ctx
+	}
+	return NewCheckModeContext(ctx, *task.CheckMode)
+}
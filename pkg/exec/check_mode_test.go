@@ -0,0 +1,367 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arduino/go-apt-client"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// snapshotDir describes everything under dir, to tell whether anything
+// changed.
+func snapshotDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	snapshot := map[string]string{}
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		desc := info.Mode().String()
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			desc += " -> " + target
+		case d.Type().IsRegular():
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			desc += " " + string(content)
+		}
+		snapshot[path] = desc
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestCheckModeFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content func(dir string) TaskContent
+		changed bool
+		skipped bool
+	}{
+		{
+			name: "file touch",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "new"), State: FileTouch}}
+			},
+			changed: true,
+		},
+		{
+			name: "file directory",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "newdir"), State: FileDirectory, Recurse: true}}
+			},
+			changed: true,
+		},
+		{
+			name: "file directory exists",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "dir"), State: FileDirectory}}
+			},
+		},
+		{
+			name: "file absent",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "dir"), State: FileAbsent}}
+			},
+			changed: true,
+		},
+		{
+			name: "file mode",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "existing"), State: FileFile, Mode: &proto.Mode{Value: "0600"}}}
+			},
+			changed: true,
+		},
+		{
+			name: "file link",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "link"), Src: "other", State: FileLink}}
+			},
+			changed: true,
+		},
+		{
+			name: "copy content",
+			content: func(dir string) TaskContent {
+				return &Copy{Copy: &proto.Copy{Content: "new", Dest: filepath.Join(dir, "existing")}}
+			},
+			changed: true,
+		},
+		{
+			name: "copy same content",
+			content: func(dir string) TaskContent {
+				return &Copy{Copy: &proto.Copy{Content: "existing", Dest: filepath.Join(dir, "existing")}}
+			},
+		},
+		{
+			name: "copy file into directory",
+			content: func(dir string) TaskContent {
+				return &Copy{Copy: &proto.Copy{Src: "existing", Dest: filepath.Join(dir, "dir")}}
+			},
+			changed: true,
+		},
+		{
+			name: "copy directory",
+			content: func(dir string) TaskContent {
+				return &Copy{Copy: &proto.Copy{Src: "dir", Dest: dir}}
+			},
+		},
+		{
+			name: "template",
+			content: func(dir string) TaskContent {
+				return &Template{Template: &proto.Template{Src: "greeting.j2", Dest: filepath.Join(dir, "greeting")}}
+			},
+			changed: true,
+		},
+		{
+			name: "get_url existing",
+			content: func(dir string) TaskContent {
+				return &GetURL{GetURL: &proto.GetURL{Url: "http://example.com/existing", Dest: filepath.Join(dir, "existing")}}
+			},
+		},
+		{
+			name: "get_url missing",
+			content: func(dir string) TaskContent {
+				return &GetURL{GetURL: &proto.GetURL{Url: "http://example.com/new", Dest: filepath.Join(dir, "new")}}
+			},
+			changed: true,
+		},
+		{
+			name: "get_url into directory",
+			content: func(dir string) TaskContent {
+				return &GetURL{GetURL: &proto.GetURL{Url: "http://example.com/new", Dest: filepath.Join(dir, "dir")}}
+			},
+			skipped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for path, content := range map[string]string{
+				"existing":              "existing",
+				"link":                  "",
+				"dir/file":              "file",
+				"files/existing":        "existing",
+				"files/dir/file":        "file",
+				"templates/greeting.j2": "hello",
+			} {
+				path = filepath.Join(dir, path)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if path == filepath.Join(dir, "link") {
+					if err := os.Symlink("existing", path); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before := snapshotDir(t, dir)
+			ctx := NewCheckModeContext(context.Background(), true)
+			result, err := tt.content(dir).Apply(ctx, dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.IsChanged() != tt.changed || result.IsSkipped() != tt.skipped {
+				t.Errorf("got changed %v and skipped %v, want %v and %v", result.IsChanged(), result.IsSkipped(), tt.changed, tt.skipped)
+			}
+			if diff := cmp.Diff(before, snapshotDir(t, dir)); diff != "" {
+				t.Errorf("files changed in check mode (-before +after):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckModeCommand(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content TaskContent
+		changed bool
+		skipped bool
+	}{
+		{name: "command", content: &Command{Command: &proto.Command{Cmd: "touch /nope"}}, skipped: true},
+		{name: "shell", content: &Shell{Shell: &proto.Shell{Cmd: "touch /nope"}}, skipped: true},
+		{name: "creates", content: &Command{Command: &proto.Command{Cmd: "touch /nope", Creates: filepath.Join(dir, "new")}}, changed: true},
+		{name: "created", content: &Shell{Shell: &proto.Shell{Cmd: "touch /nope", Creates: existing}}, skipped: true},
+		{name: "removes", content: &Shell{Shell: &proto.Shell{Cmd: "rm /nope", Removes: existing}}, changed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Commands don't run in check mode: the mock expects nothing.
+			ctx := NewCheckModeContext(newMockCommandContext(t, nil), true)
+			result, err := tt.content.Apply(ctx, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.IsChanged() != tt.changed || result.IsSkipped() != tt.skipped {
+				t.Errorf("got changed %v and skipped %v, want %v and %v", result.IsChanged(), result.IsSkipped(), tt.changed, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestCheckModeApt(t *testing.T) {
+	pTrue := true
+	repoLine := "deb https://download.docker.com/linux/debian bookworm stable"
+	repo := &apt.Repository{Enabled: true, URI: "https://download.docker.com/linux/debian", Distribution: "bookworm", Components: "stable"}
+
+	tests := []struct {
+		name     string
+		content  TaskContent
+		mockFunc func(*MockaptClient)
+		changed  bool
+		skipped  bool
+	}{
+		{
+			name:    "install",
+			content: &Apt{Apt: &proto.Apt{Name: &proto.PackageList{Items: []string{"foo", "bar"}}}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ListInstalled().Return([]*apt.Package{{Name: "foo"}}, nil)
+			},
+			changed: true,
+		},
+		{
+			name:    "installed",
+			content: &Apt{Apt: &proto.Apt{Name: &proto.PackageList{Items: []string{"foo"}}}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ListInstalled().Return([]*apt.Package{{Name: "foo"}}, nil)
+			},
+		},
+		{
+			name:    "latest installed",
+			content: &Apt{Apt: &proto.Apt{Name: &proto.PackageList{Items: []string{"foo"}}, State: AptLatest}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ListInstalled().Return([]*apt.Package{{Name: "foo"}}, nil)
+			},
+			skipped: true,
+		},
+		{
+			name:    "remove",
+			content: &Apt{Apt: &proto.Apt{Name: &proto.PackageList{Items: []string{"foo", "bar"}}, State: AptAbsent}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ListInstalled().Return([]*apt.Package{{Name: "foo"}}, nil)
+			},
+			changed: true,
+		},
+		{
+			name:     "update cache and clean",
+			content:  &Apt{Apt: &proto.Apt{UpdateCache: &pTrue, Clean: true, Upgrade: AptUpgradeNo}},
+			mockFunc: func(m *MockaptClient) {},
+			changed:  true,
+		},
+		{
+			name:     "upgrade",
+			content:  &Apt{Apt: &proto.Apt{Upgrade: AptUpgradeDist}},
+			mockFunc: func(m *MockaptClient) {},
+			skipped:  true,
+		},
+		{
+			name:    "add repository",
+			content: &AptRepository{AptRepository: &proto.AptRepository{Repo: repoLine}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ParseAPTConfigFolder("/etc/apt").Return(nil, nil)
+				m.EXPECT().ParseAPTConfigLine(repoLine).Return(repo)
+			},
+			changed: true,
+		},
+		{
+			name:    "existing repository",
+			content: &AptRepository{AptRepository: &proto.AptRepository{Repo: repoLine}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ParseAPTConfigFolder("/etc/apt").Return(apt.RepositoryList{repo}, nil)
+				m.EXPECT().ParseAPTConfigLine(repoLine).Return(repo)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing but reading what's there is expected from the client.
+			ctx := NewCheckModeContext(newMockAptContext(t, tt.mockFunc), true)
+			result, err := tt.content.Apply(ctx, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.IsChanged() != tt.changed || result.IsSkipped() != tt.skipped {
+				t.Errorf("got changed %v and skipped %v, want %v and %v", result.IsChanged(), result.IsSkipped(), tt.changed, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestExecuteTaskCheckMode(t *testing.T) {
+	pFalse, pTrue := false, true
+
+	tests := []struct {
+		name      string
+		check     bool
+		checkMode *bool
+		runs      bool
+	}{
+		{name: "default", runs: true},
+		{name: "check", check: true},
+		{name: "task in check mode", checkMode: &pTrue},
+		{name: "task not in check mode", check: true, checkMode: &pFalse, runs: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+				if tt.runs {
+					m.EXPECT().SetStdout(gomock.Any())
+					m.EXPECT().SetStderr(gomock.Any())
+					m.EXPECT().Run().Return(nil)
+				}
+			})
+			ctx = NewCheckModeContext(ctx, tt.check)
+
+			task := Task{
+				Name:      fmt.Sprintf("check mode %v", tt.checkMode),
+				CheckMode: tt.checkMode,
+				Content: &Block{Block: &proto.Block{Tasks: []*proto.Task{{
+					Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+				}}}},
+			}
+			if err := ExecuteTask(ctx, zap.NewNop(), task, "", false); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -3,8 +3,6 @@ package exec
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
 	"strings"
 	"time"
 
@@ -17,8 +15,6 @@ import (
 //	}
 type Command struct {
 	*proto.Command `yaml:",inline"`
-
-	cmdFactory cmdFactory
 }
 
 type CommandResult struct {
@@ -50,18 +46,18 @@ func (c *Command) Validate() error {
 }
 
 func (c *Command) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
-	if ctxCmdFactory, ok := ctx.Value(commandFactoryContextKey).(cmdFactory); ok {
-		c.cmdFactory = ctxCmdFactory
-	} else {
-		c.cmdFactory = realCmdFactory
-	}
-
 	result := CommandResult{}
+
+	factory, err := taskCmdFactory(ctx)
+	if err != nil {
+		result.TaskFailed()
+		return &result, err
+	}
 	var name string
 	var args []string
 	if c.Cmd != "" {
 		if c.ExpandArgumentVars {
-			splitCmd := strings.Fields(os.ExpandEnv(c.Cmd))
+			splitCmd := strings.Fields(expandEnv(ctx, c.Cmd))
 			name = splitCmd[0]
 			if len(splitCmd) > 1 {
 				args = splitCmd[1:]
@@ -77,7 +73,7 @@ func (c *Command) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		if c.ExpandArgumentVars {
 			var expandedArgs []string
 			for _, arg := range c.Argv {
-				expandedArgs = append(expandedArgs, os.ExpandEnv(arg))
+				expandedArgs = append(expandedArgs, expandEnv(ctx, arg))
 			}
 			name = expandedArgs[0]
 			if len(expandedArgs) > 1 {
@@ -101,8 +97,13 @@ func (c *Command) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		return &result, nil
 	}
 
+	if CheckModeFromContext(ctx) {
+		checkCommand(&result.CommonResult, c.Creates, c.Removes)
+		return &result, nil
+	}
+
 	start := time.Now()
-	stdout, stderr, rc, err := ApplyCommand(c.cmdFactory, c.Chdir, c.Stdin, c.StdinAddNewline, name, args)
+	stdout, stderr, rc, err := ApplyCommand(factory, c.Chdir, c.Stdin, c.StdinAddNewline, name, args)
 	end := time.Now()
 
 	result.Start = start
@@ -117,11 +118,6 @@ func (c *Command) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		return &result, fmt.Errorf("failed to execute command: %w", err)
 	}
 
-	// TODO: Debug.
-	if stdout != "" {
-		log.Print(stdout)
-	}
-
 	result.TaskChanged()
 	return &result, nil
 }
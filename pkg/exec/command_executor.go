@@ -1,11 +1,13 @@
 package exec
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 //go:generate mockgen -destination=mock_command_executor_test.go -package=exec . commandExecutor
@@ -19,6 +21,8 @@ type commandExecutor interface {
 	SetStdin(io.Reader)
 	SetStdout(io.Writer)
 	SetStderr(io.Writer)
+	SetEnv([]string)
+	SetCredential(*syscall.Credential)
 }
 
 // realCommandExecutor is a wrapper around *exec.Cmd that implements CommandExecutor.
@@ -46,6 +50,17 @@ func (r *realCommandExecutor) SetStderr(stderr io.Writer) {
 	r.cmd.Stderr = stderr
 }
 
+func (r *realCommandExecutor) SetEnv(env []string) {
+	r.cmd.Env = env
+}
+
+func (r *realCommandExecutor) SetCredential(cred *syscall.Credential) {
+	if r.cmd.SysProcAttr == nil {
+		r.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	r.cmd.SysProcAttr.Credential = cred
+}
+
 // CmdFactory creates a CommandExecutor from a command name and arguments.
 type cmdFactory func(name string, args ...string) commandExecutor
 
@@ -54,6 +69,22 @@ func realCmdFactory(name string, args ...string) commandExecutor {
 	return &realCommandExecutor{cmd: exec.Command(name, args...)}
 }
 
+// taskCmdFactory returns the factory creating the commands tasks run: as the
+// user tasks should run as, with the environment variables set by plays, roles
+// and tasks. The factory they're created with can be set in ctx, for tests.
+func taskCmdFactory(ctx context.Context) (cmdFactory, error) {
+	factory, ok := ctx.Value(commandFactoryContextKey).(cmdFactory)
+	if !ok {
+		factory = realCmdFactory
+	}
+
+	factory, err := becomeCmdFactory(ctx, factory)
+	if err != nil {
+		return nil, err
+	}
+	return environmentCmdFactory(ctx, factory), nil
+}
+
 type exitCoder interface {
 	ExitCode() int
 }
@@ -133,3 +164,16 @@ func shouldApply(creates, removes string) (bool, error) {
 
 	return true, nil
 }
+
+// checkCommand reports what a command would do in check mode, once
+// shouldApply says it would run. Only `creates` and `removes` tell it would
+// change something: without them, the command is skipped.
+func checkCommand(result *CommonResult, creates, removes string) {
+	if creates == "" && removes == "" {
+		result.Msg = checkModeUnpredictable
+		result.TaskSkipped()
+		return
+	}
+	result.Msg = "command would have run if not in check mode"
+	result.TaskChanged()
+}
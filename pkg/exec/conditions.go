@@ -0,0 +1,74 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mickael-carl/sophons/pkg/exec/util"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+// applyConditions overrides the result of applying task with its
+// `changed_when` and `failed_when` conditions, if any. err is the error
+// applying the task returned. Conditions see the result in the variable the
+// task registers it in.
+func applyConditions(ctx context.Context, task Task, result Result, err error) (Result, error) {
+	if len(task.ChangedWhen) == 0 && len(task.FailedWhen) == 0 {
+		return result, err
+	}
+
+	if err != nil {
+		result.TaskFailed()
+	}
+
+	if len(task.ChangedWhen) > 0 {
+		changed, condErr := util.JinjaProcessWhen(resultContext(ctx, task, result, err), task.ChangedWhen...)
+		if condErr != nil {
+			result.TaskFailed()
+			return result, fmt.Errorf("failed to process changed_when condition: %w", condErr)
+		}
+		result.SetChanged(changed)
+	}
+
+	if len(task.FailedWhen) == 0 {
+		return result, err
+	}
+
+	failed, condErr := util.JinjaProcessWhen(resultContext(ctx, task, result, err), task.FailedWhen...)
+	if condErr != nil {
+		result.TaskFailed()
+		return result, fmt.Errorf("failed to process failed_when condition: %w", condErr)
+	}
+	result.SetFailed(failed)
+
+	switch {
+	case !failed:
+		return result, nil
+	case err == nil:
+		return result, errors.New("failed_when condition is true")
+	default:
+		return result, err
+	}
+}
+
+// resultContext returns a context where result is registered, if task
+// registers it.
+func resultContext(ctx context.Context, task Task, result Result, err error) context.Context {
+	if task.Register == "" {
+		return ctx
+	}
+
+	vars, ok := variables.FromContext(ctx)
+	if !ok {
+		vars = variables.Variables{}
+	}
+	resultVars := variables.Variables{}
+	resultVars.Merge(vars)
+	resultCtx := variables.NewContext(ctx, resultVars)
+
+	// This only fails if the result can't be converted, in which case the
+	// conditions fail on the variable being undefined.
+	_ = registerResult(resultCtx, task, result, err)
+	return resultCtx
+}
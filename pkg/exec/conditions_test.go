@@ -0,0 +1,143 @@
+package exec
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mickael-carl/sophons/pkg/callback"
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func TestExecuteTaskConditions(t *testing.T) {
+	tests := []struct {
+		name         string
+		changedWhen  []string
+		failedWhen   []string
+		ignoreErrors bool
+		loop         []any
+		runErr       error
+		wantErr      bool
+		wantChanged  bool
+		wantFailed   bool
+		wantIgnored  bool
+	}{
+		{
+			name:        "no conditions",
+			wantChanged: true,
+		},
+		{
+			name:        "changed_when false",
+			changedWhen: []string{"false"},
+		},
+		{
+			name:        "changed_when on the registered result",
+			changedWhen: []string{"result.rc == 0", "not result.failed"},
+			wantChanged: true,
+		},
+		{
+			name:       "failed_when false on a failure",
+			failedWhen: []string{"false"},
+			runErr:     errors.New("boom"),
+		},
+		{
+			name:        "failed_when on the registered result",
+			failedWhen:  []string{"result.changed"},
+			wantErr:     true,
+			wantChanged: true,
+			wantFailed:  true,
+		},
+		{
+			name:        "failed_when conditions all have to hold",
+			failedWhen:  []string{"result.changed", "false"},
+			wantChanged: true,
+		},
+		{
+			name:        "failed_when undefined variable",
+			failedWhen:  []string{"nope.rc"},
+			wantErr:     true,
+			wantChanged: true,
+			wantFailed:  true,
+		},
+		{
+			name:         "ignore_errors",
+			ignoreErrors: true,
+			runErr:       errors.New("boom"),
+			wantFailed:   true,
+			wantIgnored:  true,
+		},
+		{
+			name:        "changed_when in a loop",
+			changedWhen: []string{"item != 'b'"},
+			loop:        []any{"a", "b"},
+			wantChanged: true,
+		},
+		{
+			name:        "changed_when false for all items",
+			changedWhen: []string{"item == 'c'"},
+			loop:        []any{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+				m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+				m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+				m.EXPECT().Run().Return(tt.runErr).AnyTimes()
+			})
+			vars := variables.Variables{}
+			ctx = variables.NewContext(ctx, vars)
+			recorder := &callbackRecorder{}
+			ctx = callback.NewContext(ctx, recorder, "localhost")
+
+			pt := &proto.Task{
+				Name:         "check",
+				Register:     "result",
+				ChangedWhen:  tt.changedWhen,
+				FailedWhen:   tt.failedWhen,
+				IgnoreErrors: tt.ignoreErrors,
+				Content:      &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+			}
+			if tt.loop != nil {
+				loop, err := structpb.NewValue(tt.loop)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pt.Loop = loop
+			}
+			task, err := FromProto(pt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ExecuteTask(ctx, zap.NewNop(), *task, "", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			result := recorder.events[len(recorder.events)-1].GetTaskResult()
+			if result.GetChanged() != tt.wantChanged {
+				t.Errorf("expected changed=%v, got %v", tt.wantChanged, result.GetChanged())
+			}
+			if result.GetFailed() != tt.wantFailed {
+				t.Errorf("expected failed=%v, got %v", tt.wantFailed, result.GetFailed())
+			}
+			if result.GetIgnored() != tt.wantIgnored {
+				t.Errorf("expected ignored=%v, got %v", tt.wantIgnored, result.GetIgnored())
+			}
+
+			registered, ok := vars["result"].(map[string]any)
+			if !ok {
+				t.Fatal("expected the result to be registered")
+			}
+			if registered["failed"] != tt.wantFailed {
+				t.Errorf("expected registered failed=%v, got %v", tt.wantFailed, registered["failed"])
+			}
+		})
+	}
+}
@@ -1,15 +1,23 @@
 package exec
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 
+	"github.com/mickael-carl/sophons/pkg/exec/util"
 	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/registry"
 )
@@ -22,6 +30,18 @@ type Copy struct {
 }
 
 type CopyResult struct {
+	BackupFile string `yaml:"backup_file,omitempty"`
+	Checksum   string
+	Dest       string
+	Gid        uint64
+	Group      string
+	MD5Sum     string
+	Mode       string
+	Owner      string
+	Size       uint64
+	State      string
+	Uid        uint64
+
 	CommonResult `yaml:",inline"`
 }
 
@@ -60,6 +80,27 @@ func copySingleFile(src, dst string) error {
 	return nil
 }
 
+// checksums returns the SHA1 and MD5 checksums of what r reads.
+func checksums(r io.Reader) (string, string, error) {
+	sha1sum, md5sum := sha1.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha1sum, md5sum), r); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(sha1sum.Sum(nil)), hex.EncodeToString(md5sum.Sum(nil)), nil
+}
+
+// fileChecksum returns the SHA1 checksum of the file at path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum, _, err := checksums(f)
+	return sum, err
+}
+
 func (c *Copy) Validate() error {
 	// We don't support copying random files from the controller, as it seems
 	// like a bad idea. All files should belong in a role. This might change
@@ -84,90 +125,465 @@ func (c *Copy) Validate() error {
 		return errors.New("dest is required")
 	}
 
-	return nil
+	return validateCommand(c.Copy.Validate)
+}
+
+// force tells whether files that exist already are replaced. It's the
+// default.
+func (c *Copy) force() bool {
+	return c.Force == nil || *c.Force
+}
+
+// localFollow tells whether links in the source are followed, rather than
+// copied as links. It's the default.
+func (c *Copy) localFollow() bool {
+	return c.LocalFollow == nil || *c.LocalFollow
+}
+
+// copyEntry is a file, a directory or a link copy puts in place.
+type copyEntry struct {
+	dest string
+	// src is the file the contents come from, unless it's content.
+	src     string
+	content []byte
+	// mode is the mode set on files, if any.
+	mode string
+	dir  bool
+	// link is the target of links copied as links.
+	link string
+}
+
+func (e copyEntry) open() (io.ReadCloser, error) {
+	if e.src == "" {
+		return io.NopCloser(bytes.NewReader(e.content)), nil
+	}
+	return os.Open(e.src)
+}
+
+// checksums returns the SHA1 and MD5 checksums of the file's contents.
+func (e copyEntry) checksums() (string, string, error) {
+	r, err := e.open()
+	if err != nil {
+		return "", "", err
+	}
+	defer r.Close()
+
+	return checksums(r)
+}
+
+// diff returns the diff of replacing what's at dest with the file.
+func (e copyEntry) diff(dest string) (Diff, error) {
+	content := e.content
+	header := "dynamically generated"
+	if e.src != "" {
+		var err error
+		if content, err = os.ReadFile(e.src); err != nil {
+			return Diff{}, err
+		}
+		header = e.src
+	}
+	return contentDiff(dest, content, header)
+}
+
+// write writes the file at dest, returning the path of the backup of the one
+// it replaces, if any.
+func (e copyEntry) write(dest string, opts writeOptions) (string, error) {
+	r, err := e.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	return writeFile(dest, r, opts)
+}
+
+// fileMode returns the mode set on a copied file. With `mode: preserve`, it's
+// the one of its source, described by info.
+func (c *Copy) fileMode(info fs.FileInfo) string {
+	mode := c.Mode.GetValue()
+	if mode == "preserve" {
+		return fmt.Sprintf("%04o", info.Mode().Perm())
+	}
+	return mode
+}
+
+// fileDest returns where a single file is copied to: in dest if it's a
+// directory, or at dest otherwise.
+func (c *Copy) fileDest() (string, error) {
+	if strings.HasSuffix(c.Dest, "/") {
+		return filepath.Join(c.Dest, filepath.Base(c.Src)), nil
+	}
+
+	d, err := os.Stat(c.Dest)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return c.Dest, nil
+		}
+		return "", err
+	}
+
+	if d.IsDir() {
+		return filepath.Join(c.Dest, filepath.Base(c.Src)), nil
+	}
+	return c.Dest, nil
 }
 
-func (c *Copy) copyDir(actualSrc string) error {
-	copyContentsOnly := strings.HasSuffix(actualSrc, string(os.PathSeparator))
+// entries returns what copying srcPath, described by info, puts in place.
+// Directories come before what's in them.
+func (c *Copy) entries(srcPath string, info fs.FileInfo) ([]copyEntry, error) {
+	if c.Content != "" {
+		return []copyEntry{{dest: c.Dest, content: []byte(c.Content), mode: c.Mode.GetValue()}}, nil
+	}
+
+	if !info.IsDir() {
+		dest, err := c.fileDest()
+		if err != nil {
+			return nil, err
+		}
+
+		entries := []copyEntry{{dest: dest, src: srcPath, mode: c.fileMode(info)}}
+		if strings.HasSuffix(c.Dest, "/") {
+			entries = append([]copyEntry{{dest: c.Dest, dir: true}}, entries...)
+		}
+		return entries, nil
+	}
 
+	// With a trailing slash, only what's in the directory is copied.
 	dstDir := c.Dest
-	if !copyContentsOnly {
+	if !strings.HasSuffix(c.Src, "/") {
 		dstDir = filepath.Join(c.Dest, filepath.Base(c.Src))
 	}
+	return c.dirEntries(srcPath, dstDir)
+}
 
-	if err := os.MkdirAll(dstDir, 0o777); err != nil {
-		return fmt.Errorf("failed to create destination directory %s: %w", dstDir, err)
+// dirEntries returns what copying the directory srcDir to dstDir puts in
+// place.
+func (c *Copy) dirEntries(srcDir, dstDir string) ([]copyEntry, error) {
+	// Walking a link doesn't go into the directory it points to.
+	srcDir, err := filepath.EvalSymlinks(srcDir)
+	if err != nil {
+		return nil, err
 	}
 
-	return filepath.WalkDir(actualSrc, func(path string, d fs.DirEntry, err error) error {
+	var entries []copyEntry
+	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		relPath, err := filepath.Rel(actualSrc, path)
+		relPath, err := filepath.Rel(srcDir, path)
 		if err != nil {
 			return err
 		}
+		dest := filepath.Join(dstDir, relPath)
 
 		if d.IsDir() {
-			// TODO: change when adding c.Mode.
-			return os.MkdirAll(filepath.Join(dstDir, relPath), 0o777)
+			entries = append(entries, copyEntry{dest: dest, dir: true})
+			return nil
 		}
 
-		return copySingleFile(path, filepath.Join(dstDir, relPath))
+		if d.Type()&fs.ModeSymlink != 0 && !c.localFollow() {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, copyEntry{dest: dest, link: target})
+			return nil
+		}
+
+		// This follows links.
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			linked, err := c.dirEntries(path, dest)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, linked...)
+			return nil
+		}
+
+		entries = append(entries, copyEntry{dest: dest, src: path, mode: c.fileMode(info)})
+		return nil
 	})
+	return entries, err
 }
 
-func (c *Copy) copyContent() error {
-	if err := os.WriteFile(c.Dest, []byte(c.Content), 0o666); err != nil {
-		return fmt.Errorf("failed to write content to %s: %w", c.Dest, err)
+// putFile puts the file e in place, and tells whether that changed anything.
+func (c *Copy) putFile(ctx context.Context, result *CopyResult, e copyEntry, uid, gid int) (bool, error) {
+	check := CheckModeFromContext(ctx)
+
+	dest := e.dest
+	info, err := os.Lstat(dest)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
 	}
-	return nil
+
+	if exists && !c.force() {
+		return false, nil
+	}
+
+	// A link is replaced by the file, unless it's followed.
+	isLink := exists && info.Mode()&fs.ModeSymlink != 0
+	if isLink && c.Follow {
+		if dest, err = filepath.EvalSymlinks(dest); err != nil {
+			return false, err
+		}
+		if info, err = os.Stat(dest); err != nil {
+			return false, err
+		}
+		isLink = false
+	}
+
+	if exists && info.IsDir() {
+		return false, fmt.Errorf("%s is a directory", dest)
+	}
+
+	changed := !exists || isLink
+	if !changed {
+		sum, _, err := e.checksums()
+		if err != nil {
+			return false, err
+		}
+		destSum, err := fileChecksum(dest)
+		if err != nil {
+			return false, err
+		}
+		changed = sum != destSum
+	}
+
+	if changed {
+		if DiffModeFromContext(ctx) {
+			d, err := e.diff(dest)
+			if err != nil {
+				return false, err
+			}
+			addDiff(ctx, &result.CommonResult, d)
+		}
+
+		if check {
+			return true, nil
+		}
+
+		validate, err := fileValidator(ctx, c.Copy.Validate)
+		if err != nil {
+			return false, err
+		}
+
+		// Links not followed are replaced by writing the file.
+		opts := writeOptions{
+			Backup:       c.Backup,
+			UnsafeWrites: c.UnsafeWrites,
+			Validate:     validate,
+			Mode:         e.mode,
+			UID:          uid,
+			GID:          gid,
+		}
+		if result.BackupFile, err = e.write(dest, opts); err != nil {
+			return false, err
+		}
+	}
+
+	attrsChanged, err := c.putAttrs(ctx, dest, e.mode, uid, gid)
+	return changed || attrsChanged, err
 }
 
-func (c *Copy) copyFile(actualSrc string) error {
-	if strings.HasSuffix(c.Dest, "/") {
-		if err := os.Mkdir(c.Dest, 0o777); err != nil && !errors.Is(err, fs.ErrExist) {
-			return err
+// putDir creates the directory e if it doesn't exist, and tells whether that
+// changed anything.
+func (c *Copy) putDir(ctx context.Context, e copyEntry, uid, gid int) (bool, error) {
+	_, err := os.Stat(e.dest)
+	if err == nil {
+		return c.putAttrs(ctx, e.dest, "", uid, gid)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	if CheckModeFromContext(ctx) {
+		return true, nil
+	}
+
+	if err := os.MkdirAll(e.dest, 0o777); err != nil {
+		return false, fmt.Errorf("failed to create destination directory %s: %w", e.dest, err)
+	}
+	// directory_mode only applies to the directories created.
+	_, err = c.putAttrs(ctx, e.dest, c.DirectoryMode.GetValue(), uid, gid)
+	return true, err
+}
+
+// putLink puts the link e in place, and tells whether that changed anything.
+func (c *Copy) putLink(ctx context.Context, e copyEntry) (bool, error) {
+	info, err := os.Lstat(e.dest)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	if exists && info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(e.dest)
+		if err != nil {
+			return false, err
+		}
+		if target == e.link {
+			return false, nil
 		}
-		return copySingleFile(actualSrc, filepath.Join(c.Dest, c.Src))
 	}
 
-	d, err := os.Stat(c.Dest)
+	if CheckModeFromContext(ctx) {
+		return true, nil
+	}
+
+	if exists {
+		if err := os.Remove(e.dest); err != nil {
+			return false, err
+		}
+	}
+	return true, os.Symlink(e.link, e.dest)
+}
+
+// putAttrs applies mode and ownership to path, and tells whether that changed
+// anything. In check mode, path might not exist yet, in which case it's
+// created with them anyway.
+func (c *Copy) putAttrs(ctx context.Context, path, mode string, uid, gid int) (bool, error) {
+	needsUpdate, err := needsModeOrOwnershipChange(path, mode, uid, gid)
+	if err != nil {
+		if CheckModeFromContext(ctx) && errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	if !needsUpdate || CheckModeFromContext(ctx) {
+		return needsUpdate, nil
+	}
+
+	if err := util.ApplyModeAndIDs(path, mode, uid, gid); err != nil {
+		return false, fmt.Errorf("failed to apply mode and IDs to %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// describe fills in the return values describing the file or directory at
+// path, following links, if there's one.
+func (r *CopyResult) describe(path string) error {
+	r.Dest = path
+
+	stat, err := os.Stat(path)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return copySingleFile(actualSrc, c.Dest)
+			return nil
 		}
 		return err
 	}
 
-	if d.IsDir() {
-		return copySingleFile(actualSrc, filepath.Join(c.Dest, c.Src))
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("couldn't get metadata for %s", path)
 	}
 
-	return copySingleFile(actualSrc, c.Dest)
+	r.State = FileFile
+	if stat.IsDir() {
+		r.State = FileDirectory
+	}
+	r.Mode = fmt.Sprintf("%04o", stat.Mode().Perm())
+	r.Size = uint64(stat.Size())
+	r.Uid = uint64(st.Uid)
+	r.Gid = uint64(st.Gid)
+
+	// Ignore errors, the IDs might not have a matching user or group.
+	if owner, err := user.LookupId(strconv.Itoa(int(st.Uid))); err == nil {
+		r.Owner = owner.Username
+	}
+	if group, err := user.LookupGroupId(strconv.Itoa(int(st.Gid))); err == nil {
+		r.Group = group.Name
+	}
+	return nil
 }
 
-func (c *Copy) Apply(_ context.Context, parentPath string, _ bool) (Result, error) {
-	if c.Content != "" {
-		return &CopyResult{}, c.copyContent()
+func (c *Copy) Apply(ctx context.Context, parentPath string, _ bool) (Result, error) {
+	result := CopyResult{}
+
+	uid, err := util.GetUid(c.Owner)
+	if err != nil {
+		result.TaskFailed()
+		return &result, err
 	}
 
-	srcPath := filepath.Join(parentPath, "files", c.Src)
-	f, err := os.Stat(srcPath)
+	gid, err := util.GetGid(c.Group)
 	if err != nil {
-		return &CopyResult{}, fmt.Errorf("failed to read %s: %w", c.Src, err)
+		result.TaskFailed()
+		return &result, err
 	}
 
-	if f.IsDir() {
-		err = c.copyDir(srcPath)
-	} else {
-		err = c.copyFile(srcPath)
+	srcPath := c.Src
+	if !c.RemoteSrc {
+		srcPath = filepath.Join(parentPath, "files", c.Src)
+	}
+
+	var info fs.FileInfo
+	if c.Content == "" {
+		if info, err = os.Stat(srcPath); err != nil {
+			result.TaskFailed()
+			return &result, fmt.Errorf("failed to read %s: %w", c.Src, err)
+		}
 	}
 
+	entries, err := c.entries(srcPath, info)
 	if err != nil {
-		return &CopyResult{}, fmt.Errorf("failed to copy %s to %s: %w", c.Src, c.Dest, err)
+		result.TaskFailed()
+		return &result, fmt.Errorf("failed to list what to copy from %s: %w", c.Src, err)
+	}
+
+	// A directory is described by itself, and a file by the file, which
+	// comes last.
+	isDir := info != nil && info.IsDir()
+	described := entries[len(entries)-1]
+	if isDir {
+		described = entries[0]
+	} else {
+		if result.Checksum, result.MD5Sum, err = described.checksums(); err != nil {
+			result.TaskFailed()
+			return &result, fmt.Errorf("failed to compute the checksum of %s: %w", c.Src, err)
+		}
+		if c.Checksum != "" && c.Checksum != result.Checksum {
+			result.TaskFailed()
+			return &result, fmt.Errorf("checksum %s of %s doesn't match the expected %s", result.Checksum, c.Src, c.Checksum)
+		}
+	}
+
+	for _, e := range entries {
+		var changed bool
+		switch {
+		case e.dir:
+			changed, err = c.putDir(ctx, e, uid, gid)
+		case e.link != "":
+			changed, err = c.putLink(ctx, e)
+		default:
+			changed, err = c.putFile(ctx, &result, e, uid, gid)
+		}
+		if err != nil {
+			result.TaskFailed()
+			return &result, fmt.Errorf("failed to copy %s to %s: %w", c.Src, e.dest, err)
+		}
+		if changed {
+			result.TaskChanged()
+		}
+	}
+
+	if CheckModeFromContext(ctx) {
+		// Nothing was copied: there's nothing to look at.
+		result.Dest = described.dest
+		return &result, nil
+	}
+
+	if err := result.describe(described.dest); err != nil {
+		result.TaskFailed()
+		return &result, fmt.Errorf("failed to stat %s: %w", described.dest, err)
 	}
 
-	return &CopyResult{}, nil
+	return &result, nil
 }
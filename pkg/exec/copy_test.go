@@ -1,9 +1,23 @@
 package exec
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/goccy/go-yaml"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
 	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
 )
 
 func TestCopyValidate(t *testing.T) {
@@ -105,6 +119,18 @@ func TestCopyValidate(t *testing.T) {
 			WantErr:     true,
 			ErrContains: "src or content",
 		},
+		{
+			Name: "validate without %s",
+			Input: &Copy{
+				Copy: &proto.Copy{
+					Content:  "hello",
+					Dest:     "/etc/sudoers",
+					Validate: "visudo -cf",
+				},
+			},
+			WantErr: true,
+			ErrMsg:  "validate must contain %s: visudo -cf",
+		},
 		{
 			Name: "dest with trailing slash is directory",
 			Input: &Copy{
@@ -119,3 +145,362 @@ func TestCopyValidate(t *testing.T) {
 
 	RunValidationTests(t, tests)
 }
+
+func TestCopyApply(t *testing.T) {
+	pFalse := false
+	helloSum := "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+
+	tests := []struct {
+		name    string
+		copy    func(dir string) *proto.Copy
+		changed bool
+		wantErr bool
+		// files describes the files expected under dir, as snapshotDir
+		// does.
+		files  map[string]string
+		result func(dir string, r *CopyResult) error
+	}{
+		{
+			name: "content",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Content: "hello", Dest: filepath.Join(dir, "new"), Mode: &proto.Mode{Value: "0600"}}
+			},
+			changed: true,
+			files:   map[string]string{"new": "-rw------- hello"},
+			result: func(dir string, r *CopyResult) error {
+				if r.Checksum != helloSum || r.MD5Sum != "5d41402abc4b2a76b9719d911017c592" {
+					return fmt.Errorf("got checksums %s and %s", r.Checksum, r.MD5Sum)
+				}
+				if r.Dest != filepath.Join(dir, "new") || r.Size != 5 || r.Mode != "0600" || r.State != FileFile {
+					return fmt.Errorf("got dest %s, size %d, mode %s and state %s", r.Dest, r.Size, r.Mode, r.State)
+				}
+				return nil
+			},
+		},
+		{
+			name: "same content",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Content: "existing", Dest: filepath.Join(dir, "existing")}
+			},
+			files: map[string]string{"existing": "-rw-r--r-- existing"},
+		},
+		{
+			name: "mode only",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Content: "existing", Dest: filepath.Join(dir, "existing"), Mode: &proto.Mode{Value: "u=rw,go-rwx"}}
+			},
+			changed: true,
+			files:   map[string]string{"existing": "-rw------- existing"},
+		},
+		{
+			name: "file into new directory",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Src: "sub/hello", Dest: filepath.Join(dir, "newdir") + "/", DirectoryMode: &proto.Mode{Value: "0700"}}
+			},
+			changed: true,
+			files: map[string]string{
+				"newdir":       "drwx------",
+				"newdir/hello": "-rw-r--r-- hello",
+			},
+		},
+		{
+			name: "not forced",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Content: "new", Dest: filepath.Join(dir, "existing"), Force: &pFalse}
+			},
+			files: map[string]string{"existing": "-rw-r--r-- existing"},
+		},
+		{
+			name: "backup",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Content: "new", Dest: filepath.Join(dir, "existing"), Backup: true}
+			},
+			changed: true,
+			files:   map[string]string{"existing": "-rw-r--r-- new"},
+			result: func(dir string, r *CopyResult) error {
+				content, err := os.ReadFile(r.BackupFile)
+				if err != nil {
+					return err
+				}
+				if string(content) != "existing" || !strings.HasPrefix(r.BackupFile, filepath.Join(dir, "existing.")) {
+					return fmt.Errorf("got backup %s with %q", r.BackupFile, content)
+				}
+				return nil
+			},
+		},
+		{
+			name: "checksum",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Src: "sub/hello", Dest: filepath.Join(dir, "new"), Checksum: helloSum}
+			},
+			changed: true,
+			files:   map[string]string{"new": "-rw-r--r-- hello"},
+		},
+		{
+			name: "checksum mismatch",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Src: "sub/hello", Dest: filepath.Join(dir, "new"), Checksum: "nope"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "preserve mode",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Src: "script", Dest: filepath.Join(dir, "new"), Mode: &proto.Mode{Value: "preserve"}}
+			},
+			changed: true,
+			files:   map[string]string{"new": "-rwxr-x--- #!/bin/sh"},
+		},
+		{
+			name: "remote src",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Src: filepath.Join(dir, "existing"), Dest: filepath.Join(dir, "new"), RemoteSrc: true}
+			},
+			changed: true,
+			files:   map[string]string{"new": "-rw-r--r-- existing"},
+		},
+		{
+			name: "directory contents",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Src: "sub/", Dest: filepath.Join(dir, "newdir")}
+			},
+			changed: true,
+			files: map[string]string{
+				"newdir/hello": "-rw-r--r-- hello",
+				"newdir/link":  "-rw-r--r-- hello",
+			},
+			result: func(dir string, r *CopyResult) error {
+				if r.Dest != filepath.Join(dir, "newdir") || r.State != FileDirectory || r.Checksum != "" {
+					return fmt.Errorf("got dest %s, state %s and checksum %s", r.Dest, r.State, r.Checksum)
+				}
+				return nil
+			},
+		},
+		{
+			name: "directory without following links",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Src: "sub", Dest: dir, LocalFollow: &pFalse}
+			},
+			changed: true,
+			files: map[string]string{
+				"sub/hello": "-rw-r--r-- hello",
+				"sub/link":  "Lrwxrwxrwx -> hello",
+			},
+		},
+		{
+			name: "replace link",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Content: "new", Dest: filepath.Join(dir, "link")}
+			},
+			changed: true,
+			files: map[string]string{
+				"link":     "-rw-r--r-- new",
+				"existing": "-rw-r--r-- existing",
+			},
+		},
+		{
+			name: "follow link",
+			copy: func(dir string) *proto.Copy {
+				return &proto.Copy{Content: "new", Dest: filepath.Join(dir, "link"), Follow: true}
+			},
+			changed: true,
+			files: map[string]string{
+				"link":     "Lrwxrwxrwx -> existing",
+				"existing": "-rw-r--r-- new",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for path, content := range map[string]string{
+				"existing":        "existing",
+				"files/sub/hello": "hello",
+				"files/script":    "#!/bin/sh",
+				"files/sub/link":  "",
+				"link":            "",
+			} {
+				path = filepath.Join(dir, path)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				switch filepath.Base(path) {
+				case "link":
+					target := "existing"
+					if strings.Contains(path, "files") {
+						target = "hello"
+					}
+					if err := os.Symlink(target, path); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Chmod(filepath.Join(dir, "files/script"), 0o750); err != nil {
+				t.Fatal(err)
+			}
+
+			c := &Copy{Copy: tt.copy(dir)}
+			result, err := c.Apply(context.Background(), dir, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if result.IsChanged() != tt.changed {
+				t.Errorf("got changed %v, want %v", result.IsChanged(), tt.changed)
+			}
+
+			snapshot := snapshotDir(t, dir)
+			got := map[string]string{}
+			for path := range tt.files {
+				got[path] = snapshot[filepath.Join(dir, path)]
+			}
+			if diff := cmp.Diff(tt.files, got); diff != "" {
+				t.Errorf("files mismatch (-want +got):\n%s", diff)
+			}
+
+			if tt.result != nil {
+				if err := tt.result(dir, result.(*CopyResult)); err != nil {
+					t.Error(err)
+				}
+			}
+
+			// Copying again changes nothing.
+			result, err = c.Apply(context.Background(), dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.IsChanged() {
+				t.Error("copying again changed something")
+			}
+		})
+	}
+}
+
+func TestCopyApplyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		stderr  string
+		runErr  error
+		wantErr string
+		want    string
+	}{
+		{
+			name: "valid",
+			want: "new",
+		},
+		{
+			name:    "invalid",
+			stderr:  "syntax error near line 1\n",
+			runErr:  &testExitError{1, errors.New("exit status 1")},
+			wantErr: "failed to validate: exit status 1: syntax error near line 1",
+			want:    "existing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "existing")
+			if err := os.WriteFile(dest, []byte("existing"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			ctrl := gomock.NewController(t)
+			m := NewMockcommandExecutor(ctrl)
+			var stderr io.Writer
+			m.EXPECT().SetStdout(gomock.Any())
+			m.EXPECT().SetStderr(gomock.Any()).Do(func(w io.Writer) { stderr = w })
+
+			var validated string
+			factory := cmdFactory(func(name string, args ...string) commandExecutor {
+				if name != "visudo" || len(args) != 2 || args[0] != "-cf" {
+					t.Errorf("got command %s %v", name, args)
+				}
+				validated = args[len(args)-1]
+				return m
+			})
+			m.EXPECT().Run().DoAndReturn(func() error {
+				// The validator is given the new content, before it's in
+				// place.
+				content, err := os.ReadFile(validated)
+				if err != nil || string(content) != "new" || validated == dest {
+					t.Errorf("validating %s with %q: %v", validated, content, err)
+				}
+				stderr.Write([]byte(tt.stderr))
+				return tt.runErr
+			})
+
+			c := &Copy{Copy: &proto.Copy{Content: "new", Dest: dest, Validate: "visudo -cf %s"}}
+			ctx := context.WithValue(context.Background(), commandFactoryContextKey, factory)
+			result, err := c.Apply(ctx, "", false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				if !result.IsFailed() {
+					t.Error("task didn't fail")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("got %q in %s, want %q", content, dest, tt.want)
+			}
+
+			// The temporary file validated is gone.
+			if _, err := os.Stat(validated); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s is still there: %v", validated, err)
+			}
+		})
+	}
+}
+
+func TestCopyTemplatedForce(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "existing")
+	if err := os.WriteFile(dest, []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := []byte(`
+- ansible.builtin.copy:
+    content: new
+    dest: ` + dest + `
+    force: "{{ overwrite }}"
+`)
+	var tasks []*proto.Task
+	if err := yaml.Unmarshal(b, &tasks); err != nil {
+		t.Fatal(err)
+	}
+	task, err := FromProto(tasks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := variables.NewContext(context.Background(), variables.Variables{"overwrite": false})
+	if err := ExecuteTask(ctx, zap.NewNop(), *task, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "existing" {
+		t.Errorf("got %q in %s, want it left alone", content, dest)
+	}
+}
@@ -0,0 +1,57 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+var diffModeContextKey = &struct{ name string }{"diff mode"}
+
+// Diff is a change a task made, or would make in check mode: what something
+// was before and after it. Callbacks show it as a unified diff.
+type Diff struct {
+	Before string `yaml:"before" json:"before"`
+	After  string `yaml:"after" json:"after"`
+	// BeforeHeader and AfterHeader tell what was compared, e.g. a file's
+	// path.
+	BeforeHeader string `yaml:"before_header,omitempty" json:"before_header,omitempty"`
+	AfterHeader  string `yaml:"after_header,omitempty" json:"after_header,omitempty"`
+}
+
+// NewDiffModeContext returns a new context where tasks report the changes
+// they make, if diff is set.
+func NewDiffModeContext(ctx context.Context, diff bool) context.Context {
+	return context.WithValue(ctx, diffModeContextKey, diff)
+}
+
+// DiffModeFromContext tells whether tasks report the changes they make.
+func DiffModeFromContext(ctx context.Context) bool {
+	diff, _ := ctx.Value(diffModeContextKey).(bool)
+	return diff
+}
+
+// addDiff adds d to the result, in diff mode and if anything changed.
+func addDiff(ctx context.Context, result *CommonResult, d Diff) {
+	if !DiffModeFromContext(ctx) || d.Before == d.After {
+		return
+	}
+	result.Diff = append(result.Diff, d)
+}
+
+// contentDiff returns the diff of writing content to the file at dest.
+// afterHeader tells where content comes from.
+func contentDiff(dest string, content []byte, afterHeader string) (Diff, error) {
+	before, err := os.ReadFile(dest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Diff{}, err
+	}
+
+	return Diff{
+		Before:       string(before),
+		After:        string(content),
+		BeforeHeader: dest,
+		AfterHeader:  afterHeader,
+	}, nil
+}
@@ -0,0 +1,273 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/arduino/go-apt-client"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+// resultDiffs returns the diffs in the result of the modules reporting some.
+func resultDiffs(result Result) []Diff {
+	switch r := result.(type) {
+	case *TemplateResult:
+		return r.Diff
+	case *CopyResult:
+		return r.Diff
+	case *FileResult:
+		return r.Diff
+	case *AptResult:
+		return r.Diff
+	case *AptRepositoryResult:
+		return r.Diff
+	}
+	return nil
+}
+
+func TestDiffFiles(t *testing.T) {
+	owner, err := user.LookupId(strconv.Itoa(os.Getuid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := fmt.Sprintf("owner: %s\ngroup: %s\n", owner.Username, group.Name)
+
+	tests := []struct {
+		name     string
+		content  func(dir string) TaskContent
+		expected func(dir string) []Diff
+	}{
+		{
+			name: "template",
+			content: func(dir string) TaskContent {
+				return &Template{Template: &proto.Template{Src: "greeting.j2", Dest: filepath.Join(dir, "existing")}}
+			},
+			expected: func(dir string) []Diff {
+				return []Diff{{
+					Before:       "hi\n",
+					After:        "hello\n",
+					BeforeHeader: filepath.Join(dir, "existing"),
+					AfterHeader:  filepath.Join(dir, "templates/greeting.j2"),
+				}}
+			},
+		},
+		{
+			name: "copy content",
+			content: func(dir string) TaskContent {
+				return &Copy{Copy: &proto.Copy{Content: "new\n", Dest: filepath.Join(dir, "new")}}
+			},
+			expected: func(dir string) []Diff {
+				return []Diff{{
+					After:        "new\n",
+					BeforeHeader: filepath.Join(dir, "new"),
+					AfterHeader:  "dynamically generated",
+				}}
+			},
+		},
+		{
+			name: "copy file unchanged",
+			content: func(dir string) TaskContent {
+				return &Copy{Copy: &proto.Copy{Src: "existing", Dest: filepath.Join(dir, "existing")}}
+			},
+			expected: func(dir string) []Diff { return nil },
+		},
+		{
+			name: "copy directory",
+			content: func(dir string) TaskContent {
+				return &Copy{Copy: &proto.Copy{Src: "dir", Dest: dir}}
+			},
+			expected: func(dir string) []Diff {
+				return []Diff{{
+					Before:       "file\n",
+					After:        "other file\n",
+					BeforeHeader: filepath.Join(dir, "dir/file"),
+					AfterHeader:  filepath.Join(dir, "files/dir/file"),
+				}}
+			},
+		},
+		{
+			name: "file touch",
+			content: func(dir string) TaskContent {
+				// Who owns new files can't be told in check mode, unless
+				// it's set.
+				return &File{File: &proto.File{
+					Path:  filepath.Join(dir, "new"),
+					State: FileTouch,
+					Mode:  &proto.Mode{Value: "0600"},
+					Owner: owner.Username,
+					Group: group.Name,
+				}}
+			},
+			expected: func(dir string) []Diff {
+				path := filepath.Join(dir, "new")
+				return []Diff{{
+					Before:       "path: " + path + "\nstate: absent\n",
+					After:        "path: " + path + "\nstate: file\nmode: 0600\n" + ids,
+					BeforeHeader: path,
+					AfterHeader:  path,
+				}}
+			},
+		},
+		{
+			name: "file mode",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "existing"), State: FileFile, Mode: &proto.Mode{Value: "0600"}}}
+			},
+			expected: func(dir string) []Diff {
+				path := filepath.Join(dir, "existing")
+				return []Diff{{
+					Before:       "path: " + path + "\nstate: file\nmode: 0644\n" + ids,
+					After:        "path: " + path + "\nstate: file\nmode: 0600\n" + ids,
+					BeforeHeader: path,
+					AfterHeader:  path,
+				}}
+			},
+		},
+		{
+			name: "file absent",
+			content: func(dir string) TaskContent {
+				return &File{File: &proto.File{Path: filepath.Join(dir, "dir"), State: FileAbsent}}
+			},
+			expected: func(dir string) []Diff {
+				path := filepath.Join(dir, "dir")
+				return []Diff{{
+					Before:       "path: " + path + "\nstate: directory\nmode: 0755\n" + ids,
+					After:        "path: " + path + "\nstate: absent\n",
+					BeforeHeader: path,
+					AfterHeader:  path,
+				}}
+			},
+		},
+	}
+
+	for _, check := range []bool{false, true} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s check=%v", tt.name, check), func(t *testing.T) {
+				dir := t.TempDir()
+				for path, content := range map[string]string{
+					"existing":              "hi\n",
+					"dir/file":              "file\n",
+					"files/existing":        "hi\n",
+					"files/dir/file":        "other file\n",
+					"templates/greeting.j2": "hello\n",
+				} {
+					path = filepath.Join(dir, path)
+					if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.Chmod(filepath.Dir(path), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.Chmod(path, 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				ctx := NewDiffModeContext(NewCheckModeContext(context.Background(), check), true)
+				result, err := tt.content(dir).Apply(ctx, dir, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(tt.expected(dir), resultDiffs(result)); diff != "" {
+					t.Errorf("diff mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestDiffApt(t *testing.T) {
+	repoLine := "deb https://download.docker.com/linux/debian bookworm stable"
+	repo := &apt.Repository{Enabled: true, URI: "https://download.docker.com/linux/debian", Distribution: "bookworm", Components: "stable"}
+	other := &apt.Repository{Enabled: true, URI: "http://deb.debian.org/debian", Distribution: "bookworm", Components: "main"}
+
+	tests := []struct {
+		name     string
+		content  TaskContent
+		mockFunc func(*MockaptClient)
+		expected []Diff
+	}{
+		{
+			name:    "install",
+			content: &Apt{Apt: &proto.Apt{Name: &proto.PackageList{Items: []string{"foo", "bar"}}}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ListInstalled().Return([]*apt.Package{{Name: "foo"}}, nil).Times(2)
+				m.EXPECT().Install(&apt.Package{Name: "bar"}).Return("", nil)
+			},
+			expected: []Diff{{Before: "foo\n", After: "foo\nbar\n"}},
+		},
+		{
+			name:    "installed",
+			content: &Apt{Apt: &proto.Apt{Name: &proto.PackageList{Items: []string{"foo"}}}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ListInstalled().Return([]*apt.Package{{Name: "foo"}}, nil).Times(2)
+			},
+		},
+		{
+			name:    "remove",
+			content: &Apt{Apt: &proto.Apt{Name: &proto.PackageList{Items: []string{"foo", "bar"}}, State: AptAbsent}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ListInstalled().Return([]*apt.Package{{Name: "foo"}}, nil)
+				m.EXPECT().Remove(&apt.Package{Name: "foo"}, &apt.Package{Name: "bar"}).Return("", nil)
+			},
+			expected: []Diff{{Before: "foo\n"}},
+		},
+		{
+			name:    "add repository",
+			content: &AptRepository{AptRepository: &proto.AptRepository{Repo: repoLine}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ParseAPTConfigFolder("/etc/apt").Return(apt.RepositoryList{other}, nil)
+				m.EXPECT().ParseAPTConfigLine(repoLine).Return(repo)
+				m.EXPECT().AddRepository(repo, "/etc/apt", "download_docker_com_linux_debian.list").Return(nil)
+				m.EXPECT().CheckForUpdates().Return("", nil)
+			},
+			expected: []Diff{{
+				Before: "deb http://deb.debian.org/debian bookworm main\n",
+				After:  "deb http://deb.debian.org/debian bookworm main\n" + repoLine + "\n",
+			}},
+		},
+		{
+			name:    "remove repository",
+			content: &AptRepository{AptRepository: &proto.AptRepository{Repo: repoLine, State: AptRepositoryAbsent}},
+			mockFunc: func(m *MockaptClient) {
+				m.EXPECT().ParseAPTConfigFolder("/etc/apt").Return(apt.RepositoryList{repo, other}, nil)
+				m.EXPECT().ParseAPTConfigLine(repoLine).Return(repo)
+				m.EXPECT().RemoveRepository(repo, "/etc/apt").Return(nil)
+				m.EXPECT().CheckForUpdates().Return("", nil)
+			},
+			expected: []Diff{{
+				Before: repoLine + "\ndeb http://deb.debian.org/debian bookworm main\n",
+				After:  "deb http://deb.debian.org/debian bookworm main\n",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewDiffModeContext(newMockAptContext(t, tt.mockFunc), true)
+			result, err := tt.content.Apply(ctx, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expected, resultDiffs(result)); diff != "" {
+				t.Errorf("diff mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
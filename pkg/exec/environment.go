@@ -0,0 +1,182 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mickael-carl/sophons/pkg/exec/util"
+)
+
+var environmentContextKey = &struct{ name string }{"environment"}
+
+// NewEnvironmentContext returns a new context where env is set on top of the
+// environment variables carried by ctx, for the commands tasks run.
+func NewEnvironmentContext(ctx context.Context, env map[string]string) context.Context {
+	if len(env) == 0 {
+		return ctx
+	}
+
+	merged := maps.Clone(EnvironmentFromContext(ctx))
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, env)
+	return context.WithValue(ctx, environmentContextKey, merged)
+}
+
+// EnvironmentFromContext returns the environment variables set by plays,
+// roles and tasks carried by ctx.
+func EnvironmentFromContext(ctx context.Context) map[string]string {
+	env, _ := ctx.Value(environmentContextKey).(map[string]string)
+	return env
+}
+
+// EnvironmentContext renders the `environment` keyword of a play, role or
+// block, and returns a new context where it's set. env is either a dict or a
+// Jinja template evaluating to one.
+func EnvironmentContext(ctx context.Context, env any) (context.Context, error) {
+	if env == nil {
+		return ctx, nil
+	}
+
+	holder := struct{ Environment any }{Environment: env}
+	if err := util.ProcessJinjaTemplates(ctx, &holder); err != nil {
+		return ctx, fmt.Errorf("failed to process Jinja templating: %w", err)
+	}
+	return taskEnvironmentContext(ctx, holder.Environment)
+}
+
+// taskEnvironmentContext returns a new context where env, once rendered, is
+// set.
+func taskEnvironmentContext(ctx context.Context, env any) (context.Context, error) {
+	if env == nil {
+		return ctx, nil
+	}
+
+	dict, ok := env.(map[string]any)
+	if !ok {
+		return ctx, fmt.Errorf("environment must be a dict, got %T", env)
+	}
+
+	vars := make(map[string]string, len(dict))
+	for k, v := range dict {
+		switch v := v.(type) {
+		case nil:
+			vars[k] = ""
+		case map[string]any, []any:
+			return ctx, fmt.Errorf("environment variable %s must be a scalar, got %T", k, v)
+		default:
+			vars[k] = fmt.Sprint(v)
+		}
+	}
+	return NewEnvironmentContext(ctx, vars), nil
+}
+
+// commandEnv returns the environment of the executer, with env on top of it.
+func commandEnv(env map[string]string) []string {
+	out := os.Environ()
+	for k, v := range env {
+		out = append(out, k+"="+v)
+	}
+	return out
+}
+
+// environmentCmdFactory wraps factory so that commands are run with the
+// environment variables set by plays, roles and tasks.
+func environmentCmdFactory(ctx context.Context, factory cmdFactory) cmdFactory {
+	env := EnvironmentFromContext(ctx)
+	if len(env) == 0 {
+		return factory
+	}
+
+	return func(name string, args ...string) commandExecutor {
+		cmd := factory(name, args...)
+		cmd.SetEnv(commandEnv(env))
+		return cmd
+	}
+}
+
+// expandEnv replaces ${var} or $var in s with the environment variables set
+// by plays, roles and tasks, or the executer's.
+func expandEnv(ctx context.Context, s string) string {
+	env := EnvironmentFromContext(ctx)
+	return os.Expand(s, func(k string) string {
+		if v, ok := env[k]; ok {
+			return v
+		}
+		return os.Getenv(k)
+	})
+}
+
+// environmentProxy returns the proxy to use for requests, from the proxy
+// variables set by plays, roles and tasks. The executer's own are used if
+// none are set.
+func environmentProxy(ctx context.Context) func(*http.Request) (*url.URL, error) {
+	env := EnvironmentFromContext(ctx)
+	get := func(name string) string {
+		if v, ok := env[name]; ok {
+			return v
+		}
+		return env[strings.ToUpper(name)]
+	}
+
+	httpProxy, httpsProxy, noProxy := get("http_proxy"), get("https_proxy"), get("no_proxy")
+	if httpProxy == "" && httpsProxy == "" && noProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		proxy := httpProxy
+		if req.URL.Scheme == "https" {
+			proxy = httpsProxy
+		}
+		if proxy == "" || bypassProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+
+		u, err := url.Parse(proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			// Like curl, proxies can be given without a scheme.
+			if u, err = url.Parse("http://" + proxy); err != nil {
+				return nil, fmt.Errorf("invalid proxy address %q: %w", proxy, err)
+			}
+		}
+		return u, nil
+	}
+}
+
+// bypassProxy tells whether host matches one of the comma-separated patterns
+// of noProxy: `*`, hosts, which also match their subdomains, or IP
+// addresses and networks.
+func bypassProxy(host, noProxy string) bool {
+	ip := net.ParseIP(host)
+	for _, pattern := range strings.Split(noProxy, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "":
+			continue
+		case pattern == "*":
+			return true
+		case ip != nil:
+			if _, network, err := net.ParseCIDR(pattern); err == nil && network.Contains(ip) {
+				return true
+			}
+			if ip.Equal(net.ParseIP(pattern)) {
+				return true
+			}
+		default:
+			pattern = strings.TrimPrefix(pattern, ".")
+			host = strings.ToLower(host)
+			if host == pattern || strings.HasSuffix(host, "."+pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
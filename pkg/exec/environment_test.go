@@ -0,0 +1,171 @@
+package exec
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func TestEnvironmentContext(t *testing.T) {
+	ctx := variables.NewContext(context.Background(), variables.Variables{
+		"proxy_env": map[string]any{"http_proxy": "http://proxy:3128"},
+		"lang":      "C",
+	})
+	ctx = NewEnvironmentContext(ctx, map[string]string{"LANG": "en_US.UTF-8", "TERM": "dumb"})
+
+	tests := []struct {
+		name     string
+		env      any
+		expected map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "nothing",
+			expected: map[string]string{"LANG": "en_US.UTF-8", "TERM": "dumb"},
+		},
+		{
+			name:     "dict",
+			env:      map[string]any{"LANG": "{{ lang }}", "RETRIES": 3, "EMPTY": nil},
+			expected: map[string]string{"LANG": "C", "TERM": "dumb", "RETRIES": "3", "EMPTY": ""},
+		},
+		{
+			name:     "template",
+			env:      "{{ proxy_env }}",
+			expected: map[string]string{"LANG": "en_US.UTF-8", "TERM": "dumb", "http_proxy": "http://proxy:3128"},
+		},
+		{name: "not a dict", env: "{{ lang }}", wantErr: true},
+		{name: "not a scalar", env: map[string]any{"PATH": []any{"/bin"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envCtx, err := EnvironmentContext(ctx, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EnvironmentContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.expected, EnvironmentFromContext(envCtx)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The parent context is left alone.
+	if diff := cmp.Diff(map[string]string{"LANG": "en_US.UTF-8", "TERM": "dumb"}, EnvironmentFromContext(ctx)); diff != "" {
+		t.Errorf("parent environment modified (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecuteTaskEnvironment(t *testing.T) {
+	taskEnv, err := structpb.NewValue(map[string]any{"FOO": "task"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		task     Task
+		expected []string
+	}{
+		{
+			name: "task",
+			task: Task{
+				Environment: map[string]any{"FOO": "{{ foo }}"},
+				Content:     &Command{Command: &proto.Command{Cmd: "env"}},
+			},
+			expected: []string{"FOO=foo", "PLAY=play"},
+		},
+		{
+			name: "block",
+			task: Task{
+				Environment: map[string]any{"PLAY": "block"},
+				Content: &Block{Block: &proto.Block{Tasks: []*proto.Task{{
+					Environment: taskEnv,
+					Content:     &proto.Task_Shell{Shell: &proto.Shell{Cmd: "env"}},
+				}}}},
+			},
+			expected: []string{"FOO=task", "PLAY=block"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var env []string
+			ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+				m.EXPECT().SetStdout(gomock.Any())
+				m.EXPECT().SetStderr(gomock.Any())
+				m.EXPECT().SetEnv(gomock.Any()).Do(func(e []string) { env = e })
+				m.EXPECT().Run().Return(nil)
+			})
+			ctx = variables.NewContext(ctx, variables.Variables{"foo": "foo"})
+			ctx = NewEnvironmentContext(ctx, map[string]string{"PLAY": "play"})
+
+			if err := ExecuteTask(ctx, zap.NewNop(), tt.task, "", false); err != nil {
+				t.Fatal(err)
+			}
+
+			// The executer's environment comes first, overridden by the
+			// play's, block's and task's.
+			got := slices.Clone(env[len(env)-len(tt.expected):])
+			slices.Sort(got)
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEnvironmentProxy(t *testing.T) {
+	ctx := NewEnvironmentContext(context.Background(), map[string]string{
+		"http_proxy":  "http://proxy:3128",
+		"HTTPS_PROXY": "secure-proxy:3129",
+		"no_proxy":    "localhost,.internal,10.0.0.0/8",
+	})
+	proxy := environmentProxy(ctx)
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{url: "http://example.com/file", expected: "http://proxy:3128"},
+		{url: "https://example.com/file", expected: "http://secure-proxy:3129"},
+		{url: "http://localhost:8080/", expected: ""},
+		{url: "http://mirror.internal/", expected: ""},
+		{url: "http://internal/", expected: ""},
+		{url: "http://10.1.2.3/", expected: ""},
+		{url: "http://11.1.2.3/", expected: "http://proxy:3128"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := proxy(&http.Request{URL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotStr := ""
+			if got != nil {
+				gotStr = got.String()
+			}
+			if gotStr != tt.expected {
+				t.Errorf("proxy for %s = %q, want %q", tt.url, gotStr, tt.expected)
+			}
+		})
+	}
+}
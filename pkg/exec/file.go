@@ -9,6 +9,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/mickael-carl/sophons/pkg/exec/util"
@@ -93,6 +94,36 @@ func (f *File) Validate() error {
 	return nil
 }
 
+// desiredMode returns the permissions mode sets on path, or 0 if it doesn't
+// set any.
+func desiredMode(path string, mode any) (os.FileMode, error) {
+	switch v := mode.(type) {
+	case string:
+		if v == "" {
+			return 0, nil
+		}
+		// Try parsing as octal first
+		if numMode, err := strconv.ParseUint(v, 8, 32); err == nil {
+			return os.FileMode(numMode), nil
+		}
+		// It's a symbolic mode like "u+x", compute what it would be
+		return util.NewModeFromSpec(os.DirFS(filepath.Dir(path)), path, v)
+	case int:
+		return os.FileMode(v), nil
+	case int64:
+		return os.FileMode(v), nil
+	case uint64:
+		return os.FileMode(v), nil
+	case *uint64:
+		if v != nil {
+			return os.FileMode(*v), nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unsupported mode type %T", mode)
+	}
+}
+
 // needsModeOrOwnershipChange checks if a file/directory requires changes to
 // mode, uid, or gid. Returns true if any of the specified attributes differ
 // from current values.
@@ -108,39 +139,12 @@ func needsModeOrOwnershipChange(path string, mode any, uid, gid int) (bool, erro
 	}
 
 	if mode != nil {
-		currentMode := stat.Mode().Perm()
-		var desiredMode os.FileMode
-
-		switch v := mode.(type) {
-		case string:
-			if v != "" {
-				// Try parsing as octal first
-				if numMode, err := strconv.ParseUint(v, 8, 32); err == nil {
-					desiredMode = os.FileMode(numMode)
-				} else {
-					// It's a symbolic mode like "u+x", compute what it would be
-					newMode, err := util.NewModeFromSpec(os.DirFS(filepath.Dir(path)), path, v)
-					if err != nil {
-						return false, err
-					}
-					desiredMode = newMode
-				}
-			}
-		case int:
-			desiredMode = os.FileMode(v)
-		case int64:
-			desiredMode = os.FileMode(v)
-		case uint64:
-			desiredMode = os.FileMode(v)
-		case *uint64:
-			if v != nil {
-				desiredMode = os.FileMode(*v)
-			}
-		default:
-			return false, fmt.Errorf("unsupported mode type %T", mode)
+		desiredMode, err := desiredMode(path, mode)
+		if err != nil {
+			return false, err
 		}
 
-		if desiredMode != 0 && currentMode != desiredMode {
+		if desiredMode != 0 && stat.Mode().Perm() != desiredMode {
 			return true, nil
 		}
 	}
@@ -156,7 +160,9 @@ func needsModeOrOwnershipChange(path string, mode any, uid, gid int) (bool, erro
 	return false, nil
 }
 
-func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
+func (f *File) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
+	check := CheckModeFromContext(ctx)
+
 	var follow bool
 	// The default for `follow` is true.
 	if f.Follow == nil {
@@ -167,6 +173,16 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 
 	result := FileResult{}
 
+	diff := DiffModeFromContext(ctx)
+	var before fileState
+	if diff {
+		var err error
+		if before, err = statFileState(f.Path); err != nil {
+			result.TaskFailed()
+			return &result, err
+		}
+	}
+
 	exists := false
 	_, err := os.Lstat(f.Path)
 	if err == nil {
@@ -205,17 +221,25 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 	case FileAbsent:
 		result.Path = f.Path
 		result.State = FileAbsent
-		if exists {
-			if err := os.RemoveAll(f.Path); err != nil {
-				result.TaskFailed()
-				return &result, err
-			}
-			result.TaskChanged()
+		if !exists {
+			break
+		}
+		changed = true
+		if check {
+			break
+		}
+		if err := os.RemoveAll(f.Path); err != nil {
+			result.TaskFailed()
+			return &result, err
 		}
-		return &result, nil
 
 	case FileDirectory:
 		result.Path = f.Path
+		if check && !exists {
+			changed = true
+			break
+		}
+
 		// If f.Mode is not specified, i.e. we don't specify a mode, Ansible
 		// says it'll use the default umask. To emulate that, but not do
 		// anything on existing files/directories, we call MkdirAll, which
@@ -242,6 +266,9 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 
 					if needsUpdate {
 						changed = true
+						if check {
+							return nil
+						}
 						if err := os.Lchown(path, uid, gid); err != nil {
 							return err
 						}
@@ -254,6 +281,9 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 
 					if needsUpdate {
 						changed = true
+						if check {
+							return nil
+						}
 						if err := util.ApplyModeAndIDs(path, f.Mode.GetValue(), uid, gid); err != nil {
 							return err
 						}
@@ -274,6 +304,9 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 
 			if needsUpdate {
 				changed = true
+				if check {
+					break
+				}
 				if err := util.ApplyModeAndIDs(f.Path, f.Mode.GetValue(), uid, gid); err != nil {
 					result.TaskFailed()
 					return &result, fmt.Errorf("couldn't apply mode and IDs to %s: %w", f.Path, err)
@@ -302,6 +335,9 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 
 		if needsUpdate {
 			changed = true
+			if check {
+				break
+			}
 			if err := util.ApplyModeAndIDs(f.Path, f.Mode.GetValue(), uid, gid); err != nil {
 				result.TaskFailed()
 				return &result, fmt.Errorf("couldn't apply mode and IDs to %s: %w", f.Path, err)
@@ -316,11 +352,14 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 	case FileLink:
 		result.Dest = f.Path
 		if !exists {
+			changed = true
+			if check {
+				break
+			}
 			if err := os.Symlink(f.Src, f.Path); err != nil {
 				result.TaskFailed()
 				return &result, err
 			}
-			changed = true
 		} else {
 			existingSrc, err := os.Readlink(f.Path)
 			if err != nil {
@@ -329,6 +368,11 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 			}
 
 			if existingSrc != f.Src {
+				changed = true
+				if check {
+					break
+				}
+
 				if err := os.Remove(f.Path); err != nil {
 					result.TaskFailed()
 					return &result, err
@@ -338,7 +382,6 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 					result.TaskFailed()
 					return &result, err
 				}
-				changed = true
 			}
 		}
 
@@ -350,22 +393,28 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 			}
 
 			if needsUpdate {
+				changed = true
+				if check {
+					break
+				}
 				if err := util.ApplyModeAndIDs(f.Path, f.Mode.GetValue(), uid, gid); err != nil {
 					result.TaskFailed()
 					return &result, fmt.Errorf("couldn't apply mode and IDs to %s: %w", f.Path, err)
 				}
-				changed = true
 			}
 		}
 
 	case FileTouch:
 		result.Dest = f.Path
 		if !exists {
+			changed = true
+			if check {
+				break
+			}
 			if _, err := os.Create(f.Path); err != nil {
 				result.TaskFailed()
 				return &result, fmt.Errorf("failed to create %s: %w", f.Path, err)
 			}
-			changed = true
 		}
 
 		// The Ansible docs say that if the file exists, atime and mtime will
@@ -378,11 +427,14 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 		}
 
 		if needsUpdate {
+			changed = true
+			if check {
+				break
+			}
 			if err := util.ApplyModeAndIDs(f.Path, f.Mode.GetValue(), uid, gid); err != nil {
 				result.TaskFailed()
 				return &result, fmt.Errorf("couldn't apply mode and IDs to %s: %w", f.Path, err)
 			}
-			changed = true
 		}
 
 	default:
@@ -392,6 +444,26 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 
 	if changed {
 		result.TaskChanged()
+
+		if diff {
+			after, err := f.stateAfter(before, actualState, check)
+			if err != nil {
+				result.TaskFailed()
+				return &result, err
+			}
+			addDiff(ctx, &result.CommonResult, Diff{
+				Before:       before.String(),
+				After:        after.String(),
+				BeforeHeader: f.Path,
+				AfterHeader:  f.Path,
+			})
+		}
+	}
+
+	if check && !exists {
+		// In check mode, nothing was created: there's nothing to look at.
+		result.State = actualState
+		return &result, nil
 	}
 
 	if actualState != FileAbsent {
@@ -408,24 +480,7 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 			return &result, fmt.Errorf("couldn't get metadata for %s: %w", f.Path, err)
 		}
 
-		switch mode := stat.Mode(); {
-		case mode&os.ModeSymlink != 0:
-			result.State = FileLink
-		case mode.IsDir():
-			result.State = FileDirectory
-		default:
-			// We still need to check if the target is actually a hard link.
-			if st.Nlink > 1 {
-				result.State = FileHard
-			} else {
-				// It's either a regular file, at which point this is correct,
-				// or it's e.g. a char device, or something that's basically a
-				// special file. Ansible treats the latter as a file so we do
-				// the same.
-				result.State = FileFile
-			}
-		}
-
+		result.State = pathState(stat, st)
 		result.Uid = st.Uid
 		result.Gid = st.Gid
 		result.Size = uint64(st.Size)
@@ -445,3 +500,114 @@ func (f *File) Apply(_ context.Context, _ string, _ bool) (Result, error) {
 
 	return &result, nil
 }
+
+// pathState returns the state of the path with stat.
+func pathState(stat os.FileInfo, st *syscall.Stat_t) string {
+	switch mode := stat.Mode(); {
+	case mode&os.ModeSymlink != 0:
+		return FileLink
+	case mode.IsDir():
+		return FileDirectory
+	// We still need to check if the target is actually a hard link.
+	case st.Nlink > 1:
+		return FileHard
+	default:
+		// It's either a regular file, at which point this is correct, or it's
+		// e.g. a char device, or something that's basically a special file.
+		// Ansible treats the latter as a file so we do the same.
+		return FileFile
+	}
+}
+
+// fileState is what the file module changes about a path, as shown in diffs.
+type fileState struct {
+	Path  string
+	State string
+	Mode  string
+	Owner string
+	Group string
+}
+
+func (s fileState) String() string {
+	var b strings.Builder
+	for _, field := range []struct{ name, value string }{
+		{"path", s.Path},
+		{"state", s.State},
+		{"mode", s.Mode},
+		{"owner", s.Owner},
+		{"group", s.Group},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", field.name, field.value)
+		}
+	}
+	return b.String()
+}
+
+// statFileState returns the current state of path.
+func statFileState(path string) (fileState, error) {
+	stat, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileState{Path: path, State: FileAbsent}, nil
+	}
+	if err != nil {
+		return fileState{}, err
+	}
+
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileState{}, fmt.Errorf("couldn't get metadata for %s", path)
+	}
+
+	s := fileState{
+		Path:  path,
+		State: pathState(stat, st),
+		Mode:  fmt.Sprintf("%#o", stat.Mode().Perm()),
+		Owner: strconv.Itoa(int(st.Uid)),
+		Group: strconv.Itoa(int(st.Gid)),
+	}
+	if owner, err := user.LookupId(s.Owner); err == nil {
+		s.Owner = owner.Username
+	}
+	if group, err := user.LookupGroupId(s.Group); err == nil {
+		s.Group = group.Name
+	}
+	return s, nil
+}
+
+// stateAfter returns the state of the path after the task changed it from
+// before. In check mode nothing changed, so it's what the task would have
+// done.
+func (f *File) stateAfter(before fileState, state string, check bool) (fileState, error) {
+	if !check {
+		return statFileState(f.Path)
+	}
+
+	after := before
+	switch state {
+	case FileAbsent:
+		return fileState{Path: f.Path, State: FileAbsent}, nil
+	case FileTouch:
+		if before.State == FileAbsent {
+			after.State = FileFile
+		}
+	case FileDirectory, FileLink:
+		after.State = state
+	}
+
+	if mode := f.Mode.GetValue(); mode != "" {
+		after.Mode = mode
+		// Symbolic modes are relative to the current one, which a missing
+		// path doesn't have.
+		if m, err := desiredMode(f.Path, mode); err == nil {
+			after.Mode = fmt.Sprintf("%#o", m)
+		}
+	}
+	if f.Owner != "" {
+		after.Owner = f.Owner
+	}
+	if f.Group != "" {
+		after.Group = f.Group
+	}
+	return after, nil
+}
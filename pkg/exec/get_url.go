@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -27,6 +26,8 @@ type GetURL struct {
 }
 
 type GetURLResult struct {
+	BackupFile string `yaml:"backup_file,omitempty"`
+
 	CommonResult `yaml:",inline"`
 }
 
@@ -96,8 +97,17 @@ func (g *GetURL) Validate() error {
 	return nil
 }
 
-func (g *GetURL) Apply(_ context.Context, parentPath string, _ bool) (Result, error) {
-	resp, err := http.Get(g.Url)
+func (g *GetURL) Apply(ctx context.Context, parentPath string, _ bool) (Result, error) {
+	if CheckModeFromContext(ctx) {
+		return g.check()
+	}
+
+	// Proxies set in `environment` are honoured, like Ansible does.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = environmentProxy(ctx)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(g.Url)
 	if err != nil {
 		return &GetURLResult{}, fmt.Errorf("failed to get URL %s: %w", g.Url, err)
 	}
@@ -120,38 +130,43 @@ func (g *GetURL) Apply(_ context.Context, parentPath string, _ bool) (Result, er
 		}
 	}
 
-	out, err := os.Create(actualDest)
-	if err != nil {
-		return &GetURLResult{}, fmt.Errorf("failed to create file %s: %w", g.Dest, err)
-	}
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		out.Close()
-		return &GetURLResult{}, fmt.Errorf("failed to write to file %s: %w", g.Dest, err)
-	}
-
-	if err := out.Close(); err != nil {
-		return &GetURLResult{}, err
-	}
-
-	if g.Mode == nil && g.Owner == "" && g.Group == "" {
-		return &GetURLResult{}, nil
-	}
-
+	result := GetURLResult{}
 	uid, err := util.GetUid(g.Owner)
 	if err != nil {
-		return &GetURLResult{}, err
+		return &result, err
 	}
 
 	gid, err := util.GetGid(g.Group)
 	if err != nil {
-		return &GetURLResult{}, err
+		return &result, err
 	}
 
-	if err := util.ApplyModeAndIDs(actualDest, g.Mode.GetValue(), uid, gid); err != nil {
-		return &GetURLResult{}, fmt.Errorf("failed to apply mode and IDs to %s: %w", actualDest, err)
+	opts := writeOptions{Backup: g.Backup, UnsafeWrites: g.UnsafeWrites, Mode: g.Mode.GetValue(), UID: uid, GID: gid}
+	if result.BackupFile, err = writeFile(actualDest, resp.Body, opts); err != nil {
+		return &result, fmt.Errorf("failed to write to file %s: %w", g.Dest, err)
 	}
 
-	return &GetURLResult{}, nil
+	return &result, nil
+}
+
+// check reports whether downloading would change anything, without
+// downloading. Like Ansible, an existing file is only downloaded again with
+// `force`. Without it, there's no telling what would happen when dest is a
+// directory: where the file goes depends on the response.
+func (g *GetURL) check() (Result, error) {
+	result := GetURLResult{}
+
+	d, err := os.Stat(g.Dest)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		result.TaskChanged()
+	case err != nil:
+		result.TaskFailed()
+		return &result, fmt.Errorf("failed to stat %s: %w", g.Dest, err)
+	case d.IsDir() || g.GetForce():
+		result.Msg = checkModeUnpredictable
+		result.TaskSkipped()
+	}
+
+	return &result, nil
 }
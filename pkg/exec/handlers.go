@@ -0,0 +1,144 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"go.uber.org/zap"
+
+	protopackage "github.com/mickael-carl/sophons/pkg/proto"
+)
+
+var handlersContextKey = &struct{ name string }{"handlers"}
+
+// Handlers holds the handlers of a play, and whether they were notified. Like
+// Ansible, handlers from roles come before the play's own, and notified
+// handlers run in the order they were defined.
+type Handlers struct {
+	logger   *zap.Logger
+	handlers []*handler
+	// roleHandlers is the number of handlers from roles, at the start of
+	// handlers.
+	roleHandlers int
+}
+
+type handler struct {
+	task Task
+	// ctx is the context the handler was defined in: it runs with the same
+	// variables as the tasks around it.
+	ctx        context.Context
+	parentPath string
+	isRole     bool
+	notified   bool
+}
+
+func NewHandlers(logger *zap.Logger) *Handlers {
+	return &Handlers{logger: logger}
+}
+
+// NewHandlersContext returns a new context carrying the given handlers.
+func NewHandlersContext(ctx context.Context, h *Handlers) context.Context {
+	return context.WithValue(ctx, handlersContextKey, h)
+}
+
+// HandlersFromContext returns the handlers carried by ctx, if any.
+func HandlersFromContext(ctx context.Context) (*Handlers, bool) {
+	h, ok := ctx.Value(handlersContextKey).(*Handlers)
+	return h, ok
+}
+
+// Add defines handlers. They run with ctx, and parentPath and isRole are used
+// the same way they are for tasks. A role's handlers are only defined once,
+// even if the role is applied several times, so that they don't run twice
+// when notified.
+func (h *Handlers) Add(ctx context.Context, tasks []*protopackage.Task, parentPath string, isRole bool) error {
+	for _, protoTask := range tasks {
+		task, err := FromProto(protoTask)
+		if err != nil {
+			return fmt.Errorf("failed to convert handler: %w", err)
+		}
+		task.Handler = true
+
+		if isRole && h.hasRoleHandler(parentPath, *task) {
+			continue
+		}
+
+		hd := &handler{
+			task:       *task,
+			ctx:        ctx,
+			parentPath: parentPath,
+			isRole:     isRole,
+		}
+		if isRole {
+			h.handlers = slices.Insert(h.handlers, h.roleHandlers, hd)
+			h.roleHandlers++
+		} else {
+			h.handlers = append(h.handlers, hd)
+		}
+	}
+	return nil
+}
+
+// hasRoleHandler tells whether the role at parentPath already defined a
+// handler with the same name, listening to the same topics, as task.
+func (h *Handlers) hasRoleHandler(parentPath string, task Task) bool {
+	for _, hd := range h.handlers[:h.roleHandlers] {
+		if hd.parentPath == parentPath && hd.task.Name == task.Name && slices.Equal(hd.task.Listen, task.Listen) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify marks the handlers with the given name, or listening to it, as
+// notified.
+func (h *Handlers) Notify(name string) error {
+	found := false
+	for _, hd := range h.handlers {
+		if hd.task.Name == name || slices.Contains(hd.task.Listen, name) {
+			hd.notified = true
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no handler named or listening to %q", name)
+	}
+	return nil
+}
+
+// Flush runs the handlers that were notified, each once. Handlers can notify
+// the ones defined after them, which run as part of the same flush.
+func (h *Handlers) Flush() error {
+	for _, hd := range h.handlers {
+		if !hd.notified {
+			continue
+		}
+		hd.notified = false
+
+		if err := ExecuteTask(hd.ctx, h.logger, hd.task, hd.parentPath, hd.isRole); err != nil {
+			return fmt.Errorf("failed to run handler %q: %w", hd.task.Name, err)
+		}
+	}
+	return nil
+}
+
+// notifyHandlers notifies the handlers task asks for, if it made a change
+// without failing. Like Ansible, failures ignored with `ignore_errors` don't
+// notify either.
+func notifyHandlers(ctx context.Context, task Task, result Result) error {
+	if len(task.Notify) == 0 || result == nil || !result.IsChanged() || result.IsFailed() {
+		return nil
+	}
+
+	h, ok := HandlersFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("no handler named or listening to %q", task.Notify[0])
+	}
+	for _, name := range task.Notify {
+		if err := h.Notify(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/callback"
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func commandTask(name string, notify ...string) *proto.Task {
+	return &proto.Task{
+		Name:   name,
+		Notify: notify,
+		Content: &proto.Task_Command{
+			Command: &proto.Command{Cmd: "true"},
+		},
+	}
+}
+
+// taskStarts returns the names of the tasks recorded as started, with
+// handlers prefixed.
+func taskStarts(r *callbackRecorder) []string {
+	var names []string
+	for _, e := range r.events {
+		if start := e.GetTaskStart(); start != nil {
+			name := start.GetName()
+			if start.GetHandler() {
+				name = "handler: " + name
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestHandlers(t *testing.T) {
+	ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+		m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+		m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+		m.EXPECT().Run().Return(nil).AnyTimes()
+	})
+	ctx = variables.NewContext(ctx, variables.Variables{})
+	recorder := &callbackRecorder{}
+	ctx = callback.NewContext(ctx, recorder, "localhost")
+
+	handlers := NewHandlers(zap.NewNop())
+	ctx = NewHandlersContext(ctx, handlers)
+
+	listener := commandTask("restart web")
+	listener.Listen = []string{"web"}
+	if err := handlers.Add(ctx, []*proto.Task{
+		listener,
+		commandTask("restart db", "reload proxy"),
+		commandTask("reload proxy"),
+		commandTask("unused"),
+	}, "", false); err != nil {
+		t.Fatal(err)
+	}
+	// The role is applied twice: its handler still only runs once.
+	for range 2 {
+		if err := handlers.Add(ctx, []*proto.Task{commandTask("role handler")}, "roles/web", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	skipped := commandTask("skipped", "unused")
+	skipped.When = []string{"false"}
+	ignored := commandTask("ignored", "unused")
+	ignored.ChangedWhen = []string{"true"}
+	ignored.FailedWhen = []string{"true"}
+	ignored.IgnoreErrors = true
+
+	tasks := []*proto.Task{
+		commandTask("configure web", "web", "role handler"),
+		skipped,
+		ignored,
+		{Content: &proto.Task_Meta{Meta: &proto.Meta{FreeForm: "flush_handlers"}}},
+		commandTask("configure db", "restart db"),
+		commandTask("configure db again", "restart db"),
+	}
+	for _, pt := range tasks {
+		task, err := FromProto(pt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ExecuteTask(ctx, zap.NewNop(), *task, "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := handlers.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"configure web",
+		"skipped",
+		"ignored",
+		"handler: role handler",
+		"handler: restart web",
+		"configure db",
+		"configure db again",
+		"handler: restart db",
+		"handler: reload proxy",
+	}
+	if diff := cmp.Diff(expected, taskStarts(recorder)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandlersUnknown(t *testing.T) {
+	ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+		m.EXPECT().SetStdout(gomock.Any())
+		m.EXPECT().SetStderr(gomock.Any())
+		m.EXPECT().Run().Return(nil)
+	})
+	ctx = variables.NewContext(ctx, variables.Variables{})
+	ctx = NewHandlersContext(ctx, NewHandlers(zap.NewNop()))
+
+	task, err := FromProto(commandTask("configure", "nope"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecuteTask(ctx, zap.NewNop(), *task, "", false); err == nil {
+		t.Error("expected an error notifying an unknown handler")
+	}
+}
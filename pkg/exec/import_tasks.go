@@ -3,13 +3,8 @@ package exec
 import (
 	"context"
 	"errors"
-	"fmt"
-	"os"
 	"path/filepath"
 
-	"github.com/goccy/go-yaml"
-
-	"github.com/mickael-carl/sophons/pkg/exec/util"
 	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/registry"
 )
@@ -60,37 +55,8 @@ func (it *ImportTasks) Apply(ctx context.Context, parentPath string, isRole bool
 		taskPath = filepath.Join(parentPath, it.File)
 	}
 
-	taskData, err := os.ReadFile(taskPath)
-	if err != nil {
-		return &ImportTasksResult{}, fmt.Errorf("failed to read tasks from %s: %w", taskPath, err)
-	}
-
-	var protoTasks []*proto.Task
-	if err := yaml.Unmarshal(taskData, &protoTasks); err != nil {
-		return &ImportTasksResult{}, fmt.Errorf("failed to parse tasks from %s: %w", taskPath, err)
-	}
-
-	for _, protoTask := range protoTasks {
-		task, err := FromProto(protoTask)
-		if err != nil {
-			return &ImportTasksResult{}, fmt.Errorf("failed to convert task from %s: %w", taskPath, err)
-		}
-
-		if err := util.ProcessJinjaTemplates(ctx, task); err != nil {
-			return &ImportTasksResult{}, fmt.Errorf("failed to render Jinja templating from %s: %w", taskPath, err)
-		}
-
-		if err := task.Validate(); err != nil {
-			return &ImportTasksResult{}, fmt.Errorf("failed to validate task from %s: %w", taskPath, err)
-		}
-
-		// TODO: handle result values. It's likely not possible to do register
-		// on this, and because it's import_tasks, we can't (well Ansible
-		// can't) loop.
-		_, err = task.Apply(ctx, parentPath, isRole)
-		if err != nil {
-			return &ImportTasksResult{}, fmt.Errorf("failed to apply task from %s: %w", taskPath, err)
-		}
+	if err := executeTaskFile(ctx, taskPath, parentPath, isRole); err != nil {
+		return &ImportTasksResult{}, err
 	}
 
 	return &ImportTasksResult{}, nil
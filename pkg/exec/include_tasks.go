@@ -9,7 +9,6 @@ import (
 
 	"github.com/goccy/go-yaml"
 
-	"github.com/mickael-carl/sophons/pkg/exec/util"
 	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/registry"
 )
@@ -60,37 +59,37 @@ func (it *IncludeTasks) Apply(ctx context.Context, parentPath string, isRole boo
 		taskPath = filepath.Join(parentPath, it.File)
 	}
 
+	if err := executeTaskFile(ctx, taskPath, parentPath, isRole); err != nil {
+		return &IncludeTasksResult{}, err
+	}
+
+	return &IncludeTasksResult{}, nil
+}
+
+// executeTaskFile runs the tasks defined in the file at taskPath, the same
+// way as any other task.
+func executeTaskFile(ctx context.Context, taskPath, parentPath string, isRole bool) error {
 	taskData, err := os.ReadFile(taskPath)
 	if err != nil {
-		return &IncludeTasksResult{}, fmt.Errorf("failed to read tasks from %s: %w", taskPath, err)
+		return fmt.Errorf("failed to read tasks from %s: %w", taskPath, err)
 	}
 
 	var protoTasks []*proto.Task
 	if err := yaml.Unmarshal(taskData, &protoTasks); err != nil {
-		return &IncludeTasksResult{}, fmt.Errorf("failed to parse tasks from %s: %w", taskPath, err)
+		return fmt.Errorf("failed to parse tasks from %s: %w", taskPath, err)
 	}
 
+	logger := loggerFromContext(ctx)
 	for _, protoTask := range protoTasks {
 		task, err := FromProto(protoTask)
 		if err != nil {
-			return &IncludeTasksResult{}, fmt.Errorf("failed to convert task from %s: %w", taskPath, err)
-		}
-
-		if err := util.ProcessJinjaTemplates(ctx, task); err != nil {
-			return &IncludeTasksResult{}, fmt.Errorf("failed to render Jinja templating from %s: %w", taskPath, err)
-		}
-
-		if err := task.Validate(); err != nil {
-			return &IncludeTasksResult{}, fmt.Errorf("failed to validate task from %s: %w", taskPath, err)
+			return fmt.Errorf("failed to convert task from %s: %w", taskPath, err)
 		}
 
-		// TODO: handle result values. It's likely not possible to do register
-		// on this.
-		_, err = task.Apply(ctx, parentPath, isRole)
-		if err != nil {
-			return &IncludeTasksResult{}, fmt.Errorf("failed to apply task from %s: %w", taskPath, err)
+		if err := ExecuteTask(ctx, logger, *task, parentPath, isRole); err != nil {
+			return fmt.Errorf("failed to apply task from %s: %w", taskPath, err)
 		}
 	}
 
-	return &IncludeTasksResult{}, nil
+	return nil
 }
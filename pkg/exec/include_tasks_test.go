@@ -1,9 +1,19 @@
 package exec
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/callback"
 	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
 )
 
 func TestIncludeTasksValidate(t *testing.T) {
@@ -36,3 +46,82 @@ func TestIncludeTasksValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestIncludedTasksExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		task *proto.Task
+	}{
+		{
+			name: "include_tasks",
+			task: &proto.Task{
+				Name:    "include",
+				Content: &proto.Task_IncludeTasks{IncludeTasks: &proto.IncludeTasks{File: "tasks.yaml"}},
+			},
+		},
+		{
+			name: "import_tasks",
+			task: &proto.Task{
+				Name:    "include",
+				Content: &proto.Task_ImportTasks{ImportTasks: &proto.ImportTasks{File: "tasks.yaml"}},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	tasks := `
+- name: loop
+  command:
+    cmd: "echo {{ item }}"
+  loop:
+    - a
+    - b
+  register: looped
+- name: skipped
+  command:
+    cmd: echo skipped
+  when: "false"
+`
+	if err := os.WriteFile(filepath.Join(dir, "tasks.yaml"), []byte(tasks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			m := NewMockcommandExecutor(ctrl)
+			m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+			m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+			m.EXPECT().Run().Return(nil).Times(2)
+
+			var cmds []string
+			ctx := context.WithValue(context.Background(), commandFactoryContextKey, cmdFactory(func(name string, args ...string) commandExecutor {
+				cmds = append(cmds, strings.Join(append([]string{name}, args...), " "))
+				return m
+			}))
+			vars := variables.Variables{}
+			ctx = variables.NewContext(ctx, vars)
+			recorder := &callbackRecorder{}
+			ctx = callback.NewContext(ctx, recorder, "localhost")
+
+			task, err := FromProto(tt.task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ExecuteTask(ctx, zap.NewNop(), *task, dir, false); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff([]string{"echo a", "echo b"}, cmds); diff != "" {
+				t.Errorf("commands mismatch (-want +got):\n%s", diff)
+			}
+			expected := []string{"loop: changed", "skipped: skipped", "include: ok"}
+			if diff := cmp.Diff(expected, taskOutcomes(recorder)); diff != "" {
+				t.Errorf("outcomes mismatch (-want +got):\n%s", diff)
+			}
+			if _, ok := vars["looped"]; !ok {
+				t.Error("expected the included task's result to be registered")
+			}
+		})
+	}
+}
@@ -0,0 +1,512 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nikolalohinski/gonja/v2"
+
+	"github.com/mickael-carl/sophons/pkg/exec/util"
+	"github.com/mickael-carl/sophons/pkg/lookup"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+const (
+	passwordLength = 20
+	passwordChars  = "ascii_letters,digits,.,,:-_"
+)
+
+// passwordCharsets are the named sets of characters the password lookup
+// accepts in `chars`, as in Python's string module.
+var passwordCharsets = map[string]string{
+	"ascii_letters":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"ascii_lowercase": "abcdefghijklmnopqrstuvwxyz",
+	"ascii_uppercase": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits":          "0123456789",
+	"hexdigits":       "0123456789abcdefABCDEF",
+	"octdigits":       "01234567",
+	"punctuation":     "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+}
+
+func init() {
+	lookups := map[string]lookup.Lookup{
+		"env":         lookupEnv,
+		"file":        lookupFile,
+		"fileglob":    lookupFileglob,
+		"first_found": lookupFirstFound,
+		"password":    lookupPassword,
+		"pipe":        lookupPipe,
+		"template":    lookupTemplate,
+		"vars":        lookupVars,
+	}
+	for name, l := range lookups {
+		lookup.Register(name, l)
+		lookup.Register("ansible.builtin."+name, l)
+	}
+}
+
+// stringTerms returns terms, that must all be strings.
+func stringTerms(terms []any) ([]string, error) {
+	strs := make([]string, 0, len(terms))
+	for _, term := range terms {
+		s, ok := term.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected strings as terms, got %T", term)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// boolKwarg returns the boolean keyword argument name, or def if it's unset.
+func boolKwarg(kwargs map[string]any, name string, def bool) (bool, error) {
+	v, ok := kwargs[name]
+	if !ok {
+		return def, nil
+	}
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("%s must be a boolean, got %q", name, v)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("%s must be a boolean, got %T", name, v)
+	}
+}
+
+// stringListKwarg returns the keyword argument name as a list of strings. A
+// string is split on any of seps.
+func stringListKwarg(v any, name, seps string) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool {
+			return strings.ContainsRune(seps, r)
+		}), nil
+	case []any:
+		strs, err := stringTerms(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return strs, nil
+	default:
+		return nil, fmt.Errorf("%s must be a string or a list of strings, got %T", name, v)
+	}
+}
+
+// lookupEnv returns the values of the environment variables named by terms,
+// or `default` for the unset ones. Unlike in Ansible, they're read from the
+// executer's environment on the target node, not from the controller's.
+func lookupEnv(_ context.Context, terms []any, kwargs map[string]any) ([]any, error) {
+	names, err := stringTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	def := ""
+	if d, ok := kwargs["default"]; ok {
+		def = fmt.Sprint(d)
+	}
+
+	values := make([]any, 0, len(names))
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			value = def
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// lookupFile returns the contents of the files named by terms. Relative paths
+// are looked up in the `files` directory of the role or play first, then in
+// the role or play's directory itself. Trailing whitespace is stripped unless
+// `rstrip` is false, and leading whitespace if `lstrip` is true.
+func lookupFile(ctx context.Context, terms []any, kwargs map[string]any) ([]any, error) {
+	names, err := stringTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	rstrip, err := boolKwarg(kwargs, "rstrip", true)
+	if err != nil {
+		return nil, err
+	}
+	lstrip, err := boolKwarg(kwargs, "lstrip", false)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, 0, len(names))
+	for _, name := range names {
+		path, err := lookup.FindFile(ctx, "files", name)
+		if err != nil {
+			return nil, fmt.Errorf("could not find file %s: %w", name, err)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		value := string(content)
+		if rstrip {
+			value = strings.TrimRight(value, " \t\r\n")
+		}
+		if lstrip {
+			value = strings.TrimLeft(value, " \t\r\n")
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// lookupFileglob returns the files matching the patterns in terms, like
+// `with_fileglob`.
+func lookupFileglob(ctx context.Context, terms []any, _ map[string]any) ([]any, error) {
+	patterns, err := stringTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []any{}
+	for _, pattern := range patterns {
+		files, err := globFiles(pattern, lookup.ParentPathFromContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, files...)
+	}
+	return values, nil
+}
+
+// lookupFirstFound returns the path of the first file found among terms. Terms
+// are file names, lists of them, or dicts of `files` and `paths` to look them
+// up in, which can also be given as keyword arguments. Files are looked up
+// like with the file lookup. Nothing is returned if no file is found and
+// `skip` is true.
+func lookupFirstFound(ctx context.Context, terms []any, kwargs map[string]any) ([]any, error) {
+	skip, err := boolKwarg(kwargs, "skip", false)
+	if err != nil {
+		return nil, err
+	}
+
+	// candidates returns the files to look for, from their names and the
+	// paths to look them up in.
+	candidates := func(names []string, paths any) ([]string, error) {
+		dirs, err := stringListKwarg(paths, "paths", ",:;")
+		if err != nil {
+			return nil, err
+		}
+		if len(dirs) == 0 {
+			return names, nil
+		}
+
+		var out []string
+		for _, name := range names {
+			for _, dir := range dirs {
+				out = append(out, filepath.Join(dir, name))
+			}
+		}
+		return out, nil
+	}
+
+	var search []string
+	for _, term := range terms {
+		var names []string
+		paths := kwargs["paths"]
+		switch term := term.(type) {
+		case string:
+			names = []string{term}
+		case []any:
+			if names, err = stringTerms(term); err != nil {
+				return nil, err
+			}
+		case map[string]any:
+			if skip, err = boolKwarg(term, "skip", skip); err != nil {
+				return nil, err
+			}
+			if names, err = stringListKwarg(term["files"], "files", ",;"); err != nil {
+				return nil, err
+			}
+			paths = term["paths"]
+		default:
+			return nil, fmt.Errorf("expected file names or dicts as terms, got %T", term)
+		}
+
+		c, err := candidates(names, paths)
+		if err != nil {
+			return nil, err
+		}
+		search = append(search, c...)
+	}
+
+	names, err := stringListKwarg(kwargs["files"], "files", ",;")
+	if err != nil {
+		return nil, err
+	}
+	c, err := candidates(names, kwargs["paths"])
+	if err != nil {
+		return nil, err
+	}
+	search = append(search, c...)
+
+	for _, name := range search {
+		path, err := lookup.FindFile(ctx, "files", name)
+		if err == nil {
+			return []any{path}, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if skip {
+		return []any{}, nil
+	}
+	return nil, errors.New("no file was found when using first_found")
+}
+
+// passwordCharset returns the characters to generate a password with, from a
+// comma-separated list of charset names and literal characters, where `,,` is
+// a literal comma.
+func passwordCharset(chars any) (string, error) {
+	var specs []string
+	switch chars := chars.(type) {
+	case string:
+		for _, part := range strings.Split(strings.ReplaceAll(chars, ",,", "\x00"), ",") {
+			if part != "" {
+				specs = append(specs, strings.ReplaceAll(part, "\x00", ","))
+			}
+		}
+	case []any:
+		var err error
+		if specs, err = stringTerms(chars); err != nil {
+			return "", fmt.Errorf("chars: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("chars must be a string or a list of strings, got %T", chars)
+	}
+
+	var charset strings.Builder
+	for _, spec := range specs {
+		if set, ok := passwordCharsets[spec]; ok {
+			charset.WriteString(set)
+		} else {
+			charset.WriteString(spec)
+		}
+	}
+	if charset.Len() == 0 {
+		return "", errors.New("chars can't be empty")
+	}
+	return charset.String(), nil
+}
+
+// lookupPassword returns the passwords stored in the files named by terms,
+// generating and storing random ones in the files that don't exist yet. Terms
+// can set `length` and `chars` after the file name, e.g. `creds/db length=15
+// chars=ascii_letters,digits`, as can keyword arguments. Passwords aren't
+// stored for `/dev/null`. `encrypt`, `ident` and `seed` aren't supported.
+//
+// Unlike in Ansible, the files are on the target node, not on the controller,
+// so each host gets its own passwords. Paths have to be absolute: the role or
+// play's directory relative paths would be in is removed after every run
+// through the dialer, and the passwords would change every time.
+func lookupPassword(ctx context.Context, terms []any, kwargs map[string]any) ([]any, error) {
+	specs, err := stringTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, 0, len(specs))
+	for _, spec := range specs {
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			return nil, errors.New("expected a file name")
+		}
+
+		params := map[string]any{"length": passwordLength, "chars": passwordChars}
+		for k, v := range kwargs {
+			params[k] = v
+		}
+		for _, field := range fields[1:] {
+			k, v, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid parameter %q", field)
+			}
+			params[k] = v
+		}
+
+		length := 0
+		switch l := params["length"].(type) {
+		case int:
+			length = l
+		case string:
+			if length, err = strconv.Atoi(l); err != nil {
+				return nil, fmt.Errorf("length must be an integer, got %q", l)
+			}
+		default:
+			return nil, fmt.Errorf("length must be an integer, got %T", l)
+		}
+		if length <= 0 {
+			return nil, fmt.Errorf("length must be positive, got %d", length)
+		}
+
+		charset, err := passwordCharset(params["chars"])
+		if err != nil {
+			return nil, err
+		}
+
+		path := fields[0]
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("password file %s must be an absolute path, for the password to be kept across runs", path)
+		}
+
+		content, err := os.ReadFile(path)
+		if err == nil && path != os.DevNull {
+			password, _, _ := strings.Cut(strings.TrimSpace(string(content)), " salt=")
+			values = append(values, password)
+			continue
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		password := make([]byte, length)
+		for i := range password {
+			j, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate password: %w", err)
+			}
+			password[i] = charset[j.Int64()]
+		}
+
+		if path != os.DevNull {
+			if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+				return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+			}
+			if err := os.WriteFile(path, append(password, '\n'), 0o600); err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", path, err)
+			}
+		}
+		values = append(values, string(password))
+	}
+	return values, nil
+}
+
+// lookupPipe returns the output of the commands in terms, run with `sh -c`,
+// with trailing whitespace stripped. Unlike in Ansible, they run on the
+// target node, not on the controller. Like the commands modules run, they run
+// as the task's user, with its environment.
+func lookupPipe(ctx context.Context, terms []any, _ map[string]any) ([]any, error) {
+	cmds, err := stringTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	factory, ok := ctx.Value(commandFactoryContextKey).(cmdFactory)
+	if !ok {
+		factory = realCmdFactory
+	}
+	factory, err = becomeCmdFactory(ctx, factory)
+	if err != nil {
+		return nil, err
+	}
+	factory = environmentCmdFactory(ctx, factory)
+
+	values := make([]any, 0, len(cmds))
+	for _, cmd := range cmds {
+		stdout, stderr, rc, err := ApplyCommand(factory, "", "", nil, "/bin/sh", []string{"-c", cmd})
+		if err != nil {
+			return nil, fmt.Errorf("command %q failed with return code %d: %s", cmd, rc, strings.TrimSpace(stderr))
+		}
+		values = append(values, strings.TrimRight(stdout, " \t\r\n"))
+	}
+	return values, nil
+}
+
+// lookupTemplate returns the templates named by terms, rendered. Relative
+// paths are looked up in the `templates` directory of the role or play first,
+// then in the role or play's directory itself. `template_vars` are set on top
+// of the other variables.
+func lookupTemplate(ctx context.Context, terms []any, kwargs map[string]any) ([]any, error) {
+	names, err := stringTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	if templateVars, ok := kwargs["template_vars"]; ok {
+		tv, ok := templateVars.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("template_vars must be a dict, got %T", templateVars)
+		}
+		vars, ok := variables.FromContext(ctx)
+		if !ok {
+			vars = variables.Variables{}
+		}
+		templateCtxVars := variables.Variables{}
+		templateCtxVars.Merge(vars)
+		templateCtxVars.Merge(tv)
+		ctx = variables.NewContext(ctx, templateCtxVars)
+	}
+
+	values := make([]any, 0, len(names))
+	for _, name := range names {
+		path, err := lookup.FindFile(ctx, "templates", name)
+		if err != nil {
+			return nil, fmt.Errorf("could not find template %s: %w", name, err)
+		}
+
+		template, err := gonja.FromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file %s: %w", path, err)
+		}
+
+		var buf bytes.Buffer
+		if err := template.Execute(&buf, util.JinjaContext(ctx)); err != nil {
+			return nil, fmt.Errorf("failed to template file %s: %w", path, err)
+		}
+		values = append(values, buf.String())
+	}
+	return values, nil
+}
+
+// lookupVars returns the values of the variables named by terms, or `default`
+// for the undefined ones.
+func lookupVars(ctx context.Context, terms []any, kwargs map[string]any) ([]any, error) {
+	names, err := stringTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, ok := variables.FromContext(ctx)
+	if !ok {
+		vars = variables.Variables{}
+	}
+
+	values := make([]any, 0, len(names))
+	for _, name := range names {
+		value, ok := vars[name]
+		if !ok {
+			if value, ok = kwargs["default"]; !ok {
+				return nil, fmt.Errorf("no variable named %s", name)
+			}
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
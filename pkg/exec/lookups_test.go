@@ -0,0 +1,222 @@
+package exec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/lookup"
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func TestLookups(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"files/motd":           "hello\n\n",
+		"files/b.conf":         "",
+		"files/a.conf":         "",
+		"vars/debian.yml":      "",
+		"templates/greet.j2":   "hello {{ name }}",
+		"root.txt":             "  root  \n",
+		"templates/nested.txt": "{{ lookup('file', 'motd') }}",
+	} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("SOPHONS_TEST_LOOKUP", "set")
+
+	ctx := variables.NewContext(context.Background(), variables.Variables{"name": "world", "port": 80})
+	ctx = lookup.NewContext(ctx, dir)
+
+	tests := []struct {
+		name     string
+		lookup   string
+		terms    []any
+		kwargs   map[string]any
+		expected []any
+		wantErr  bool
+	}{
+		{name: "file", lookup: "file", terms: []any{"motd"}, expected: []any{"hello"}},
+		{name: "file in parent path", lookup: "file", terms: []any{"root.txt"}, kwargs: map[string]any{"lstrip": true}, expected: []any{"root"}},
+		{name: "file absolute", lookup: "ansible.builtin.file", terms: []any{filepath.Join(dir, "root.txt")}, kwargs: map[string]any{"rstrip": false}, expected: []any{"  root  \n"}},
+		{name: "file missing", lookup: "file", terms: []any{"nope"}, wantErr: true},
+		{name: "env", lookup: "env", terms: []any{"SOPHONS_TEST_LOOKUP", "SOPHONS_TEST_UNSET"}, expected: []any{"set", ""}},
+		{name: "env default", lookup: "env", terms: []any{"SOPHONS_TEST_UNSET"}, kwargs: map[string]any{"default": "x"}, expected: []any{"x"}},
+		{name: "template", lookup: "template", terms: []any{"greet.j2"}, expected: []any{"hello world"}},
+		{name: "template vars", lookup: "template", terms: []any{"greet.j2"}, kwargs: map[string]any{"template_vars": map[string]any{"name": "you"}}, expected: []any{"hello you"}},
+		{name: "template with lookup", lookup: "template", terms: []any{"nested.txt"}, expected: []any{"hello"}},
+		{name: "pipe", lookup: "pipe", terms: []any{"echo hi; echo there"}, expected: []any{"hi\nthere"}},
+		{name: "pipe failure", lookup: "pipe", terms: []any{"exit 3"}, wantErr: true},
+		{
+			name:     "fileglob",
+			lookup:   "fileglob",
+			terms:    []any{"*.conf", "*.nope"},
+			expected: []any{filepath.Join(dir, "files", "a.conf"), filepath.Join(dir, "files", "b.conf")},
+		},
+		{
+			name:     "first_found",
+			lookup:   "first_found",
+			terms:    []any{"nope", []any{"motd", "a.conf"}},
+			expected: []any{filepath.Join(dir, "files", "motd")},
+		},
+		{
+			name:     "first_found with paths",
+			lookup:   "first_found",
+			terms:    []any{map[string]any{"files": "ubuntu.yml,debian.yml", "paths": []any{"vars"}}},
+			expected: []any{filepath.Join(dir, "vars", "debian.yml")},
+		},
+		{
+			name:     "first_found kwargs",
+			lookup:   "first_found",
+			kwargs:   map[string]any{"files": []any{"debian.yml"}, "paths": "nope:vars"},
+			expected: []any{filepath.Join(dir, "vars", "debian.yml")},
+		},
+		{name: "first_found nothing", lookup: "first_found", terms: []any{"nope"}, wantErr: true},
+		{name: "first_found skip", lookup: "first_found", terms: []any{"nope"}, kwargs: map[string]any{"skip": true}, expected: []any{}},
+		{name: "vars", lookup: "vars", terms: []any{"name", "port"}, expected: []any{"world", 80}},
+		{name: "vars default", lookup: "vars", terms: []any{"nope"}, kwargs: map[string]any{"default": "x"}, expected: []any{"x"}},
+		{name: "vars undefined", lookup: "vars", terms: []any{"nope"}, wantErr: true},
+		{name: "non-string term", lookup: "env", terms: []any{1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookup.Registry[tt.lookup](ctx, tt.terms, tt.kwargs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLookupPassword(t *testing.T) {
+	dir := t.TempDir()
+	ctx := lookup.NewContext(context.Background(), dir)
+
+	got, err := lookupPassword(ctx, []any{filepath.Join(dir, "creds", "db") + " length=12 chars=digits"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := got[0].(string)
+	if len(password) != 12 || strings.Trim(password, "0123456789") != "" {
+		t.Errorf("expected 12 digits, got %q", password)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "creds", "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != password+"\n" {
+		t.Errorf("expected %q to be stored, got %q", password, content)
+	}
+
+	// The stored password is returned from then on.
+	got, err = lookupPassword(ctx, []any{filepath.Join(dir, "creds", "db")}, map[string]any{"length": 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]any{password}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = lookupPassword(ctx, []any{os.DevNull}, map[string]any{"chars": []any{"ab", ",,"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	password = got[0].(string)
+	if len(password) != passwordLength || strings.Trim(password, "ab,") != "" {
+		t.Errorf("expected %d characters out of \"ab,\", got %q", passwordLength, password)
+	}
+
+	if _, err := lookupPassword(ctx, []any{filepath.Join(dir, "creds", "other") + " length=nope"}, nil); err == nil {
+		t.Error("expected an error for an invalid length")
+	}
+
+	// Relative paths would be in the directory the dialer removes after
+	// every run.
+	if _, err := lookupPassword(ctx, []any{"creds/other"}, nil); err == nil {
+		t.Error("expected an error for a relative path")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "creds", "other")); err == nil {
+		t.Error("expected no password to be stored for a relative path")
+	}
+}
+
+func TestPasswordCharset(t *testing.T) {
+	tests := []struct {
+		chars    any
+		expected string
+	}{
+		{chars: passwordChars, expected: passwordCharsets["ascii_letters"] + passwordCharsets["digits"] + ".,:-_"},
+		{chars: "digits,x,,", expected: "0123456789x,"},
+		{chars: []any{"octdigits", "x"}, expected: "01234567x"},
+	}
+
+	for _, tt := range tests {
+		got, err := passwordCharset(tt.chars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.expected {
+			t.Errorf("passwordCharset(%q) = %q, want %q", tt.chars, got, tt.expected)
+		}
+	}
+}
+
+func TestExecuteTaskLookup(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "files"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "files", "greeting"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := gomock.NewController(t)
+	m := NewMockcommandExecutor(ctrl)
+	m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+	m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+	m.EXPECT().Run().Return(nil).Times(1)
+
+	var cmds []string
+	ctx := context.WithValue(context.Background(), commandFactoryContextKey, cmdFactory(func(name string, args ...string) commandExecutor {
+		cmds = append(cmds, strings.Join(append([]string{name}, args...), " "))
+		return m
+	}))
+
+	task := Task{
+		Name: "greet",
+		Loop: "{{ query('fileglob', '*') }}",
+		Content: &Command{Command: &proto.Command{
+			Cmd: "echo {{ lookup('file', item | basename) }}",
+		}},
+	}
+	if err := ExecuteTask(ctx, zap.NewNop(), task, dir, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"echo hello"}, cmds); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+}
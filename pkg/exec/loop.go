@@ -0,0 +1,536 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/exec/util"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+// defaultLoopVar is the variable holding the current item, unless
+// `loop_control` says otherwise.
+const defaultLoopVar = "item"
+
+type LoopResult struct {
+	CommonResult `yaml:",inline"`
+	Results      []Result `yaml:"results" json:"results"`
+}
+
+// LoopItemResult is the result of an iteration of a loop. Like Ansible, it's
+// registered along with the item it's for.
+type LoopItemResult struct {
+	Result
+	Item     any
+	LoopVar  string
+	IndexVar string
+	Index    int
+	// Label is shown instead of the item, if set.
+	Label string
+
+	// err is the error the iteration failed with, if any.
+	err error
+}
+
+func (r *LoopItemResult) MarshalYAML() (any, error) {
+	m, err := resultToMap(r.Result)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = map[string]any{}
+	}
+
+	if msg, _ := m["msg"].(string); r.err != nil && msg == "" {
+		m["msg"] = r.err.Error()
+	}
+	m[r.LoopVar] = r.Item
+	m["ansible_loop_var"] = r.LoopVar
+	if r.IndexVar != "" {
+		m[r.IndexVar] = r.Index
+		m["ansible_index_var"] = r.IndexVar
+	}
+	if r.Label != "" {
+		m["_ansible_item_label"] = r.Label
+	}
+	return m, nil
+}
+
+// runLoop runs task for every item of its loop.
+func runLoop(ctx context.Context, logger *zap.Logger, task Task, parentPath string, isRole bool) (Result, error) {
+	tempLoopHolder := struct{ Loop any }{Loop: task.Loop}
+	if err := util.ProcessJinjaTemplates(ctx, &tempLoopHolder); err != nil {
+		return &CommonResult{}, fmt.Errorf("failed to process Jinja templating for loop: %w", err)
+	}
+
+	var items []any
+	var err error
+	if task.LoopWith != "" {
+		items, err = withLoopItems(task.LoopWith, tempLoopHolder.Loop, parentPath)
+	} else {
+		items, err = loopItems(tempLoopHolder.Loop)
+	}
+	if err != nil {
+		return &CommonResult{}, err
+	}
+
+	loopVar := defaultLoopVar
+	var indexVar, label string
+	var pause time.Duration
+	var extended bool
+	if lc := task.LoopControl; lc != nil {
+		if lc.LoopVar != "" {
+			loopVar = lc.LoopVar
+		}
+		indexVar = lc.IndexVar
+		label = lc.Label
+		pause = time.Duration(lc.Pause * float64(time.Second))
+		extended = lc.Extended
+	}
+
+	loopResults := LoopResult{
+		Results: []Result{},
+	}
+	// Like Ansible, a loop is only skipped if all its items are.
+	allSkipped := true
+	var itemErrs []error
+
+	for i, item := range items {
+		if i > 0 && pause > 0 {
+			select {
+			case <-ctx.Done():
+				loopResults.TaskFailed()
+				return &loopResults, fmt.Errorf("interrupted while pausing between items: %w", ctx.Err())
+			case <-time.After(pause):
+			}
+		}
+
+		newContent, err := deepCopyContent(task.Content)
+		if err != nil {
+			return &loopResults, fmt.Errorf("failed to copy task content: %w", err)
+		}
+
+		iterTask := Task{
+			Name:          task.Name,
+			When:          task.When,
+			Content:       newContent,
+			TemplatedArgs: task.TemplatedArgs,
+			Register:      task.Register,
+			ChangedWhen:   task.ChangedWhen,
+			FailedWhen:    task.FailedWhen,
+			Until:         task.Until,
+			Retries:       task.Retries,
+			Delay:         task.Delay,
+			Environment:   task.Environment,
+		}
+
+		iterVars := variables.Variables{loopVar: item}
+		if indexVar != "" {
+			iterVars[indexVar] = i
+		}
+		if extended {
+			iterVars["ansible_loop"] = extendedLoopVars(items, i)
+		}
+		loopCtx, done := scopedVarsContext(ctx, iterVars)
+
+		itemResult := &LoopItemResult{
+			Item:     item,
+			LoopVar:  loopVar,
+			IndexVar: indexVar,
+			Index:    i,
+		}
+		if label != "" {
+			labelHolder := struct{ Label string }{Label: label}
+			if err := util.ProcessJinjaTemplates(loopCtx, &labelHolder); err != nil {
+				done()
+				return &loopResults, fmt.Errorf("failed to process Jinja templating for loop label: %w", err)
+			}
+			itemResult.Label = labelHolder.Label
+		}
+
+		itemResult.Result, err = processAndRunTask(loopCtx, logger, iterTask, parentPath, isRole)
+		done()
+		if err != nil {
+			// Like Ansible, the other items still run, and the loop fails
+			// once they're done.
+			itemResult.TaskFailed()
+			itemResult.err = err
+			itemErrs = append(itemErrs, fmt.Errorf("item %d: %w", i, err))
+		}
+
+		if itemResult.IsChanged() {
+			loopResults.TaskChanged()
+		}
+		if !itemResult.IsSkipped() {
+			allSkipped = false
+		}
+		if itemResult.IsFailed() {
+			loopResults.TaskFailed()
+		}
+		loopResults.Results = append(loopResults.Results, itemResult)
+	}
+
+	if allSkipped {
+		loopResults.TaskSkipped()
+	}
+	if len(itemErrs) > 0 {
+		return &loopResults, fmt.Errorf("one or more items failed: %w", errors.Join(itemErrs...))
+	}
+	return &loopResults, nil
+}
+
+// loopItems returns the items of a rendered loop.
+func loopItems(loop any) ([]any, error) {
+	switch loop := loop.(type) {
+	case []any:
+		return loop, nil
+	case []string:
+		// It might be a slice of strings if the Jinja processing resulted in
+		// that.
+		items := make([]any, len(loop))
+		for i, v := range loop {
+			items[i] = v
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("loop variable is not a list: %T", loop)
+	}
+}
+
+// extendedLoopVars returns `ansible_loop` for the item at index i, when
+// `loop_control.extended` is set.
+func extendedLoopVars(items []any, i int) map[string]any {
+	vars := map[string]any{
+		"allitems":  items,
+		"index":     i + 1,
+		"index0":    i,
+		"revindex":  len(items) - i,
+		"revindex0": len(items) - i - 1,
+		"first":     i == 0,
+		"last":      i == len(items)-1,
+		"length":    len(items),
+	}
+	if i > 0 {
+		vars["previtem"] = items[i-1]
+	}
+	if i < len(items)-1 {
+		vars["nextitem"] = items[i+1]
+	}
+	return vars
+}
+
+// withLoopItems returns the items of a legacy `with_*` loop, with, e.g.,
+// `items` for `with_items`. loop is its rendered value.
+func withLoopItems(with string, loop any, parentPath string) ([]any, error) {
+	switch with {
+	case "items":
+		return withItems(loop), nil
+	case "dict":
+		return withDict(loop)
+	case "together":
+		return withTogether(loop)
+	case "subelements":
+		return withSubelements(loop)
+	case "sequence":
+		return withSequence(loop)
+	case "fileglob":
+		return withFileglob(loop, parentPath)
+	default:
+		return nil, fmt.Errorf("unsupported loop with_%s", with)
+	}
+}
+
+// withItems flattens lists one level down.
+func withItems(loop any) []any {
+	list, err := loopItems(loop)
+	if err != nil {
+		return []any{loop}
+	}
+
+	var items []any
+	for _, item := range list {
+		if sub, err := loopItems(item); err == nil {
+			items = append(items, sub...)
+		} else {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// withDict returns the key and value of each entry of a dict, sorted by key.
+func withDict(loop any) ([]any, error) {
+	dict, ok := loop.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("with_dict expects a dict, got %T", loop)
+	}
+
+	var items []any
+	for _, k := range slices.Sorted(maps.Keys(dict)) {
+		items = append(items, map[string]any{"key": k, "value": dict[k]})
+	}
+	return items, nil
+}
+
+// withTogether zips lists together, filling the shorter ones with nil.
+func withTogether(loop any) ([]any, error) {
+	outer, err := loopItems(loop)
+	if err != nil {
+		return nil, fmt.Errorf("with_together expects a list of lists: %w", err)
+	}
+
+	var lists [][]any
+	length := 0
+	for _, l := range outer {
+		list, err := loopItems(l)
+		if err != nil {
+			return nil, fmt.Errorf("with_together expects a list of lists: %w", err)
+		}
+		lists = append(lists, list)
+		length = max(length, len(list))
+	}
+
+	items := make([]any, length)
+	for i := range length {
+		item := make([]any, len(lists))
+		for j, list := range lists {
+			if i < len(list) {
+				item[j] = list[i]
+			}
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// withSubelements pairs every element of a list of dicts with each of the
+// elements of one of its keys. It expects the list, the key, and optionally
+// flags, of which only `skip_missing` is supported.
+func withSubelements(loop any) ([]any, error) {
+	args, err := loopItems(loop)
+	if err != nil || len(args) < 2 || len(args) > 3 {
+		return nil, errors.New("with_subelements expects a list of dicts, a key, and optionally flags")
+	}
+
+	elements, err := loopItems(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("with_subelements expects a list of dicts: %w", err)
+	}
+	key, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("with_subelements expects a key, got %T", args[1])
+	}
+	skipMissing := false
+	if len(args) == 3 {
+		flags, ok := args[2].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("with_subelements expects flags as a dict, got %T", args[2])
+		}
+		skipMissing, _ = flags["skip_missing"].(bool)
+	}
+
+	var items []any
+	for _, e := range elements {
+		element, ok := e.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("with_subelements expects a list of dicts, got an element of type %T", e)
+		}
+
+		value, ok := element[key]
+		if !ok {
+			if skipMissing {
+				continue
+			}
+			return nil, fmt.Errorf("with_subelements: key %q not found in element", key)
+		}
+		subelements, err := loopItems(value)
+		if err != nil {
+			return nil, fmt.Errorf("with_subelements: key %q isn't a list: %w", key, err)
+		}
+		for _, sub := range subelements {
+			items = append(items, []any{element, sub})
+		}
+	}
+	return items, nil
+}
+
+// withSequence returns a sequence of numbers, formatted as strings. It
+// accepts the key=value form, e.g. `start=1 end=10 stride=2 format=host%02d`
+// or `count=3`, and the shortcut form, e.g. `1-10/2:host%02d`.
+func withSequence(loop any) ([]any, error) {
+	spec, ok := loop.(string)
+	if !ok {
+		return nil, fmt.Errorf("with_sequence expects a string, got %T", loop)
+	}
+
+	start, end, stride, count := 1, 0, 1, -1
+	format := "%d"
+	hasEnd := false
+
+	parseInt := func(name, s string) (int, error) {
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("with_sequence: invalid %s %q", name, s)
+		}
+		return int(i), nil
+	}
+
+	var err error
+	if !strings.Contains(spec, "=") {
+		// Shortcut form: [start-]end[/stride][:format].
+		if s, f, ok := strings.Cut(spec, ":"); ok {
+			spec, format = s, f
+		}
+		if s, st, ok := strings.Cut(spec, "/"); ok {
+			spec = s
+			if stride, err = parseInt("stride", st); err != nil {
+				return nil, err
+			}
+		}
+		if s, e, ok := strings.Cut(spec, "-"); ok {
+			if start, err = parseInt("start", s); err != nil {
+				return nil, err
+			}
+			spec = e
+		}
+		if end, err = parseInt("end", spec); err != nil {
+			return nil, err
+		}
+		hasEnd = true
+	} else {
+		for _, field := range strings.Fields(spec) {
+			k, v, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("with_sequence: invalid argument %q", field)
+			}
+			switch k {
+			case "start":
+				start, err = parseInt(k, v)
+			case "end":
+				end, err = parseInt(k, v)
+				hasEnd = true
+			case "stride":
+				stride, err = parseInt(k, v)
+			case "count":
+				count, err = parseInt(k, v)
+			case "format":
+				format = v
+			default:
+				return nil, fmt.Errorf("with_sequence: unknown argument %q", k)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if count >= 0 {
+		if hasEnd {
+			return nil, errors.New("with_sequence: count and end can't both be set")
+		}
+		if count == 0 {
+			return []any{}, nil
+		}
+		end = start + (count-1)*stride
+	} else if !hasEnd {
+		return nil, errors.New("with_sequence: either end or count is required")
+	}
+
+	if stride == 0 {
+		return nil, errors.New("with_sequence: stride can't be 0")
+	}
+	if (stride > 0 && end < start) || (stride < 0 && end > start) {
+		return nil, errors.New("with_sequence: stride goes the wrong way")
+	}
+
+	var items []any
+	for i := start; (stride > 0 && i <= end) || (stride < 0 && i >= end); i += stride {
+		items = append(items, fmt.Sprintf(format, i))
+	}
+	return items, nil
+}
+
+// withFileglob returns the files matching patterns, sorted. Relative patterns
+// are looked up in the `files` directory of the role or play first, then in
+// the role or play's directory itself.
+func withFileglob(loop any, parentPath string) ([]any, error) {
+	var patterns []string
+	switch loop := loop.(type) {
+	case string:
+		patterns = []string{loop}
+	default:
+		list, err := loopItems(loop)
+		if err != nil {
+			return nil, fmt.Errorf("with_fileglob expects patterns: %w", err)
+		}
+		for _, p := range list {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("with_fileglob expects patterns, got %T", p)
+			}
+			patterns = append(patterns, s)
+		}
+	}
+
+	var items []any
+	for _, pattern := range patterns {
+		files, err := globFiles(pattern, parentPath)
+		if err != nil {
+			return nil, fmt.Errorf("with_fileglob: %w", err)
+		}
+		items = append(items, files...)
+	}
+	return items, nil
+}
+
+// globFiles returns the absolute paths of the files matching pattern, sorted.
+// Relative patterns are looked up in the `files` directory of the role or play
+// at parentPath first, then in parentPath itself.
+func globFiles(pattern, parentPath string) ([]any, error) {
+	dirs := []string{""}
+	if !filepath.IsAbs(pattern) {
+		dirs = []string{filepath.Join(parentPath, "files"), parentPath}
+	}
+
+	for _, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+
+		var files []string
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+				abs, err := filepath.Abs(m)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, abs)
+			} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		slices.Sort(files)
+		items := make([]any, 0, len(files))
+		for _, f := range files {
+			items = append(items, f)
+		}
+		return items, nil
+	}
+	return nil, nil
+}
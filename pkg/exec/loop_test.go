@@ -0,0 +1,418 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func TestExecuteTaskLoopControl(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	m := NewMockcommandExecutor(ctrl)
+	m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+	m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+	m.EXPECT().Run().Return(nil).Times(3)
+
+	var cmds []string
+	ctx := context.WithValue(context.Background(), commandFactoryContextKey, cmdFactory(func(name string, args ...string) commandExecutor {
+		cmds = append(cmds, strings.Join(append([]string{name}, args...), " "))
+		return m
+	}))
+	vars := variables.Variables{"item": "outer"}
+	ctx = variables.NewContext(ctx, vars)
+
+	task := Task{
+		Name:        "echo",
+		Loop:        []any{"a", "b", "c"},
+		ChangedWhen: []string{"ansible_loop.first"},
+		LoopControl: &proto.LoopControl{
+			LoopVar:  "pkg",
+			IndexVar: "i",
+			Label:    "{{ pkg }}-{{ i }}",
+			Extended: true,
+		},
+		Content: &Command{Command: &proto.Command{
+			Cmd: "echo {{ pkg }} {{ i }} {{ ansible_loop.revindex }} {{ item }}",
+		}},
+		Register: "out",
+	}
+
+	if err := ExecuteTask(ctx, zap.NewNop(), task, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCmds := []string{
+		"echo a 0 3 outer",
+		"echo b 1 2 outer",
+		"echo c 2 1 outer",
+	}
+	if diff := cmp.Diff(expectedCmds, cmds); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+
+	if vars["item"] != "outer" {
+		t.Errorf("expected item to be left alone, got %v", vars["item"])
+	}
+	if _, ok := vars["pkg"]; ok {
+		t.Error("expected the loop variable to be unset after the loop")
+	}
+
+	out, ok := vars["out"].(map[string]any)
+	if !ok {
+		t.Fatal("expected the result to be registered")
+	}
+	if out["changed"] != true {
+		t.Error("expected the loop to be changed")
+	}
+	results, _ := out["results"].([]any)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	type itemResult struct {
+		Pkg, LoopVar, IndexVar, Label string
+		I                             uint64
+		Changed                       bool
+	}
+	var got []itemResult
+	for _, r := range results {
+		r := r.(map[string]any)
+		got = append(got, itemResult{
+			Pkg:      r["pkg"].(string),
+			LoopVar:  r["ansible_loop_var"].(string),
+			IndexVar: r["ansible_index_var"].(string),
+			Label:    r["_ansible_item_label"].(string),
+			I:        r["i"].(uint64),
+			Changed:  r["changed"].(bool),
+		})
+	}
+	expected := []itemResult{
+		{Pkg: "a", LoopVar: "pkg", IndexVar: "i", Label: "a-0", I: 0, Changed: true},
+		{Pkg: "b", LoopVar: "pkg", IndexVar: "i", Label: "b-1", I: 1},
+		{Pkg: "c", LoopVar: "pkg", IndexVar: "i", Label: "c-2", I: 2},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecuteTaskLoopSkipped(t *testing.T) {
+	ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+		m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+		m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+		m.EXPECT().Run().Return(nil).Times(1)
+	})
+	vars := variables.Variables{}
+	ctx = variables.NewContext(ctx, vars)
+
+	task := Task{
+		Loop:     []any{1, 2, 3},
+		When:     []string{"item > 2"},
+		Content:  &Command{Command: &proto.Command{Cmd: "true"}},
+		Register: "out",
+	}
+	if err := ExecuteTask(ctx, zap.NewNop(), task, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The loop isn't skipped, since one of its items isn't.
+	out := vars["out"].(map[string]any)
+	if out["skipped"] != false {
+		t.Errorf("expected the loop not to be skipped, got %v", out["skipped"])
+	}
+}
+
+func TestExecuteTaskLoopItemFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	m := NewMockcommandExecutor(ctrl)
+	m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+	m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+
+	var cmds []string
+	m.EXPECT().Run().DoAndReturn(func() error {
+		if cmds[len(cmds)-1] == "false b" {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}).Times(3)
+
+	ctx := context.WithValue(context.Background(), commandFactoryContextKey, cmdFactory(func(name string, args ...string) commandExecutor {
+		cmds = append(cmds, strings.Join(append([]string{name}, args...), " "))
+		return m
+	}))
+	vars := variables.Variables{}
+	ctx = variables.NewContext(ctx, vars)
+
+	task := Task{
+		Loop:     []any{"a", "b", "c"},
+		Content:  &Command{Command: &proto.Command{Cmd: "false {{ item }}"}},
+		Register: "out",
+	}
+	if err := ExecuteTask(ctx, zap.NewNop(), task, "", false); err == nil {
+		t.Fatal("expected the loop to fail")
+	}
+
+	// The items after the failing one still run.
+	expectedCmds := []string{"false a", "false b", "false c"}
+	if diff := cmp.Diff(expectedCmds, cmds); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+
+	out := vars["out"].(map[string]any)
+	if out["failed"] != true {
+		t.Error("expected the loop to be failed")
+	}
+	var failed []bool
+	for _, r := range out["results"].([]any) {
+		failed = append(failed, r.(map[string]any)["failed"].(bool))
+	}
+	if diff := cmp.Diff([]bool{false, true, false}, failed); diff != "" {
+		t.Errorf("failed items mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecuteTaskLoopNative(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	m := NewMockcommandExecutor(ctrl)
+	m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+	m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+	m.EXPECT().Run().Return(nil).Times(2)
+
+	var cmds []string
+	ctx := context.WithValue(context.Background(), commandFactoryContextKey, cmdFactory(func(name string, args ...string) commandExecutor {
+		cmds = append(cmds, strings.Join(append([]string{name}, args...), " "))
+		return m
+	}))
+	ctx = variables.NewContext(ctx, variables.Variables{
+		"users": []any{
+			map[string]any{"name": "alice", "uid": 1000},
+			map[string]any{"name": "bob", "uid": 1001},
+		},
+	})
+
+	task := Task{
+		Loop: "{{ users }}",
+		Content: &Command{Command: &proto.Command{
+			Cmd: "useradd -u {{ item.uid }} {{ item.name }}",
+		}},
+	}
+	if err := ExecuteTask(ctx, zap.NewNop(), task, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"useradd -u 1000 alice",
+		"useradd -u 1001 bob",
+	}
+	if diff := cmp.Diff(expected, cmds); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithLoopItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		with     string
+		loop     any
+		expected []any
+		wantErr  bool
+	}{
+		{
+			name:     "items flattens one level",
+			with:     "items",
+			loop:     []any{"a", []any{"b", []any{"c"}}},
+			expected: []any{"a", "b", []any{"c"}},
+		},
+		{
+			name:     "items with a single value",
+			with:     "items",
+			loop:     "a",
+			expected: []any{"a"},
+		},
+		{
+			name: "dict",
+			with: "dict",
+			loop: map[string]any{"b": 2, "a": 1},
+			expected: []any{
+				map[string]any{"key": "a", "value": 1},
+				map[string]any{"key": "b", "value": 2},
+			},
+		},
+		{
+			name:    "dict with a list",
+			with:    "dict",
+			loop:    []any{"a"},
+			wantErr: true,
+		},
+		{
+			name: "together",
+			with: "together",
+			loop: []any{[]any{"a", "b"}, []any{1}},
+			expected: []any{
+				[]any{"a", 1},
+				[]any{"b", nil},
+			},
+		},
+		{
+			name: "subelements",
+			with: "subelements",
+			loop: []any{
+				[]any{
+					map[string]any{"name": "alice", "keys": []any{"k1", "k2"}},
+					map[string]any{"name": "bob", "keys": []any{"k3"}},
+				},
+				"keys",
+			},
+			expected: []any{
+				[]any{map[string]any{"name": "alice", "keys": []any{"k1", "k2"}}, "k1"},
+				[]any{map[string]any{"name": "alice", "keys": []any{"k1", "k2"}}, "k2"},
+				[]any{map[string]any{"name": "bob", "keys": []any{"k3"}}, "k3"},
+			},
+		},
+		{
+			name: "subelements skipping missing keys",
+			with: "subelements",
+			loop: []any{
+				[]any{
+					map[string]any{"name": "alice"},
+					map[string]any{"name": "bob", "keys": []any{"k3"}},
+				},
+				"keys",
+				map[string]any{"skip_missing": true},
+			},
+			expected: []any{
+				[]any{map[string]any{"name": "bob", "keys": []any{"k3"}}, "k3"},
+			},
+		},
+		{
+			name: "subelements with a missing key",
+			with: "subelements",
+			loop: []any{
+				[]any{map[string]any{"name": "alice"}},
+				"keys",
+			},
+			wantErr: true,
+		},
+		{
+			name:     "sequence",
+			with:     "sequence",
+			loop:     "start=1 end=5 stride=2",
+			expected: []any{"1", "3", "5"},
+		},
+		{
+			name:     "sequence with count and format",
+			with:     "sequence",
+			loop:     "start=0 count=3 format=host%02d",
+			expected: []any{"host00", "host01", "host02"},
+		},
+		{
+			name:     "sequence shortcut",
+			with:     "sequence",
+			loop:     "4-8/2:web%d",
+			expected: []any{"web4", "web6", "web8"},
+		},
+		{
+			name:     "sequence counting down",
+			with:     "sequence",
+			loop:     "start=3 end=1 stride=-1",
+			expected: []any{"3", "2", "1"},
+		},
+		{
+			name:    "sequence without end",
+			with:    "sequence",
+			loop:    "start=1",
+			wantErr: true,
+		},
+		{
+			name:    "sequence going the wrong way",
+			with:    "sequence",
+			loop:    "start=5 end=1",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported",
+			with:    "nested",
+			loop:    []any{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withLoopItems(tt.with, tt.loop, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithFileglob(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"files/b.conf", "files/a.conf", "files/c.txt", "d.conf"} {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "files", "e.conf"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		loop     any
+		expected []any
+	}{
+		{
+			name: "relative to files",
+			loop: "*.conf",
+			expected: []any{
+				filepath.Join(dir, "files", "a.conf"),
+				filepath.Join(dir, "files", "b.conf"),
+			},
+		},
+		{
+			name:     "relative to the role or play",
+			loop:     []any{"d.*"},
+			expected: []any{filepath.Join(dir, "d.conf")},
+		},
+		{
+			name:     "absolute",
+			loop:     filepath.Join(dir, "files", "*.txt"),
+			expected: []any{filepath.Join(dir, "files", "c.txt")},
+		},
+		{
+			name: "no match",
+			loop: "*.nope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withLoopItems("fileglob", tt.loop, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
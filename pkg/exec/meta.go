@@ -0,0 +1,66 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/registry"
+)
+
+//	@meta{
+//	  "deviations": [
+//	    "only the `flush_handlers` action is supported"
+//	  ]
+//	}
+type Meta struct {
+	*proto.Meta `yaml:",inline"`
+}
+
+type MetaResult struct {
+	CommonResult `yaml:",inline"`
+}
+
+// metaActions are the supported meta actions.
+var metaActions = []string{"flush_handlers"}
+
+func init() {
+	reg := registry.TaskRegistration{
+		ProtoFactory: func() any { return &proto.Meta{} },
+		ProtoWrapper: func(msg any) any { return &proto.Task_Meta{Meta: msg.(*proto.Meta)} },
+		ExecAdapter: func(content any) any {
+			if c, ok := content.(*proto.Task_Meta); ok {
+				return &Meta{Meta: c.Meta}
+			}
+			return nil
+		},
+	}
+	registry.Register("meta", reg, (*proto.Task_Meta)(nil))
+	registry.Register("ansible.builtin.meta", reg, (*proto.Task_Meta)(nil))
+}
+
+func (m *Meta) Validate() error {
+	if !slices.Contains(metaActions, m.FreeForm) {
+		return fmt.Errorf("unsupported meta action %q, must be one of %v", m.FreeForm, metaActions)
+	}
+	return nil
+}
+
+func (m *Meta) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
+	result := MetaResult{}
+
+	switch m.FreeForm {
+	case "flush_handlers":
+		h, ok := HandlersFromContext(ctx)
+		if !ok {
+			return &result, nil
+		}
+		if err := h.Flush(); err != nil {
+			result.TaskFailed()
+			return &result, err
+		}
+	}
+
+	return &result, nil
+}
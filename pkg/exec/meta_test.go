@@ -0,0 +1,20 @@
+package exec
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func TestMetaValidate(t *testing.T) {
+	if err := (&Meta{Meta: &proto.Meta{FreeForm: "flush_handlers"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (&Meta{Meta: &proto.Meta{FreeForm: "end_play"}}).Validate(); err == nil {
+		t.Error("expected an error for an unsupported action")
+	}
+	if _, err := (&Meta{Meta: &proto.Meta{FreeForm: "flush_handlers"}}).Apply(context.Background(), "", false); err != nil {
+		t.Errorf("expected flushing without handlers to do nothing, got %v", err)
+	}
+}
@@ -12,6 +12,7 @@ package exec
 import (
 	io "io"
 	reflect "reflect"
+	syscall "syscall"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -54,6 +55,18 @@ func (mr *MockcommandExecutorMockRecorder) Run() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockcommandExecutor)(nil).Run))
 }
 
+// SetCredential mocks base method.
+func (m *MockcommandExecutor) SetCredential(arg0 *syscall.Credential) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetCredential", arg0)
+}
+
+// SetCredential indicates an expected call of SetCredential.
+func (mr *MockcommandExecutorMockRecorder) SetCredential(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCredential", reflect.TypeOf((*MockcommandExecutor)(nil).SetCredential), arg0)
+}
+
 // SetDir mocks base method.
 func (m *MockcommandExecutor) SetDir(arg0 string) {
 	m.ctrl.T.Helper()
@@ -66,6 +79,18 @@ func (mr *MockcommandExecutorMockRecorder) SetDir(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDir", reflect.TypeOf((*MockcommandExecutor)(nil).SetDir), arg0)
 }
 
+// SetEnv mocks base method.
+func (m *MockcommandExecutor) SetEnv(arg0 []string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetEnv", arg0)
+}
+
+// SetEnv indicates an expected call of SetEnv.
+func (mr *MockcommandExecutorMockRecorder) SetEnv(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEnv", reflect.TypeOf((*MockcommandExecutor)(nil).SetEnv), arg0)
+}
+
 // SetStderr mocks base method.
 func (m *MockcommandExecutor) SetStderr(arg0 io.Writer) {
 	m.ctrl.T.Helper()
@@ -3,7 +3,6 @@ package exec
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -16,8 +15,6 @@ import (
 //	}
 type Shell struct {
 	*proto.Shell `yaml:",inline"`
-
-	cmdFactory cmdFactory
 }
 
 type ShellResult struct {
@@ -49,14 +46,14 @@ func (s *Shell) Validate() error {
 }
 
 func (s *Shell) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
-	if ctxCmdFactory, ok := ctx.Value(commandFactoryContextKey).(cmdFactory); ok {
-		s.cmdFactory = ctxCmdFactory
-	} else {
-		s.cmdFactory = realCmdFactory
-	}
-
 	result := ShellResult{}
 
+	factory, err := taskCmdFactory(ctx)
+	if err != nil {
+		result.TaskFailed()
+		return &result, err
+	}
+
 	var args []string
 	name := "/bin/sh"
 	if s.Executable != "" {
@@ -83,8 +80,13 @@ func (s *Shell) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		return &result, nil
 	}
 
+	if CheckModeFromContext(ctx) {
+		checkCommand(&result.CommonResult, s.Creates, s.Removes)
+		return &result, nil
+	}
+
 	start := time.Now()
-	stdout, stderr, rc, err := ApplyCommand(s.cmdFactory, s.Chdir, s.Stdin, s.StdinAddNewline, name, args)
+	stdout, stderr, rc, err := ApplyCommand(factory, s.Chdir, s.Stdin, s.StdinAddNewline, name, args)
 	end := time.Now()
 
 	result.Start = start
@@ -99,11 +101,6 @@ func (s *Shell) Apply(ctx context.Context, _ string, _ bool) (Result, error) {
 		return &result, fmt.Errorf("failed to execute command: %w", err)
 	}
 
-	// TODO: Debug.
-	if stdout != "" {
-		log.Print(stdout)
-	}
-
 	result.TaskChanged()
 
 	return &result, nil
@@ -0,0 +1,92 @@
+package exec
+
+import (
+	"context"
+	"slices"
+)
+
+var (
+	tagsContextKey         = &struct{ name string }{"tags"}
+	tagSelectionContextKey = &struct{ name string }{"tag selection"}
+)
+
+// TagSelection selects the tasks to run by their tags, as set with `--tags`
+// and `--skip-tags`. Besides tags set on tasks, these can be:
+//   - `all`, matching all tasks but the ones tagged `never`, and the default
+//     for Tags,
+//   - `tagged` and `untagged`, matching the tasks with and without tags.
+//
+// Tasks tagged `always` run unless it's skipped explicitly, and the ones
+// tagged `never` only run when one of their tags is selected explicitly.
+type TagSelection struct {
+	Tags     []string
+	SkipTags []string
+}
+
+// Selected tells whether a task with tags runs.
+func (s TagSelection) Selected(tags []string) bool {
+	only := s.Tags
+	if len(only) == 0 {
+		only = []string{"all"}
+	}
+	if len(tags) == 0 {
+		tags = []string{"untagged"}
+	}
+	untagged := slices.Equal(tags, []string{"untagged"})
+	always := slices.Contains(tags, "always")
+	never := slices.Contains(tags, "never")
+
+	switch {
+	case always:
+	case slices.Contains(only, "all") && !never:
+	case slices.ContainsFunc(tags, func(tag string) bool { return slices.Contains(only, tag) }):
+	case slices.Contains(only, "tagged") && !untagged && !never:
+	default:
+		return false
+	}
+
+	switch {
+	case slices.Contains(s.SkipTags, "all"):
+		return always && !slices.Contains(s.SkipTags, "always")
+	case slices.ContainsFunc(tags, func(tag string) bool { return slices.Contains(s.SkipTags, tag) }):
+		return false
+	case slices.Contains(s.SkipTags, "tagged") && !untagged:
+		return false
+	}
+	return true
+}
+
+// NewTagSelectionContext returns a new context where s selects the tasks to
+// run.
+func NewTagSelectionContext(ctx context.Context, s TagSelection) context.Context {
+	return context.WithValue(ctx, tagSelectionContextKey, s)
+}
+
+// NewTagsContext returns a new context where tags are inherited by the tasks
+// run with it, on top of the ones ctx carries. Plays, roles, blocks and
+// included tasks pass their tags down this way.
+func NewTagsContext(ctx context.Context, tags []string) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+
+	inherited, _ := ctx.Value(tagsContextKey).([]string)
+	return context.WithValue(ctx, tagsContextKey, append(slices.Clone(inherited), tags...))
+}
+
+// taskSelected tells whether a task runs given the tags it has and inherits.
+// Blocks and included tasks are always run, for the tasks in them to be
+// selected on their own, and handlers run whenever they're notified.
+func taskSelected(ctx context.Context, task Task) bool {
+	switch task.Content.(type) {
+	case *Block, *IncludeTasks, *ImportTasks:
+		return true
+	}
+	if task.Handler {
+		return true
+	}
+
+	s, _ := ctx.Value(tagSelectionContextKey).(TagSelection)
+	inherited, _ := ctx.Value(tagsContextKey).([]string)
+	return s.Selected(append(slices.Clone(inherited), task.Tags...))
+}
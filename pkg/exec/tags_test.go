@@ -0,0 +1,156 @@
+package exec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+)
+
+func TestTagSelection(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection TagSelection
+		tags      []string
+		expected  bool
+	}{
+		{name: "all by default", tags: []string{"nginx"}, expected: true},
+		{name: "untagged by default", expected: true},
+		{name: "never by default", tags: []string{"never", "debug"}, expected: false},
+		{name: "always by default", tags: []string{"always"}, expected: true},
+		{name: "selected", selection: TagSelection{Tags: []string{"nginx"}}, tags: []string{"web", "nginx"}, expected: true},
+		{name: "not selected", selection: TagSelection{Tags: []string{"nginx"}}, tags: []string{"db"}, expected: false},
+		{name: "untagged not selected", selection: TagSelection{Tags: []string{"nginx"}}, expected: false},
+		{name: "always selected", selection: TagSelection{Tags: []string{"nginx"}}, tags: []string{"always"}, expected: true},
+		{name: "never selected explicitly", selection: TagSelection{Tags: []string{"debug"}}, tags: []string{"never", "debug"}, expected: true},
+		{name: "never not selected by all", selection: TagSelection{Tags: []string{"all"}}, tags: []string{"never"}, expected: false},
+		{name: "tagged", selection: TagSelection{Tags: []string{"tagged"}}, tags: []string{"db"}, expected: true},
+		{name: "tagged untagged", selection: TagSelection{Tags: []string{"tagged"}}, expected: false},
+		{name: "untagged", selection: TagSelection{Tags: []string{"untagged"}}, expected: true},
+		{name: "untagged tagged", selection: TagSelection{Tags: []string{"untagged"}}, tags: []string{"db"}, expected: false},
+		{name: "skipped", selection: TagSelection{SkipTags: []string{"db"}}, tags: []string{"web", "db"}, expected: false},
+		{name: "selected and skipped", selection: TagSelection{Tags: []string{"web"}, SkipTags: []string{"db"}}, tags: []string{"web", "db"}, expected: false},
+		{name: "always skipped", selection: TagSelection{SkipTags: []string{"always"}}, tags: []string{"always"}, expected: false},
+		{name: "always not skipped by all", selection: TagSelection{SkipTags: []string{"all"}}, tags: []string{"always"}, expected: true},
+		{name: "all skipped", selection: TagSelection{SkipTags: []string{"all"}}, tags: []string{"web"}, expected: false},
+		{name: "tagged skipped", selection: TagSelection{SkipTags: []string{"tagged"}}, tags: []string{"web"}, expected: false},
+		{name: "untagged skipped", selection: TagSelection{SkipTags: []string{"untagged"}}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selection.Selected(tt.tags); got != tt.expected {
+				t.Errorf("Selected(%v) = %v, want %v", tt.tags, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteTaskTags(t *testing.T) {
+	dir := t.TempDir()
+	tasksYAML := `
+- ansible.builtin.command:
+    cmd: "echo included"
+- ansible.builtin.command:
+    cmd: "echo included db"
+  tags: db
+`
+	if err := os.WriteFile(filepath.Join(dir, "tasks.yaml"), []byte(tasksYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []Task{
+		{Content: &Command{Command: &proto.Command{Cmd: "echo untagged"}}},
+		{
+			Tags:    []string{"db"},
+			Content: &Command{Command: &proto.Command{Cmd: "echo db"}},
+		},
+		{
+			// The task isn't selected, so it's not rendered either: the
+			// lookup doesn't exist.
+			Tags:    []string{"never"},
+			Loop:    "{{ query('nope') }}",
+			Content: &Command{Command: &proto.Command{Cmd: "echo {{ item }}"}},
+		},
+		{
+			Tags: []string{"nginx"},
+			Content: &Block{Block: &proto.Block{Tasks: []*proto.Task{
+				{Content: &proto.Task_Command{Command: &proto.Command{Cmd: "echo block"}}},
+				{Tags: []string{"debug"}, Content: &proto.Task_Command{Command: &proto.Command{Cmd: "echo block debug"}}},
+			}}},
+		},
+		{
+			Tags:    []string{"nginx"},
+			Content: &IncludeTasks{IncludeTasks: &proto.IncludeTasks{File: "tasks.yaml"}},
+		},
+		{
+			Tags:    []string{"never", "debug"},
+			Content: &Command{Command: &proto.Command{Cmd: "echo debug"}},
+		},
+		{
+			Tags:    []string{"always"},
+			Content: &Command{Command: &proto.Command{Cmd: "echo always"}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		selection TagSelection
+		expected  []string
+	}{
+		{
+			name:      "tags",
+			selection: TagSelection{Tags: []string{"nginx"}},
+			expected:  []string{"echo block", "echo block debug", "echo included", "echo included db", "echo always"},
+		},
+		{
+			name:      "skip tags",
+			selection: TagSelection{Tags: []string{"nginx"}, SkipTags: []string{"debug", "always"}},
+			expected:  []string{"echo block", "echo included", "echo included db"},
+		},
+		{
+			name:      "never",
+			selection: TagSelection{Tags: []string{"debug"}},
+			expected:  []string{"echo block debug", "echo debug", "echo always"},
+		},
+		{
+			name:      "inherited",
+			selection: TagSelection{SkipTags: []string{"nginx"}},
+			expected:  []string{"echo untagged", "echo db", "echo always"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			m := NewMockcommandExecutor(ctrl)
+			m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+			m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+			m.EXPECT().Run().Return(nil).AnyTimes()
+
+			var cmds []string
+			ctx := context.WithValue(context.Background(), commandFactoryContextKey, cmdFactory(func(name string, args ...string) commandExecutor {
+				cmds = append(cmds, strings.Join(append([]string{name}, args...), " "))
+				return m
+			}))
+			ctx = NewTagSelectionContext(ctx, tt.selection)
+
+			for _, task := range tasks {
+				if err := ExecuteTask(ctx, zap.NewNop(), task, dir, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if diff := cmp.Diff(tt.expected, cmds); diff != "" {
+				t.Errorf("commands mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
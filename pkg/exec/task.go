@@ -2,33 +2,82 @@ package exec
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 
 	"github.com/goccy/go-yaml"
 
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/mickael-carl/sophons/pkg/exec/util"
+	"github.com/mickael-carl/sophons/pkg/lookup"
 	protopackage "github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/registry"
 	"github.com/mickael-carl/sophons/pkg/variables"
 )
 
+var loggerContextKey = &struct{ name string }{"logger"}
+
 type Task struct {
-	Name     string
-	When     string
-	Loop     any
-	Content  TaskContent
-	Register string
+	Name string
+	// Module is the name of the module the task uses, e.g. `command`.
+	Module  string
+	When    []string
+	Loop    any
+	Content TaskContent
+	// TemplatedArgs are the templates of the module arguments that aren't
+	// strings, by name. They're set into Content once rendered.
+	TemplatedArgs map[string]string
+	Register      string
+	Become        *bool
+	BecomeUser    string
+	BecomeMethod  string
+	Notify        []string
+	Listen        []string
+	Vars          variables.Variables
+	// LoopWith is set for `with_*` loops, e.g. to `items` for `with_items`.
+	LoopWith    string
+	LoopControl *protopackage.LoopControl
+	// Environment is the task's `environment`, a dict once rendered.
+	Environment any
+	// Tags select whether the task runs. Blocks and included tasks pass
+	// theirs down to the tasks in them.
+	Tags []string
+	// CheckMode overrides whether the task runs in check mode, if set.
+	CheckMode    *bool
+	ChangedWhen  []string
+	FailedWhen   []string
+	IgnoreErrors bool
+	Until        []string
+	Retries      *int32
+	Delay        *int32
+	// Handler is set for handlers, which only run when notified.
+	Handler bool
 }
 
 func (t Task) Validate() error {
 	return t.Content.Validate()
 }
 
-func (t Task) Apply(ctx context.Context, parentPath string, isRole bool) (Result, error) {
+// Apply applies the task's content, as the user it should run as.
+func (t Task) Apply(ctx context.Context, parentPath string, isRole bool) (result Result, err error) {
+	if runsAsUser(t.Content) {
+		return t.Content.Apply(ctx, parentPath, isRole)
+	}
+
+	restore, err := becomeUser(ctx)
+	if err != nil {
+		return &CommonResult{}, err
+	}
+	defer func() {
+		if restoreErr := restore(); restoreErr != nil {
+			err = errors.Join(err, restoreErr)
+		}
+	}()
 	return t.Content.Apply(ctx, parentPath, isRole)
 }
 
@@ -53,19 +102,54 @@ type TaskContent interface {
 // FromProto converts a proto.Task to an exec.Task for execution.
 func FromProto(pt *protopackage.Task) (*Task, error) {
 	t := &Task{
-		Name:     pt.Name,
-		When:     pt.When,
-		Register: pt.Register,
+		Name:         pt.Name,
+		When:         pt.When,
+		Register:     pt.Register,
+		Become:       pt.Become,
+		BecomeUser:   pt.BecomeUser,
+		BecomeMethod: pt.BecomeMethod,
+		Notify:       pt.Notify,
+		Listen:       pt.Listen,
+		ChangedWhen:  pt.ChangedWhen,
+		FailedWhen:   pt.FailedWhen,
+		IgnoreErrors: pt.IgnoreErrors,
+		Until:        pt.Until,
+		Retries:      pt.Retries,
+		Delay:        pt.Delay,
+		LoopWith:     pt.LoopWith,
+		LoopControl:  pt.LoopControl,
+		Tags:         pt.Tags,
+		CheckMode:    pt.CheckMode,
 	}
 
 	if pt.Loop != nil {
-		t.Loop = pt.Loop.AsInterface()
+		t.Loop = fromStructValue(pt.Loop.AsInterface())
+	}
+
+	if pt.Vars != nil {
+		t.Vars = structToVariables(pt.Vars)
+	}
+
+	if pt.Environment != nil {
+		t.Environment = fromStructValue(pt.Environment.AsInterface())
+	}
+
+	if pt.TemplatedArgs != nil {
+		t.TemplatedArgs = map[string]string{}
+		for name, value := range pt.TemplatedArgs.AsMap() {
+			t.TemplatedArgs[name], _ = value.(string)
+		}
 	}
 
 	if pt.Content == nil {
 		return t, nil
 	}
 
+	m := pt.ProtoReflect()
+	if fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("content")); fd != nil {
+		t.Module = string(fd.Name())
+	}
+
 	reg, ok := registry.TypeRegistry[reflect.TypeOf(pt.Content)]
 	if !ok {
 		return nil, fmt.Errorf("unknown proto content type %T: not registered", pt.Content)
@@ -83,6 +167,39 @@ func FromProto(pt *protopackage.Task) (*Task, error) {
 	return t, nil
 }
 
+// structToVariables converts variables stored in a protobuf Struct. Numbers
+// are stored as doubles there: whole ones are converted back to integers, so
+// that they don't get rendered as `1.0` in templates.
+func structToVariables(s *structpb.Struct) variables.Variables {
+	vars := variables.Variables{}
+	for k, v := range s.AsMap() {
+		vars[k] = fromStructValue(v)
+	}
+	return vars
+}
+
+func fromStructValue(v any) any {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int(v)
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = fromStructValue(v[i])
+		}
+		return v
+	case map[string]any:
+		for k := range v {
+			v[k] = fromStructValue(v[k])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
 // deepCopyContent takes any task's content and returns a copy of it alongside
 // any error while doing so. We need this to support loops, since when `loop`
 // is set we need to evaluate Jinja templates with a new variable, `item`, for
@@ -116,124 +233,194 @@ func processAndRunTask(ctx context.Context, logger *zap.Logger, task Task, paren
 	if err := util.ProcessJinjaTemplates(ctx, &task); err != nil {
 		return &CommonResult{}, fmt.Errorf("failed to process Jinja templating: %w", err)
 	}
+	if err := util.ProcessJinjaArgs(ctx, task.Content, task.TemplatedArgs); err != nil {
+		return &CommonResult{}, fmt.Errorf("failed to process Jinja templating: %w", err)
+	}
 
-	whenResult, err := util.JinjaProcessWhen(ctx, task.When)
+	whenResult, err := util.JinjaProcessWhen(ctx, task.When...)
 	if err != nil {
 		return &CommonResult{}, fmt.Errorf("failed to process when condition: %w", err)
 	}
 
 	if !whenResult {
 		logger.Debug("skipping task due to when condition", zap.String("task", task.Name))
-		return &CommonResult{}, nil
+		result := &CommonResult{}
+		result.TaskSkipped()
+		return result, nil
 	}
 
 	logger.Debug("executing task", zap.Any("task", task))
 	if err := task.Validate(); err != nil {
 		return &CommonResult{}, fmt.Errorf("validation failed: %w", err)
 	}
-	return task.Apply(ctx, parentPath, isRole)
+
+	ctx, err = taskEnvironmentContext(ctx, task.Environment)
+	if err != nil {
+		return &CommonResult{}, fmt.Errorf("invalid environment: %w", err)
+	}
+	return applyUntil(ctx, logger, task, parentPath, isRole)
 }
 
-// ExecuteTask executes a single task, processing any loop items and rendering
-// Jinja templates.
-func ExecuteTask(ctx context.Context, logger *zap.Logger, task Task, parentPath string, isRole bool) error {
-	if task.Loop == nil {
-		result, err := processAndRunTask(ctx, logger, task, parentPath, isRole)
-		if err != nil {
-			return fmt.Errorf("failed to execute task: %w", err)
-		}
+// TaskError is the error ExecuteTask returns when a task fails. It carries
+// the task and its result, for `rescue` sections to know about them.
+type TaskError struct {
+	Task   Task
+	Result Result
+	Err    error
+}
 
-		if task.Register != "" {
-			vars, ok := variables.FromContext(ctx)
-			if !ok {
-				vars = variables.Variables{}
-			}
-			resultMap, err := resultToMap(result)
-			if err != nil {
-				return fmt.Errorf("failed to convert result to map: %w", err)
-			}
-			vars[task.Register] = resultMap
-		}
-		return nil
+func (e *TaskError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// newLoggerContext returns a new context carrying logger, for tasks running
+// other tasks, like blocks, to log with it.
+func newLoggerContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// loggerFromContext returns the logger carried by ctx, or a no-op one.
+func loggerFromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*zap.Logger); ok {
+		return logger
 	}
+	return zap.NewNop()
+}
+
+// taskVarsContext returns a context with the task's variables on top of the
+// ones in ctx, and a function to call once the task is done.
+func taskVarsContext(ctx context.Context, task Task) (context.Context, func()) {
+	return scopedVarsContext(ctx, task.Vars)
+}
 
-	tempLoopHolder := struct{ Loop any }{Loop: task.Loop}
-	if err := util.ProcessJinjaTemplates(ctx, &tempLoopHolder); err != nil {
-		return fmt.Errorf("failed to process Jinja templating for loop: %w", err)
+// scopedVarsContext returns a context with scoped on top of the variables in
+// ctx, and a function to call once done with it. scoped are only set
+// meanwhile, but other variables set then, like the ones registered by the
+// tasks of a block, are kept.
+func scopedVarsContext(ctx context.Context, scoped variables.Variables) (context.Context, func()) {
+	if len(scoped) == 0 {
+		return ctx, func() {}
 	}
-	task.Loop = tempLoopHolder.Loop
 
-	loopValues, ok := task.Loop.([]any)
+	vars, ok := variables.FromContext(ctx)
 	if !ok {
-		// It might be a slice of strings if the Jinja processing resulted in
-		// that.
-		loopStrValues, okStr := task.Loop.([]string)
-		if !okStr {
-			return fmt.Errorf("loop variable is not a list: %T", task.Loop)
-		}
-		loopValues = make([]any, len(loopStrValues))
-		for i, v := range loopStrValues {
-			loopValues[i] = v
+		vars = variables.Variables{}
+	}
+	scopedVars := variables.Variables{}
+	scopedVars.Merge(vars)
+	scopedVars.Merge(scoped)
+
+	return variables.NewContext(ctx, scopedVars), func() {
+		for k, v := range scopedVars {
+			if _, ok := scoped[k]; !ok {
+				vars[k] = v
+			}
 		}
 	}
+}
 
-	loopResults := LoopResult{
-		Results: []Result{},
+// ExecuteTask executes a single task, processing any loop items and rendering
+// Jinja templates.
+func ExecuteTask(ctx context.Context, logger *zap.Logger, task Task, parentPath string, isRole bool) error {
+	// Tasks are selected by their tags before anything else, so that the
+	// ones not selected aren't even rendered. Like Ansible, they're not
+	// reported either.
+	if !taskSelected(ctx, task) {
+		logger.Debug("skipping task due to tags", zap.String("task", task.Name))
+		return nil
 	}
+	ctx = NewTagsContext(ctx, task.Tags)
 
-	for _, item := range loopValues {
-		newContent, err := deepCopyContent(task.Content)
+	ctx, err := taskBecomeContext(ctx, task)
+	if err != nil {
+		return fmt.Errorf("invalid become settings: %w", err)
+	}
+	ctx = taskCheckModeContext(ctx, task)
+	ctx = newLoggerContext(ctx, logger)
+	ctx = lookup.NewContext(ctx, parentPath)
+	ctx, done := taskVarsContext(ctx, task)
+	defer done()
+
+	switch content := task.Content.(type) {
+	case *Block:
+		// Blocks aren't reported: the tasks in them are.
+		ctx, err := EnvironmentContext(ctx, task.Environment)
 		if err != nil {
-			return fmt.Errorf("failed to copy task content: %w", err)
+			return fmt.Errorf("invalid environment: %w", err)
 		}
+		return content.run(ctx, logger, task.When, parentPath, isRole)
+	case *Meta:
+		// Meta tasks act on the run itself: they aren't reported.
+		_, err := runTask(ctx, logger, task, parentPath, isRole)
+		return err
+	}
 
-		iterTask := Task{
-			Name:    task.Name,
-			Content: newContent,
-		}
+	notifyTaskStart(ctx, logger, task)
+	result, err := runTask(ctx, logger, task, parentPath, isRole)
+	if err != nil {
+		result.TaskFailed()
+	}
+	notifyTaskResult(ctx, logger, task, result, err)
 
-		currentVars, ok := variables.FromContext(ctx)
-		if !ok {
-			currentVars = variables.Variables{}
-		}
-		currentVars["item"] = item
-		loopCtx := variables.NewContext(ctx, currentVars)
+	// Failed tasks register their result too, for `rescue` sections or
+	// the tasks after the ones ignoring errors to look at it.
+	if regErr := registerResult(ctx, task, result, err); regErr != nil {
+		return regErr
+	}
 
-		result, err := processAndRunTask(loopCtx, logger, iterTask, parentPath, isRole)
-		if err != nil {
-			return fmt.Errorf("failed to execute task: %w", err)
+	if err != nil {
+		if !task.IgnoreErrors {
+			return &TaskError{Task: task, Result: result, Err: err}
 		}
+		logger.Debug("ignoring task failure", zap.String("task", task.Name), zap.Error(err))
+	}
 
-		if result.IsChanged() {
-			loopResults.TaskChanged()
-		}
-		if result.IsSkipped() {
-			loopResults.TaskSkipped()
-		}
-		if result.IsFailed() {
-			loopResults.TaskFailed()
-		}
-		loopResults.Results = append(loopResults.Results, result)
+	return notifyHandlers(ctx, task, result)
+}
+
+// registerResult sets the variable the task registers its result in, if any.
+// err is the error the task failed with, if any.
+func registerResult(ctx context.Context, task Task, result Result, err error) error {
+	if task.Register == "" {
+		return nil
 	}
 
-	if task.Register != "" {
-		vars, ok := variables.FromContext(ctx)
-		if !ok {
-			vars = variables.Variables{}
-		}
-		resultMap, err := resultToMap(&loopResults)
+	vars, ok := variables.FromContext(ctx)
+	if !ok {
+		vars = variables.Variables{}
+	}
+	resultMap, mapErr := resultToMap(result)
+	if mapErr != nil {
+		return fmt.Errorf("failed to convert result to map: %w", mapErr)
+	}
+	if msg, _ := resultMap["msg"].(string); err != nil && msg == "" {
+		resultMap["msg"] = err.Error()
+	}
+	vars[task.Register] = resultMap
+	return nil
+}
+
+// runTask runs a task, once or for every item of its loop.
+func runTask(ctx context.Context, logger *zap.Logger, task Task, parentPath string, isRole bool) (Result, error) {
+	if task.Loop == nil {
+		result, err := processAndRunTask(ctx, logger, task, parentPath, isRole)
 		if err != nil {
-			return fmt.Errorf("failed to convert loop result to map: %w", err)
+			return result, fmt.Errorf("failed to execute task: %w", err)
 		}
-		vars[task.Register] = resultMap
+		return result, nil
 	}
 
-	return nil
+	return runLoop(ctx, logger, task, parentPath, isRole)
 }
 
 type CommonResult struct {
 	Changed bool `yaml:"changed" json:"changed"`
-	// TODO: add diff.
+	// Diff holds the changes the task made, in diff mode.
+	Diff        []Diff   `yaml:"diff,omitempty" json:"diff,omitempty"`
 	Failed      bool     `yaml:"failed" json:"failed"`
 	Msg         string   `yaml:"msg" json:"msg"`
 	RC          int      `yaml:"rc"`
@@ -242,6 +429,9 @@ type CommonResult struct {
 	StderrLines []string `yaml:"stderr_lines" json:"stderr_lines"`
 	Stdout      string   `yaml:"stdout" json:"stdout"`
 	StdoutLines []string `yaml:"stdout_lines" json:"stdout_lines"`
+	// Attempts is how many times the task ran, when it has an `until`
+	// condition.
+	Attempts int `yaml:"attempts,omitempty" json:"attempts,omitempty"`
 }
 
 func (c *CommonResult) TaskChanged() {
@@ -256,6 +446,18 @@ func (c *CommonResult) TaskFailed() {
 	c.Failed = true
 }
 
+func (c *CommonResult) SetChanged(changed bool) {
+	c.Changed = changed
+}
+
+func (c *CommonResult) SetFailed(failed bool) {
+	c.Failed = failed
+}
+
+func (c *CommonResult) SetAttempts(attempts int) {
+	c.Attempts = attempts
+}
+
 func (c *CommonResult) IsChanged() bool {
 	return c.Changed
 }
@@ -272,16 +474,16 @@ type Result interface {
 	TaskChanged()
 	TaskSkipped()
 	TaskFailed()
+	// SetChanged and SetFailed override what the module reported, for
+	// `changed_when` and `failed_when`.
+	SetChanged(bool)
+	SetFailed(bool)
+	SetAttempts(int)
 	IsChanged() bool
 	IsSkipped() bool
 	IsFailed() bool
 }
 
-type LoopResult struct {
-	CommonResult `yaml:",inline"`
-	Results      []Result `yaml:"results" json:"results"`
-}
-
 // resultToMap converts a Result interface to a map[string]any by
 // marshalling it to YAML and then unmarshalling it. This is to make sure that
 // the registered variables have snake_case keys.
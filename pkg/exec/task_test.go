@@ -135,7 +135,7 @@ func TestTaskApplyLoop(t *testing.T) {
 
 	task := Task{
 		Name: "install foo and {{ bar }}",
-		When: "hello",
+		When: []string{"bar == 'bar'"},
 		Loop: []string{
 			"foo",
 			"{{ bar }}",
@@ -182,10 +182,12 @@ func TestTaskApplyLoop(t *testing.T) {
 		"msg":     string(""),
 		"results": []any{
 			map[string]any{
+				"ansible_loop_var":  "item",
 				"cache_update_time": "1970-01-01T00:00:00Z",
 				"cache_updated":     false,
 				"changed":           true,
 				"failed":            false,
+				"item":              "foo",
 				"msg":               "",
 				"rc":                uint64(0),
 				"skipped":           false,
@@ -195,10 +197,12 @@ func TestTaskApplyLoop(t *testing.T) {
 				"stdout_lines":      []any{},
 			},
 			map[string]any{
+				"ansible_loop_var":  "item",
 				"cache_update_time": "1970-01-01T00:00:00Z",
 				"cache_updated":     false,
 				"changed":           true,
 				"failed":            false,
+				"item":              "bar",
 				"msg":               "",
 				"rc":                uint64(0),
 				"skipped":           false,
@@ -234,7 +238,7 @@ func TestFromProto(t *testing.T) {
 			name: "basic task with all fields",
 			pt: &proto.Task{
 				Name:     "test task",
-				When:     "true",
+				When:     []string{"true"},
 				Register: "result",
 				Content: &proto.Task_Command{
 					Command: &proto.Command{
@@ -244,7 +248,8 @@ func TestFromProto(t *testing.T) {
 			},
 			want: &Task{
 				Name:     "test task",
-				When:     "true",
+				Module:   "command",
+				When:     []string{"true"},
 				Register: "result",
 				Content: &Command{
 					Command: &proto.Command{
@@ -274,8 +279,9 @@ func TestFromProto(t *testing.T) {
 				},
 			},
 			want: &Task{
-				Name: "test with loop",
-				Loop: []any{"item1", "item2"},
+				Name:   "test with loop",
+				Module: "command",
+				Loop:   []any{"item1", "item2"},
 				Content: &Command{
 					Command: &proto.Command{
 						Cmd: "echo {{ item }}",
@@ -283,15 +289,39 @@ func TestFromProto(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "task with templated arguments",
+			pt: &proto.Task{
+				Name: "test with templated arguments",
+				TemplatedArgs: &structpb.Struct{Fields: map[string]*structpb.Value{
+					"strip_empty_ends": structpb.NewStringValue("{{ strip }}"),
+				}},
+				Content: &proto.Task_Command{
+					Command: &proto.Command{
+						Cmd: "echo hello",
+					},
+				},
+			},
+			want: &Task{
+				Name:          "test with templated arguments",
+				Module:        "command",
+				TemplatedArgs: map[string]string{"strip_empty_ends": "{{ strip }}"},
+				Content: &Command{
+					Command: &proto.Command{
+						Cmd: "echo hello",
+					},
+				},
+			},
+		},
 		{
 			name: "task with nil content",
 			pt: &proto.Task{
 				Name: "task without content",
-				When: "inventory_hostname == 'localhost'",
+				When: []string{"inventory_hostname == 'localhost'"},
 			},
 			want: &Task{
 				Name:    "task without content",
-				When:    "inventory_hostname == 'localhost'",
+				When:    []string{"inventory_hostname == 'localhost'"},
 				Content: nil,
 			},
 		},
@@ -307,8 +337,9 @@ func TestFromProto(t *testing.T) {
 				},
 			},
 			want: &Task{
-				Name: "task without loop",
-				Loop: nil,
+				Name:   "task without loop",
+				Module: "shell",
+				Loop:   nil,
 				Content: &Shell{
 					Shell: &proto.Shell{
 						Cmd: "ls -la",
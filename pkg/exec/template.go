@@ -12,12 +12,10 @@ import (
 	"path/filepath"
 
 	"github.com/nikolalohinski/gonja/v2"
-	gonjaexec "github.com/nikolalohinski/gonja/v2/exec"
 
 	"github.com/mickael-carl/sophons/pkg/exec/util"
 	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/registry"
-	"github.com/mickael-carl/sophons/pkg/variables"
 )
 
 //	@meta {
@@ -28,14 +26,15 @@ type Template struct {
 }
 
 type TemplateResult struct {
-	Checksum string
-	Dest     string
-	Gid      uint64
-	Group    string
-	MD5Sum   string
-	Mode     string
-	Owner    string
-	Size     uint64
+	BackupFile string `yaml:"backup_file,omitempty"`
+	Checksum   string
+	Dest       string
+	Gid        uint64
+	Group      string
+	MD5Sum     string
+	Mode       string
+	Owner      string
+	Size       uint64
 	// We can't support this: Ansible fills it in with the copied template file
 	// on the target node but our execution model is fundamentally not working
 	// like that.
@@ -76,7 +75,7 @@ func (c *Template) Validate() error {
 		return errors.New("dest is required")
 	}
 
-	return nil
+	return validateCommand(c.Template.Validate)
 }
 
 func (c *Template) Apply(ctx context.Context, parentPath string, isRole bool) (Result, error) {
@@ -89,11 +88,7 @@ func (c *Template) Apply(ctx context.Context, parentPath string, isRole bool) (R
 		return &result, fmt.Errorf("failed to read template file %s: %w", srcPath, err)
 	}
 
-	vars, ok := variables.FromContext(ctx)
-	if !ok {
-		vars = variables.Variables{}
-	}
-	varsCtx := gonjaexec.NewContext(vars)
+	varsCtx := util.JinjaContext(ctx)
 
 	var buf bytes.Buffer
 	if err := template.Execute(&buf, varsCtx); err != nil {
@@ -114,8 +109,9 @@ func (c *Template) Apply(ctx context.Context, parentPath string, isRole bool) (R
 		return &result, fmt.Errorf("failed to state %s: %w", srcPath, err)
 	}
 
+	var existingContent []byte
 	if err == nil && stat.Mode().IsRegular() {
-		existingContent, err := os.ReadFile(c.Dest)
+		existingContent, err = os.ReadFile(c.Dest)
 		if err != nil {
 			result.TaskFailed()
 			return &result, fmt.Errorf("failed to read from %s: %w", srcPath, err)
@@ -129,7 +125,47 @@ func (c *Template) Apply(ctx context.Context, parentPath string, isRole bool) (R
 		}
 	}
 
-	if err := os.WriteFile(c.Dest, renderedContent, 0o644); err != nil {
+	addDiff(ctx, &result.CommonResult, Diff{
+		Before:       string(existingContent),
+		After:        string(renderedContent),
+		BeforeHeader: c.Dest,
+		AfterHeader:  srcPath,
+	})
+
+	if CheckModeFromContext(ctx) {
+		result.TaskChanged()
+		result.MD5Sum = renderedMD5
+		result.Dest = c.Dest
+		return &result, nil
+	}
+
+	validate, err := fileValidator(ctx, c.Template.Validate)
+	if err != nil {
+		result.TaskFailed()
+		return &result, err
+	}
+
+	uid, err := util.GetUid(c.Owner)
+	if err != nil {
+		result.TaskFailed()
+		return &result, err
+	}
+
+	gid, err := util.GetGid(c.Group)
+	if err != nil {
+		result.TaskFailed()
+		return &result, err
+	}
+
+	opts := writeOptions{
+		Backup:       c.Backup,
+		UnsafeWrites: c.UnsafeWrites,
+		Validate:     validate,
+		Mode:         c.Mode.GetValue(),
+		UID:          uid,
+		GID:          gid,
+	}
+	if result.BackupFile, err = writeFile(c.Dest, bytes.NewReader(renderedContent), opts); err != nil {
 		result.TaskFailed()
 		return &result, fmt.Errorf("failed to write destination %s: %w", c.Dest, err)
 	}
@@ -138,40 +174,17 @@ func (c *Template) Apply(ctx context.Context, parentPath string, isRole bool) (R
 	// MD5Sum is only populated when changed.
 	result.MD5Sum = renderedMD5
 
-	if c.Mode != nil && c.Mode.Value != "" || c.Owner != "" || c.Group != "" {
-		uid, err := util.GetUid(c.Owner)
-		if err != nil {
-			result.TaskFailed()
-			return &result, err
-		}
-
-		gid, err := util.GetGid(c.Group)
-		if err != nil {
-			result.TaskFailed()
-			return &result, err
-		}
-
-		var modeValue any
-		if c.Mode != nil {
-			modeValue = c.Mode.Value
+	// Populate result fields with explicitly set values.
+	if c.Owner != "" {
+		result.Owner = c.Owner
+		if uid != -1 {
+			result.Uid = uint64(uid)
 		}
-		if err := util.ApplyModeAndIDs(c.Dest, modeValue, uid, gid); err != nil {
-			result.TaskFailed()
-			return &result, fmt.Errorf("failed to apply mode and IDs to %s: %w", c.Dest, err)
-		}
-
-		// Populate result fields with explicitly set values.
-		if c.Owner != "" {
-			result.Owner = c.Owner
-			if uid != -1 {
-				result.Uid = uint64(uid)
-			}
-		}
-		if c.Group != "" {
-			result.Group = c.Group
-			if gid != -1 {
-				result.Gid = uint64(gid)
-			}
+	}
+	if c.Group != "" {
+		result.Group = c.Group
+		if gid != -1 {
+			result.Gid = uint64(gid)
 		}
 	}
 
@@ -5,15 +5,19 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/mock/gomock"
 
 	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/variables"
@@ -57,6 +61,18 @@ func TestTemplateValidate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "dest is required",
 		},
+		{
+			name: "validate without %s",
+			template: &Template{
+				Template: &proto.Template{
+					Src:      "foo",
+					Dest:     "bar",
+					Validate: "nginx -t -c",
+				},
+			},
+			wantErr: true,
+			errMsg:  "validate must contain %s: nginx -t -c",
+		},
 		{
 			name: "valid",
 			template: &Template{
@@ -195,6 +211,71 @@ func TestTemplateApply(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "backup on different content",
+			setup: func(t *testing.T, tempDir string) (*Template, context.Context) {
+				createTemplateFile(t, tempDir, "test.j2", "New Content")
+				destFile := filepath.Join(tempDir, "dest.txt")
+				createDestFile(t, destFile, "Old Content")
+
+				return &Template{
+					Template: &proto.Template{
+						Src:    "test.j2",
+						Dest:   destFile,
+						Backup: true,
+					},
+				}, context.Background()
+			},
+			verify: func(t *testing.T, result *TemplateResult, tempDir string) {
+				destFile := filepath.Join(tempDir, "dest.txt")
+				verifyFileContent(t, destFile, "New Content")
+
+				if !strings.HasPrefix(result.BackupFile, destFile+".") {
+					t.Errorf("unexpected backup file %q", result.BackupFile)
+				}
+				verifyFileContent(t, result.BackupFile, "Old Content")
+			},
+			wantErr: false,
+		},
+		{
+			name: "error - validation failure keeps destination",
+			setup: func(t *testing.T, tempDir string) (*Template, context.Context) {
+				createTemplateFile(t, tempDir, "test.j2", "New Content")
+				destFile := filepath.Join(tempDir, "dest.txt")
+				createDestFile(t, destFile, "Old Content")
+
+				ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+					var stderr io.Writer
+					m.EXPECT().SetStdout(gomock.Any())
+					m.EXPECT().SetStderr(gomock.Any()).Do(func(w io.Writer) { stderr = w })
+					m.EXPECT().Run().DoAndReturn(func() error {
+						stderr.Write([]byte("unknown directive"))
+						return &testExitError{1, errors.New("exit status 1")}
+					})
+				})
+
+				return &Template{
+					Template: &proto.Template{
+						Src:      "test.j2",
+						Dest:     destFile,
+						Validate: "nginx -t -c %s",
+					},
+				}, ctx
+			},
+			verify: func(t *testing.T, result *TemplateResult, tempDir string) {
+				verifyFileContent(t, filepath.Join(tempDir, "dest.txt"), "Old Content")
+
+				if !result.Failed {
+					t.Error("expected the task to fail")
+				}
+			},
+			wantErr: true,
+			errCheck: func(t *testing.T, err error) {
+				if err == nil || !strings.Contains(err.Error(), "failed to validate: exit status 1: unknown directive") {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
 		{
 			name: "change when destination missing",
 			setup: func(t *testing.T, tempDir string) (*Template, context.Context) {
@@ -266,6 +347,27 @@ func TestTemplateApply(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "template rendering with Ansible filters",
+			setup: func(t *testing.T, tempDir string) (*Template, context.Context) {
+				createTemplateFile(t, tempDir, "test.j2", "{{ ports | to_json }} {{ nope | default('x') }} {{ ports[0] is version('80', '==') }}")
+				destFile := filepath.Join(tempDir, "dest.txt")
+
+				vars := variables.Variables{"ports": []any{80, 443}}
+				ctx := variables.NewContext(context.Background(), vars)
+
+				return &Template{
+					Template: &proto.Template{
+						Src:  "test.j2",
+						Dest: destFile,
+					},
+				}, ctx
+			},
+			verify: func(t *testing.T, result *TemplateResult, tempDir string) {
+				verifyFileContent(t, filepath.Join(tempDir, "dest.txt"), "[80, 443] x True")
+			},
+			wantErr: false,
+		},
 		{
 			name: "error - missing template file",
 			setup: func(t *testing.T, tempDir string) (*Template, context.Context) {
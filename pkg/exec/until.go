@@ -0,0 +1,76 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/exec/util"
+)
+
+const (
+	// defaultRetries and defaultDelay are Ansible's defaults for `retries`
+	// and `delay`.
+	defaultRetries = 3
+	defaultDelay   = 5 * time.Second
+)
+
+// applyUntil applies task, along with its `changed_when` and `failed_when`
+// conditions. If it has an `until` condition, it's applied again until the
+// condition holds, which it sees the result in like the other conditions.
+// Failures don't stop retries.
+func applyUntil(ctx context.Context, logger *zap.Logger, task Task, parentPath string, isRole bool) (Result, error) {
+	if len(task.Until) == 0 {
+		result, err := task.Apply(ctx, parentPath, isRole)
+		return applyConditions(ctx, task, result, err)
+	}
+
+	// Like Ansible, the task runs once, then up to `retries` more times. When
+	// `retries` isn't set, it runs defaultRetries times in all.
+	attempts := defaultRetries
+	if task.Retries != nil {
+		attempts = max(1, int(*task.Retries)+1)
+	}
+	delay := defaultDelay
+	if task.Delay != nil {
+		delay = time.Duration(*task.Delay) * time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := task.Apply(ctx, parentPath, isRole)
+		result, err = applyConditions(ctx, task, result, err)
+		result.SetAttempts(attempt)
+
+		done, condErr := util.JinjaProcessWhen(resultContext(ctx, task, result, err), task.Until...)
+		if condErr != nil {
+			result.TaskFailed()
+			return result, fmt.Errorf("failed to process until condition: %w", condErr)
+		}
+		if done {
+			return result, err
+		}
+
+		if attempt >= attempts {
+			result.TaskFailed()
+			if err != nil {
+				return result, fmt.Errorf("until condition not met after %d attempts: %w", attempt, err)
+			}
+			return result, fmt.Errorf("until condition not met after %d attempts", attempt)
+		}
+
+		logger.Info("retrying task",
+			zap.String("task", task.Name),
+			zap.Int("retries_left", attempts-attempt),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			result.TaskFailed()
+			return result, fmt.Errorf("interrupted while retrying: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+
+	"github.com/mickael-carl/sophons/pkg/proto"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func TestExecuteTaskUntil(t *testing.T) {
+	boom := errors.New("boom")
+	var zero, two, sixty int32 = 0, 2, 60
+
+	tests := []struct {
+		name         string
+		until        []string
+		retries      *int32
+		delay        *int32
+		runErrs      []error
+		cancel       bool
+		wantErr      bool
+		wantAttempts int
+	}{
+		{
+			name:         "met at once",
+			until:        []string{"not result.failed"},
+			delay:        &zero,
+			runErrs:      []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "met after failures",
+			until:        []string{"not result.failed"},
+			delay:        &zero,
+			runErrs:      []error{boom, boom, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "never met",
+			until:        []string{"not result.failed"},
+			retries:      &two,
+			delay:        &zero,
+			runErrs:      []error{boom, boom, boom},
+			wantErr:      true,
+			wantAttempts: 3,
+		},
+		{
+			name:         "default retries",
+			until:        []string{"not result.failed"},
+			delay:        &zero,
+			runErrs:      []error{boom, boom, boom},
+			wantErr:      true,
+			wantAttempts: 3,
+		},
+		{
+			name:         "no retries",
+			until:        []string{"result.rc == 42"},
+			retries:      &zero,
+			runErrs:      []error{nil},
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:         "cancelled",
+			until:        []string{"not result.failed"},
+			delay:        &sixty,
+			runErrs:      []error{boom},
+			cancel:       true,
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newMockCommandContext(t, func(m *MockcommandExecutor) {
+				m.EXPECT().SetStdout(gomock.Any()).AnyTimes()
+				m.EXPECT().SetStderr(gomock.Any()).AnyTimes()
+				var calls []any
+				for _, err := range tt.runErrs {
+					calls = append(calls, m.EXPECT().Run().Return(err))
+				}
+				gomock.InOrder(calls...)
+			})
+			vars := variables.Variables{}
+			ctx = variables.NewContext(ctx, vars)
+			if tt.cancel {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				cancel()
+			}
+
+			task, err := FromProto(&proto.Task{
+				Register: "result",
+				Until:    tt.until,
+				Retries:  tt.retries,
+				Delay:    tt.delay,
+				Content:  &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ExecuteTask(ctx, zap.NewNop(), *task, "", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			registered, ok := vars["result"].(map[string]any)
+			if !ok {
+				t.Fatal("expected the result to be registered")
+			}
+			if attempts, _ := registered["attempts"].(uint64); int(attempts) != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %v", tt.wantAttempts, registered["attempts"])
+			}
+		})
+	}
+}
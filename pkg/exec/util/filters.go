@@ -0,0 +1,974 @@
+package util
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"maps"
+	"math"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/nikolalohinski/gonja/v2"
+	gonjaexec "github.com/nikolalohinski/gonja/v2/exec"
+)
+
+// The Ansible filters and tests are registered on gonja's default
+// environment, which all of our templates are rendered with.
+func init() {
+	gonja.DefaultEnvironment.Filters.Update(gonjaexec.NewFilterSet(ansibleFilters))
+	gonja.DefaultEnvironment.Tests.Update(gonjaexec.NewTestSet(ansibleTests))
+}
+
+var ansibleFilters = map[string]gonjaexec.FilterFunction{
+	"mandatory":       filterMandatory,
+	"to_json":         filterToJSON,
+	"to_nice_json":    filterToNiceJSON,
+	"to_yaml":         filterToYAML,
+	"to_nice_yaml":    filterToNiceYAML,
+	"from_json":       filterFromJSON,
+	"from_yaml":       filterFromYAML,
+	"regex_replace":   filterRegexReplace,
+	"regex_search":    filterRegexSearch,
+	"b64encode":       filterB64Encode,
+	"b64decode":       filterB64Decode,
+	"combine":         filterCombine,
+	"dict2items":      filterDict2Items,
+	"items2dict":      filterItems2Dict,
+	"selectattr":      filterSelectAttr,
+	"rejectattr":      filterRejectAttr,
+	"map":             filterMap,
+	"ternary":         filterTernary,
+	"hash":            filterHash,
+	"password_hash":   filterPasswordHash,
+	"basename":        filterBasename,
+	"dirname":         filterDirname,
+	"ipaddr":          filterIPAddr,
+	"ipv4":            filterIPv4,
+	"ipv6":            filterIPv6,
+	"quote":           filterQuote,
+	"bool":            filterBool,
+	"version":         filterVersion,
+	"version_compare": filterVersion,
+}
+
+var ansibleTests = map[string]gonjaexec.TestFunction{
+	"succeeded": testSucceeded,
+	"success":   testSucceeded,
+	"failed":    testFailed,
+	"failure":   testFailed,
+	"changed":   testChanged,
+	"change":    testChanged,
+	"skipped":   testSkipped,
+	"skip":      testSkipped,
+	"version":   testVersion,
+	"match":     testMatch,
+	"search":    testSearch,
+}
+
+// filterError returns the value filters return when they fail.
+func filterError(name string, err error) *gonjaexec.Value {
+	return gonjaexec.AsValue(fmt.Errorf("%s: %w", name, err))
+}
+
+// goValue returns the Go value held by v, e.g. a map[string]any for a dict.
+func goValue(v *gonjaexec.Value) (any, error) {
+	native := v.ToGoSimpleType(false)
+	if err, ok := native.(error); ok {
+		return nil, err
+	}
+	return native, nil
+}
+
+// normalizeNumbers converts the numbers decoded from JSON or YAML to int or
+// float64, like the rest of the variables.
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case uint64:
+		if v <= math.MaxInt {
+			return int(v)
+		}
+		return v
+	case int64:
+		return int(v)
+	case []any:
+		for i := range v {
+			v[i] = normalizeNumbers(v[i])
+		}
+		return v
+	case map[string]any:
+		for k := range v {
+			v[k] = normalizeNumbers(v[k])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func filterMandatory(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() || in.IsNil() {
+		msg := "Mandatory variable not defined."
+		if len(params.Args) > 0 {
+			msg = params.First().String()
+		}
+		return gonjaexec.AsValue(errors.New(msg))
+	}
+	return in
+}
+
+// pythonJSON encodes v as Python's json.dumps does by default, with spaces
+// after separators. Keys are sorted.
+func pythonJSON(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		buf.WriteByte('{')
+		for i, k := range slices.Sorted(maps.Keys(v)) {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := pythonJSON(buf, k); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := pythonJSON(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := pythonJSON(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+	return nil
+}
+
+func filterToJSON(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	v, err := goValue(in)
+	if err != nil {
+		return filterError("to_json", err)
+	}
+	var buf bytes.Buffer
+	if err := pythonJSON(&buf, v); err != nil {
+		return filterError("to_json", err)
+	}
+	return gonjaexec.AsValue(buf.String())
+}
+
+func filterToNiceJSON(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	indent := params.GetKeywordArgument("indent", 4).Integer()
+	v, err := goValue(in)
+	if err != nil {
+		return filterError("to_nice_json", err)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", strings.Repeat(" ", indent))
+	if err := enc.Encode(v); err != nil {
+		return filterError("to_nice_json", err)
+	}
+	return gonjaexec.AsValue(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func toYAML(name string, in *gonjaexec.Value, indent int) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	v, err := goValue(in)
+	if err != nil {
+		return filterError(name, err)
+	}
+	out, err := yaml.MarshalWithOptions(v, yaml.Indent(indent))
+	if err != nil {
+		return filterError(name, err)
+	}
+	return gonjaexec.AsValue(string(out))
+}
+
+func filterToYAML(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	return toYAML("to_yaml", in, params.GetKeywordArgument("indent", 2).Integer())
+}
+
+func filterToNiceYAML(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	return toYAML("to_nice_yaml", in, params.GetKeywordArgument("indent", 4).Integer())
+}
+
+func filterFromJSON(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	dec := json.NewDecoder(strings.NewReader(in.String()))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return filterError("from_json", err)
+	}
+	return gonjaexec.AsValue(normalizeNumbers(v))
+}
+
+func filterFromYAML(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	var v any
+	if err := yaml.Unmarshal([]byte(in.String()), &v); err != nil {
+		return filterError("from_yaml", err)
+	}
+	return gonjaexec.AsValue(normalizeNumbers(v))
+}
+
+// pythonRegexp compiles a Python regular expression, with the flags Ansible
+// filters and tests take, either as keyword arguments or, if first isn't -1,
+// as positional ones from the first one on.
+func pythonRegexp(pattern string, params *gonjaexec.VarArgs, first int) (*regexp.Regexp, error) {
+	flag := func(i int, name string) bool {
+		if first >= 0 && len(params.Args) > i {
+			return params.Args[i].IsTrue()
+		}
+		return params.GetKeywordArgument(name, false).IsTrue()
+	}
+
+	var flags string
+	if flag(first, "ignorecase") {
+		flags += "i"
+	}
+	if flag(first+1, "multiline") {
+		flags += "m"
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// testParams unpacks the arguments of a test: gonja parses the ones of, e.g.,
+// `is version('1.2', '>=')` as a single tuple.
+func testParams(params *gonjaexec.VarArgs) *gonjaexec.VarArgs {
+	if len(params.Args) != 1 || !params.Args[0].IsList() {
+		return params
+	}
+	unpacked := &gonjaexec.VarArgs{KwArgs: params.KwArgs}
+	params.Args[0].Iterate(func(_, _ int, arg, _ *gonjaexec.Value) bool {
+		unpacked.Args = append(unpacked.Args, arg)
+		return true
+	}, func() {})
+	return unpacked
+}
+
+var pythonBackref = regexp.MustCompile(`\\(\d+)|\\g<(\w+)>`)
+
+// goReplacement converts a Python replacement string, with backreferences
+// like `\1` or `\g<name>`, to a Go one.
+func goReplacement(repl string) string {
+	repl = strings.ReplaceAll(repl, "$", "$$")
+	return pythonBackref.ReplaceAllStringFunc(repl, func(ref string) string {
+		m := pythonBackref.FindStringSubmatch(ref)
+		if m[1] != "" {
+			return "${" + m[1] + "}"
+		}
+		return "${" + m[2] + "}"
+	})
+}
+
+func filterRegexReplace(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	if len(params.Args) < 1 {
+		return filterError("regex_replace", errors.New("expected a pattern"))
+	}
+	re, err := pythonRegexp(params.Args[0].String(), params, -1)
+	if err != nil {
+		return filterError("regex_replace", err)
+	}
+	repl := ""
+	if len(params.Args) > 1 {
+		repl = params.Args[1].String()
+	}
+	return gonjaexec.AsValue(re.ReplaceAllString(in.String(), goReplacement(repl)))
+}
+
+func filterRegexSearch(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	if len(params.Args) < 1 {
+		return filterError("regex_search", errors.New("expected a pattern"))
+	}
+	re, err := pythonRegexp(params.Args[0].String(), params, -1)
+	if err != nil {
+		return filterError("regex_search", err)
+	}
+
+	m := re.FindStringSubmatchIndex(in.String())
+	if m == nil {
+		return gonjaexec.AsValue(nil)
+	}
+	if len(params.Args) == 1 {
+		return gonjaexec.AsValue(in.String()[m[0]:m[1]])
+	}
+
+	// Groups to return, e.g. `\1` or `\g<name>`.
+	var groups []any
+	for _, arg := range params.Args[1:] {
+		expanded := re.ExpandString(nil, goReplacement(arg.String()), in.String(), m)
+		groups = append(groups, string(expanded))
+	}
+	return gonjaexec.AsValue(groups)
+}
+
+func filterB64Encode(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	return gonjaexec.AsValue(base64.StdEncoding.EncodeToString([]byte(in.String())))
+}
+
+func filterB64Decode(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	out, err := base64.StdEncoding.DecodeString(in.String())
+	if err != nil {
+		return filterError("b64decode", err)
+	}
+	return gonjaexec.AsValue(string(out))
+}
+
+// combineDicts merges b into a copy of a. Nested dicts are merged too if
+// recursive is set, and lists are merged according to listMerge.
+func combineDicts(a, b map[string]any, recursive bool, listMerge string) (map[string]any, error) {
+	out := maps.Clone(a)
+	for k, bv := range b {
+		av, ok := out[k]
+		if !ok {
+			out[k] = bv
+			continue
+		}
+
+		aDict, aIsDict := av.(map[string]any)
+		bDict, bIsDict := bv.(map[string]any)
+		if recursive && aIsDict && bIsDict {
+			merged, err := combineDicts(aDict, bDict, recursive, listMerge)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = merged
+			continue
+		}
+
+		aList, aIsList := av.([]any)
+		bList, bIsList := bv.([]any)
+		if !aIsList || !bIsList {
+			out[k] = bv
+			continue
+		}
+
+		switch listMerge {
+		case "replace":
+			out[k] = bList
+		case "keep":
+		case "append":
+			out[k] = slices.Concat(aList, bList)
+		case "prepend":
+			out[k] = slices.Concat(bList, aList)
+		case "append_rp":
+			out[k] = slices.Concat(withoutElems(aList, bList), bList)
+		case "prepend_rp":
+			out[k] = slices.Concat(bList, withoutElems(aList, bList))
+		default:
+			return nil, fmt.Errorf("unsupported list_merge %q", listMerge)
+		}
+	}
+	return out, nil
+}
+
+// withoutElems returns the elements of a that aren't in b.
+func withoutElems(a, b []any) []any {
+	var out []any
+	for _, elem := range a {
+		if !slices.ContainsFunc(b, func(other any) bool {
+			return gonjaexec.AsValue(elem).EqualValueTo(gonjaexec.AsValue(other))
+		}) {
+			out = append(out, elem)
+		}
+	}
+	return out
+}
+
+func filterCombine(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	recursive := params.GetKeywordArgument("recursive", false).IsTrue()
+	listMerge := params.GetKeywordArgument("list_merge", "replace").String()
+
+	// Like Ansible, combine can take a list of dicts as input.
+	dicts := []*gonjaexec.Value{in}
+	if in.IsList() {
+		dicts = nil
+		in.Iterate(func(_, _ int, elem, _ *gonjaexec.Value) bool {
+			dicts = append(dicts, elem)
+			return true
+		}, func() {})
+	}
+	dicts = append(dicts, params.Args...)
+
+	out := map[string]any{}
+	for _, d := range dicts {
+		v, err := goValue(d)
+		if err != nil {
+			return filterError("combine", err)
+		}
+		dict, ok := v.(map[string]any)
+		if !ok {
+			return filterError("combine", fmt.Errorf("expected dicts, got %T", v))
+		}
+		if out, err = combineDicts(out, dict, recursive, listMerge); err != nil {
+			return filterError("combine", err)
+		}
+	}
+	return gonjaexec.AsValue(out)
+}
+
+func filterDict2Items(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	keyName := params.GetKeywordArgument("key_name", "key").String()
+	valueName := params.GetKeywordArgument("value_name", "value").String()
+
+	v, err := goValue(in)
+	if err != nil {
+		return filterError("dict2items", err)
+	}
+	dict, ok := v.(map[string]any)
+	if !ok {
+		return filterError("dict2items", fmt.Errorf("expected a dict, got %T", v))
+	}
+
+	items := []any{}
+	for _, k := range slices.Sorted(maps.Keys(dict)) {
+		items = append(items, map[string]any{keyName: k, valueName: dict[k]})
+	}
+	return gonjaexec.AsValue(items)
+}
+
+func filterItems2Dict(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	keyName := params.GetKeywordArgument("key_name", "key").String()
+	valueName := params.GetKeywordArgument("value_name", "value").String()
+
+	v, err := goValue(in)
+	if err != nil {
+		return filterError("items2dict", err)
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return filterError("items2dict", fmt.Errorf("expected a list, got %T", v))
+	}
+
+	dict := map[string]any{}
+	for _, i := range items {
+		item, ok := i.(map[string]any)
+		if !ok {
+			return filterError("items2dict", fmt.Errorf("expected a list of dicts, got an element of type %T", i))
+		}
+		k, ok := item[keyName]
+		if !ok {
+			return filterError("items2dict", fmt.Errorf("missing %q in an element", keyName))
+		}
+		dict[gonjaexec.AsValue(k).String()] = item[valueName]
+	}
+	return gonjaexec.AsValue(dict)
+}
+
+// selectAttr keeps the elements of in whose attribute passes a test, or is
+// truthy if there's none. Elements without the attribute have it undefined,
+// e.g. for the `defined` test.
+func selectAttr(name string, e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs, keep bool) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	if len(params.Args) < 1 {
+		return filterError(name, errors.New("expected an attribute name"))
+	}
+	attribute := params.First().String()
+
+	out := []any{}
+	var errValue *gonjaexec.Value
+	in.Iterate(func(_, _ int, elem, _ *gonjaexec.Value) bool {
+		attr, found := elem.Get(attribute)
+		if !found {
+			attr = gonjaexec.AsValue(nil)
+		}
+
+		ok := attr.IsTrue()
+		if len(params.Args) > 1 {
+			result := e.ExecuteTestByName(params.Args[1].String(), attr, &gonjaexec.VarArgs{
+				Args:   params.Args[2:],
+				KwArgs: params.KwArgs,
+			})
+			if result.IsError() {
+				errValue = result
+				return false
+			}
+			ok = result.IsTrue()
+		}
+
+		if ok == keep {
+			out = append(out, elem.Interface())
+		}
+		return true
+	}, func() {})
+
+	if errValue != nil {
+		return errValue
+	}
+	return gonjaexec.AsValue(out)
+}
+
+func filterSelectAttr(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	return selectAttr("selectattr", e, in, params, true)
+}
+
+func filterRejectAttr(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	return selectAttr("rejectattr", e, in, params, false)
+}
+
+// filterMap applies a filter to every element of in, e.g. `map('upper')`, or
+// gets an attribute of each, e.g. `map(attribute='name')`.
+func filterMap(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	attribute, hasAttribute := params.KwArgs["attribute"]
+	defaultValue, hasDefault := params.KwArgs["default"]
+
+	out := []any{}
+	var errValue *gonjaexec.Value
+	in.Iterate(func(_, _ int, elem, _ *gonjaexec.Value) bool {
+		value := elem
+		if hasAttribute {
+			attr, found := elem.Get(attribute.String())
+			switch {
+			case found:
+				value = attr
+			case hasDefault:
+				value = defaultValue
+			default:
+				value = gonjaexec.AsValue(nil)
+			}
+		} else if len(params.Args) > 0 {
+			value = e.ExecuteFilterByName(params.Args[0].String(), elem, &gonjaexec.VarArgs{
+				Args:   params.Args[1:],
+				KwArgs: params.KwArgs,
+			})
+			if value.IsError() {
+				errValue = value
+				return false
+			}
+		}
+		out = append(out, value.Interface())
+		return true
+	}, func() {})
+
+	if errValue != nil {
+		return errValue
+	}
+	return gonjaexec.AsValue(out)
+}
+
+func filterTernary(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if len(params.Args) < 2 {
+		return filterError("ternary", errors.New("expected a true and a false value"))
+	}
+	if len(params.Args) > 2 && in.IsNil() {
+		return params.Args[2]
+	}
+	if !in.IsError() && in.IsTrue() {
+		return params.Args[0]
+	}
+	return params.Args[1]
+}
+
+var hashFuncs = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+func filterHash(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	algorithm := "sha1"
+	if len(params.Args) > 0 {
+		algorithm = params.First().String()
+	}
+	newHash, ok := hashFuncs[algorithm]
+	if !ok {
+		return filterError("hash", fmt.Errorf("unsupported hash type %q", algorithm))
+	}
+	h := newHash()
+	h.Write([]byte(in.String()))
+	return gonjaexec.AsValue(hex.EncodeToString(h.Sum(nil)))
+}
+
+func filterPasswordHash(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	scheme := "sha512"
+	if len(params.Args) > 0 {
+		scheme = params.Args[0].String()
+	}
+	scheme = strings.TrimSuffix(scheme, "_crypt")
+
+	salt := ""
+	if len(params.Args) > 1 {
+		salt = params.Args[1].String()
+	}
+	if s, ok := params.KwArgs["salt"]; ok {
+		salt = s.String()
+	}
+	rounds := params.GetKeywordArgument("rounds", 0).Integer()
+
+	out, err := shaCrypt(scheme, in.String(), salt, rounds)
+	if err != nil {
+		return filterError("password_hash", err)
+	}
+	return gonjaexec.AsValue(out)
+}
+
+// filterBasename and filterDirname behave like Python's os.path, which
+// differs from Go's path for trailing slashes.
+func filterBasename(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	s := in.String()
+	return gonjaexec.AsValue(s[strings.LastIndex(s, "/")+1:])
+}
+
+func filterDirname(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	s := in.String()
+	head := s[:strings.LastIndex(s, "/")+1]
+	if strings.Trim(head, "/") != "" {
+		head = strings.TrimRight(head, "/")
+	}
+	return gonjaexec.AsValue(head)
+}
+
+// ipQuery answers an ipaddr query about s, an address or a network in CIDR
+// notation. It returns false if s isn't one, or if it doesn't match the query
+// or version, which is 0 for any.
+func ipQuery(s, query string, version int) (any, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return false, nil
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	addr := prefix.Addr()
+
+	if (version == 4 && !addr.Is4()) || (version == 6 && !addr.Is6()) {
+		return false, nil
+	}
+
+	switch query {
+	case "":
+		return s, nil
+	case "address":
+		return addr.String(), nil
+	case "network":
+		return prefix.Masked().Addr().String(), nil
+	case "prefix":
+		return prefix.Bits(), nil
+	case "netmask":
+		mask := make([]byte, addr.BitLen()/8)
+		for i := range prefix.Bits() {
+			mask[i/8] |= 0x80 >> (i % 8)
+		}
+		m, _ := netip.AddrFromSlice(mask)
+		return m.String(), nil
+	case "broadcast":
+		b := prefix.Masked().Addr().AsSlice()
+		for i := prefix.Bits(); i < len(b)*8; i++ {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+		m, _ := netip.AddrFromSlice(b)
+		return m.String(), nil
+	case "host":
+		return prefix.String(), nil
+	case "net":
+		if prefix.Masked() != prefix {
+			return false, nil
+		}
+		return prefix.String(), nil
+	case "private":
+		if !addr.IsPrivate() {
+			return false, nil
+		}
+		return s, nil
+	case "public":
+		if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			return false, nil
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported query %q", query)
+	}
+}
+
+func ipFilter(name string, in *gonjaexec.Value, params *gonjaexec.VarArgs, version int) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	query := ""
+	if len(params.Args) > 0 {
+		query = params.First().String()
+	}
+
+	// Lists are filtered, keeping the values that match the query.
+	if in.IsList() {
+		out := []any{}
+		var err error
+		in.Iterate(func(_, _ int, elem, _ *gonjaexec.Value) bool {
+			var result any
+			if result, err = ipQuery(elem.String(), query, version); err != nil {
+				return false
+			}
+			if result != false {
+				out = append(out, result)
+			}
+			return true
+		}, func() {})
+		if err != nil {
+			return filterError(name, err)
+		}
+		return gonjaexec.AsValue(out)
+	}
+
+	result, err := ipQuery(in.String(), query, version)
+	if err != nil {
+		return filterError(name, err)
+	}
+	return gonjaexec.AsValue(result)
+}
+
+func filterIPAddr(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	return ipFilter("ipaddr", in, params, 0)
+}
+
+func filterIPv4(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	return ipFilter("ipv4", in, params, 4)
+}
+
+func filterIPv6(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	return ipFilter("ipv6", in, params, 6)
+}
+
+var shellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// filterQuote quotes a string for the shell, like Python's shlex.quote.
+func filterQuote(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	s := in.String()
+	if s == "" {
+		return gonjaexec.AsValue("''")
+	}
+	if shellSafe.MatchString(s) {
+		return in
+	}
+	return gonjaexec.AsValue("'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'")
+}
+
+func filterBool(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() || in.IsNil() || in.IsBool() {
+		return in
+	}
+	switch strings.ToLower(in.String()) {
+	case "yes", "on", "1", "true":
+		return gonjaexec.AsValue(true)
+	default:
+		return gonjaexec.AsValue(false)
+	}
+}
+
+// filterVersion is the `version` test, as a filter, like Ansible's former
+// `version_compare`.
+func filterVersion(e *gonjaexec.Evaluator, in *gonjaexec.Value, params *gonjaexec.VarArgs) *gonjaexec.Value {
+	if in.IsError() {
+		return in
+	}
+	ok, err := testVersion(nil, in, params)
+	if err != nil {
+		return filterError("version", err)
+	}
+	return gonjaexec.AsValue(ok)
+}
+
+// resultField returns a boolean field of a registered result, for the tests
+// on results like `failed`.
+func resultField(name string, in *gonjaexec.Value, field string) (bool, error) {
+	if !in.IsDict() {
+		return false, fmt.Errorf("the %q test expects a dictionary", name)
+	}
+	v, found := in.Get(field)
+	return found && v.IsTrue(), nil
+}
+
+func testSucceeded(ctx *gonjaexec.Context, in *gonjaexec.Value, params *gonjaexec.VarArgs) (bool, error) {
+	failed, err := resultField("succeeded", in, "failed")
+	return !failed, err
+}
+
+func testFailed(ctx *gonjaexec.Context, in *gonjaexec.Value, params *gonjaexec.VarArgs) (bool, error) {
+	return resultField("failed", in, "failed")
+}
+
+func testChanged(ctx *gonjaexec.Context, in *gonjaexec.Value, params *gonjaexec.VarArgs) (bool, error) {
+	return resultField("changed", in, "changed")
+}
+
+func testSkipped(ctx *gonjaexec.Context, in *gonjaexec.Value, params *gonjaexec.VarArgs) (bool, error) {
+	return resultField("skipped", in, "skipped")
+}
+
+var versionPart = regexp.MustCompile(`\d+|[a-zA-Z]+`)
+
+// compareVersions compares versions like Python's LooseVersion: numbers are
+// compared as such, and come before letters.
+func compareVersions(a, b string) int {
+	pa, pb := versionPart.FindAllString(a, -1), versionPart.FindAllString(b, -1)
+	for i := range min(len(pa), len(pb)) {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = na - nb
+		case errA == nil:
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(pa[i], pb[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+func testVersion(ctx *gonjaexec.Context, in *gonjaexec.Value, params *gonjaexec.VarArgs) (bool, error) {
+	params = testParams(params)
+	if len(params.Args) < 1 {
+		return false, errors.New("version: expected a version to compare to")
+	}
+	operator := "eq"
+	if len(params.Args) > 1 {
+		operator = params.Args[1].String()
+	}
+	if op, ok := params.KwArgs["operator"]; ok {
+		operator = op.String()
+	}
+
+	c := compareVersions(in.String(), params.First().String())
+	switch operator {
+	case "<", "lt":
+		return c < 0, nil
+	case "<=", "le":
+		return c <= 0, nil
+	case ">", "gt":
+		return c > 0, nil
+	case ">=", "ge":
+		return c >= 0, nil
+	case "==", "=", "eq":
+		return c == 0, nil
+	case "!=", "<>", "ne":
+		return c != 0, nil
+	default:
+		return false, fmt.Errorf("version: invalid operator %q", operator)
+	}
+}
+
+// testMatch tells whether the start of a string matches a regular expression,
+// like Python's re.match.
+func testMatch(ctx *gonjaexec.Context, in *gonjaexec.Value, params *gonjaexec.VarArgs) (bool, error) {
+	params = testParams(params)
+	if len(params.Args) < 1 {
+		return false, errors.New("match: expected a pattern")
+	}
+	re, err := pythonRegexp(`\A(?:`+params.First().String()+`)`, params, 1)
+	if err != nil {
+		return false, fmt.Errorf("match: %w", err)
+	}
+	return re.MatchString(in.String()), nil
+}
+
+func testSearch(ctx *gonjaexec.Context, in *gonjaexec.Value, params *gonjaexec.VarArgs) (bool, error) {
+	params = testParams(params)
+	if len(params.Args) < 1 {
+		return false, errors.New("search: expected a pattern")
+	}
+	re, err := pythonRegexp(params.First().String(), params, 1)
+	if err != nil {
+		return false, fmt.Errorf("search: %w", err)
+	}
+	return re.MatchString(in.String()), nil
+}
@@ -0,0 +1,162 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nikolalohinski/gonja/v2"
+	gonjaexec "github.com/nikolalohinski/gonja/v2/exec"
+
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func TestFilters(t *testing.T) {
+	vars := variables.Variables{
+		"users": []any{
+			map[string]any{"name": "alice", "admin": true},
+			map[string]any{"name": "bob"},
+		},
+		"base":     map[string]any{"a": 1, "nested": map[string]any{"x": 1, "y": 2}, "list": []any{1, 2}},
+		"override": map[string]any{"b": 2, "nested": map[string]any{"y": 3}, "list": []any{2, 3}},
+		"result":   map[string]any{"changed": true, "failed": false},
+		"skipped":  map[string]any{"changed": false, "skipped": true},
+		"path":     "/etc/nginx/nginx.conf",
+		"empty":    "",
+	}
+	varsCtx := gonjaexec.NewContext(vars)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+		wantErr  bool
+	}{
+		{name: "default", input: "{{ nope | default('x') }}", expected: "x"},
+		{name: "d", input: "{{ nope | d('x') }}", expected: "x"},
+		{name: "default on empty string", input: "{{ empty | default('x', true) }}", expected: "x"},
+		{name: "mandatory", input: "{{ path | mandatory }}", expected: "/etc/nginx/nginx.conf"},
+		{name: "mandatory undefined", input: "{{ nope | mandatory }}", wantErr: true},
+		{name: "to_json", input: "{{ base | to_json }}", expected: `{"a": 1, "list": [1, 2], "nested": {"x": 1, "y": 2}}`},
+		{name: "to_nice_json", input: "{{ {'a': [1]} | to_nice_json(indent=2) }}", expected: "{\n  \"a\": [\n    1\n  ]\n}"},
+		{name: "to_nice_yaml", input: "{{ {'a': {'b': [1, 2]}} | to_nice_yaml }}", expected: "a:\n    b:\n    - 1\n    - 2\n"},
+		{name: "from_json", input: `{{ '{"a": [1, 2.5, "x"]}' | from_json }}`, expected: map[string]any{"a": []any{1, 2.5, "x"}}},
+		{name: "from_yaml", input: "{{ 'a: 1\nb: [x]' | from_yaml }}", expected: map[string]any{"a": 1, "b": []any{"x"}}},
+		{name: "regex_replace", input: `{{ 'foo-bar' | regex_replace('(\\w+)-(\\w+)', '\\2-\\1') }}`, expected: "bar-foo"},
+		{name: "regex_replace ignorecase", input: `{{ 'FOO' | regex_replace('foo', 'x$', ignorecase=true) }}`, expected: "x$"},
+		{name: "regex_search", input: `{{ 'version 1.2.3' | regex_search('\\d+\\.\\d+') }}`, expected: "1.2"},
+		{name: "regex_search groups", input: `{{ 'version 1.2.3' | regex_search('(?P<major>\\d+)\\.(\\d+)', '\\g<major>', '\\2') }}`, expected: []any{"1", "2"}},
+		{name: "regex_search no match", input: `{{ 'abc' | regex_search('\\d') }}`, expected: nil},
+		{name: "b64encode", input: "{{ 'hello' | b64encode }}", expected: "aGVsbG8="},
+		{name: "b64decode", input: "{{ 'aGVsbG8=' | b64decode }}", expected: "hello"},
+		{
+			name:     "combine",
+			input:    "{{ base | combine(override) }}",
+			expected: map[string]any{"a": 1, "b": 2, "nested": map[string]any{"y": 3}, "list": []any{2, 3}},
+		},
+		{
+			name:     "combine recursive",
+			input:    "{{ base | combine(override, recursive=true, list_merge='append_rp') }}",
+			expected: map[string]any{"a": 1, "b": 2, "nested": map[string]any{"x": 1, "y": 3}, "list": []any{1, 2, 3}},
+		},
+		{
+			name:     "dict2items",
+			input:    "{{ {'b': 2, 'a': 1} | dict2items }}",
+			expected: []any{map[string]any{"key": "a", "value": 1}, map[string]any{"key": "b", "value": 2}},
+		},
+		{
+			name:     "items2dict",
+			input:    "{{ [{'name': 'a', 'v': 1}] | items2dict(key_name='name', value_name='v') }}",
+			expected: map[string]any{"a": 1},
+		},
+		{name: "selectattr", input: "{{ users | selectattr('admin') | map(attribute='name') }}", expected: []any{"alice"}},
+		{name: "selectattr defined", input: "{{ users | selectattr('admin', 'defined') | map(attribute='name') }}", expected: []any{"alice"}},
+		{name: "rejectattr", input: "{{ users | rejectattr('name', 'equalto', 'alice') | map(attribute='name') }}", expected: []any{"bob"}},
+		{name: "map filter", input: "{{ ['a', 'b'] | map('upper') }}", expected: []any{"A", "B"}},
+		{name: "map filter with arguments", input: "{{ ['a-1'] | map('regex_replace', '-', '_') }}", expected: []any{"a_1"}},
+		{name: "ternary", input: "{{ (1 == 1) | ternary('yes', 'no') }}", expected: "yes"},
+		{name: "ternary false", input: "{{ false | ternary('yes', 'no') }}", expected: "no"},
+		{name: "hash", input: "{{ 'hello' | hash('sha1') }}", expected: "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+		{name: "hash md5", input: "{{ 'hello' | hash('md5') }}", expected: "5d41402abc4b2a76b9719d911017c592"},
+		{
+			name:     "password_hash",
+			input:    "{{ 'Hello world!' | password_hash('sha512', 'saltstring') }}",
+			expected: "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		},
+		{
+			name:     "password_hash with rounds",
+			input:    "{{ 'Hello world!' | password_hash('sha512', salt='saltstringsaltstring', rounds=10000) }}",
+			expected: "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+		},
+		{
+			name:     "password_hash sha256",
+			input:    "{{ 'Hello world!' | password_hash('sha256_crypt', 'saltstring') }}",
+			expected: "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		},
+		{name: "basename", input: "{{ path | basename }}", expected: "nginx.conf"},
+		{name: "dirname", input: "{{ path | dirname }}", expected: "/etc/nginx"},
+		{name: "dirname of root", input: "{{ '/etc' | dirname }}", expected: "/"},
+		{name: "ipaddr", input: "{{ '192.168.1.10' | ipaddr }}", expected: "192.168.1.10"},
+		{name: "ipaddr invalid", input: "{{ 'nope' | ipaddr }}", expected: false},
+		{name: "ipaddr address", input: "{{ '192.168.1.10/24' | ipaddr('address') }}", expected: "192.168.1.10"},
+		{name: "ipaddr network", input: "{{ '192.168.1.10/24' | ipaddr('network') }}", expected: "192.168.1.0"},
+		{name: "ipaddr netmask", input: "{{ '192.168.1.10/20' | ipaddr('netmask') }}", expected: "255.255.240.0"},
+		{name: "ipaddr broadcast", input: "{{ '192.168.1.10/24' | ipaddr('broadcast') }}", expected: "192.168.1.255"},
+		{name: "ipaddr prefix", input: "{{ '192.168.1.10/24' | ipaddr('prefix') }}", expected: 24},
+		{name: "ipv4 on a list", input: "{{ ['10.0.0.1', '::1', 'x'] | ipv4 }}", expected: []any{"10.0.0.1"}},
+		{name: "ipv6", input: "{{ '10.0.0.1' | ipv6 }}", expected: false},
+		{name: "quote", input: "{{ \"it's\" | quote }}", expected: `'it'"'"'s'`},
+		{name: "quote safe", input: "{{ path | quote }}", expected: "/etc/nginx/nginx.conf"},
+		{name: "bool", input: "{{ 'yes' | bool }}", expected: true},
+		{name: "bool false", input: "{{ 'off' | bool }}", expected: false},
+		{name: "version filter", input: "{{ '1.10' | version('1.9', '>') }}", expected: true},
+		{name: "is defined", input: "{{ path is defined }}", expected: true},
+		{name: "is not defined", input: "{{ nope is defined }}", expected: false},
+		{name: "is succeeded", input: "{{ result is succeeded }}", expected: true},
+		{name: "is failed", input: "{{ result is failed }}", expected: false},
+		{name: "is changed", input: "{{ result is changed }}", expected: true},
+		{name: "is skipped", input: "{{ skipped is skipped }}", expected: true},
+		{name: "is failed on a string", input: "{{ path is failed }}", wantErr: true},
+		{name: "is version", input: "{{ '1.2.10' is version('1.2.9', '>=') }}", expected: true},
+		{name: "is version lower", input: "{{ '1.2' is version('1.2.0', 'lt') }}", expected: true},
+		{name: "is version equal", input: "{{ '2.0' is version('2.0') }}", expected: true},
+		{name: "is match", input: "{{ path is match('/etc') }}", expected: true},
+		{name: "is match anchored", input: "{{ path is match('nginx') }}", expected: false},
+		{name: "is search", input: "{{ path is search('NGINX', true) }}", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinjaNative(tt.input, varsCtx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFiltersStrictUndefined(t *testing.T) {
+	gonja.DefaultConfig.StrictUndefined = true
+	t.Cleanup(func() { gonja.DefaultConfig.StrictUndefined = false })
+
+	ctx := variables.NewContext(context.Background(), variables.Variables{})
+
+	ok, err := JinjaProcessWhen(ctx, "nope is not defined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("expected an undefined variable not to be defined")
+	}
+
+	holder := struct{ Value string }{Value: "{{ nope | default('x') }}"}
+	if err := ProcessJinjaTemplates(ctx, &holder); err != nil {
+		t.Fatal(err)
+	}
+	if holder.Value != "x" {
+		t.Errorf("expected the default value, got %q", holder.Value)
+	}
+}
@@ -3,8 +3,12 @@ package util
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -12,61 +16,85 @@ import (
 	gonjaexec "github.com/nikolalohinski/gonja/v2/exec"
 	"github.com/nikolalohinski/gonja/v2/loaders"
 	"github.com/nikolalohinski/gonja/v2/nodes"
-
-	"github.com/mickael-carl/sophons/pkg/variables"
 )
 
-func JinjaProcessWhen(ctx context.Context, when string) (bool, error) {
-	if when == "" {
-		return true, nil
-	}
-
-	vars, ok := variables.FromContext(ctx)
-	if !ok {
-		vars = variables.Variables{}
-	}
-	varsCtx := gonjaexec.NewContext(vars)
+// JinjaProcessWhen tells whether all conditions hold, e.g. the ones of a
+// task's `when`. Conditions are Jinja expressions, or templates like
+// "{{ foo }}", and their results are evaluated with Ansible's truthiness
+// rules, see truthy. Empty conditions always hold.
+func JinjaProcessWhen(ctx context.Context, conditions ...string) (bool, error) {
+	varsCtx := JinjaContext(ctx)
+	for _, condition := range conditions {
+		if condition == "" {
+			continue
+		}
 
-	template, err := gonja.FromString("{{ " + when + " }}")
-	if err != nil {
-		return false, err
-	}
+		expression := condition
+		if !strings.Contains(condition, "{{") {
+			expression = "{{ " + condition + " }}"
+		}
+		result, err := renderJinjaNative(expression, varsCtx)
+		if err != nil {
+			return false, err
+		}
 
-	result, err := template.ExecuteToString(varsCtx)
-	if err != nil {
-		return false, err
+		ok, err := truthy(result)
+		if err != nil {
+			return false, fmt.Errorf("condition %q: %w", condition, err)
+		}
+		if !ok {
+			return false, nil
+		}
 	}
+	return true, nil
+}
 
-	// TODO: For now, we'll consider "true" as true, and anything else as
-	// false. Ansible has more complex rules, but this is a start.
-	// https://docs.ansible.com/ansible/latest/user_guide/playbooks_conditionals.html#conditionals
-	switch result {
-	case "True", "true":
-		return true, nil
-	case "False", "false":
-		return false, nil
+// truthy returns the boolean value of the result of a condition. Numbers,
+// lists and dicts follow Python's rules: they're false when zero or empty.
+// Strings follow Ansible's `bool` filter, e.g. "yes" and "True" are true, "no"
+// and "" are false. Other strings, and nothing, are ambiguous: rather than
+// skipping or running a task by mistake, an error is returned.
+// https://docs.ansible.com/ansible/latest/playbook_guide/playbooks_conditionals.html
+func truthy(v any) (bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return false, errors.New("conditional result is None, expected a boolean")
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "on", "y", "1":
+			return true, nil
+		case "false", "no", "off", "n", "0", "":
+			return false, nil
+		}
+		return false, fmt.Errorf("conditional result %q is ambiguous, expected a boolean", v)
 	}
 
-	// Attempt to convert to a number
-	if i, err := strconv.Atoi(result); err == nil {
-		return i != 0, nil
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() != 0, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() != 0, nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() != 0, nil
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() != 0, nil
+	default:
+		return false, fmt.Errorf("conditional result of type %T is ambiguous, expected a boolean", v)
 	}
-
-	return false, nil
 }
 
-// renderJinjaStringToSlice renders a Jinja template string and returns a []string.
-// If the template evaluates to a list, all elements are returned.
-// If the template evaluates to a single value, a single-element slice is returned.
-// If the string is empty or doesn't contain "{{", it returns the original string.
-func renderJinjaStringToSlice(jinjaString string, varsCtx *gonjaexec.Context) ([]string, error) {
-	if jinjaString == "" {
-		return []string{""}, nil
-	}
-
+// renderJinjaNative renders a Jinja template string natively, like Ansible's
+// jinja2_native: a template that is a single expression, e.g. "{{ users }}",
+// returns the value it evaluates to, be it a list, a dict, a number or a
+// boolean. Other templates, e.g. "prefix-{{ var }}", are rendered as a string.
+// It returns nil if the expression evaluates to nothing.
+func renderJinjaNative(jinjaString string, varsCtx *gonjaexec.Context) (any, error) {
 	// Not a Jinja template, return as-is
 	if !strings.Contains(jinjaString, "{{") {
-		return []string{jinjaString}, nil
+		return jinjaString, nil
 	}
 
 	template, err := gonja.FromString(jinjaString)
@@ -79,45 +107,99 @@ func renderJinjaStringToSlice(jinjaString string, varsCtx *gonjaexec.Context) ([
 		return nil, err
 	}
 
-	env := gonja.DefaultEnvironment
-	env.Context = varsCtx
-
 	var buf bytes.Buffer
-	renderer := gonjaexec.NewRenderer(env, &buf, gonja.DefaultConfig, loader, template)
+	renderer := gonjaexec.NewRenderer(jinjaEnvironment(varsCtx), &buf, gonja.DefaultConfig, loader, template)
 
-	// Special case: if the template is ONLY a variable reference (e.g., "{{ foo }}")
-	// and it evaluates to a list, we want to expand it. Otherwise, we just render
-	// the template as a string.
 	if len(renderer.RootNode.Nodes) == 1 {
 		if outputNode, ok := renderer.RootNode.Nodes[0].(*nodes.Output); ok {
 			value := renderer.Eval(outputNode.Expression)
-
+			if value.IsError() {
+				return nil, errors.New(value.Error())
+			}
 			if value.IsNil() {
 				return nil, nil
 			}
 
-			// If it's a list, convert to []string
-			if value.IsList() {
-				outSlice := []string{}
-				for _, i := range value.ToGoSimpleType(false).([]any) {
-					s, ok := i.(string)
-					if !ok {
-						return nil, fmt.Errorf("found a non-string value in a jinja list, which is not supported")
-					}
-					outSlice = append(outSlice, s)
-				}
-				return outSlice, nil
+			native := value.ToGoSimpleType(false)
+			if err, ok := native.(error); ok {
+				return nil, err
 			}
+			return native, nil
 		}
 	}
 
 	// For mixed templates (e.g., "prefix-{{ var }}"), just render as a string
-	rendered, err := template.ExecuteToString(varsCtx)
+	return template.ExecuteToString(varsCtx)
+}
+
+// jinjaEnvironment returns an environment to render a template with varsCtx,
+// on top of gonja's global functions and variables, like Template.Execute
+// does. gonja's default environment is shared by every template, so it's
+// copied rather than having the variables of one task set in it for all the
+// templates rendered after.
+func jinjaEnvironment(varsCtx *gonjaexec.Context) *gonjaexec.Environment {
+	env := *gonja.DefaultEnvironment
+	env.Context = gonja.DefaultContext.Inherit().Update(varsCtx)
+	return &env
+}
+
+// renderNativeValue renders the templates in v natively. Lists and dicts are
+// rendered element by element, into new ones.
+func renderNativeValue(v any, varsCtx *gonjaexec.Context) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return renderJinjaNative(v, varsCtx)
+	case []any:
+		rendered := make([]any, len(v))
+		for i, elem := range v {
+			r, err := renderNativeValue(elem, varsCtx)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	case map[string]any:
+		rendered := make(map[string]any, len(v))
+		for k, elem := range v {
+			r, err := renderNativeValue(elem, varsCtx)
+			if err != nil {
+				return nil, err
+			}
+			rendered[k] = r
+		}
+		return rendered, nil
+	default:
+		return v, nil
+	}
+}
+
+// renderJinjaStringToSlice renders a Jinja template string and returns a []string.
+// If the template evaluates to a list, all elements are returned, as strings.
+// If the template evaluates to a single value, a single-element slice is returned.
+// If the string is empty or doesn't contain "{{", it returns the original string.
+func renderJinjaStringToSlice(jinjaString string, varsCtx *gonjaexec.Context) ([]string, error) {
+	if jinjaString == "" {
+		return []string{""}, nil
+	}
+
+	rendered, err := renderJinjaNative(jinjaString, varsCtx)
 	if err != nil {
 		return nil, err
 	}
 
-	return []string{rendered}, nil
+	switch rendered := rendered.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		outSlice := make([]string, 0, len(rendered))
+		for _, elem := range rendered {
+			outSlice = append(outSlice, gonjaexec.AsValue(elem).String())
+		}
+		return outSlice, nil
+	default:
+		return []string{gonjaexec.AsValue(rendered).String()}, nil
+	}
 }
 
 func ProcessJinjaTemplates(ctx context.Context, taskContent any) error {
@@ -131,6 +213,7 @@ func ProcessJinjaTemplates(ctx context.Context, taskContent any) error {
 	}
 
 	t := v.Type()
+	varsCtx := JinjaContext(ctx)
 
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
@@ -141,13 +224,6 @@ func ProcessJinjaTemplates(ctx context.Context, taskContent any) error {
 			continue
 		}
 
-		vars, ok := variables.FromContext(ctx)
-		if !ok {
-			vars = variables.Variables{}
-		}
-
-		varsCtx := gonjaexec.NewContext(vars)
-
 		switch field.Kind() {
 		case reflect.String:
 			jinjaString := field.String()
@@ -248,13 +324,12 @@ func ProcessJinjaTemplates(ctx context.Context, taskContent any) error {
 				continue
 			}
 
-			// If the interface we're getting is a string, let's treat it as a
-			// Jinja template that needs rendering. The output might be a
-			// string, but not only. For now we also see a case where the
-			// output could be a list so let's support that by parsing the
-			// actual output.
-			if str, ok := field.Interface().(string); ok {
-				rendered, err := renderJinjaStringToSlice(str, varsCtx)
+			// Strings, and lists and dicts holding them, are rendered
+			// natively: a template that is a single expression is replaced
+			// by the value it evaluates to, e.g. a list of dicts for a loop.
+			switch value := field.Interface().(type) {
+			case string, []any, map[string]any:
+				rendered, err := renderNativeValue(value, varsCtx)
 				if err != nil {
 					return err
 				}
@@ -263,18 +338,8 @@ func ProcessJinjaTemplates(ctx context.Context, taskContent any) error {
 				if rendered == nil {
 					continue
 				}
-
-				// If it's a list (more than one element), set as []string
-				if len(rendered) > 1 {
-					field.Set(reflect.ValueOf(rendered))
-					continue
-				}
-
-				// Single element - set as the unwrapped string
-				if len(rendered) == 1 {
-					field.Set(reflect.ValueOf(rendered[0]))
-					continue
-				}
+				field.Set(reflect.ValueOf(rendered))
+				continue
 			}
 
 			// If we're dealing with a slice, we need to iterate over its
@@ -303,11 +368,12 @@ func ProcessJinjaTemplates(ctx context.Context, taskContent any) error {
 				continue
 			}
 
-			if field.Elem().Kind() == reflect.Ptr {
+			switch field.Elem().Kind() {
+			case reflect.Ptr:
 				if err := ProcessJinjaTemplates(ctx, field.Elem().Interface()); err != nil {
 					return err
 				}
-			} else {
+			case reflect.Struct:
 				newValue := reflect.New(field.Elem().Type())
 				newValue.Elem().Set(field.Elem())
 				if err := ProcessJinjaTemplates(ctx, newValue.Interface()); err != nil {
@@ -320,3 +386,190 @@ func ProcessJinjaTemplates(ctx context.Context, taskContent any) error {
 
 	return nil
 }
+
+// ProcessJinjaArgs renders args, templates for the arguments of a module that
+// aren't strings, natively, e.g. `force: "{{ overwrite }}"`. The results are
+// set into the fields of target named like the arguments in their yaml tags,
+// converted to the fields' types.
+func ProcessJinjaArgs(ctx context.Context, target any, args map[string]string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	varsCtx := JinjaContext(ctx)
+	for _, name := range slices.Sorted(maps.Keys(args)) {
+		field, ok := fieldByYAMLName(reflect.ValueOf(target), name)
+		if !ok {
+			return fmt.Errorf("unknown argument %q", name)
+		}
+
+		rendered, err := renderJinjaNative(args[name], varsCtx)
+		if err != nil {
+			return err
+		}
+		if err := setNative(field, rendered); err != nil {
+			return fmt.Errorf("invalid value for argument %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// fieldByYAMLName returns the field of the struct v points to whose yaml tag
+// names it name. Embedded structs, like the proto messages modules wrap, are
+// looked into too.
+func fieldByYAMLName(v reflect.Value, name string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		fieldType := t.Field(i)
+		if fieldType.Anonymous {
+			if field, ok := fieldByYAMLName(v.Field(i), name); ok {
+				return field, true
+			}
+			continue
+		}
+		if fieldType.PkgPath != "" {
+			continue
+		}
+
+		tagName, _, _ := strings.Cut(fieldType.Tag.Get("yaml"), ",")
+		if tagName == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// setNative sets field to value, the result of a native rendering, converted
+// to the field's type. Strings are converted to booleans with Ansible's
+// truthiness rules, and to numbers if they hold one.
+func setNative(field reflect.Value, value any) error {
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	if field.Kind() == reflect.Ptr {
+		elem := reflect.New(field.Type().Elem())
+		if err := setNative(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch field.Kind() {
+	case reflect.Bool:
+		switch value.(type) {
+		case bool, string:
+		default:
+			if !rv.CanInt() && !rv.CanUint() {
+				return fmt.Errorf("expected a boolean, got %T", value)
+			}
+		}
+		b, err := truthy(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := nativeInt(rv)
+		if err != nil {
+			return err
+		}
+		if field.OverflowInt(i) {
+			return fmt.Errorf("%d is out of range", i)
+		}
+		field.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := nativeInt(rv)
+		if err != nil {
+			return err
+		}
+		if i < 0 || field.OverflowUint(uint64(i)) {
+			return fmt.Errorf("%d is out of range", i)
+		}
+		field.SetUint(uint64(i))
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch {
+		case rv.CanFloat():
+			f = rv.Float()
+		case rv.CanInt():
+			f = float64(rv.Int())
+		case rv.CanUint():
+			f = float64(rv.Uint())
+		case rv.Kind() == reflect.String:
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(rv.String()), 64); err != nil {
+				return fmt.Errorf("expected a number, got %q", rv.String())
+			}
+		default:
+			return fmt.Errorf("expected a number, got %T", value)
+		}
+		if field.OverflowFloat(f) {
+			return fmt.Errorf("%v is out of range", f)
+		}
+		field.SetFloat(f)
+
+	case reflect.String:
+		field.SetString(gonjaexec.AsValue(value).String())
+
+	case reflect.Slice:
+		list, ok := value.([]any)
+		if !ok {
+			list = []any{value}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(list), len(list))
+		for i, elem := range list {
+			if err := setNative(slice.Index(i), elem); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+
+	default:
+		return fmt.Errorf("can't set a field of type %s", field.Type())
+	}
+	return nil
+}
+
+// nativeInt returns the integer v holds, be it a number without a fractional
+// part or a string holding one.
+func nativeInt(v reflect.Value) (int64, error) {
+	switch {
+	case v.CanInt():
+		return v.Int(), nil
+	case v.CanUint():
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("%d is out of range", v.Uint())
+		}
+		return int64(v.Uint()), nil
+	case v.CanFloat():
+		if f := v.Float(); f == math.Trunc(f) && f >= math.MinInt64 && f <= math.MaxInt64 {
+			return int64(f), nil
+		}
+		return 0, fmt.Errorf("expected an integer, got %v", v.Float())
+	case v.Kind() == reflect.String:
+		i, err := strconv.ParseInt(strings.TrimSpace(v.String()), 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, got %q", v.String())
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %s", v.Type())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/nikolalohinski/gonja/v2"
 	gonjaexec "github.com/nikolalohinski/gonja/v2/exec"
 
 	"github.com/mickael-carl/sophons/pkg/variables"
@@ -147,6 +148,7 @@ func TestRenderJinjaStringToSlice(t *testing.T) {
 	vars := variables.Variables{
 		"package_list":  []any{"git", "man-db"},
 		"postgres_type": "client",
+		"ports":         []any{80, 443},
 	}
 	varsCtx := gonjaexec.NewContext(vars)
 
@@ -166,6 +168,11 @@ func TestRenderJinjaStringToSlice(t *testing.T) {
 			input:    "{{ package_list }}",
 			expected: []string{"git", "man-db"},
 		},
+		{
+			name:     "template to list of non-strings",
+			input:    "{{ ports }}",
+			expected: []string{"80", "443"},
+		},
 		{
 			name:     "template in string",
 			input:    "postgresql-{{ postgres_type }}",
@@ -285,11 +292,53 @@ func TestJinjaProcessWhen(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name:     "string that doesn't match true/false patterns defaults to false",
-			when:     "'some random string'",
-			vars:     variables.Variables{},
+			name:    "string that doesn't match true/false patterns is ambiguous",
+			when:    "'some random string'",
+			vars:    variables.Variables{},
+			wantErr: true,
+		},
+		{
+			name:     "yes string is true",
+			when:     "enabled",
+			vars:     variables.Variables{"enabled": "yes"},
+			expected: true,
+		},
+		{
+			name:     "False string is false",
+			when:     "enabled",
+			vars:     variables.Variables{"enabled": "False"},
 			expected: false,
 		},
+		{
+			name:     "empty string is false",
+			when:     "name",
+			vars:     variables.Variables{"name": ""},
+			expected: false,
+		},
+		{
+			name:     "non-empty list is true",
+			when:     "users",
+			vars:     variables.Variables{"users": []any{"alice"}},
+			expected: true,
+		},
+		{
+			name:     "empty dict is false",
+			when:     "config",
+			vars:     variables.Variables{"config": map[string]any{}},
+			expected: false,
+		},
+		{
+			name:     "template",
+			when:     "{{ enabled }}",
+			vars:     variables.Variables{"enabled": true},
+			expected: true,
+		},
+		{
+			name:    "none is ambiguous",
+			when:    "nothing",
+			vars:    variables.Variables{"nothing": nil},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -311,6 +360,36 @@ func TestJinjaProcessWhen(t *testing.T) {
 	}
 }
 
+func TestJinjaProcessWhenConditions(t *testing.T) {
+	ctx := variables.NewContext(context.Background(), variables.Variables{"count": 5})
+
+	tests := []struct {
+		name       string
+		conditions []string
+		expected   bool
+		wantErr    bool
+	}{
+		{name: "no conditions", expected: true},
+		{name: "all hold", conditions: []string{"count > 3", "count < 10"}, expected: true},
+		{name: "one doesn't hold", conditions: []string{"count > 3", "count > 10"}, expected: false},
+		{name: "empty ones are skipped", conditions: []string{"", "count == 5"}, expected: true},
+		{name: "stops at the first that doesn't hold", conditions: []string{"false", "nope"}, expected: false},
+		{name: "ambiguous one", conditions: []string{"true", "'maybe'"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := JinjaProcessWhen(ctx, tt.conditions...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("JinjaProcessWhen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("JinjaProcessWhen() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestProcessJinjaTemplatesPointer(t *testing.T) {
 	type nestedStruct struct {
 		Value string
@@ -359,3 +438,226 @@ func TestProcessJinjaTemplatesPointer(t *testing.T) {
 		t.Errorf("NilPtr should remain nil")
 	}
 }
+
+func TestRenderJinjaNative(t *testing.T) {
+	vars := variables.Variables{
+		"users": []any{
+			map[string]any{"name": "alice", "uid": 1000},
+			map[string]any{"name": "bob", "uid": 1001},
+		},
+		"port":    8080,
+		"enabled": true,
+		"name":    "web",
+	}
+	varsCtx := gonjaexec.NewContext(vars)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+	}{
+		{
+			name:     "plain string",
+			input:    "htop",
+			expected: "htop",
+		},
+		{
+			name:  "list of dicts",
+			input: "{{ users }}",
+			expected: []any{
+				map[string]any{"name": "alice", "uid": 1000},
+				map[string]any{"name": "bob", "uid": 1001},
+			},
+		},
+		{
+			name:     "dict",
+			input:    "{{ users[0] }}",
+			expected: map[string]any{"name": "alice", "uid": 1000},
+		},
+		{
+			name:     "int",
+			input:    "{{ port }}",
+			expected: 8080,
+		},
+		{
+			name:     "expression",
+			input:    "{{ port + 1 }}",
+			expected: 8081,
+		},
+		{
+			name:     "bool",
+			input:    "{{ enabled }}",
+			expected: true,
+		},
+		{
+			name:     "string",
+			input:    "{{ name }}",
+			expected: "web",
+		},
+		{
+			name:     "mixed template",
+			input:    "{{ name }}-{{ port }}",
+			expected: "web-8080",
+		},
+		{
+			name:     "undefined",
+			input:    "{{ nope }}",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinjaNative(tt.input, varsCtx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("renderJinjaNative() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcessJinjaTemplatesInterfaceNative(t *testing.T) {
+	type interfaceStruct struct {
+		Loop  any
+		List  any
+		Dict  any
+		Count any
+	}
+
+	ctx := variables.NewContext(context.Background(), variables.Variables{
+		"users": []any{map[string]any{"name": "alice"}},
+		"foo":   "bar",
+		"count": 3,
+	})
+
+	list := []any{"{{ foo }}", 1, "{{ count }}"}
+	is := &interfaceStruct{
+		Loop:  "{{ users }}",
+		List:  list,
+		Dict:  map[string]any{"key": "{{ foo }}", "nested": []any{"{{ count }}"}},
+		Count: "{{ count }}",
+	}
+
+	if err := ProcessJinjaTemplates(ctx, is); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &interfaceStruct{
+		Loop:  []any{map[string]any{"name": "alice"}},
+		List:  []any{"bar", 1, 3},
+		Dict:  map[string]any{"key": "bar", "nested": []any{3}},
+		Count: 3,
+	}
+	if diff := cmp.Diff(expected, is); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// The original list is left alone, for loops to render it again for
+	// every item.
+	if diff := cmp.Diff([]any{"{{ foo }}", 1, "{{ count }}"}, list); diff != "" {
+		t.Errorf("original list was modified (-want +got):\n%s", diff)
+	}
+}
+
+func TestProcessJinjaArgs(t *testing.T) {
+	type message struct {
+		Force       *bool  `yaml:"force"`
+		Purge       bool   `yaml:"purge"`
+		LockTimeout int64  `yaml:"lock_timeout"`
+		Retries     *int32 `yaml:"retries"`
+	}
+	type content struct {
+		*message `yaml:",inline"`
+	}
+
+	ctx := variables.NewContext(context.Background(), variables.Variables{
+		"overwrite": true,
+		"purge":     "yes",
+		"timeout":   uint64(60),
+		"retries":   "3",
+	})
+
+	c := &content{message: &message{}}
+	args := map[string]string{
+		"force":        "{{ overwrite }}",
+		"purge":        "{{ purge }}",
+		"lock_timeout": "{{ timeout }}",
+		"retries":      "{{ retries }}",
+	}
+	if err := ProcessJinjaArgs(ctx, c, args); err != nil {
+		t.Fatal(err)
+	}
+
+	force := true
+	retries := int32(3)
+	expected := &message{
+		Force:       &force,
+		Purge:       true,
+		LockTimeout: 60,
+		Retries:     &retries,
+	}
+	if diff := cmp.Diff(expected, c.message); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProcessJinjaArgsErrors(t *testing.T) {
+	type message struct {
+		Force   *bool `yaml:"force"`
+		Retries int32 `yaml:"retries"`
+	}
+
+	ctx := variables.NewContext(context.Background(), variables.Variables{
+		"maybe": "perhaps",
+		"big":   uint64(1 << 40),
+		"half":  1.5,
+	})
+
+	tests := []struct {
+		name string
+		args map[string]string
+	}{
+		{name: "ambiguous boolean", args: map[string]string{"force": "{{ maybe }}"}},
+		{name: "out of range", args: map[string]string{"retries": "{{ big }}"}},
+		{name: "not an integer", args: map[string]string{"retries": "{{ half }}"}},
+		{name: "unknown argument", args: map[string]string{"nope": "{{ maybe }}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ProcessJinjaArgs(ctx, &message{}, tt.args); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRenderJinjaNativeScoped(t *testing.T) {
+	if _, err := renderJinjaNative("{{ secret_x == 5 }}", gonjaexec.NewContext(map[string]any{"secret_x": 5})); err != nil {
+		t.Fatal(err)
+	}
+
+	// The variables of one render aren't kept for the ones after it.
+	if gonja.DefaultEnvironment.Context.Has("secret_x") {
+		t.Error("variables leaked into gonja's default environment")
+	}
+	ok, err := JinjaProcessWhen(context.Background(), "secret_x is defined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expected secret_x to be undefined in a later render")
+	}
+
+	// Global functions are still available.
+	got, err := renderJinjaNative("{{ range(3) | list }}", gonjaexec.EmptyContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]any{0, 1, 2}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
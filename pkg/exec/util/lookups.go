@@ -0,0 +1,120 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	gonjaexec "github.com/nikolalohinski/gonja/v2/exec"
+
+	"github.com/mickael-carl/sophons/pkg/lookup"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+// JinjaContext returns the context to render Jinja templates with: the
+// variables carried by ctx, and the `lookup()`, `query()` and `q()` functions
+// calling the lookup plugins with ctx.
+func JinjaContext(ctx context.Context) *gonjaexec.Context {
+	vars, ok := variables.FromContext(ctx)
+	if !ok {
+		vars = variables.Variables{}
+	}
+
+	// The variables are copied so that the functions aren't added to them.
+	data := maps.Clone(vars)
+	data["lookup"] = lookupFunction(ctx, false)
+	data["query"] = lookupFunction(ctx, true)
+	data["q"] = lookupFunction(ctx, true)
+	return gonjaexec.NewContext(data)
+}
+
+// lookupFunction returns a Jinja function calling the lookup plugin named by
+// its first argument with the other ones. Like in Ansible, `query()` always
+// returns a list while `lookup()` joins the values found with commas, unless
+// called with `wantlist=True`. Errors are ignored with `errors='ignore'` or
+// `errors='warn'`.
+func lookupFunction(ctx context.Context, wantList bool) func(*gonjaexec.VarArgs) (any, error) {
+	return func(params *gonjaexec.VarArgs) (any, error) {
+		if len(params.Args) == 0 || !params.Args[0].IsString() {
+			return nil, gonjaexec.ErrInvalidCall(errors.New("expected the name of a lookup as first argument"))
+		}
+		name := params.Args[0].String()
+
+		l, ok := lookup.Registry[name]
+		if !ok {
+			return nil, fmt.Errorf("lookup plugin %q not found", name)
+		}
+
+		terms := make([]any, 0, len(params.Args)-1)
+		for _, arg := range params.Args[1:] {
+			term, err := goValue(arg)
+			if err != nil {
+				return nil, fmt.Errorf("lookup %s: %w", name, err)
+			}
+			terms = append(terms, term)
+		}
+
+		list := wantList
+		errorsMode := "strict"
+		kwargs := map[string]any{}
+		for k, v := range params.KwArgs {
+			switch k {
+			case "wantlist":
+				list = list || v.Bool()
+			case "errors":
+				errorsMode = v.String()
+			default:
+				kwarg, err := goValue(v)
+				if err != nil {
+					return nil, fmt.Errorf("lookup %s: %w", name, err)
+				}
+				kwargs[k] = kwarg
+			}
+		}
+
+		switch errorsMode {
+		case "strict", "warn", "ignore":
+		default:
+			return nil, fmt.Errorf("lookup %s: errors must be one of strict, warn or ignore, got %q", name, errorsMode)
+		}
+
+		values, err := l(ctx, terms, kwargs)
+		if err != nil {
+			if errorsMode == "strict" {
+				return nil, fmt.Errorf("lookup %s: %w", name, err)
+			}
+			if list {
+				return []any{}, nil
+			}
+			return nil, nil
+		}
+
+		if list {
+			if values == nil {
+				values = []any{}
+			}
+			return values, nil
+		}
+		return joinLookupValues(values), nil
+	}
+}
+
+// joinLookupValues joins the values found by a lookup with commas, as Ansible
+// does for `lookup()`. If they aren't all strings, a single value is returned
+// as-is, and several as a list.
+func joinLookupValues(values []any) any {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			if len(values) == 1 {
+				return values[0]
+			}
+			return values
+		}
+		strs = append(strs, s)
+	}
+	return strings.Join(strs, ",")
+}
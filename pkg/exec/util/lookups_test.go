@@ -0,0 +1,67 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mickael-carl/sophons/pkg/lookup"
+	"github.com/mickael-carl/sophons/pkg/variables"
+)
+
+func TestJinjaContextLookups(t *testing.T) {
+	lookup.Register("test_terms", func(_ context.Context, terms []any, kwargs map[string]any) ([]any, error) {
+		if fail, _ := kwargs["fail"].(bool); fail {
+			return nil, errors.New("failed")
+		}
+		return terms, nil
+	})
+	t.Cleanup(func() { delete(lookup.Registry, "test_terms") })
+
+	vars := variables.Variables{"name": "b"}
+	ctx := variables.NewContext(context.Background(), vars)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+		wantErr  bool
+	}{
+		{name: "lookup joins values", input: "{{ lookup('test_terms', 'a', name) }}", expected: "a,b"},
+		{name: "lookup single value", input: "{{ lookup('test_terms', 'a') }}", expected: "a"},
+		{name: "lookup non-string value", input: "{{ lookup('test_terms', [1, 2]) }}", expected: []any{1, 2}},
+		{name: "lookup wantlist", input: "{{ lookup('test_terms', 'a', wantlist=True) }}", expected: []any{"a"}},
+		{name: "query", input: "{{ query('test_terms', 'a', 'b') }}", expected: []any{"a", "b"}},
+		{name: "q", input: "{{ q('test_terms', 'a') }}", expected: []any{"a"}},
+		{name: "query nothing", input: "{{ query('test_terms') }}", expected: []any{}},
+		{name: "lookup error", input: "{{ lookup('test_terms', 'a', fail=True) }}", wantErr: true},
+		{name: "lookup error ignored", input: "{{ lookup('test_terms', 'a', fail=True, errors='ignore') }}", expected: nil},
+		{name: "query error ignored", input: "{{ query('test_terms', 'a', fail=True, errors='warn') }}", expected: []any{}},
+		{name: "invalid errors", input: "{{ lookup('test_terms', 'a', errors='nope') }}", wantErr: true},
+		{name: "unknown lookup", input: "{{ lookup('nope', 'a') }}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJinjaNative(tt.input, JinjaContext(ctx))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if diff := cmp.Diff(variables.Variables{"name": "b"}, vars); diff != "" {
+		t.Errorf("variables were modified (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,183 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+)
+
+const (
+	cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// shaCryptRounds is the default number of rounds of SHA-crypt, which
+	// isn't written out in hashes.
+	shaCryptRounds    = 5000
+	shaCryptMaxSalt   = 16
+	shaCryptMinRounds = 1000
+	shaCryptMaxRounds = 999999999
+)
+
+// shaCryptScheme is a variant of SHA-crypt, as used in /etc/shadow.
+type shaCryptScheme struct {
+	id      string
+	newHash func() hash.Hash
+	// order is the order the bytes of the digest are encoded in, by groups
+	// of three.
+	order [][3]int
+	// encodeTail encodes the bytes of the digest left out by order.
+	encodeTail func(digest []byte) string
+}
+
+var shaCryptSchemes = map[string]shaCryptScheme{
+	"sha256": {
+		id:      "5",
+		newHash: sha256.New,
+		order: [][3]int{
+			{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+			{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+		},
+		encodeTail: func(d []byte) string {
+			return cryptBase64(0, d[31], d[30], 3)
+		},
+	},
+	"sha512": {
+		id:      "6",
+		newHash: sha512.New,
+		order: [][3]int{
+			{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+			{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+			{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+			{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+			{62, 20, 41},
+		},
+		encodeTail: func(d []byte) string {
+			return cryptBase64(0, 0, d[63], 2)
+		},
+	},
+}
+
+// shaCrypt hashes password with SHA-crypt, as crypt(3) does for `$5$` and
+// `$6$` hashes. A random salt is generated if salt is empty, and rounds
+// defaults to 5000 if it's 0.
+func shaCrypt(scheme, password, salt string, rounds int) (string, error) {
+	s, ok := shaCryptSchemes[scheme]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash type %q", scheme)
+	}
+
+	if salt == "" {
+		var err error
+		if salt, err = cryptSalt(shaCryptMaxSalt); err != nil {
+			return "", err
+		}
+	}
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+
+	prefix := "$" + s.id + "$"
+	if rounds == 0 {
+		rounds = shaCryptRounds
+	} else {
+		rounds = min(max(rounds, shaCryptMinRounds), shaCryptMaxRounds)
+		prefix += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+
+	pw, sb := []byte(password), []byte(salt)
+
+	h := s.newHash()
+	h.Write(pw)
+	h.Write(sb)
+	h.Write(pw)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(pw)
+	h.Write(sb)
+	h.Write(repeatBytes(b, len(pw)))
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(pw)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for range len(pw) {
+		h.Write(pw)
+	}
+	p := repeatBytes(h.Sum(nil), len(pw))
+
+	h.Reset()
+	for range 16 + int(a[0]) {
+		h.Write(sb)
+	}
+	ds := repeatBytes(h.Sum(nil), len(sb))
+
+	c := a
+	for i := range rounds {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(ds)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := prefix + salt + "$"
+	for _, o := range s.order {
+		out += cryptBase64(c[o[0]], c[o[1]], c[o[2]], 4)
+	}
+	return out + s.encodeTail(c), nil
+}
+
+// repeatBytes repeats b up to n bytes.
+func repeatBytes(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+	return out
+}
+
+// cryptBase64 encodes three bytes into n characters of crypt's base64.
+func cryptBase64(b2, b1, b0 byte, n int) string {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	out := make([]byte, n)
+	for i := range n {
+		out[i] = cryptAlphabet[w&0x3f]
+		w >>= 6
+	}
+	return string(out)
+}
+
+// cryptSalt returns a random salt of n characters.
+func cryptSalt(n int) (string, error) {
+	out := make([]byte, n)
+	for i := range out {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(len(cryptAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate salt: %w", err)
+		}
+		out[i] = cryptAlphabet[j.Int64()]
+	}
+	return string(out), nil
+}
@@ -15,6 +15,18 @@ func GetStringSlice(i any) []string {
 	if slice, ok := i.([]string); ok {
 		return slice
 	}
+	// Lists rendered natively hold their elements as any.
+	if slice, ok := i.([]any); ok {
+		strs := make([]string, 0, len(slice))
+		for _, elem := range slice {
+			str, ok := elem.(string)
+			if !ok {
+				return nil
+			}
+			strs = append(strs, str)
+		}
+		return strs
+	}
 	return nil
 }
 
@@ -0,0 +1,382 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/mickael-carl/sophons/pkg/exec/util"
+)
+
+// writeOptions are the options of writeFile, which modules writing files
+// expose as `backup` and `unsafe_writes`.
+type writeOptions struct {
+	// Backup keeps a timestamped copy of the file replaced.
+	Backup bool
+	// UnsafeWrites lets the file be written in place when it can't be
+	// replaced atomically, e.g. when it's bind mounted.
+	UnsafeWrites bool
+	// Validate, if set, checks the file written before it replaces the one
+	// in place, which is left alone if it fails.
+	Validate func(path string) error
+	// Mode, if set, is the mode of the file written, either in octal or in
+	// symbolic form.
+	Mode string
+	// UID and GID own the file written. Like with os.Chown, -1 leaves them
+	// alone.
+	UID, GID int
+}
+
+// writeFile writes what r reads to dest, atomically, like Ansible does: to a
+// temporary file next to dest, synced to disk, with the mode, ownership and
+// extended attributes of the file it replaces, then the ones requested,
+// validated if need be, and then renamed over it. Readers see either the old or the new file, never a part of
+// it. It returns the path of the backup, if one was made.
+func writeFile(dest string, r io.Reader, opts writeOptions) (string, error) {
+	info, err := os.Lstat(dest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	// A link is replaced, rather than what it points to.
+	existing := err == nil && info.Mode().IsRegular()
+
+	pattern := "." + filepath.Base(dest) + ".*.tmp"
+	tmp, err := os.CreateTemp(filepath.Dir(dest), pattern)
+	if err != nil && opts.UnsafeWrites && unsafeWriteAllowed(err) {
+		// The file is still written to a temporary file first, for it to be
+		// validated, and then in place as renaming it over dest fails.
+		tmp, err = os.CreateTemp("", pattern)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create a temporary file for %s: %w", dest, err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to sync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if existing {
+		err = copyAttributes(dest, tmp.Name(), info)
+	} else {
+		// Temporary files are only readable by their owner: new files get
+		// the usual mode instead.
+		err = os.Chmod(tmp.Name(), 0o666&^processUmask)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to set the attributes of %s: %w", tmp.Name(), err)
+	}
+	if err := opts.applyModeAndIDs(tmp.Name()); err != nil {
+		return "", err
+	}
+
+	if opts.Validate != nil {
+		if err := opts.Validate(tmp.Name()); err != nil {
+			return "", err
+		}
+	}
+
+	var backup string
+	if existing && opts.Backup {
+		if backup, err = backupFile(dest); err != nil {
+			return "", fmt.Errorf("failed to back up %s: %w", dest, err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		if opts.UnsafeWrites && unsafeWriteAllowed(err) {
+			f, err := os.Open(tmp.Name())
+			if err != nil {
+				return backup, err
+			}
+			defer f.Close()
+			if err := writeInPlace(dest, f); err != nil {
+				return backup, err
+			}
+			return backup, opts.applyModeAndIDs(dest)
+		}
+		return backup, fmt.Errorf("failed to replace %s: %w", dest, err)
+	}
+
+	return backup, syncDir(filepath.Dir(dest))
+}
+
+// applyModeAndIDs gives the file at path the mode and ownership requested,
+// if any.
+func (o writeOptions) applyModeAndIDs(path string) error {
+	if o.Mode == "" && o.UID == -1 && o.GID == -1 {
+		return nil
+	}
+	if err := util.ApplyModeAndIDs(path, o.Mode, o.UID, o.GID); err != nil {
+		return fmt.Errorf("failed to apply mode and IDs to %s: %w", path, err)
+	}
+	return nil
+}
+
+// fileValidator returns a function running validate, the command copy and
+// template check files with, on a file: `%s` in it is replaced with its path.
+// It returns nil if validate is empty.
+func fileValidator(ctx context.Context, validate string) (func(path string) error, error) {
+	if validate == "" {
+		return nil, nil
+	}
+
+	factory, err := taskCmdFactory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateCommand(validate); err != nil {
+		return nil, err
+	}
+	argv, err := splitShellWords(validate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validate command: %w", err)
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("validate command is empty")
+	}
+
+	return func(path string) error {
+		argv := slices.Clone(argv)
+		for i, arg := range argv {
+			argv[i] = strings.ReplaceAll(arg, "%s", path)
+		}
+
+		_, stderr, _, err := ApplyCommand(factory, "", "", nil, argv[0], argv[1:])
+		if err != nil {
+			if stderr = strings.TrimSpace(stderr); stderr != "" {
+				return fmt.Errorf("failed to validate: %w: %s", err, stderr)
+			}
+			return fmt.Errorf("failed to validate: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// splitShellWords splits s into words like a POSIX shell would, without
+// expanding anything: words are separated by unquoted whitespace, single
+// quotes keep everything up to the next one as is, and backslashes escape the
+// next character, or, within double quotes, one of `$`, "`", `"`, `\` and a
+// newline.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+			case r == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]):
+				i++
+				if runes[i] != '\n' {
+					word.WriteRune(runes[i])
+				}
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			if i+1 == len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// validateCommand checks validate, the command copy and template check files
+// with.
+func validateCommand(validate string) error {
+	if validate != "" && !strings.Contains(validate, "%s") {
+		return fmt.Errorf("validate must contain %%s: %s", validate)
+	}
+	return nil
+}
+
+// unsafeWriteAllowed tells whether err is one of the errors Ansible falls
+// back to writing in place for, with `unsafe_writes`.
+func unsafeWriteAllowed(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EPERM, syscall.EACCES, syscall.EXDEV, syscall.EBUSY, syscall.ETXTBSY} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeInPlace writes what r reads to dest, truncating it. Readers might see
+// a part of it only.
+func writeInPlace(dest string, r io.Reader) error {
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", dest, err)
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync %s: %w", dest, err)
+	}
+	return f.Close()
+}
+
+// copyAttributes gives the file at dst the mode, ownership and extended
+// attributes of the one at src, described by info.
+func copyAttributes(src, dst string, info fs.FileInfo) error {
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		// Only root can give files away: otherwise, they belong to whoever
+		// writes them, like Ansible does.
+		if err := os.Chown(dst, int(st.Uid), int(st.Gid)); err != nil && !errors.Is(err, syscall.EPERM) {
+			return err
+		}
+	}
+
+	return copyXattrs(src, dst)
+}
+
+// copyXattrs copies the extended attributes of the file at src to the one at
+// dst, on file systems supporting them.
+func copyXattrs(src, dst string) error {
+	size, err := unix.Listxattr(src, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	names := make([]byte, size)
+	if size, err = unix.Listxattr(src, names); err != nil {
+		return err
+	}
+
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		size, err := unix.Getxattr(src, string(name), nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Getxattr(src, string(name), value); err != nil {
+			return err
+		}
+
+		// Some attributes can't be set by everyone, e.g. `security.*` ones:
+		// they're left out rather than failing the write.
+		if err := unix.Setxattr(dst, string(name), value[:size], 0); err != nil && !errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("failed to set extended attribute %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// processUmask is the executer's umask. It can only be read by setting it, so
+// it's read once, before any file gets created, rather than changing it while
+// other goroutines may be creating files.
+var processUmask = func() os.FileMode {
+	umask := unix.Umask(0)
+	unix.Umask(umask)
+	return os.FileMode(umask)
+}()
+
+// syncDir syncs the directory at path, for a rename in it to be on disk.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Not all file systems support syncing directories.
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return nil
+}
+
+// backupFile copies the file at path next to it, with its mode, ownership and
+// times, as Ansible does with `backup: yes`. It returns the path of the copy.
+func backupFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	backup := fmt.Sprintf("%s.%d.%s~", path, os.Getpid(), time.Now().Format("2006-01-02@15:04:05"))
+	if err := copySingleFile(path, backup); err != nil {
+		return "", err
+	}
+
+	if err := copyAttributes(path, backup, info); err != nil {
+		return "", err
+	}
+	return backup, os.Chtimes(backup, time.Now(), info.ModTime())
+}
@@ -0,0 +1,232 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dest string)
+		opts  writeOptions
+		// files describes the files expected in the directory of dest, as
+		// snapshotDir does, backups aside.
+		files      map[string]string
+		wantBackup bool
+	}{
+		{
+			name:  "new file",
+			opts:  writeOptions{UID: -1, GID: -1},
+			files: map[string]string{"dest": "-rw-r--r-- new"},
+		},
+		{
+			name: "existing file keeps its mode",
+			setup: func(t *testing.T, dest string) {
+				if err := os.WriteFile(dest, []byte("old"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts:  writeOptions{UID: -1, GID: -1},
+			files: map[string]string{"dest": "-rw------- new"},
+		},
+		{
+			name: "link is replaced",
+			setup: func(t *testing.T, dest string) {
+				target := filepath.Join(filepath.Dir(dest), "target")
+				if err := os.WriteFile(target, []byte("target"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(target, dest); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts: writeOptions{UID: -1, GID: -1},
+			files: map[string]string{
+				"dest":   "-rw-r--r-- new",
+				"target": "-rw-r--r-- target",
+			},
+		},
+		{
+			name: "backup",
+			setup: func(t *testing.T, dest string) {
+				if err := os.WriteFile(dest, []byte("old"), 0o640); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts:       writeOptions{Backup: true, UID: -1, GID: -1},
+			files:      map[string]string{"dest": "-rw-r----- new"},
+			wantBackup: true,
+		},
+		{
+			name:  "no backup of a new file",
+			opts:  writeOptions{Backup: true, UID: -1, GID: -1},
+			files: map[string]string{"dest": "-rw-r--r-- new"},
+		},
+		{
+			name: "mode set before validating",
+			setup: func(t *testing.T, dest string) {
+				if err := os.WriteFile(dest, []byte("old"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts: writeOptions{
+				Mode: "0600",
+				UID:  -1,
+				GID:  -1,
+				Validate: func(path string) error {
+					info, err := os.Stat(path)
+					if err != nil {
+						return err
+					}
+					if info.Mode().Perm() != 0o600 {
+						return fmt.Errorf("validated %s with mode %o", path, info.Mode().Perm())
+					}
+					return nil
+				},
+			},
+			files: map[string]string{"dest": "-rw------- new"},
+		},
+	}
+
+	oldUmask := unix.Umask(0o022)
+	defer unix.Umask(oldUmask)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dest := filepath.Join(dir, "dest")
+			if tt.setup != nil {
+				tt.setup(t, dest)
+			}
+
+			backup, err := writeFile(dest, strings.NewReader("new"), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantBackup {
+				content, err := os.ReadFile(backup)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != "old" || !strings.HasPrefix(backup, dest+".") || !strings.HasSuffix(backup, "~") {
+					t.Errorf("got backup %s with %q", backup, content)
+				}
+				if err := os.Remove(backup); err != nil {
+					t.Fatal(err)
+				}
+			} else if backup != "" {
+				t.Errorf("got unexpected backup %s", backup)
+			}
+
+			want := map[string]string{}
+			for name, desc := range tt.files {
+				want[filepath.Join(dir, name)] = desc
+			}
+			got := snapshotDir(t, dir)
+			delete(got, dir)
+			// No temporary file is left behind.
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("files mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteFileXattrs(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "dest")
+	if err := os.WriteFile(dest, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(dest, "user.sophons", []byte("kept"), 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			t.Skip("extended attributes aren't supported")
+		}
+		t.Fatal(err)
+	}
+
+	if _, err := writeFile(dest, strings.NewReader("new"), writeOptions{UID: -1, GID: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	value := make([]byte, 16)
+	size, err := unix.Getxattr(dest, "user.sophons", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(value[:size]); got != "kept" {
+		t.Errorf("got extended attribute %q, want %q", got, "kept")
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+		wantErr  bool
+	}{
+		{in: "visudo -cf %s", expected: []string{"visudo", "-cf", "%s"}},
+		{in: "sh -c 'visudo -cf %s'", expected: []string{"sh", "-c", "visudo -cf %s"}},
+		{in: `nginx -t -c "%s" -g 'pid /tmp/x;'`, expected: []string{"nginx", "-t", "-c", "%s", "-g", "pid /tmp/x;"}},
+		{in: `echo "a \"b\" \c" d\ e ''`, expected: []string{"echo", `a "b" \c`, "d e", ""}},
+		{in: "  spaced\t out  ", expected: []string{"spaced", "out"}},
+		{in: "sh -c 'unterminated %s", wantErr: true},
+		{in: `trailing \`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := splitShellWords(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFileValidator(t *testing.T) {
+	var argv []string
+	ctrl := gomock.NewController(t)
+	m := NewMockcommandExecutor(ctrl)
+	m.EXPECT().SetStdout(gomock.Any())
+	m.EXPECT().SetStderr(gomock.Any())
+	m.EXPECT().Run().Return(nil)
+	ctx := context.WithValue(context.Background(), commandFactoryContextKey, cmdFactory(func(name string, args ...string) commandExecutor {
+		argv = append([]string{name}, args...)
+		return m
+	}))
+
+	validator, err := fileValidator(ctx, "sh -c 'visudo -cf %s'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validator("/tmp/sudoers"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"sh", "-c", "visudo -cf /tmp/sudoers"}, argv); diff != "" {
+		t.Errorf("command mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := fileValidator(ctx, "visudo -c"); err == nil {
+		t.Errorf("expected an error for a command without %%s")
+	}
+}
@@ -0,0 +1,62 @@
+package lookup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// Lookup is a lookup plugin, as called with `lookup('name', terms...)` in
+// Jinja templates. It returns the values found for terms, e.g. the contents of
+// the files named by terms for `file`.
+type Lookup func(ctx context.Context, terms []any, kwargs map[string]any) ([]any, error)
+
+// Registry maps lookup names (e.g., "file", "ansible.builtin.file") to lookup
+// plugins.
+var Registry = map[string]Lookup{}
+
+// Register registers a lookup plugin, for Jinja templates to call it.
+func Register(name string, l Lookup) {
+	Registry[name] = l
+}
+
+type key int
+
+var parentPathKey key
+
+// NewContext returns a new context carrying the path of the role or playbook
+// the task being run belongs to, for lookups to find files relative to it.
+func NewContext(ctx context.Context, parentPath string) context.Context {
+	return context.WithValue(ctx, parentPathKey, parentPath)
+}
+
+// ParentPathFromContext returns the path of the role or playbook carried by
+// ctx, or an empty one.
+func ParentPathFromContext(ctx context.Context) string {
+	parentPath, _ := ctx.Value(parentPathKey).(string)
+	return parentPath
+}
+
+// FindFile looks name up in the subdir directory of the role or playbook
+// carried by ctx first, e.g. `files` or `templates`, then in the role or
+// playbook's directory itself. Absolute names are returned as-is. It returns
+// an error wrapping os.ErrNotExist if name can't be found.
+func FindFile(ctx context.Context, subdir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+
+	parentPath := ParentPathFromContext(ctx)
+	for _, dir := range []string{filepath.Join(parentPath, subdir), parentPath} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return filepath.Abs(path)
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", &os.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+}
@@ -1,6 +1,10 @@
 package playbook
 
 import (
+	"errors"
+
+	"github.com/goccy/go-yaml"
+
 	"github.com/mickael-carl/sophons/pkg/proto"
 	"github.com/mickael-carl/sophons/pkg/variables"
 )
@@ -8,9 +12,67 @@ import (
 type Playbook []Play
 
 type Play struct {
-	Hosts     string `yaml:"hosts"`
-	Roles     []string
-	Tasks     []*proto.Task
-	Vars      variables.Variables
-	VarsFiles []string `yaml:"vars_files"`
+	Name         string `yaml:"name"`
+	Hosts        string `yaml:"hosts"`
+	Roles        []PlayRole
+	Tasks        []*proto.Task
+	Handlers     []*proto.Task
+	Vars         variables.Variables
+	VarsFiles    []string `yaml:"vars_files"`
+	Become       *bool    `yaml:"become"`
+	BecomeUser   string   `yaml:"become_user"`
+	BecomeMethod string   `yaml:"become_method"`
+	// Environment sets environment variables for the commands the play's
+	// roles and tasks run.
+	Environment any `yaml:"environment"`
+	// Tags are inherited by the play's roles and tasks.
+	Tags Tags `yaml:"tags"`
+}
+
+// PlayRole is a role a play applies. It's either given by name, or as a dict
+// with its name in `role` along with keywords applying to it.
+type PlayRole struct {
+	Role         string `yaml:"role"`
+	Environment  any    `yaml:"environment"`
+	Tags         Tags   `yaml:"tags"`
+	Become       *bool  `yaml:"become"`
+	BecomeUser   string `yaml:"become_user"`
+	BecomeMethod string `yaml:"become_method"`
+}
+
+// Tags are the tags of a play or a role, given either as a list or as a
+// string where they're separated by commas.
+type Tags []string
+
+func (t *Tags) UnmarshalYAML(b []byte) error {
+	var raw any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	tags, err := proto.Tags(raw)
+	if err != nil {
+		return err
+	}
+	*t = tags
+	return nil
+}
+
+func (r *PlayRole) UnmarshalYAML(b []byte) error {
+	var name string
+	if err := yaml.Unmarshal(b, &name); err == nil {
+		r.Role = name
+		return nil
+	}
+
+	type plain PlayRole
+	var role plain
+	if err := yaml.Unmarshal(b, &role); err != nil {
+		return err
+	}
+	if role.Role == "" {
+		return errors.New("role is required")
+	}
+	*r = PlayRole(role)
+	return nil
 }
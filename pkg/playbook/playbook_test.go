@@ -28,11 +28,21 @@ func TestPlaybookUnmarshalYAML(t *testing.T) {
      - file:
          path: /foo/bar
          state: file
+       notify: remove bar
+   handlers:
+     - name: remove bar
+       file:
+         path: /foo/bar
+         state: absent
  - hosts: some-group
+   become: true
+   become_user: postgres
+   become_method: su
    tasks:
      - ansible.builtin.file:
          path: /foo/bar/baz
          state: touch
+       become: false
      - ansible.builtin.file:
          path: /foo/bar
          state: directory
@@ -59,6 +69,7 @@ func TestPlaybookUnmarshalYAML(t *testing.T) {
 	}
 
 	pTrue := true
+	pFalse := false
 
 	expected := Playbook{
 		Play{
@@ -84,11 +95,26 @@ func TestPlaybookUnmarshalYAML(t *testing.T) {
 							State: exec.FileFile,
 						},
 					},
+					Notify: []string{"remove bar"},
+				},
+			},
+			Handlers: []*proto.Task{
+				{
+					Name: "remove bar",
+					Content: &proto.Task_File{
+						File: &proto.File{
+							Path:  "/foo/bar",
+							State: exec.FileAbsent,
+						},
+					},
 				},
 			},
 		},
 		Play{
-			Hosts: "some-group",
+			Hosts:        "some-group",
+			Become:       &pTrue,
+			BecomeUser:   "postgres",
+			BecomeMethod: "su",
 			Tasks: []*proto.Task{
 				{
 					Content: &proto.Task_File{
@@ -97,6 +123,7 @@ func TestPlaybookUnmarshalYAML(t *testing.T) {
 							State: exec.FileTouch,
 						},
 					},
+					Become: &pFalse,
 				},
 				{
 					Content: &proto.Task_File{
@@ -140,3 +167,111 @@ func TestPlaybookUnmarshalYAML(t *testing.T) {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestPlaybookUnmarshalYAMLRolesAndEnvironment(t *testing.T) {
+	b := []byte(`
+- hosts: all
+  environment:
+    http_proxy: http://proxy:3128
+  roles:
+    - common
+    - role: web
+      environment: "{{ web_env }}"
+`)
+
+	var got Playbook
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Playbook{
+		Play{
+			Hosts:       "all",
+			Environment: map[string]any{"http_proxy": "http://proxy:3128"},
+			Roles: []PlayRole{
+				{Role: "common"},
+				{Role: "web", Environment: "{{ web_env }}"},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	var invalid Playbook
+	if err := yaml.Unmarshal([]byte("- roles:\n    - environment: {}\n"), &invalid); err == nil {
+		t.Error("expected an error for a role without a name")
+	}
+}
+
+func TestPlaybookUnmarshalYAMLTags(t *testing.T) {
+	b := []byte(`
+- hosts: all
+  tags: site
+  roles:
+    - role: web
+      tags:
+        - nginx
+        - web
+    - role: db
+      tags: "db, postgres"
+`)
+
+	var got Playbook
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Playbook{
+		Play{
+			Hosts: "all",
+			Tags:  Tags{"site"},
+			Roles: []PlayRole{
+				{Role: "web", Tags: Tags{"nginx", "web"}},
+				{Role: "db", Tags: Tags{"db", "postgres"}},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	var invalid Playbook
+	if err := yaml.Unmarshal([]byte("- tags: {}\n"), &invalid); err == nil {
+		t.Error("expected an error for tags that aren't a string or a list")
+	}
+}
+
+func TestPlaybookUnmarshalYAMLRoleBecome(t *testing.T) {
+	b := []byte(`
+- hosts: all
+  roles:
+    - role: db
+      become: true
+      become_user: postgres
+      become_method: su
+    - web
+`)
+
+	var got Playbook
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	become := true
+	expected := Playbook{
+		Play{
+			Hosts: "all",
+			Roles: []PlayRole{
+				{Role: "db", Become: &become, BecomeUser: "postgres", BecomeMethod: "su"},
+				{Role: "web"},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
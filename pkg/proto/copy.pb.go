@@ -26,34 +26,34 @@ type Copy struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// @inject_tag: yaml:"content" sophons:"implemented"
 	Content string `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty" yaml:"content" sophons:"implemented"`
-	// @inject_tag: yaml:"remote_src"
-	RemoteSrc bool `protobuf:"varint,2,opt,name=remote_src,json=remoteSrc,proto3" json:"remote_src,omitempty" yaml:"remote_src"`
+	// @inject_tag: yaml:"remote_src" sophons:"implemented"
+	RemoteSrc bool `protobuf:"varint,2,opt,name=remote_src,json=remoteSrc,proto3" json:"remote_src,omitempty" yaml:"remote_src" sophons:"implemented"`
 	// @inject_tag: yaml:"src" sophons:"implemented"
 	Src string `protobuf:"bytes,3,opt,name=src,proto3" json:"src,omitempty" yaml:"src" sophons:"implemented"`
 	// @inject_tag: yaml:"dest" sophons:"implemented"
 	Dest string `protobuf:"bytes,4,opt,name=dest,proto3" json:"dest,omitempty" yaml:"dest" sophons:"implemented"`
 	// @inject_tag: yaml:"attributes"
 	Attributes string `protobuf:"bytes,5,opt,name=attributes,proto3" json:"attributes,omitempty" yaml:"attributes"`
-	// @inject_tag: yaml:"backup"
-	Backup bool `protobuf:"varint,6,opt,name=backup,proto3" json:"backup,omitempty" yaml:"backup"`
-	// @inject_tag: yaml:"checksum"
-	Checksum string `protobuf:"bytes,7,opt,name=checksum,proto3" json:"checksum,omitempty" yaml:"checksum"`
+	// @inject_tag: yaml:"backup" sophons:"implemented"
+	Backup bool `protobuf:"varint,6,opt,name=backup,proto3" json:"backup,omitempty" yaml:"backup" sophons:"implemented"`
+	// @inject_tag: yaml:"checksum" sophons:"implemented"
+	Checksum string `protobuf:"bytes,7,opt,name=checksum,proto3" json:"checksum,omitempty" yaml:"checksum" sophons:"implemented"`
 	// @inject_tag: yaml:"decrypt"
 	Decrypt *bool `protobuf:"varint,8,opt,name=decrypt,proto3,oneof" json:"decrypt,omitempty" yaml:"decrypt"`
-	// @inject_tag: yaml:"directory_mode"
-	DirectoryMode string `protobuf:"bytes,9,opt,name=directory_mode,json=directoryMode,proto3" json:"directory_mode,omitempty" yaml:"directory_mode"`
-	// @inject_tag: yaml:"follow"
-	Follow bool `protobuf:"varint,10,opt,name=follow,proto3" json:"follow,omitempty" yaml:"follow"`
-	// @inject_tag: yaml:"force"
-	Force *bool `protobuf:"varint,11,opt,name=force,proto3,oneof" json:"force,omitempty" yaml:"force"`
-	// @inject_tag: yaml:"group"
-	Group string `protobuf:"bytes,12,opt,name=group,proto3" json:"group,omitempty" yaml:"group"`
-	// @inject_tag: yaml:"local_follow"
-	LocalFollow bool `protobuf:"varint,13,opt,name=local_follow,json=localFollow,proto3" json:"local_follow,omitempty" yaml:"local_follow"`
-	// @inject_tag: yaml:"mode"
-	Mode string `protobuf:"bytes,14,opt,name=mode,proto3" json:"mode,omitempty" yaml:"mode"`
-	// @inject_tag: yaml:"owner"
-	Owner string `protobuf:"bytes,15,opt,name=owner,proto3" json:"owner,omitempty" yaml:"owner"`
+	// @inject_tag: yaml:"directory_mode" sophons:"implemented"
+	DirectoryMode *Mode `protobuf:"bytes,9,opt,name=directory_mode,json=directoryMode,proto3" json:"directory_mode,omitempty" yaml:"directory_mode" sophons:"implemented"`
+	// @inject_tag: yaml:"follow" sophons:"implemented"
+	Follow bool `protobuf:"varint,10,opt,name=follow,proto3" json:"follow,omitempty" yaml:"follow" sophons:"implemented"`
+	// @inject_tag: yaml:"force" sophons:"implemented"
+	Force *bool `protobuf:"varint,11,opt,name=force,proto3,oneof" json:"force,omitempty" yaml:"force" sophons:"implemented"`
+	// @inject_tag: yaml:"group" sophons:"implemented"
+	Group string `protobuf:"bytes,12,opt,name=group,proto3" json:"group,omitempty" yaml:"group" sophons:"implemented"`
+	// @inject_tag: yaml:"local_follow" sophons:"implemented"
+	LocalFollow *bool `protobuf:"varint,13,opt,name=local_follow,json=localFollow,proto3,oneof" json:"local_follow,omitempty" yaml:"local_follow" sophons:"implemented"`
+	// @inject_tag: yaml:"mode" sophons:"implemented"
+	Mode *Mode `protobuf:"bytes,14,opt,name=mode,proto3" json:"mode,omitempty" yaml:"mode" sophons:"implemented"`
+	// @inject_tag: yaml:"owner" sophons:"implemented"
+	Owner string `protobuf:"bytes,15,opt,name=owner,proto3" json:"owner,omitempty" yaml:"owner" sophons:"implemented"`
 	// @inject_tag: yaml:"selevel"
 	Selevel string `protobuf:"bytes,16,opt,name=selevel,proto3" json:"selevel,omitempty" yaml:"selevel"`
 	// @inject_tag: yaml:"serole"
@@ -62,10 +62,10 @@ type Copy struct {
 	Setype string `protobuf:"bytes,18,opt,name=setype,proto3" json:"setype,omitempty" yaml:"setype"`
 	// @inject_tag: yaml:"seuser"
 	Seuser string `protobuf:"bytes,19,opt,name=seuser,proto3" json:"seuser,omitempty" yaml:"seuser"`
-	// @inject_tag: yaml:"unsafe_writes"
-	UnsafeWrites bool `protobuf:"varint,20,opt,name=unsafe_writes,json=unsafeWrites,proto3" json:"unsafe_writes,omitempty" yaml:"unsafe_writes"`
-	// @inject_tag: yaml:"validate"
-	Validate      string `protobuf:"bytes,21,opt,name=validate,proto3" json:"validate,omitempty" yaml:"validate"`
+	// @inject_tag: yaml:"unsafe_writes" sophons:"implemented"
+	UnsafeWrites bool `protobuf:"varint,20,opt,name=unsafe_writes,json=unsafeWrites,proto3" json:"unsafe_writes,omitempty" yaml:"unsafe_writes" sophons:"implemented"`
+	// @inject_tag: yaml:"validate" sophons:"implemented"
+	Validate      string `protobuf:"bytes,21,opt,name=validate,proto3" json:"validate,omitempty" yaml:"validate" sophons:"implemented"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -156,11 +156,11 @@ func (x *Copy) GetDecrypt() bool {
 	return false
 }
 
-func (x *Copy) GetDirectoryMode() string {
+func (x *Copy) GetDirectoryMode() *Mode {
 	if x != nil {
 		return x.DirectoryMode
 	}
-	return ""
+	return nil
 }
 
 func (x *Copy) GetFollow() bool {
@@ -185,17 +185,17 @@ func (x *Copy) GetGroup() string {
 }
 
 func (x *Copy) GetLocalFollow() bool {
-	if x != nil {
-		return x.LocalFollow
+	if x != nil && x.LocalFollow != nil {
+		return *x.LocalFollow
 	}
 	return false
 }
 
-func (x *Copy) GetMode() string {
+func (x *Copy) GetMode() *Mode {
 	if x != nil {
 		return x.Mode
 	}
-	return ""
+	return nil
 }
 
 func (x *Copy) GetOwner() string {
@@ -251,7 +251,7 @@ var File_proto_copy_proto protoreflect.FileDescriptor
 
 const file_proto_copy_proto_rawDesc = "" +
 	"\n" +
-	"\x10proto/copy.proto\x12\x05proto\"\xce\x04\n" +
+	"\x10proto/copy.proto\x12\x05proto\x1a\x10proto/mode.proto\"\xfe\x04\n" +
 	"\x04Copy\x12\x18\n" +
 	"\acontent\x18\x01 \x01(\tR\acontent\x12\x1d\n" +
 	"\n" +
@@ -263,14 +263,14 @@ const file_proto_copy_proto_rawDesc = "" +
 	"attributes\x12\x16\n" +
 	"\x06backup\x18\x06 \x01(\bR\x06backup\x12\x1a\n" +
 	"\bchecksum\x18\a \x01(\tR\bchecksum\x12\x1d\n" +
-	"\adecrypt\x18\b \x01(\bH\x00R\adecrypt\x88\x01\x01\x12%\n" +
-	"\x0edirectory_mode\x18\t \x01(\tR\rdirectoryMode\x12\x16\n" +
+	"\adecrypt\x18\b \x01(\bH\x00R\adecrypt\x88\x01\x01\x122\n" +
+	"\x0edirectory_mode\x18\t \x01(\v2\v.proto.ModeR\rdirectoryMode\x12\x16\n" +
 	"\x06follow\x18\n" +
 	" \x01(\bR\x06follow\x12\x19\n" +
 	"\x05force\x18\v \x01(\bH\x01R\x05force\x88\x01\x01\x12\x14\n" +
-	"\x05group\x18\f \x01(\tR\x05group\x12!\n" +
-	"\flocal_follow\x18\r \x01(\bR\vlocalFollow\x12\x12\n" +
-	"\x04mode\x18\x0e \x01(\tR\x04mode\x12\x14\n" +
+	"\x05group\x18\f \x01(\tR\x05group\x12&\n" +
+	"\flocal_follow\x18\r \x01(\bH\x02R\vlocalFollow\x88\x01\x01\x12\x1f\n" +
+	"\x04mode\x18\x0e \x01(\v2\v.proto.ModeR\x04mode\x12\x14\n" +
 	"\x05owner\x18\x0f \x01(\tR\x05owner\x12\x18\n" +
 	"\aselevel\x18\x10 \x01(\tR\aselevel\x12\x16\n" +
 	"\x06serole\x18\x11 \x01(\tR\x06serole\x12\x16\n" +
@@ -280,7 +280,8 @@ const file_proto_copy_proto_rawDesc = "" +
 	"\bvalidate\x18\x15 \x01(\tR\bvalidateB\n" +
 	"\n" +
 	"\b_decryptB\b\n" +
-	"\x06_forceB+Z)github.com/mickael-carl/sophons/pkg/protob\x06proto3"
+	"\x06_forceB\x0f\n" +
+	"\r_local_followB+Z)github.com/mickael-carl/sophons/pkg/protob\x06proto3"
 
 var (
 	file_proto_copy_proto_rawDescOnce sync.Once
@@ -297,13 +298,16 @@ func file_proto_copy_proto_rawDescGZIP() []byte {
 var file_proto_copy_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
 var file_proto_copy_proto_goTypes = []any{
 	(*Copy)(nil), // 0: proto.Copy
+	(*Mode)(nil), // 1: proto.Mode
 }
 var file_proto_copy_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: proto.Copy.directory_mode:type_name -> proto.Mode
+	1, // 1: proto.Copy.mode:type_name -> proto.Mode
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_copy_proto_init() }
@@ -311,6 +315,7 @@ func file_proto_copy_proto_init() {
 	if File_proto_copy_proto != nil {
 		return
 	}
+	file_proto_mode_proto_init()
 	file_proto_copy_proto_msgTypes[0].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -36,8 +36,8 @@ type GetURL struct {
 	Owner string `protobuf:"bytes,5,opt,name=owner,proto3" json:"owner,omitempty" yaml:"owner" sophons:"implemented"`
 	// @inject_tag: yaml:"attributes"
 	Attributes string `protobuf:"bytes,6,opt,name=attributes,proto3" json:"attributes,omitempty" yaml:"attributes"`
-	// @inject_tag: yaml:"backup"
-	Backup bool `protobuf:"varint,7,opt,name=backup,proto3" json:"backup,omitempty" yaml:"backup"`
+	// @inject_tag: yaml:"backup" sophons:"implemented"
+	Backup bool `protobuf:"varint,7,opt,name=backup,proto3" json:"backup,omitempty" yaml:"backup" sophons:"implemented"`
 	// @inject_tag: yaml:"checksum"
 	Checksum string `protobuf:"bytes,8,opt,name=checksum,proto3" json:"checksum,omitempty" yaml:"checksum"`
 	// @inject_tag: yaml:"ciphers"
@@ -68,8 +68,8 @@ type GetURL struct {
 	TmpDest string `protobuf:"bytes,21,opt,name=tmp_dest,json=tmpDest,proto3" json:"tmp_dest,omitempty" yaml:"tmp_dest"`
 	// @inject_tag: yaml:"unredirected_headers"
 	UnredirectedHeaders []string `protobuf:"bytes,22,rep,name=unredirected_headers,json=unredirectedHeaders,proto3" json:"unredirected_headers,omitempty" yaml:"unredirected_headers"`
-	// @inject_tag: yaml:"unsafe_writes"
-	UnsafeWrites bool `protobuf:"varint,23,opt,name=unsafe_writes,json=unsafeWrites,proto3" json:"unsafe_writes,omitempty" yaml:"unsafe_writes"`
+	// @inject_tag: yaml:"unsafe_writes" sophons:"implemented"
+	UnsafeWrites bool `protobuf:"varint,23,opt,name=unsafe_writes,json=unsafeWrites,proto3" json:"unsafe_writes,omitempty" yaml:"unsafe_writes" sophons:"implemented"`
 	// @inject_tag: yaml:"url_password"
 	UrlPassword string `protobuf:"bytes,24,opt,name=url_password,json=urlPassword,proto3" json:"url_password,omitempty" yaml:"url_password"`
 	// @inject_tag: yaml:"url_username"
@@ -0,0 +1,18 @@
+package proto
+
+import (
+	"github.com/goccy/go-yaml"
+)
+
+// UnmarshalYAML handles the action given either as a string, as it usually
+// is (`meta: flush_handlers`), or through free_form.
+func (m *Meta) UnmarshalYAML(b []byte) error {
+	var action string
+	if err := yaml.Unmarshal(b, &action); err == nil {
+		m.FreeForm = action
+		return nil
+	}
+
+	type plain Meta
+	return yaml.Unmarshal(b, (*plain)(m))
+}
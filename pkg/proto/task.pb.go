@@ -27,8 +27,9 @@ type Task struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// @inject_tag: yaml:"name"
 	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty" yaml:"name"`
+	// When runs the task only if all conditions hold.
 	// @inject_tag: yaml:"when"
-	When string `protobuf:"bytes,2,opt,name=when,proto3" json:"when,omitempty" yaml:"when"`
+	When []string `protobuf:"bytes,2,rep,name=when,proto3" json:"when,omitempty" yaml:"when"`
 	// Loop can be a string (Jinja template), array of strings, or array of objects
 	// @inject_tag: yaml:"loop"
 	Loop *structpb.Value `protobuf:"bytes,3,opt,name=loop,proto3" json:"loop,omitempty" yaml:"loop"`
@@ -46,7 +47,72 @@ type Task struct {
 	//	*Task_IncludeTasks
 	//	*Task_Shell
 	//	*Task_Template
-	Content       isTask_Content `protobuf_oneof:"content"`
+	//	*Task_Meta
+	//	*Task_Block
+	Content isTask_Content `protobuf_oneof:"content"`
+	// Become runs the task as become_user rather than as the connecting user.
+	// When unset, the play's setting applies.
+	// @inject_tag: yaml:"become"
+	Become *bool `protobuf:"varint,15,opt,name=become,proto3,oneof" json:"become,omitempty" yaml:"become"`
+	// @inject_tag: yaml:"become_user"
+	BecomeUser string `protobuf:"bytes,16,opt,name=become_user,json=becomeUser,proto3" json:"become_user,omitempty" yaml:"become_user"`
+	// @inject_tag: yaml:"become_method"
+	BecomeMethod string `protobuf:"bytes,17,opt,name=become_method,json=becomeMethod,proto3" json:"become_method,omitempty" yaml:"become_method"`
+	// Notify lists the handlers to run when the task reports a change, by name
+	// or by topic.
+	// @inject_tag: yaml:"notify"
+	Notify []string `protobuf:"bytes,18,rep,name=notify,proto3" json:"notify,omitempty" yaml:"notify"`
+	// Listen lists the topics a handler runs for, on top of its name.
+	// @inject_tag: yaml:"listen"
+	Listen []string `protobuf:"bytes,19,rep,name=listen,proto3" json:"listen,omitempty" yaml:"listen"`
+	// Vars are variables only set for this task, or for the tasks of a block.
+	// @inject_tag: yaml:"vars"
+	Vars *structpb.Struct `protobuf:"bytes,22,opt,name=vars,proto3" json:"vars,omitempty" yaml:"vars"`
+	// ChangedWhen overrides whether the task reports a change. All conditions
+	// have to hold.
+	// @inject_tag: yaml:"changed_when"
+	ChangedWhen []string `protobuf:"bytes,23,rep,name=changed_when,json=changedWhen,proto3" json:"changed_when,omitempty" yaml:"changed_when"`
+	// FailedWhen overrides whether the task failed. All conditions have to
+	// hold.
+	// @inject_tag: yaml:"failed_when"
+	FailedWhen []string `protobuf:"bytes,24,rep,name=failed_when,json=failedWhen,proto3" json:"failed_when,omitempty" yaml:"failed_when"`
+	// IgnoreErrors records failures without stopping the run.
+	// @inject_tag: yaml:"ignore_errors"
+	IgnoreErrors bool `protobuf:"varint,25,opt,name=ignore_errors,json=ignoreErrors,proto3" json:"ignore_errors,omitempty" yaml:"ignore_errors"`
+	// Until makes the task run again until all conditions hold, up to retries
+	// more times, waiting delay seconds in between.
+	// @inject_tag: yaml:"until"
+	Until []string `protobuf:"bytes,26,rep,name=until,proto3" json:"until,omitempty" yaml:"until"`
+	// Retries defaults to 3.
+	// @inject_tag: yaml:"retries"
+	Retries *int32 `protobuf:"varint,27,opt,name=retries,proto3,oneof" json:"retries,omitempty" yaml:"retries"`
+	// Delay defaults to 5.
+	// @inject_tag: yaml:"delay"
+	Delay *int32 `protobuf:"varint,28,opt,name=delay,proto3,oneof" json:"delay,omitempty" yaml:"delay"`
+	// LoopWith is set for the legacy `with_*` loops, with the lookup they use,
+	// e.g. `items` for `with_items`. Their value is in loop.
+	// @inject_tag: yaml:"loop_with"
+	LoopWith string `protobuf:"bytes,29,opt,name=loop_with,json=loopWith,proto3" json:"loop_with,omitempty" yaml:"loop_with"`
+	// @inject_tag: yaml:"loop_control"
+	LoopControl *LoopControl `protobuf:"bytes,30,opt,name=loop_control,json=loopControl,proto3" json:"loop_control,omitempty" yaml:"loop_control"`
+	// Environment sets environment variables for the commands the task runs.
+	// It's either a dict or a Jinja template evaluating to one.
+	// @inject_tag: yaml:"environment"
+	Environment *structpb.Value `protobuf:"bytes,31,opt,name=environment,proto3" json:"environment,omitempty" yaml:"environment"`
+	// Tags select whether the task runs, with `--tags` and `--skip-tags`. The
+	// tasks of a block or of included tasks inherit them.
+	// @inject_tag: yaml:"tags"
+	Tags []string `protobuf:"bytes,32,rep,name=tags,proto3" json:"tags,omitempty" yaml:"tags"`
+	// CheckMode runs the task in check mode when true, reporting what it would
+	// change without changing anything, or for real when false, whatever the
+	// executer's `--check` says. When unset, the executer's setting applies.
+	// @inject_tag: yaml:"check_mode"
+	CheckMode *bool `protobuf:"varint,33,opt,name=check_mode,json=checkMode,proto3,oneof" json:"check_mode,omitempty" yaml:"check_mode"`
+	// TemplatedArgs holds the module arguments that aren't strings, like
+	// booleans and numbers, but are set to Jinja templates, by name. They're
+	// rendered when the task runs.
+	// @inject_tag: yaml:"templated_args"
+	TemplatedArgs *structpb.Struct `protobuf:"bytes,34,opt,name=templated_args,json=templatedArgs,proto3" json:"templated_args,omitempty" yaml:"templated_args"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -88,11 +154,11 @@ func (x *Task) GetName() string {
 	return ""
 }
 
-func (x *Task) GetWhen() string {
+func (x *Task) GetWhen() []string {
 	if x != nil {
 		return x.When
 	}
-	return ""
+	return nil
 }
 
 func (x *Task) GetLoop() *structpb.Value {
@@ -206,6 +272,150 @@ func (x *Task) GetTemplate() *Template {
 	return nil
 }
 
+func (x *Task) GetMeta() *Meta {
+	if x != nil {
+		if x, ok := x.Content.(*Task_Meta); ok {
+			return x.Meta
+		}
+	}
+	return nil
+}
+
+func (x *Task) GetBlock() *Block {
+	if x != nil {
+		if x, ok := x.Content.(*Task_Block); ok {
+			return x.Block
+		}
+	}
+	return nil
+}
+
+func (x *Task) GetBecome() bool {
+	if x != nil && x.Become != nil {
+		return *x.Become
+	}
+	return false
+}
+
+func (x *Task) GetBecomeUser() string {
+	if x != nil {
+		return x.BecomeUser
+	}
+	return ""
+}
+
+func (x *Task) GetBecomeMethod() string {
+	if x != nil {
+		return x.BecomeMethod
+	}
+	return ""
+}
+
+func (x *Task) GetNotify() []string {
+	if x != nil {
+		return x.Notify
+	}
+	return nil
+}
+
+func (x *Task) GetListen() []string {
+	if x != nil {
+		return x.Listen
+	}
+	return nil
+}
+
+func (x *Task) GetVars() *structpb.Struct {
+	if x != nil {
+		return x.Vars
+	}
+	return nil
+}
+
+func (x *Task) GetChangedWhen() []string {
+	if x != nil {
+		return x.ChangedWhen
+	}
+	return nil
+}
+
+func (x *Task) GetFailedWhen() []string {
+	if x != nil {
+		return x.FailedWhen
+	}
+	return nil
+}
+
+func (x *Task) GetIgnoreErrors() bool {
+	if x != nil {
+		return x.IgnoreErrors
+	}
+	return false
+}
+
+func (x *Task) GetUntil() []string {
+	if x != nil {
+		return x.Until
+	}
+	return nil
+}
+
+func (x *Task) GetRetries() int32 {
+	if x != nil && x.Retries != nil {
+		return *x.Retries
+	}
+	return 0
+}
+
+func (x *Task) GetDelay() int32 {
+	if x != nil && x.Delay != nil {
+		return *x.Delay
+	}
+	return 0
+}
+
+func (x *Task) GetLoopWith() string {
+	if x != nil {
+		return x.LoopWith
+	}
+	return ""
+}
+
+func (x *Task) GetLoopControl() *LoopControl {
+	if x != nil {
+		return x.LoopControl
+	}
+	return nil
+}
+
+func (x *Task) GetEnvironment() *structpb.Value {
+	if x != nil {
+		return x.Environment
+	}
+	return nil
+}
+
+func (x *Task) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Task) GetCheckMode() bool {
+	if x != nil && x.CheckMode != nil {
+		return *x.CheckMode
+	}
+	return false
+}
+
+func (x *Task) GetTemplatedArgs() *structpb.Struct {
+	if x != nil {
+		return x.TemplatedArgs
+	}
+	return nil
+}
+
 type isTask_Content interface {
 	isTask_Content()
 }
@@ -250,6 +460,14 @@ type Task_Template struct {
 	Template *Template `protobuf:"bytes,14,opt,name=template,proto3,oneof"`
 }
 
+type Task_Meta struct {
+	Meta *Meta `protobuf:"bytes,20,opt,name=meta,proto3,oneof"`
+}
+
+type Task_Block struct {
+	Block *Block `protobuf:"bytes,21,opt,name=block,proto3,oneof"`
+}
+
 func (*Task_Apt) isTask_Content() {}
 
 func (*Task_AptRepository) isTask_Content() {}
@@ -270,14 +488,172 @@ func (*Task_Shell) isTask_Content() {}
 
 func (*Task_Template) isTask_Content() {}
 
+func (*Task_Meta) isTask_Content() {}
+
+func (*Task_Block) isTask_Content() {}
+
+// LoopControl configures how a task loops.
+type LoopControl struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// LoopVar is the name of the variable holding the current item. Defaults
+	// to `item`.
+	// @inject_tag: yaml:"loop_var"
+	LoopVar string `protobuf:"bytes,1,opt,name=loop_var,json=loopVar,proto3" json:"loop_var,omitempty" yaml:"loop_var"`
+	// IndexVar is the name of the variable holding the current item's index.
+	// @inject_tag: yaml:"index_var"
+	IndexVar string `protobuf:"bytes,2,opt,name=index_var,json=indexVar,proto3" json:"index_var,omitempty" yaml:"index_var"`
+	// Label is shown instead of the current item.
+	// @inject_tag: yaml:"label"
+	Label string `protobuf:"bytes,3,opt,name=label,proto3" json:"label,omitempty" yaml:"label"`
+	// Pause is the number of seconds to wait between items.
+	// @inject_tag: yaml:"pause"
+	Pause float64 `protobuf:"fixed64,4,opt,name=pause,proto3" json:"pause,omitempty" yaml:"pause"`
+	// Extended sets `ansible_loop`, with more details about the loop.
+	// @inject_tag: yaml:"extended"
+	Extended      bool `protobuf:"varint,5,opt,name=extended,proto3" json:"extended,omitempty" yaml:"extended"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoopControl) Reset() {
+	*x = LoopControl{}
+	mi := &file_proto_task_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoopControl) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoopControl) ProtoMessage() {}
+
+func (x *LoopControl) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_task_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoopControl.ProtoReflect.Descriptor instead.
+func (*LoopControl) Descriptor() ([]byte, []int) {
+	return file_proto_task_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LoopControl) GetLoopVar() string {
+	if x != nil {
+		return x.LoopVar
+	}
+	return ""
+}
+
+func (x *LoopControl) GetIndexVar() string {
+	if x != nil {
+		return x.IndexVar
+	}
+	return ""
+}
+
+func (x *LoopControl) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *LoopControl) GetPause() float64 {
+	if x != nil {
+		return x.Pause
+	}
+	return 0
+}
+
+func (x *LoopControl) GetExtended() bool {
+	if x != nil {
+		return x.Extended
+	}
+	return false
+}
+
+// Block groups tasks, along with tasks to run when one of them fails and tasks
+// to run no matter what.
+type Block struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// @inject_tag: yaml:"block"
+	Tasks []*Task `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty" yaml:"block"`
+	// @inject_tag: yaml:"rescue"
+	Rescue []*Task `protobuf:"bytes,2,rep,name=rescue,proto3" json:"rescue,omitempty" yaml:"rescue"`
+	// @inject_tag: yaml:"always"
+	Always        []*Task `protobuf:"bytes,3,rep,name=always,proto3" json:"always,omitempty" yaml:"always"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Block) Reset() {
+	*x = Block{}
+	mi := &file_proto_task_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Block) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Block) ProtoMessage() {}
+
+func (x *Block) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_task_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Block.ProtoReflect.Descriptor instead.
+func (*Block) Descriptor() ([]byte, []int) {
+	return file_proto_task_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Block) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+func (x *Block) GetRescue() []*Task {
+	if x != nil {
+		return x.Rescue
+	}
+	return nil
+}
+
+func (x *Block) GetAlways() []*Task {
+	if x != nil {
+		return x.Always
+	}
+	return nil
+}
+
 var File_proto_task_proto protoreflect.FileDescriptor
 
 const file_proto_task_proto_rawDesc = "" +
 	"\n" +
-	"\x10proto/task.proto\x12\x05proto\x1a\x1cgoogle/protobuf/struct.proto\x1a\x0fproto/apt.proto\x1a\x1aproto/apt_repository.proto\x1a\x13proto/command.proto\x1a\x10proto/copy.proto\x1a\x10proto/file.proto\x1a\x13proto/get_url.proto\x1a\x18proto/import_tasks.proto\x1a\x19proto/include_tasks.proto\x1a\x11proto/shell.proto\x1a\x14proto/template.proto\"\xc6\x04\n" +
+	"\x10proto/task.proto\x12\x05proto\x1a\x1cgoogle/protobuf/struct.proto\x1a\x0fproto/apt.proto\x1a\x1aproto/apt_repository.proto\x1a\x13proto/command.proto\x1a\x10proto/copy.proto\x1a\x10proto/file.proto\x1a\x13proto/get_url.proto\x1a\x18proto/import_tasks.proto\x1a\x19proto/include_tasks.proto\x1a\x10proto/meta.proto\x1a\x11proto/shell.proto\x1a\x14proto/template.proto\"\xbe\n" +
+	"\n" +
 	"\x04Task\x12\x12\n" +
 	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
-	"\x04when\x18\x02 \x01(\tR\x04when\x12*\n" +
+	"\x04when\x18\x02 \x03(\tR\x04when\x12*\n" +
 	"\x04loop\x18\x03 \x01(\v2\x16.google.protobuf.ValueR\x04loop\x12\x1a\n" +
 	"\bregister\x18\x04 \x01(\tR\bregister\x12\x1e\n" +
 	"\x03apt\x18\x05 \x01(\v2\n" +
@@ -291,8 +667,46 @@ const file_proto_task_proto_rawDesc = "" +
 	"\fimport_tasks\x18\v \x01(\v2\x12.proto.ImportTasksH\x00R\vimportTasks\x12:\n" +
 	"\rinclude_tasks\x18\f \x01(\v2\x13.proto.IncludeTasksH\x00R\fincludeTasks\x12$\n" +
 	"\x05shell\x18\r \x01(\v2\f.proto.ShellH\x00R\x05shell\x12-\n" +
-	"\btemplate\x18\x0e \x01(\v2\x0f.proto.TemplateH\x00R\btemplateB\t\n" +
-	"\acontentB+Z)github.com/mickael-carl/sophons/pkg/protob\x06proto3"
+	"\btemplate\x18\x0e \x01(\v2\x0f.proto.TemplateH\x00R\btemplate\x12!\n" +
+	"\x04meta\x18\x14 \x01(\v2\v.proto.MetaH\x00R\x04meta\x12$\n" +
+	"\x05block\x18\x15 \x01(\v2\f.proto.BlockH\x00R\x05block\x12\x1b\n" +
+	"\x06become\x18\x0f \x01(\bH\x01R\x06become\x88\x01\x01\x12\x1f\n" +
+	"\vbecome_user\x18\x10 \x01(\tR\n" +
+	"becomeUser\x12#\n" +
+	"\rbecome_method\x18\x11 \x01(\tR\fbecomeMethod\x12\x16\n" +
+	"\x06notify\x18\x12 \x03(\tR\x06notify\x12\x16\n" +
+	"\x06listen\x18\x13 \x03(\tR\x06listen\x12+\n" +
+	"\x04vars\x18\x16 \x01(\v2\x17.google.protobuf.StructR\x04vars\x12!\n" +
+	"\fchanged_when\x18\x17 \x03(\tR\vchangedWhen\x12\x1f\n" +
+	"\vfailed_when\x18\x18 \x03(\tR\n" +
+	"failedWhen\x12#\n" +
+	"\rignore_errors\x18\x19 \x01(\bR\fignoreErrors\x12\x14\n" +
+	"\x05until\x18\x1a \x03(\tR\x05until\x12\x1d\n" +
+	"\aretries\x18\x1b \x01(\x05H\x02R\aretries\x88\x01\x01\x12\x19\n" +
+	"\x05delay\x18\x1c \x01(\x05H\x03R\x05delay\x88\x01\x01\x12\x1b\n" +
+	"\tloop_with\x18\x1d \x01(\tR\bloopWith\x125\n" +
+	"\floop_control\x18\x1e \x01(\v2\x12.proto.LoopControlR\vloopControl\x128\n" +
+	"\venvironment\x18\x1f \x01(\v2\x16.google.protobuf.ValueR\venvironment\x12\x12\n" +
+	"\x04tags\x18  \x03(\tR\x04tags\x12\"\n" +
+	"\n" +
+	"check_mode\x18! \x01(\bH\x04R\tcheckMode\x88\x01\x01\x12>\n" +
+	"\x0etemplated_args\x18\" \x01(\v2\x17.google.protobuf.StructR\rtemplatedArgsB\t\n" +
+	"\acontentB\t\n" +
+	"\a_becomeB\n" +
+	"\n" +
+	"\b_retriesB\b\n" +
+	"\x06_delayB\r\n" +
+	"\v_check_mode\"\x8d\x01\n" +
+	"\vLoopControl\x12\x19\n" +
+	"\bloop_var\x18\x01 \x01(\tR\aloopVar\x12\x1b\n" +
+	"\tindex_var\x18\x02 \x01(\tR\bindexVar\x12\x14\n" +
+	"\x05label\x18\x03 \x01(\tR\x05label\x12\x14\n" +
+	"\x05pause\x18\x04 \x01(\x01R\x05pause\x12\x1a\n" +
+	"\bextended\x18\x05 \x01(\bR\bextended\"t\n" +
+	"\x05Block\x12!\n" +
+	"\x05tasks\x18\x01 \x03(\v2\v.proto.TaskR\x05tasks\x12#\n" +
+	"\x06rescue\x18\x02 \x03(\v2\v.proto.TaskR\x06rescue\x12#\n" +
+	"\x06always\x18\x03 \x03(\v2\v.proto.TaskR\x06alwaysB+Z)github.com/mickael-carl/sophons/pkg/protob\x06proto3"
 
 var (
 	file_proto_task_proto_rawDescOnce sync.Once
@@ -306,38 +720,51 @@ func file_proto_task_proto_rawDescGZIP() []byte {
 	return file_proto_task_proto_rawDescData
 }
 
-var file_proto_task_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_proto_task_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_proto_task_proto_goTypes = []any{
-	(*Task)(nil),           // 0: proto.Task
-	(*structpb.Value)(nil), // 1: google.protobuf.Value
-	(*Apt)(nil),            // 2: proto.Apt
-	(*AptRepository)(nil),  // 3: proto.AptRepository
-	(*Command)(nil),        // 4: proto.Command
-	(*Copy)(nil),           // 5: proto.Copy
-	(*File)(nil),           // 6: proto.File
-	(*GetURL)(nil),         // 7: proto.GetURL
-	(*ImportTasks)(nil),    // 8: proto.ImportTasks
-	(*IncludeTasks)(nil),   // 9: proto.IncludeTasks
-	(*Shell)(nil),          // 10: proto.Shell
-	(*Template)(nil),       // 11: proto.Template
+	(*Task)(nil),            // 0: proto.Task
+	(*LoopControl)(nil),     // 1: proto.LoopControl
+	(*Block)(nil),           // 2: proto.Block
+	(*structpb.Value)(nil),  // 3: google.protobuf.Value
+	(*Apt)(nil),             // 4: proto.Apt
+	(*AptRepository)(nil),   // 5: proto.AptRepository
+	(*Command)(nil),         // 6: proto.Command
+	(*Copy)(nil),            // 7: proto.Copy
+	(*File)(nil),            // 8: proto.File
+	(*GetURL)(nil),          // 9: proto.GetURL
+	(*ImportTasks)(nil),     // 10: proto.ImportTasks
+	(*IncludeTasks)(nil),    // 11: proto.IncludeTasks
+	(*Shell)(nil),           // 12: proto.Shell
+	(*Template)(nil),        // 13: proto.Template
+	(*Meta)(nil),            // 14: proto.Meta
+	(*structpb.Struct)(nil), // 15: google.protobuf.Struct
 }
 var file_proto_task_proto_depIdxs = []int32{
-	1,  // 0: proto.Task.loop:type_name -> google.protobuf.Value
-	2,  // 1: proto.Task.apt:type_name -> proto.Apt
-	3,  // 2: proto.Task.apt_repository:type_name -> proto.AptRepository
-	4,  // 3: proto.Task.command:type_name -> proto.Command
-	5,  // 4: proto.Task.copy:type_name -> proto.Copy
-	6,  // 5: proto.Task.file:type_name -> proto.File
-	7,  // 6: proto.Task.get_url:type_name -> proto.GetURL
-	8,  // 7: proto.Task.import_tasks:type_name -> proto.ImportTasks
-	9,  // 8: proto.Task.include_tasks:type_name -> proto.IncludeTasks
-	10, // 9: proto.Task.shell:type_name -> proto.Shell
-	11, // 10: proto.Task.template:type_name -> proto.Template
-	11, // [11:11] is the sub-list for method output_type
-	11, // [11:11] is the sub-list for method input_type
-	11, // [11:11] is the sub-list for extension type_name
-	11, // [11:11] is the sub-list for extension extendee
-	0,  // [0:11] is the sub-list for field type_name
+	3,  // 0: proto.Task.loop:type_name -> google.protobuf.Value
+	4,  // 1: proto.Task.apt:type_name -> proto.Apt
+	5,  // 2: proto.Task.apt_repository:type_name -> proto.AptRepository
+	6,  // 3: proto.Task.command:type_name -> proto.Command
+	7,  // 4: proto.Task.copy:type_name -> proto.Copy
+	8,  // 5: proto.Task.file:type_name -> proto.File
+	9,  // 6: proto.Task.get_url:type_name -> proto.GetURL
+	10, // 7: proto.Task.import_tasks:type_name -> proto.ImportTasks
+	11, // 8: proto.Task.include_tasks:type_name -> proto.IncludeTasks
+	12, // 9: proto.Task.shell:type_name -> proto.Shell
+	13, // 10: proto.Task.template:type_name -> proto.Template
+	14, // 11: proto.Task.meta:type_name -> proto.Meta
+	2,  // 12: proto.Task.block:type_name -> proto.Block
+	15, // 13: proto.Task.vars:type_name -> google.protobuf.Struct
+	1,  // 14: proto.Task.loop_control:type_name -> proto.LoopControl
+	3,  // 15: proto.Task.environment:type_name -> google.protobuf.Value
+	15, // 16: proto.Task.templated_args:type_name -> google.protobuf.Struct
+	0,  // 17: proto.Block.tasks:type_name -> proto.Task
+	0,  // 18: proto.Block.rescue:type_name -> proto.Task
+	0,  // 19: proto.Block.always:type_name -> proto.Task
+	20, // [20:20] is the sub-list for method output_type
+	20, // [20:20] is the sub-list for method input_type
+	20, // [20:20] is the sub-list for extension type_name
+	20, // [20:20] is the sub-list for extension extendee
+	0,  // [0:20] is the sub-list for field type_name
 }
 
 func init() { file_proto_task_proto_init() }
@@ -353,6 +780,7 @@ func file_proto_task_proto_init() {
 	file_proto_get_url_proto_init()
 	file_proto_import_tasks_proto_init()
 	file_proto_include_tasks_proto_init()
+	file_proto_meta_proto_init()
 	file_proto_shell_proto_init()
 	file_proto_template_proto_init()
 	file_proto_task_proto_msgTypes[0].OneofWrappers = []any{
@@ -366,6 +794,8 @@ func file_proto_task_proto_init() {
 		(*Task_IncludeTasks)(nil),
 		(*Task_Shell)(nil),
 		(*Task_Template)(nil),
+		(*Task_Meta)(nil),
+		(*Task_Block)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -373,7 +803,7 @@ func file_proto_task_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_task_proto_rawDesc), len(file_proto_task_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
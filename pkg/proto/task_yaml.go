@@ -2,6 +2,10 @@ package proto
 
 import (
 	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/goccy/go-yaml"
 	"github.com/goccy/go-yaml/ast"
@@ -14,13 +18,36 @@ func init() {
 	yaml.RegisterCustomUnmarshaler[[]*Task](tasksUnmarshalYAML)
 }
 
+// loopWith are the supported legacy `with_*` loops. They're translated to
+// `loop`, with LoopWith set to what comes after `with_`.
+var loopWith = []string{"items", "dict", "together", "subelements", "sequence", "fileglob"}
+
 func tasksUnmarshalYAML(t *[]*Task, b []byte) error {
 	type unmarshalTask struct {
-		Name       string              `yaml:"name"`
-		When       string              `yaml:"when"`
-		Loop       any                 `yaml:"loop"`
-		Register   string              `yaml:"register"`
-		RawContent map[string]ast.Node `yaml:",inline"`
+		Name         string              `yaml:"name"`
+		When         any                 `yaml:"when"`
+		Loop         any                 `yaml:"loop"`
+		Register     string              `yaml:"register"`
+		Become       *bool               `yaml:"become"`
+		BecomeUser   string              `yaml:"become_user"`
+		BecomeMethod string              `yaml:"become_method"`
+		Notify       any                 `yaml:"notify"`
+		Listen       any                 `yaml:"listen"`
+		Vars         map[string]any      `yaml:"vars"`
+		Environment  any                 `yaml:"environment"`
+		Tags         any                 `yaml:"tags"`
+		CheckMode    *bool               `yaml:"check_mode"`
+		ChangedWhen  any                 `yaml:"changed_when"`
+		FailedWhen   any                 `yaml:"failed_when"`
+		IgnoreErrors bool                `yaml:"ignore_errors"`
+		Until        any                 `yaml:"until"`
+		Retries      *int32              `yaml:"retries"`
+		Delay        *int32              `yaml:"delay"`
+		LoopControl  *LoopControl        `yaml:"loop_control"`
+		Block        []*Task             `yaml:"block"`
+		Rescue       []*Task             `yaml:"rescue"`
+		Always       []*Task             `yaml:"always"`
+		RawContent   map[string]ast.Node `yaml:",inline"`
 	}
 
 	var raw []unmarshalTask
@@ -31,19 +58,99 @@ func tasksUnmarshalYAML(t *[]*Task, b []byte) error {
 	var tasksOut []*Task
 	for _, task := range raw {
 		protoTask := &Task{
-			Name:     task.Name,
-			When:     task.When,
-			Register: task.Register,
+			Name:         task.Name,
+			Register:     task.Register,
+			Become:       task.Become,
+			BecomeUser:   task.BecomeUser,
+			BecomeMethod: task.BecomeMethod,
+			IgnoreErrors: task.IgnoreErrors,
+			Retries:      task.Retries,
+			Delay:        task.Delay,
+			LoopControl:  task.LoopControl,
+			CheckMode:    task.CheckMode,
+		}
+
+		var err error
+		if protoTask.When, err = conditions(task.When); err != nil {
+			return fmt.Errorf("invalid when for task %q: %w", task.Name, err)
+		}
+		if protoTask.Notify, err = stringOrList(task.Notify); err != nil {
+			return fmt.Errorf("invalid notify for task %q: %w", task.Name, err)
+		}
+		if protoTask.Listen, err = stringOrList(task.Listen); err != nil {
+			return fmt.Errorf("invalid listen for task %q: %w", task.Name, err)
+		}
+		if protoTask.ChangedWhen, err = conditions(task.ChangedWhen); err != nil {
+			return fmt.Errorf("invalid changed_when for task %q: %w", task.Name, err)
+		}
+		if protoTask.FailedWhen, err = conditions(task.FailedWhen); err != nil {
+			return fmt.Errorf("invalid failed_when for task %q: %w", task.Name, err)
+		}
+		if protoTask.Until, err = conditions(task.Until); err != nil {
+			return fmt.Errorf("invalid until for task %q: %w", task.Name, err)
+		}
+		if protoTask.Tags, err = Tags(task.Tags); err != nil {
+			return fmt.Errorf("invalid tags for task %q: %w", task.Name, err)
+		}
+
+		loop := task.Loop
+		for key, node := range task.RawContent {
+			with, ok := strings.CutPrefix(key, "with_")
+			if !ok {
+				continue
+			}
+			if !slices.Contains(loopWith, with) {
+				return fmt.Errorf("task %q uses unsupported loop %s", task.Name, key)
+			}
+			if loop != nil {
+				return fmt.Errorf("task %q can only have one loop", task.Name)
+			}
+			if err := yaml.NodeToValue(node, &loop); err != nil {
+				return fmt.Errorf("failed to unmarshal %s for task %q: %w", key, task.Name, err)
+			}
+			protoTask.LoopWith = with
 		}
 
-		if task.Loop != nil {
-			loopValue, err := structpb.NewValue(task.Loop)
+		if loop != nil {
+			loopValue, err := structpb.NewValue(loop)
 			if err != nil {
 				return fmt.Errorf("failed to convert loop to structpb.Value: %w", err)
 			}
 			protoTask.Loop = loopValue
 		}
 
+		if task.Environment != nil {
+			environment, err := structpb.NewValue(task.Environment)
+			if err != nil {
+				return fmt.Errorf("failed to convert environment to structpb.Value: %w", err)
+			}
+			protoTask.Environment = environment
+		}
+
+		if task.Vars != nil {
+			vars, err := structpb.NewStruct(task.Vars)
+			if err != nil {
+				return fmt.Errorf("failed to convert vars to structpb.Struct: %w", err)
+			}
+			protoTask.Vars = vars
+		}
+
+		if task.Block != nil {
+			if loop != nil {
+				return fmt.Errorf("block %q can't have a loop", task.Name)
+			}
+			protoTask.Content = &Task_Block{Block: &Block{
+				Tasks:  task.Block,
+				Rescue: task.Rescue,
+				Always: task.Always,
+			}}
+			tasksOut = append(tasksOut, protoTask)
+			continue
+		}
+		if task.Rescue != nil || task.Always != nil {
+			return fmt.Errorf("task %q has rescue or always without block", task.Name)
+		}
+
 		for moduleName, node := range task.RawContent {
 			reg, ok := registry.NameRegistry[moduleName]
 			if !ok {
@@ -51,6 +158,14 @@ func tasksUnmarshalYAML(t *[]*Task, b []byte) error {
 			}
 
 			protoMsg := reg.ProtoFactory()
+			args := templatedArgs(protoMsg, node)
+			if len(args) > 0 {
+				templated, err := structpb.NewStruct(args)
+				if err != nil {
+					return fmt.Errorf("failed to convert templated arguments to structpb.Struct: %w", err)
+				}
+				protoTask.TemplatedArgs = templated
+			}
 			if err := yaml.NodeToValue(node, protoMsg); err != nil {
 				return fmt.Errorf("failed to unmarshal %s module: %w", moduleName, err)
 			}
@@ -69,3 +184,129 @@ func tasksUnmarshalYAML(t *[]*Task, b []byte) error {
 	*t = tasksOut
 	return nil
 }
+
+// templatedArgs takes the arguments set to Jinja templates out of node, the
+// arguments of a module, when the fields of msg they're for aren't strings,
+// e.g. `force: "{{ overwrite }}"`. Those fields can only be set once the
+// templates are rendered, when the task runs.
+func templatedArgs(msg any, node ast.Node) map[string]any {
+	mapping, ok := node.(*ast.MappingNode)
+	if !ok {
+		return nil
+	}
+
+	fields := nonStringFields(msg)
+	args := map[string]any{}
+	values := make([]*ast.MappingValueNode, 0, len(mapping.Values))
+	for _, value := range mapping.Values {
+		name := value.Key.GetToken().Value
+		if s, ok := value.Value.(*ast.StringNode); ok && fields[name] && strings.Contains(s.Value, "{{") {
+			args[name] = s.Value
+			continue
+		}
+		values = append(values, value)
+	}
+	mapping.Values = values
+
+	return args
+}
+
+// nonStringFields returns the names, from their yaml tags, of the fields of
+// the struct msg points to that hold booleans or numbers.
+func nonStringFields(msg any) map[string]bool {
+	t := reflect.TypeOf(msg)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		switch fieldType.Kind() {
+		case reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// stringOrList converts a keyword that can be either a single string or a
+// list of strings, like `notify`, to a list.
+func stringOrList(v any) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %T", item)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("expected a string or a list of strings, got %T", v)
+	}
+}
+
+// Tags converts a `tags` keyword to a list. Tags are given either as a list,
+// or as a string where they're separated by commas.
+func Tags(v any) ([]string, error) {
+	list, err := stringOrList(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, item := range list {
+		for _, tag := range strings.Split(item, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// conditions converts a keyword holding conditions, like `changed_when`, to a
+// list. Conditions can also be booleans, e.g. `changed_when: false`.
+func conditions(v any) ([]string, error) {
+	switch v := v.(type) {
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case bool:
+				list = append(list, strconv.FormatBool(item))
+			case string:
+				list = append(list, item)
+			default:
+				return nil, fmt.Errorf("expected a condition, got %T", item)
+			}
+		}
+		return list, nil
+	default:
+		return stringOrList(v)
+	}
+}
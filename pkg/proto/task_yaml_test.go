@@ -66,3 +66,381 @@ func TestTasksUnmarshalYAML(t *testing.T) {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestTasksUnmarshalYAMLHandlers(t *testing.T) {
+	b := []byte(`
+- name: "configure"
+  ansible.builtin.command:
+    cmd: "true"
+  notify: restart web
+- name: "restart web"
+  listen:
+    - web
+    - services
+  ansible.builtin.command:
+    cmd: "true"
+  notify:
+    - reload proxy
+- meta: flush_handlers
+`)
+
+	var got []*proto.Task
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*proto.Task{
+		{
+			Name:    "configure",
+			Notify:  []string{"restart web"},
+			Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+		{
+			Name:    "restart web",
+			Notify:  []string{"reload proxy"},
+			Listen:  []string{"web", "services"},
+			Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+		{
+			Content: &proto.Task_Meta{Meta: &proto.Meta{FreeForm: "flush_handlers"}},
+		},
+	}
+
+	if diff := cmp.Diff(expected, got, cmpopts.IgnoreUnexported(proto.Task{}, proto.Command{}, proto.Meta{})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTasksUnmarshalYAMLBlock(t *testing.T) {
+	b := []byte(`
+- name: "install"
+  when: ansible_os_family == "Debian"
+  become: true
+  vars:
+    package: nginx
+  environment:
+    DEBIAN_FRONTEND: noninteractive
+  block:
+    - ansible.builtin.command:
+        cmd: "apt-get install {{ package }}"
+  rescue:
+    - ansible.builtin.command:
+        cmd: "echo {{ ansible_failed_task.name }}"
+  always:
+    - block:
+        - ansible.builtin.command:
+            cmd: "true"
+`)
+
+	var got []*proto.Task
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	pTrue := true
+	expected := []*proto.Task{
+		{
+			Name:   "install",
+			When:   []string{`ansible_os_family == "Debian"`},
+			Become: &pTrue,
+			Vars: &structpb.Struct{Fields: map[string]*structpb.Value{
+				"package": structpb.NewStringValue("nginx"),
+			}},
+			Environment: structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{
+				"DEBIAN_FRONTEND": structpb.NewStringValue("noninteractive"),
+			}}),
+			Content: &proto.Task_Block{Block: &proto.Block{
+				Tasks: []*proto.Task{
+					{Content: &proto.Task_Command{Command: &proto.Command{Cmd: "apt-get install {{ package }}"}}},
+				},
+				Rescue: []*proto.Task{
+					{Content: &proto.Task_Command{Command: &proto.Command{Cmd: "echo {{ ansible_failed_task.name }}"}}},
+				},
+				Always: []*proto.Task{
+					{Content: &proto.Task_Block{Block: &proto.Block{
+						Tasks: []*proto.Task{
+							{Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}}},
+						},
+					}}},
+				},
+			}},
+		},
+	}
+
+	if diff := cmp.Diff(expected, got, cmpopts.IgnoreUnexported(proto.Task{}, proto.Block{}, proto.Command{}, structpb.Struct{}, structpb.Value{})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTasksUnmarshalYAMLBlockErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{
+			name: "rescue without block",
+			yaml: `
+- ansible.builtin.command:
+    cmd: "true"
+  rescue:
+    - ansible.builtin.command:
+        cmd: "true"
+`,
+		},
+		{
+			name: "block with a loop",
+			yaml: `
+- loop: [1, 2]
+  block:
+    - ansible.builtin.command:
+        cmd: "true"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []*proto.Task
+			if err := yaml.Unmarshal([]byte(tt.yaml), &got); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestTasksUnmarshalYAMLConditions(t *testing.T) {
+	var retries, delay int32 = 10, 1
+	b := []byte(`
+- ansible.builtin.command:
+    cmd: "true"
+  changed_when: false
+  failed_when:
+    - result.rc != 0
+    - "'error' in result.stderr"
+  ignore_errors: true
+- ansible.builtin.command:
+    cmd: "true"
+  changed_when: result.rc == 2
+- ansible.builtin.command:
+    cmd: "true"
+  until: result.rc == 0
+  retries: 10
+  delay: 1
+- ansible.builtin.command:
+    cmd: "true"
+  when:
+    - ansible_os_family == "Debian"
+    - true
+`)
+
+	var got []*proto.Task
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*proto.Task{
+		{
+			ChangedWhen:  []string{"false"},
+			FailedWhen:   []string{"result.rc != 0", "'error' in result.stderr"},
+			IgnoreErrors: true,
+			Content:      &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+		{
+			ChangedWhen: []string{"result.rc == 2"},
+			Content:     &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+		{
+			Until:   []string{"result.rc == 0"},
+			Retries: &retries,
+			Delay:   &delay,
+			Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+		{
+			When:    []string{`ansible_os_family == "Debian"`, "true"},
+			Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+	}
+
+	if diff := cmp.Diff(expected, got, cmpopts.IgnoreUnexported(proto.Task{}, proto.Command{})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTasksUnmarshalYAMLTags(t *testing.T) {
+	b := []byte(`
+- ansible.builtin.command:
+    cmd: "true"
+  tags: nginx
+- ansible.builtin.command:
+    cmd: "true"
+  tags: "nginx, config"
+- tags:
+    - nginx
+    - never
+  block:
+    - ansible.builtin.command:
+        cmd: "true"
+`)
+
+	var got []*proto.Task
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*proto.Task{
+		{
+			Tags:    []string{"nginx"},
+			Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+		{
+			Tags:    []string{"nginx", "config"},
+			Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}},
+		},
+		{
+			Tags: []string{"nginx", "never"},
+			Content: &proto.Task_Block{Block: &proto.Block{Tasks: []*proto.Task{
+				{Content: &proto.Task_Command{Command: &proto.Command{Cmd: "true"}}},
+			}}},
+		},
+	}
+
+	if diff := cmp.Diff(expected, got, cmpopts.IgnoreUnexported(proto.Task{}, proto.Command{}, proto.Block{})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTasksUnmarshalYAMLLoops(t *testing.T) {
+	b := []byte(`
+- ansible.builtin.command:
+    cmd: "echo {{ pkg }}"
+  loop: [a, b]
+  loop_control:
+    loop_var: pkg
+    index_var: i
+    label: "{{ pkg }}"
+    pause: 0.5
+    extended: true
+- ansible.builtin.command:
+    cmd: "echo {{ item.key }}"
+  with_dict:
+    a: 1
+- ansible.builtin.command:
+    cmd: "echo {{ item }}"
+  with_sequence: start=1 end=3
+`)
+
+	var got []*proto.Task
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	type loop struct {
+		Loop        any
+		LoopWith    string
+		LoopControl *proto.LoopControl
+	}
+	var gotLoops []loop
+	for _, task := range got {
+		gotLoops = append(gotLoops, loop{
+			Loop:        task.GetLoop().AsInterface(),
+			LoopWith:    task.GetLoopWith(),
+			LoopControl: task.GetLoopControl(),
+		})
+	}
+
+	expected := []loop{
+		{
+			Loop: []any{"a", "b"},
+			LoopControl: &proto.LoopControl{
+				LoopVar:  "pkg",
+				IndexVar: "i",
+				Label:    "{{ pkg }}",
+				Pause:    0.5,
+				Extended: true,
+			},
+		},
+		{
+			Loop:     map[string]any{"a": float64(1)},
+			LoopWith: "dict",
+		},
+		{
+			Loop:     "start=1 end=3",
+			LoopWith: "sequence",
+		},
+	}
+
+	if diff := cmp.Diff(expected, gotLoops, cmpopts.IgnoreUnexported(proto.LoopControl{})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTasksUnmarshalYAMLLoopErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{
+			name: "unsupported with_ loop",
+			yaml: `
+- ansible.builtin.command:
+    cmd: "true"
+  with_nested: [[a], [b]]
+`,
+		},
+		{
+			name: "several loops",
+			yaml: `
+- ansible.builtin.command:
+    cmd: "true"
+  loop: [a]
+  with_items: [b]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []*proto.Task
+			if err := yaml.Unmarshal([]byte(tt.yaml), &got); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestTasksUnmarshalYAMLTemplatedArgs(t *testing.T) {
+	b := []byte(`
+- ansible.builtin.copy:
+    src: "{{ src }}"
+    dest: /etc/motd
+    force: "{{ overwrite }}"
+- ansible.builtin.apt:
+    name: "{{ pkgs }}"
+    lock_timeout: "{{ timeout }}"
+    purge: true
+`)
+
+	var got []*proto.Task
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(map[string]any{"force": "{{ overwrite }}"}, got[0].GetTemplatedArgs().AsMap()); diff != "" {
+		t.Errorf("copy templated arguments mismatch (-want +got):\n%s", diff)
+	}
+	copyArgs := got[0].GetCopy()
+	if copyArgs.GetSrc() != "{{ src }}" || copyArgs.GetDest() != "/etc/motd" || copyArgs.Force != nil {
+		t.Errorf("unexpected copy arguments: %v", copyArgs)
+	}
+
+	if diff := cmp.Diff(map[string]any{"lock_timeout": "{{ timeout }}"}, got[1].GetTemplatedArgs().AsMap()); diff != "" {
+		t.Errorf("apt templated arguments mismatch (-want +got):\n%s", diff)
+	}
+	aptArgs := got[1].GetApt()
+	if diff := cmp.Diff([]string{"{{ pkgs }}"}, aptArgs.GetName().GetItems()); diff != "" {
+		t.Errorf("apt name mismatch (-want +got):\n%s", diff)
+	}
+	if !aptArgs.GetPurge() || aptArgs.GetLockTimeout() != 0 {
+		t.Errorf("unexpected apt arguments: %v", aptArgs)
+	}
+}
@@ -36,8 +36,8 @@ type Template struct {
 	Src string `protobuf:"bytes,5,opt,name=src,proto3" json:"src,omitempty" yaml:"src" sophons:"implemented"`
 	// @inject_tag: yaml:"attributes"
 	Attributes string `protobuf:"bytes,6,opt,name=attributes,proto3" json:"attributes,omitempty" yaml:"attributes"`
-	// @inject_tag: yaml:"backup"
-	Backup bool `protobuf:"varint,7,opt,name=backup,proto3" json:"backup,omitempty" yaml:"backup"`
+	// @inject_tag: yaml:"backup" sophons:"implemented"
+	Backup bool `protobuf:"varint,7,opt,name=backup,proto3" json:"backup,omitempty" yaml:"backup" sophons:"implemented"`
 	// @inject_tag: yaml:"block_end_string"
 	BlockEndString string `protobuf:"bytes,8,opt,name=block_end_string,json=blockEndString,proto3" json:"block_end_string,omitempty" yaml:"block_end_string"`
 	// @inject_tag: yaml:"block_start_string"
@@ -66,10 +66,10 @@ type Template struct {
 	Seuser string `protobuf:"bytes,20,opt,name=seuser,proto3" json:"seuser,omitempty" yaml:"seuser"`
 	// @inject_tag: yaml:"trim_blocks"
 	TrimBlocks *bool `protobuf:"varint,21,opt,name=trim_blocks,json=trimBlocks,proto3,oneof" json:"trim_blocks,omitempty" yaml:"trim_blocks"`
-	// @inject_tag: yaml:"unsafe_writes"
-	UnsafeWrites bool `protobuf:"varint,22,opt,name=unsafe_writes,json=unsafeWrites,proto3" json:"unsafe_writes,omitempty" yaml:"unsafe_writes"`
-	// @inject_tag: yaml:"validate"
-	Validate string `protobuf:"bytes,23,opt,name=validate,proto3" json:"validate,omitempty" yaml:"validate"`
+	// @inject_tag: yaml:"unsafe_writes" sophons:"implemented"
+	UnsafeWrites bool `protobuf:"varint,22,opt,name=unsafe_writes,json=unsafeWrites,proto3" json:"unsafe_writes,omitempty" yaml:"unsafe_writes" sophons:"implemented"`
+	// @inject_tag: yaml:"validate" sophons:"implemented"
+	Validate string `protobuf:"bytes,23,opt,name=validate,proto3" json:"validate,omitempty" yaml:"validate" sophons:"implemented"`
 	// @inject_tag: yaml:"variable_end_string"
 	VariableEndString string `protobuf:"bytes,24,opt,name=variable_end_string,json=variableEndString,proto3" json:"variable_end_string,omitempty" yaml:"variable_end_string"`
 	// @inject_tag: yaml:"variable_start_string"
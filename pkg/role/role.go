@@ -19,6 +19,10 @@ type Role struct {
 	defaults variables.Variables
 	vars     variables.Variables
 	tasks    []*proto.Task
+	handlers []*proto.Task
+	// handlersErr is why the handlers couldn't be loaded, if they couldn't.
+	// It's only reported when the role is applied.
+	handlersErr error
 }
 
 func DiscoverRoles(fsys fs.FS) (map[string]Role, error) {
@@ -113,8 +117,20 @@ func maybeRole(fsys fs.FS, name string) (Role, bool, error) {
 			}
 			role.tasks = tasks
 
+		case "handlers":
+			isARole = true
+			// Handlers are loaded the same way tasks are. Roles whose handlers
+			// use modules that aren't supported yet still get discovered, so
+			// that they only fail the play applying them.
+			handlers, err := processTasks(fsys, entryPath)
+			if err != nil {
+				role.handlersErr = err
+				continue
+			}
+			role.handlers = handlers
+
 		// TODO: those are dirs found in a role, but not implemented currently.
-		case "handlers", "templates", "files", "meta", "library", "module_utils", "lookup_plugins":
+		case "templates", "files", "meta", "library", "module_utils", "lookup_plugins":
 			isARole = true
 		default:
 		}
@@ -124,6 +140,10 @@ func maybeRole(fsys fs.FS, name string) (Role, bool, error) {
 }
 
 func (r *Role) Apply(ctx context.Context, logger *zap.Logger, parentPath string) error {
+	if r.handlersErr != nil {
+		return fmt.Errorf("failed to load handlers: %w", r.handlersErr)
+	}
+
 	inventoryAndPlayVars, ok := variables.FromContext(ctx)
 	if !ok {
 		inventoryAndPlayVars = variables.Variables{}
@@ -135,6 +155,13 @@ func (r *Role) Apply(ctx context.Context, logger *zap.Logger, parentPath string)
 	roleCtxVars.Merge(r.vars)
 
 	roleCtx := variables.NewContext(ctx, roleCtxVars)
+
+	// The role's handlers run with its variables, like its tasks.
+	if handlers, ok := exec.HandlersFromContext(ctx); ok {
+		if err := handlers.Add(roleCtx, r.handlers, parentPath, true); err != nil {
+			return fmt.Errorf("failed to add handlers: %w", err)
+		}
+	}
 	for _, protoTask := range r.tasks {
 		execTask, err := exec.FromProto(protoTask)
 		if err != nil {
@@ -31,6 +31,12 @@ fruit: "banana"
     path: /foo
     state: touch
 `)
+	handlers := []byte(`
+- name: remove foo
+  ansible.builtin.file:
+    path: /foo
+    state: absent
+`)
 
 	fsys := fstest.MapFS{
 		"somerole/vars/main.yml": &fstest.MapFile{
@@ -42,6 +48,9 @@ fruit: "banana"
 		"somerole/tasks/main": &fstest.MapFile{
 			Data: tasks,
 		},
+		"somerole/handlers/main.yml": &fstest.MapFile{
+			Data: handlers,
+		},
 	}
 
 	got, ok, err := maybeRole(fsys, "somerole")
@@ -72,6 +81,17 @@ fruit: "banana"
 				},
 			},
 		},
+		handlers: []*proto.Task{
+			{
+				Name: "remove foo",
+				Content: &proto.Task_File{
+					File: &proto.File{
+						Path:  "/foo",
+						State: exec.FileAbsent,
+					},
+				},
+			},
+		},
 	}
 
 	if diff := cmp.Diff(expected, got, cmp.AllowUnexported(Role{}), cmpopts.IgnoreUnexported(proto.Task{}, proto.File{})); diff != "" {
@@ -283,7 +303,7 @@ func TestDiscoverRole(t *testing.T) {
 		"other": {},
 	}
 
-	if diff := cmp.Diff(expected, got, cmp.AllowUnexported(Role{}), cmpopts.IgnoreUnexported(proto.Task{}, proto.File{}, proto.Shell{})); diff != "" {
+	if diff := cmp.Diff(expected, got, cmp.AllowUnexported(Role{}), cmpopts.IgnoreFields(Role{}, "handlersErr"), cmpopts.IgnoreUnexported(proto.Task{}, proto.File{}, proto.Shell{})); diff != "" {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 }
@@ -353,3 +373,30 @@ func TestRoleApply(t *testing.T) {
 		t.Errorf("mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestRoleApplyUnsupportedHandler(t *testing.T) {
+	handler := []byte(`
+- name: Restart myservice
+  ansible.builtin.service:
+    name: myservice
+    state: restarted`)
+
+	fsys := fstest.MapFS{
+		"other/tasks/main.yml":    &fstest.MapFile{Data: []byte(`[]`)},
+		"other/handlers/main.yml": &fstest.MapFile{Data: handler},
+	}
+
+	roles, err := DiscoverRoles(fsys)
+	if err != nil {
+		t.Fatalf("discovering a role with an unsupported handler should succeed, got: %v", err)
+	}
+
+	role, ok := roles["other"]
+	if !ok {
+		t.Fatal("expected role other to be discovered")
+	}
+
+	if err := role.Apply(context.Background(), zap.NewNop(), ""); err == nil {
+		t.Error("expected applying a role with an unsupported handler to fail")
+	}
+}